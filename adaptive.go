@@ -0,0 +1,161 @@
+package limit
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// AdaptiveStats reports the rate an adaptive limiter settled on, see NewAdaptiveLimiter.
+type AdaptiveStats struct {
+	// The rate the limiter enforces now
+	Rate Rate `json:"rate"`
+	// The bounds of the count
+	Min int `json:"min"`
+	Max int `json:"max"`
+	// How many times the count was raised and cut since the limiter was created
+	Increases int `json:"increases"`
+	Decreases int `json:"decreases"`
+}
+
+// AdaptiveLimiter is a Limiter finding the rate a downstream can take from the outcome of the requests it allowed,
+// see NewAdaptiveLimiter.
+type AdaptiveLimiter interface {
+	Limiter
+	Configurer
+	// Report feeds back whether a request the limiter allowed succeeded, false for the responses telling to back off
+	// like 429 and 503. Other errors, like a bad request, shouldn't be reported as failures.
+	Report(success bool)
+}
+
+var _ AdaptiveLimiter = (*adaptiveLimiter)(nil)
+
+type adaptiveLimiter struct {
+	*tokenBucket
+
+	// Mutex, taken before the bucket's
+	mux sync.Mutex
+
+	// Config
+	min, max      int
+	per           time.Duration
+	increaseStep  int
+	increaseAfter int
+	factor        float64
+	decreaseAfter int
+	cooldown      time.Duration
+
+	// State
+	count        int
+	successes    int
+	failures     int
+	lastDecrease time.Time
+	increases    int
+	decreases    int
+}
+
+// NewAdaptiveLimiter creates a token bucket allowing initial requests per period, whose count then follows what Report
+// says about the downstream, AIMD style: it's raised additively after a run of successes and cut multiplicatively after
+// failures, within [min, max]. WithAdditiveIncrease and WithMultiplicativeDecrease tune the steps and how many reports
+// it takes, so a single failure doesn't halve the rate. The other options are the token bucket's, and its Config and
+// Limit follow the count as it changes.
+// It panics if min isn't positive, if initial isn't within [min, max] or if per isn't positive.
+func NewAdaptiveLimiter(initial, min, max int, per time.Duration, opts ...Option) AdaptiveLimiter {
+	if err := validateAdaptive(initial, min, max, per); err != nil {
+		panic(fmt.Sprintf("limit: NewAdaptiveLimiter: %v", err))
+	}
+
+	o := newOptions(opts)
+	cooldown := o.decreaseCooldown
+	if cooldown < 0 {
+		cooldown = per
+	}
+	return &adaptiveLimiter{
+		tokenBucket:   NewTokenBucket(initial, per, acceptOptions(opts, kindOther)...).(*tokenBucket),
+		min:           min,
+		max:           max,
+		per:           per,
+		increaseStep:  o.increaseStep,
+		increaseAfter: o.increaseAfter,
+		factor:        o.decreaseFactor,
+		decreaseAfter: o.decreaseAfter,
+		cooldown:      cooldown,
+		count:         initial,
+	}
+}
+
+// validateAdaptive returns why the bounds of an adaptive limiter are invalid, nil if they aren't.
+func validateAdaptive(initial, min, max int, per time.Duration) error {
+	if err := validateRate(max, per, true); err != nil {
+		return err
+	}
+	switch {
+	case min <= 0:
+		return fmt.Errorf("min %d is not positive", min)
+	case initial < min || initial > max:
+		return fmt.Errorf("initial count %d is not within [%d, %d]", initial, min, max)
+	}
+	return nil
+}
+
+func (a *adaptiveLimiter) Report(success bool) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	now := a.clock.Now()
+	if success {
+		a.successes++
+		if a.successes < a.increaseAfter || a.count >= a.max {
+			return
+		}
+		a.setCountLocked(min(a.count+a.increaseStep, a.max))
+		a.increases++
+		return
+	}
+
+	// The failures of requests sent before the last cut are already accounted for
+	if !a.lastDecrease.IsZero() && now.Sub(a.lastDecrease) < a.cooldown {
+		return
+	}
+	a.successes = 0
+	a.failures++
+	if a.failures < a.decreaseAfter || a.count <= a.min {
+		return
+	}
+	a.setCountLocked(max(int(float64(a.count)*a.factor), a.min))
+	a.lastDecrease = now
+	a.decreases++
+}
+
+// setCountLocked applies a new count to the bucket, starting over the runs of reports.
+func (a *adaptiveLimiter) setCountLocked(count int) {
+	// This must be called with the mutex already locked
+	a.count = count
+	a.successes, a.failures = 0, 0
+	a.tokenBucket.SetRate(count, a.per)
+}
+
+// SetRate changes the rate like the token bucket's, the count adapting from there on, even outside of its bounds.
+func (a *adaptiveLimiter) SetRate(count int, per time.Duration) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	a.per = per
+	a.setCountLocked(count)
+}
+
+// Stats reports the rate settled on in Adaptive.
+func (a *adaptiveLimiter) Stats() Stats {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	stats := a.tokenBucket.Stats()
+	stats.Adaptive = &AdaptiveStats{
+		Rate:      Rate{Count: a.count, Per: a.per},
+		Min:       a.min,
+		Max:       a.max,
+		Increases: a.increases,
+		Decreases: a.decreases,
+	}
+	return stats
+}
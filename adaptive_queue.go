@@ -0,0 +1,101 @@
+package limit
+
+import (
+	"fmt"
+	"time"
+)
+
+// QueueStats reports the queue size of a leaky bucket created with WithAdaptiveQueue.
+type QueueStats struct {
+	// The number of events the bucket queues now
+	MaxQueue int `json:"max_queue"`
+	// How many times the queue size was adjusted since the bucket was created
+	Adjustments int `json:"adjustments"`
+}
+
+// adaptiveQueue holds the config set with WithAdaptiveQueue.
+type adaptiveQueue struct {
+	targetMaxWait time.Duration
+	minQueue      int
+	maxQueue      int
+}
+
+// size returns the queue size that leaks in targetMaxWait at leakRate, clamped to the bounds.
+func (a *adaptiveQueue) size(leakRate time.Duration) int {
+	size := a.maxQueue
+	if leakRate > 0 && a.targetMaxWait/leakRate < time.Duration(a.maxQueue) {
+		size = int(a.targetMaxWait / leakRate)
+	}
+	return max(size, a.minQueue)
+}
+
+// tuneQueue adjusts the queue size to the leak rate, at most once per target wait. The queue grows at once but only
+// shrinks half the way at a time, and never ejects queued events, arrivals are turned away until they leak instead.
+func (l *leakyBucket) tuneQueue() {
+	// This must be called with the mutex already locked
+	if l.adaptive == nil {
+		return
+	}
+	now := l.clock.Now()
+	if now.Before(l.nextTune) {
+		return
+	}
+	l.nextTune = now.Add(l.adaptive.targetMaxWait)
+
+	target := l.adaptive.size(l.leakRate)
+	switch {
+	case target > l.maxCapacity:
+		l.maxCapacity = target
+		// There may be room for the callers waiting for it
+		l.waiters.notify()
+	case target < l.maxCapacity:
+		l.maxCapacity -= max((l.maxCapacity-target)/2, 1)
+	default:
+		return
+	}
+	l.queueAdjustments++
+}
+
+// queueStats returns the queue size stats, nil without WithAdaptiveQueue.
+func (l *leakyBucket) queueStats() *QueueStats {
+	// This must be called with the mutex already locked
+	if l.adaptive == nil {
+		return nil
+	}
+	return &QueueStats{MaxQueue: l.maxCapacity, Adjustments: l.queueAdjustments}
+}
+
+// SetLeakRate adjusts the queue size to the new rate right away with WithAdaptiveQueue.
+func (l *leakyBucket) SetLeakRate(count int, per time.Duration) {
+	if count <= 0 || per <= 0 || per/time.Duration(count) == 0 {
+		panic(fmt.Sprintf("limit: SetLeakRate with an invalid rate of %d/%s", count, per))
+	}
+	l.setLeakRate(Rate{Count: count, Per: per})
+}
+
+// SetMaxQueue sets the queue size WithAdaptiveQueue adjusts from. With DropOldest, events arriving at a queue longer
+// than n still evict the oldest ones.
+func (l *leakyBucket) SetMaxQueue(n int) {
+	if n <= 0 {
+		panic(fmt.Sprintf("limit: SetMaxQueue with an invalid queue size of %d", n))
+	}
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	l.maxCapacity = n
+	// There may be room for the callers waiting for it
+	l.waiters.notify()
+}
+
+// setLeakRate changes the rate events leak at, adjusting the queue size to it right away with WithAdaptiveQueue.
+func (l *leakyBucket) setLeakRate(rate Rate) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	l.rate = rate
+	l.leakRate = rate.Per / time.Duration(rate.Count)
+	l.nextTune = time.Time{}
+	l.tuneQueue()
+	l.waiters.notify()
+}
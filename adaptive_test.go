@@ -0,0 +1,82 @@
+package limit_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limittest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdaptiveLimiter_Report(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	limiter := limit.NewAdaptiveLimiter(10, 2, 12, 1*time.Second, limit.WithClock(clock),
+		limit.WithAdditiveIncrease(1, 5), limit.WithMultiplicativeDecrease(0.5, 2, 1*time.Second))
+
+	// A single failure doesn't cut the rate
+	limiter.Report(false)
+	assert.Equal(t, limit.Rate{Count: 10, Per: 1 * time.Second}, limiter.Limit())
+
+	// The second one does
+	limiter.Report(false)
+	assert.Equal(t, limit.Rate{Count: 5, Per: 1 * time.Second}, limiter.Limit())
+	assert.Equal(t, 5, limiter.Config().Count)
+
+	// Failures of requests sent at the old rate don't cut it again during the cooldown
+	limiter.Report(false)
+	limiter.Report(false)
+	assert.Equal(t, 5, limiter.Limit().Count)
+
+	// A run of successes raises it a step at a time, up to the max
+	for range 5 * 10 {
+		limiter.Report(true)
+	}
+	assert.Equal(t, 12, limiter.Limit().Count)
+
+	// It never goes below the min
+	clock.Advance(1 * time.Second)
+	for range 10 {
+		limiter.Report(false)
+		clock.Advance(1 * time.Second)
+	}
+	assert.Equal(t, 2, limiter.Limit().Count)
+
+	stats := limiter.Stats()
+	assert.Equal(t, &limit.AdaptiveStats{Rate: limit.Rate{Count: 2, Per: 1 * time.Second}, Min: 2, Max: 12, Increases: 7, Decreases: 4}, stats.Adaptive)
+}
+
+func TestAdaptiveLimiter_ConcurrentWait(t *testing.T) {
+	t.Parallel()
+
+	limiter := limit.NewAdaptiveLimiter(1000, 10, 1000, 1*time.Millisecond, limit.WithAdditiveIncrease(1, 1))
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				_ = limiter.WaitN(1)
+				limiter.Report(i%2 == 0)
+			}
+		}()
+	}
+	wg.Wait()
+
+	count := limiter.Limit().Count
+	assert.GreaterOrEqual(t, count, 10)
+	assert.LessOrEqual(t, count, 1000)
+	assert.Equal(t, 800, limiter.Stats().AllowedRequests)
+}
+
+func TestAdaptiveLimiter_InvalidBounds(t *testing.T) {
+	t.Parallel()
+
+	assert.PanicsWithValue(t, "limit: NewAdaptiveLimiter: initial count 1 is not within [2, 10]", func() {
+		limit.NewAdaptiveLimiter(1, 2, 10, 1*time.Second)
+	})
+	assert.Panics(t, func() { limit.NewAdaptiveLimiter(1, 0, 10, 1*time.Second) })
+}
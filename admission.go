@@ -0,0 +1,23 @@
+package limit
+
+import (
+	"context"
+	"time"
+)
+
+// Admission describes how a middleware decided on a request it limited, for hooks to annotate traces or logs with.
+type Admission struct {
+	// Key is what the request was limited by: the route name in the HTTP middleware, the key or procedure in
+	// interceptors.
+	Key string
+	// Limiter is the limiter that decided. Its Info is only worth reading if the hook records something.
+	Limiter Limiter
+	// Limited tells whether the request was turned down.
+	Limited bool
+	// Wait is how long the request waited for the limiter, zero where middleware doesn't wait.
+	Wait time.Duration
+}
+
+// AdmissionHook is called with the context of each request a middleware limited once it decided on it, before the
+// request is passed on or turned down. It runs on the request's goroutine, so it must be cheap.
+type AdmissionHook func(ctx context.Context, admission Admission)
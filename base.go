@@ -0,0 +1,252 @@
+package limit
+
+import (
+	"maps"
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+// base holds the state shared by every limiter implementation, which embed it.
+type base struct {
+	// Mutex
+	mux sync.Mutex
+
+	// Config
+	name             string
+	labels           map[string]string
+	clock            Clock
+	ttlFromContext   bool
+	blackouts        blackouts
+	startAt          time.Time
+	maxWait          time.Duration
+	linkReservations bool
+	abandonedAfter   time.Duration
+	onAbandoned      func(info ReservationInfo)
+	stacks           bool
+	clockTolerance   time.Duration
+	onClockAnomaly   func(anomaly ClockAnomaly)
+	oversubscription float64
+	pausePolicy      PausePolicy
+	maxWaiters       int
+	defaultTTL       *time.Duration
+	jitter           *jitter // Set by WithJitter
+	// Set by the embedding limiter, the requests it could allow right now and when it can allow the next one, net of
+	// pending reservations, with the mutex already locked
+	remaining   func() int
+	nextAllowed func() time.Time
+
+	// State
+	allowedEvents int
+	deniedEvents  int
+	deniedReasons map[Reason]int
+	waiters       waitQueue
+	leases        leaseSet
+	abandoned     int
+	anomalies     int
+	// Consumes that failed with ErrOversubscribed
+	oversubscribed int
+	// Reservations detached and not attached yet, by ID and the other way around
+	detached map[string]detachedReservation
+	handles  map[Reservation]ReservationHandle
+	// Called with every decision, by the ID listen returned
+	listeners      map[int]decisionListener
+	nextListenerID int
+	// Set by Close, unlike a blackout it's for good
+	stopped bool
+	// Set by Pause until Resume
+	paused   bool
+	pausedAt time.Time
+}
+
+func (b *base) init(o options) {
+	b.name = o.name
+	b.labels = o.labels
+	b.clock = o.clock
+	b.ttlFromContext = o.ttlFromContext
+	b.blackouts = o.blackouts
+	b.startAt = o.startAt
+	b.maxWait = o.maxWait
+	b.linkReservations = o.linkReservations
+	b.abandonedAfter = o.abandonedAfter
+	b.onAbandoned = o.onAbandoned
+	b.stacks = o.reservationStacks
+	b.clockTolerance = o.clockTolerance
+	b.onClockAnomaly = o.onClockAnomaly
+	b.oversubscription = o.oversubscription
+	b.pausePolicy = o.pausePolicy
+	b.maxWaiters = o.maxWaiters
+	b.defaultTTL = o.defaultTTL
+	b.jitter = newJitter(o)
+	b.deniedReasons = make(map[Reason]int)
+}
+
+// Labels returns a copy of the labels the limiter was created with. They can't change after construction, so reading
+// them takes no lock.
+func (b *base) Labels() map[string]string {
+	return maps.Clone(b.labels)
+}
+
+// countAllowed counts a request the limiter allowed.
+func (b *base) countAllowed() {
+	// This must be called with the mutex already locked
+	b.allowedEvents++
+	b.publish(true, "")
+}
+
+func (b *base) deny(reason Reason) {
+	// This must be called with the mutex already locked
+	b.deniedEvents++
+	b.deniedReasons[reason]++
+	b.publish(false, reason)
+}
+
+// stats returns the stats shared by every limiter, the caller fills in the rest.
+func (b *base) stats() Stats {
+	// This must be called with the mutex already locked
+	return Stats{
+		AllowedRequests:       b.allowedEvents,
+		DeniedRequests:        b.deniedEvents,
+		DeniedByReason:        maps.Clone(b.deniedReasons),
+		NextAllowedTime:       b.afterClosed(b.nextAllowed()),
+		Leases:                b.leases.stats(),
+		AbandonedReservations: b.abandoned,
+		ClockAnomalies:        b.anomalies,
+		Oversubscribed:        b.oversubscribed,
+		Paused:                b.paused,
+		QueueDepth:            len(b.waiters.waiters),
+		Remaining:             b.remainingNow(),
+	}
+}
+
+// retryAfter returns how long until the limiter can allow the next request, the NextAllowedTime of its stats, or zero
+// if that's unknown.
+func (b *base) retryAfter() time.Duration {
+	// This must be called with the mutex already locked
+	next := b.afterClosed(b.nextAllowed())
+	if next.IsZero() {
+		return 0
+	}
+	return max(next.Sub(b.clock.Now()), 0)
+}
+
+// remainingNow returns the requests the limiter could allow right now net of pending reservations, none while it's
+// closed or paused and once it's stopped.
+func (b *base) remainingNow() int {
+	// This must be called with the mutex already locked
+	if !b.closedUntil().IsZero() || b.paused || b.stopped {
+		return 0
+	}
+	return max(b.remaining(), 0)
+}
+
+// info builds the LimitInfo of a limiter, which has nothing remaining while it's closed or paused and once it's
+// stopped.
+func (b *base) info(limit, remaining int, reset time.Time, window time.Duration) LimitInfo {
+	// This must be called with the mutex already locked
+	if !b.closedUntil().IsZero() || b.paused || b.stopped {
+		remaining = 0
+	}
+	return LimitInfo{Limit: limit, Remaining: max(remaining, 0), Reset: reset, Window: window}
+}
+
+// reservationAges returns how long ago the pending reservations were taken, oldest first and at most n of them.
+func (b *base) reservationAges(reservedAt []time.Time, n int) []time.Duration {
+	// This must be called with the mutex already locked
+	slices.SortFunc(reservedAt, time.Time.Compare)
+	reservedAt = reservedAt[:min(len(reservedAt), max(n, 0))]
+	now := b.clock.Now()
+	ages := make([]time.Duration, 0, len(reservedAt))
+	for _, at := range reservedAt {
+		ages = append(ages, now.Sub(at))
+	}
+	return ages
+}
+
+// retryIn returns how long until next, pushed to when the limiter opens if it's closed then, or fallback if it's
+// unknown.
+func (b *base) retryIn(next time.Time, fallback time.Duration) time.Duration {
+	// This must be called with the mutex already locked
+	next = b.afterClosed(next)
+	if next.IsZero() {
+		return fallback
+	}
+	return next.Sub(b.clock.Now())
+}
+
+// waitUntil returns how long a caller arriving now waits for next, the NextAllowedTime of the stats: zero if it
+// isn't in the future, and the longest duration if it's the zero time.
+func (b *base) waitUntil(next time.Time) time.Duration {
+	if next.IsZero() {
+		return math.MaxInt64
+	}
+	return max(next.Sub(b.clock.Now()), 0)
+}
+
+// closedUntil returns when the limiter opens if it's closed now, because it hasn't started yet or is in a blackout,
+// or the zero time if it's open.
+func (b *base) closedUntil() time.Time {
+	// This must be called with the mutex already locked
+	now := b.clock.Now()
+	if now.Before(b.startAt) {
+		return b.afterClosed(b.startAt)
+	}
+	return b.blackouts.end(now)
+}
+
+// afterClosed returns when the limiter opens if it's closed at next, or next if it's open then. It returns the zero
+// time while the limiter is paused, there's no telling when it resumes.
+func (b *base) afterClosed(next time.Time) time.Time {
+	// This must be called with the mutex already locked
+	if next.IsZero() || b.paused {
+		return time.Time{}
+	}
+	if next.Before(b.startAt) {
+		next = b.startAt
+	}
+	if end := b.blackouts.end(next); !end.IsZero() {
+		return end
+	}
+	return next
+}
+
+// limitedReason returns why a request the limiter had to turn down was denied.
+func (b *base) limitedReason() Reason {
+	// This must be called with the mutex already locked
+	switch {
+	case b.clock.Now().Before(b.startAt):
+		return ReasonNotStarted
+	case !b.closedUntil().IsZero():
+		return ReasonBlackout
+	case b.paused:
+		return ReasonPaused
+	default:
+		return ReasonLimited
+	}
+}
+
+// clockStepped records that a timestamp of the limiter was ahead of the clock and was pulled back to it, reporting it
+// as a ClockAnomaly if it was further ahead than the tolerance.
+func (b *base) clockStepped(ahead time.Duration) {
+	// This must be called with the mutex already locked
+	if ahead <= b.clockTolerance {
+		return
+	}
+
+	b.anomalies++
+	if b.onClockAnomaly != nil {
+		// Called on its own goroutine, the limiter is locked
+		go b.onClockAnomaly(ClockAnomaly{Limiter: b.name, Ahead: ahead, At: b.clock.Now()})
+	}
+}
+
+// reservationRoom returns how many reservations may be pending against free capacity, more than free with
+// WithReservationOversubscription.
+func (b *base) reservationRoom(free int) int {
+	// This must be called with the mutex already locked
+	if b.oversubscription <= 1 {
+		return free
+	}
+	return int(math.Floor(float64(free) * b.oversubscription))
+}
@@ -0,0 +1,69 @@
+package limit
+
+import "time"
+
+// ClockRange is a daily time range, given as offsets from midnight, e.g. 00:30 to 00:45 is
+// ClockRange{Start: 30 * time.Minute, End: 45 * time.Minute}. A range whose End is before its Start crosses midnight,
+// and one whose End equals its Start is empty.
+type ClockRange struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// blackouts are the daily ranges during which a limiter denies every request.
+type blackouts struct {
+	ranges []ClockRange
+	loc    *time.Location
+}
+
+// end returns when the blackout t falls in ends, following windows that start right as it ends, or the zero time if
+// t isn't in a blackout.
+func (bs blackouts) end(t time.Time) time.Time {
+	if len(bs.ranges) == 0 {
+		return time.Time{}
+	}
+
+	var end time.Time
+	// Windows covering the whole day never end, stop following them after a while and check again then
+	for range 2*len(bs.ranges) + 1 {
+		next := bs.windowEnd(t)
+		if next.IsZero() {
+			break
+		}
+		end, t = next, next
+	}
+	return end
+}
+
+// windowEnd returns the end of the latest ending window t falls in, or the zero time if there is none.
+func (bs blackouts) windowEnd(t time.Time) time.Time {
+	var end time.Time
+	local := t.In(bs.loc)
+	year, month, day := local.Date()
+	for _, r := range bs.ranges {
+		if r.Start == r.End {
+			continue
+		}
+
+		// The window may have started the day before if it crosses midnight
+		for _, startDay := range []int{day - 1, day} {
+			endDay := startDay
+			if r.End < r.Start {
+				endDay++
+			}
+
+			windowStart := wallClock(year, month, startDay, r.Start, bs.loc)
+			windowEnd := wallClock(year, month, endDay, r.End, bs.loc)
+			if !t.Before(windowStart) && t.Before(windowEnd) && windowEnd.After(end) {
+				end = windowEnd
+			}
+		}
+	}
+	return end
+}
+
+// wallClock returns the time the clocks in loc show offset past midnight on the given day. Across a DST shift that is
+// not offset after midnight, e.g. 03:00 is two hours after midnight on the day the clocks skip from 02:00 to 03:00.
+func wallClock(year int, month time.Month, day int, offset time.Duration, loc *time.Location) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, int(offset), loc)
+}
@@ -0,0 +1,162 @@
+package limit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limittest"
+	"github.com/stretchr/testify/assert"
+)
+
+// maintenance is the provider's nightly maintenance window, 00:30 to 00:45.
+var maintenance = []limit.ClockRange{{Start: 30 * time.Minute, End: 45 * time.Minute}}
+
+func TestLimiter_Blackout_Boundaries(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range limiterConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := limittest.NewFakeClock(time.Date(2026, time.June, 1, 0, 29, 59, 0, time.UTC))
+			limiter := newLimiter(100, 1*time.Second, limit.WithClock(clock), limit.WithBlackouts(maintenance, time.UTC))
+
+			assert.True(t, limiter.Allowed())
+
+			clock.Advance(1 * time.Second)
+			assert.False(t, limiter.Allowed())
+			stats := limiter.Stats()
+			assert.Equal(t, map[limit.Reason]int{limit.ReasonBlackout: 1}, stats.DeniedByReason)
+			assert.Equal(t, time.Date(2026, time.June, 1, 0, 45, 0, 0, time.UTC), stats.NextAllowedTime)
+
+			clock.Advance(15*time.Minute - 1*time.Millisecond)
+			assert.False(t, limiter.Allowed())
+
+			clock.Advance(1 * time.Millisecond)
+			assert.True(t, limiter.Allowed())
+			assert.Equal(t, 2, limiter.Stats().AllowedRequests)
+		})
+	}
+}
+
+func TestLimiter_Blackout_WaitSleepsUntilWindowEnds(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range limiterConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := limittest.NewFakeClock(time.Date(2026, time.June, 1, 0, 30, 0, 0, time.UTC))
+			limiter := newLimiter(100, 1*time.Second, limit.WithClock(clock), limit.WithBlackouts(maintenance, time.UTC))
+
+			done := make(chan error, 1)
+			go func() { done <- limiter.WaitContext(context.Background()) }()
+
+			clock.BlockUntil(1)
+			clock.Advance(15*time.Minute - 1*time.Millisecond)
+			select {
+			case <-done:
+				t.Fatal("the wait should last until the window ends")
+			case <-time.After(10 * time.Millisecond):
+			}
+
+			clock.Advance(1 * time.Millisecond)
+			assert.NoError(t, <-done)
+			assert.Equal(t, time.Date(2026, time.June, 1, 0, 45, 0, 0, time.UTC), clock.Now())
+		})
+	}
+}
+
+func TestLimiter_Blackout_WaitHonorsContext(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range limiterConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := limittest.NewFakeClock(time.Date(2026, time.June, 1, 0, 30, 0, 0, time.UTC))
+			limiter := newLimiter(100, 1*time.Second, limit.WithClock(clock), limit.WithBlackouts(maintenance, time.UTC))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() { done <- limiter.WaitContext(ctx) }()
+
+			clock.BlockUntil(1)
+			cancel()
+			assert.ErrorIs(t, <-done, context.Canceled)
+			assert.Equal(t, 0, limiter.Stats().AllowedRequests)
+		})
+	}
+}
+
+func TestLimiter_Blackout_CrossesMidnight(t *testing.T) {
+	t.Parallel()
+
+	// 23:00 to 01:00 three hours east of UTC
+	loc := time.FixedZone("UTC+3", 3*60*60)
+	clock := limittest.NewFakeClock(time.Date(2026, time.June, 1, 22, 59, 0, 0, loc))
+	limiter := limit.NewTokenBucket(100, 1*time.Second, limit.WithClock(clock),
+		limit.WithBlackouts([]limit.ClockRange{{Start: 23 * time.Hour, End: 1 * time.Hour}}, loc))
+
+	assert.True(t, limiter.Allowed())
+
+	end := time.Date(2026, time.June, 2, 1, 0, 0, 0, loc)
+	for _, advance := range []time.Duration{1 * time.Minute, 1 * time.Hour, 59 * time.Minute} {
+		clock.Advance(advance)
+		assert.False(t, limiter.Allowed(), "at %s", clock.Now().In(loc))
+		assert.True(t, end.Equal(limiter.Stats().NextAllowedTime), "at %s", clock.Now().In(loc))
+	}
+
+	clock.Advance(1 * time.Minute)
+	assert.True(t, limiter.Allowed())
+}
+
+func TestLimiter_Blackout_BackToBackWindows(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Date(2026, time.June, 1, 0, 30, 0, 0, time.UTC))
+	limiter := limit.NewRollingWindow(100, 1*time.Second, limit.WithClock(clock), limit.WithBlackouts([]limit.ClockRange{
+		{Start: 30 * time.Minute, End: 45 * time.Minute},
+		{Start: 45 * time.Minute, End: 1 * time.Hour},
+	}, time.UTC))
+
+	// The second window starts as the first ends, so the limiter opens at the end of the second
+	assert.False(t, limiter.Allowed())
+	assert.Equal(t, time.Date(2026, time.June, 1, 1, 0, 0, 0, time.UTC), limiter.Stats().NextAllowedTime)
+}
+
+func TestLimiter_Blackout_DSTShift(t *testing.T) {
+	t.Parallel()
+
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("time zone database unavailable:", err)
+	}
+
+	// On March 8 2026 the clocks in New York skip from 02:00 to 03:00, so 01:00 to 03:00 lasts an hour
+	clock := limittest.NewFakeClock(time.Date(2026, time.March, 8, 1, 30, 0, 0, loc))
+	limiter := limit.NewTokenBucket(100, 1*time.Second, limit.WithClock(clock),
+		limit.WithBlackouts([]limit.ClockRange{{Start: 1 * time.Hour, End: 3 * time.Hour}}, loc))
+
+	assert.False(t, limiter.Allowed())
+	assert.True(t, time.Date(2026, time.March, 8, 3, 0, 0, 0, loc).Equal(limiter.Stats().NextAllowedTime))
+
+	clock.Advance(30 * time.Minute)
+	assert.Equal(t, 3, clock.Now().In(loc).Hour())
+	assert.True(t, limiter.Allowed())
+}
+
+func TestLease_InheritsBlackouts(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Date(2026, time.June, 1, 0, 30, 0, 0, time.UTC))
+	parent := limit.NewTokenBucket(100, 1*time.Second, limit.WithClock(clock), limit.WithBlackouts(maintenance, time.UTC))
+	lease, err := parent.(limit.Leaser).AcquireLease(limit.Rate{Count: 10, Per: 1 * time.Second}, 1*time.Hour)
+	assert.NoError(t, err)
+
+	assert.False(t, lease.Allowed())
+	clock.Advance(15 * time.Minute)
+	assert.True(t, lease.Allowed())
+}
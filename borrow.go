@@ -0,0 +1,533 @@
+package limit
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// QuotaSource hands out tokens of a central quota, e.g. a counter in Redis shared by every instance. It's only called
+// in the background, so a slow source doesn't hold up requests.
+type QuotaSource interface {
+	// Borrow takes up to n tokens from the quota, returning how many it granted, fewer than n if it's running out.
+	Borrow(n int) (granted int, err error)
+	// Return gives back n tokens that were borrowed but not used.
+	Return(n int)
+}
+
+// BorrowingLimiter is a Limiter serving tokens borrowed in chunks from a QuotaSource.
+type BorrowingLimiter interface {
+	Limiter
+	// Borrowed returns the tokens borrowed and not used yet, including the ones held by pending reservations.
+	Borrowed() int
+	// ReturnUnused stops borrowing and returns the tokens not used nor held by pending reservations to the source,
+	// e.g. on shutdown, returning how many it gave back. Afterward the limiter only allows the pending reservations.
+	ReturnUnused() int
+}
+
+type borrowing struct {
+	base
+
+	// Config
+	source   QuotaSource
+	chunk    int
+	lowWater int
+	trickle  time.Duration // The interval between requests allowed while the source fails
+	backoff  time.Duration
+
+	// State
+	tokens      int
+	inFlight    bool      // A Borrow call is running
+	retryAt     time.Time // No borrowing before then, after the source failed or granted a short chunk
+	failing     bool
+	nextTrickle time.Time
+	returned    bool
+
+	// Reservations tracking
+	pendingReservations map[*borrowingReservation]struct{}
+}
+
+// NewBorrowingLimiter creates a limiter serving tokens it borrows from remote chunk at a time, so only one request in
+// a chunk pays for a call to the source. A chunk is borrowed in the background whenever the tokens left drop below
+// lowWater, and the first one when the limiter is created. While the source fails, or after it granted a short chunk,
+// the limiter waits a second, or as set with WithBorrowBackoff, before borrowing again. Meanwhile it allows the tokens
+// it has left, and while the source fails one request per second, or as set with WithTrickleRate.
+func NewBorrowingLimiter(remote QuotaSource, chunk int, lowWater int, opts ...Option) BorrowingLimiter {
+	o := newOptions(opts)
+	b := &borrowing{
+		source:              remote,
+		chunk:               chunk,
+		lowWater:            lowWater,
+		trickle:             o.trickleRate.Per / time.Duration(max(o.trickleRate.Count, 1)),
+		backoff:             o.borrowBackoff,
+		pendingReservations: make(map[*borrowingReservation]struct{}),
+	}
+	b.init(o)
+	b.remaining = func() int { return b.tokens - reservedUnits(b.pendingReservations) }
+	b.nextAllowed = func() time.Time { return b.nextAllowedTime(1) }
+
+	b.inFlight = true
+	b.borrow()
+	return b
+}
+
+// borrowIfLow starts borrowing a chunk in the background if the tokens left dropped below the low water mark.
+func (b *borrowing) borrowIfLow() {
+	// This must be called with the mutex already locked
+	if b.inFlight || b.returned || b.clock.Now().Before(b.retryAt) {
+		return
+	}
+	if b.tokens-reservedUnits(b.pendingReservations) >= b.lowWater {
+		return
+	}
+
+	b.inFlight = true
+	go b.borrow()
+}
+
+// borrow borrows a chunk from the source and adds it to the tokens, falling back to the trickle rate if it fails.
+func (b *borrowing) borrow() {
+	granted, err := b.source.Borrow(b.chunk)
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.inFlight = false
+	if err != nil {
+		b.failing = true
+		b.retryAt = b.clock.Now().Add(b.backoff)
+		return
+	}
+	if b.returned {
+		// The limiter was shut down while borrowing
+		go b.source.Return(granted)
+		return
+	}
+
+	b.failing = false
+	b.tokens += granted
+	if granted < b.chunk {
+		// The quota is running out, don't ask again on every request
+		b.retryAt = b.clock.Now().Add(b.backoff)
+	}
+	b.waiters.notify()
+}
+
+func (b *borrowing) WaitContext(ctx context.Context) error {
+	return b.WaitNContext(ctx, 1)
+}
+
+func (b *borrowing) WaitN(n int) error {
+	return b.WaitNContext(context.Background(), n)
+}
+
+func (b *borrowing) WaitNTimeout(timeout time.Duration, n int) error {
+	ctx, cancel := withTimeout(b.clock, timeout)
+	defer cancel()
+	return b.WaitNContext(ctx, n)
+}
+
+// WaitNContext waits for n borrowed tokens, failing right away if n is more than a chunk. While the source fails,
+// the trickle rate only allows single requests.
+func (b *borrowing) WaitNContext(ctx context.Context, n int) error {
+	if n <= 0 {
+		return nil
+	}
+
+	return b.await(ctx, func() (bool, time.Duration, error) {
+		if err := b.costErrLocked(n); err != nil {
+			return false, 0, err
+		}
+
+		ok, retryIn := b.tryTakeLocked(n)
+		return ok, retryIn, nil
+	}, nil)
+}
+
+// costErrLocked denies n requests and returns an error if they exceed a chunk, nil otherwise.
+func (b *borrowing) costErrLocked(n int) error {
+	// This must be called with the mutex already locked
+	if n > b.chunk {
+		b.deny(ReasonLimited)
+		return fmt.Errorf("cost %d exceeds the chunk of %d", n, b.chunk)
+	}
+	return nil
+}
+
+func (b *borrowing) Wait() {
+	_ = b.WaitContext(context.Background())
+}
+
+func (b *borrowing) WaitTimeout(timeout time.Duration) error {
+	ctx, cancel := withTimeout(b.clock, timeout)
+	defer cancel()
+	return b.WaitContext(ctx)
+}
+
+func (b *borrowing) AllowedReport() (AdmitReport, bool) {
+	return b.allowReport(func() bool { return b.allowLocked(1) })
+}
+
+func (b *borrowing) WaitContextReport(ctx context.Context) (AdmitReport, error) {
+	return waitReport(ctx, b.WaitContext)
+}
+
+func (b *borrowing) Allowed() bool {
+	return b.AllowN(1)
+}
+
+// AllowN allows n requests from the borrowed tokens. While the source fails, the trickle rate only allows single
+// requests.
+func (b *borrowing) AllowN(n int) bool {
+	if n <= 0 {
+		return true
+	}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.allowLocked(n)
+}
+
+func (b *borrowing) allowLocked(n int) bool {
+	// This must be called with the mutex already locked
+	if b.denyHalted() {
+		return false
+	}
+	if ok, _ := b.tryTakeLocked(n); ok {
+		return true
+	}
+
+	b.deny(b.limitedReason())
+	return false
+}
+
+// tryTakeLocked takes n borrowed tokens, or the next request of the trickle rate if the source fails and n is 1,
+// otherwise it returns how long until it's worth trying again.
+func (b *borrowing) tryTakeLocked(n int) (bool, time.Duration) {
+	// This must be called with the mutex already locked
+	fromTrickle, ok := b.availableLocked(n)
+	if !ok || !b.closedUntil().IsZero() {
+		return false, b.retryIn(b.nextAllowedTime(n), b.backoff)
+	}
+
+	if fromTrickle {
+		b.nextTrickle = b.clock.Now().Add(b.trickle)
+	} else {
+		b.tokens -= n
+	}
+	b.countAllowed()
+	b.borrowIfLow()
+	return true, 0
+}
+
+// tryReserveLocked reserves n borrowed tokens, or the next request of the trickle rate if the source fails and n is 1,
+// otherwise it returns how long until it's worth trying again.
+func (b *borrowing) tryReserveLocked(ctx context.Context, n int, reservationTTL *time.Duration) (*borrowingReservation, time.Duration) {
+	// This must be called with the mutex already locked
+	fromTrickle, ok := b.availableLocked(n)
+	if !ok || !b.closedUntil().IsZero() {
+		return nil, b.retryIn(b.nextAllowedTime(n), b.backoff)
+	}
+
+	reservation := &borrowingReservation{
+		limiter:     b,
+		n:           n,
+		reservedAt:  b.clock.Now(),
+		expiresAt:   b.expiryFor(ctx, reservationTTL),
+		fromTrickle: fromTrickle,
+	}
+	if fromTrickle {
+		// The trickle request is used up by reserving it
+		b.nextTrickle = b.clock.Now().Add(b.trickle)
+	} else {
+		b.pendingReservations[reservation] = struct{}{}
+	}
+	b.watchAbandoned(reservation.reservedAt, func() bool {
+		return pendingAt(b.clock.Now(), reservation.consumed, reservation.canceled, reservation.expiresAt)
+	})
+	b.borrowIfLow()
+	return reservation, 0
+}
+
+// availableLocked borrows a chunk if the tokens are low and reports whether n requests can be allowed, and whether
+// they would come from the trickle rate rather than from the borrowed tokens.
+func (b *borrowing) availableLocked(n int) (fromTrickle, ok bool) {
+	// This must be called with the mutex already locked
+	b.cleanupExpiredReservations()
+	b.borrowIfLow()
+	if b.tokens-reservedUnits(b.pendingReservations) >= n {
+		return false, true
+	}
+	return true, n == 1 && b.failing && !b.returned && !b.clock.Now().Before(b.nextTrickle)
+}
+
+// nextAllowedTime returns when n requests can be allowed, the next request of the trickle rate if the source fails
+// and n is 1, or the zero time if only a borrowed chunk can allow them.
+func (b *borrowing) nextAllowedTime(n int) time.Time {
+	// This must be called with the mutex already locked
+	now := b.clock.Now()
+	if b.tokens-reservedUnits(b.pendingReservations) >= n {
+		return now
+	}
+	if n == 1 && b.failing && !b.returned {
+		if b.nextTrickle.After(now) {
+			return b.nextTrickle
+		}
+		return now
+	}
+	return time.Time{}
+}
+
+func (b *borrowing) cleanupExpiredReservations() {
+	// This must be called with the mutex already locked
+	now := b.clock.Now()
+	for res := range b.pendingReservations {
+		if res.expiresAt != nil && now.After(*res.expiresAt) {
+			delete(b.pendingReservations, res)
+		}
+	}
+}
+
+// Clear cancels the pending reservations. The borrowed tokens are kept, they were taken from the quota.
+func (b *borrowing) Clear() {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.cancelReservationsLocked()
+	b.nextTrickle = time.Time{}
+	b.waiters.notify()
+}
+
+func (b *borrowing) Close() error {
+	return b.close(b.cancelReservationsLocked)
+}
+
+// cancelReservationsLocked cancels the pending reservations.
+func (b *borrowing) cancelReservationsLocked() {
+	// This must be called with the mutex already locked
+	for res := range b.pendingReservations {
+		res.canceled = true
+	}
+
+	b.pendingReservations = make(map[*borrowingReservation]struct{})
+}
+
+func (b *borrowing) Stats() Stats {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.cleanupExpiredReservations()
+
+	stats := b.stats()
+	stats.PendingReservations = len(b.pendingReservations)
+	return stats
+}
+
+// Info reports the chunk as the limit and the borrowed tokens left as remaining. There is no reset, tokens come when
+// a chunk is borrowed.
+func (b *borrowing) Info() LimitInfo {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.cleanupExpiredReservations()
+
+	return b.info(b.chunk, b.tokens-reservedUnits(b.pendingReservations), time.Time{}, 0)
+}
+
+// Available returns the borrowed tokens left net of pending reservations.
+func (b *borrowing) Available() int {
+	return b.Info().Remaining
+}
+
+func (b *borrowing) EstimatedWait() time.Duration {
+	return b.waitUntil(b.Stats().NextAllowedTime)
+}
+
+// Config has no rate, the source decides how many tokens the limiter gets.
+func (b *borrowing) Config() Config {
+	return Config{Algorithm: AlgorithmBorrowing}
+}
+
+func (b *borrowing) PendingReservationAges(n int) []time.Duration {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.cleanupExpiredReservations()
+
+	reservedAt := make([]time.Time, 0, len(b.pendingReservations))
+	for res := range b.pendingReservations {
+		reservedAt = append(reservedAt, res.reservedAt)
+	}
+	return b.reservationAges(reservedAt, n)
+}
+
+func (b *borrowing) Borrowed() int {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.tokens
+}
+
+func (b *borrowing) ReturnUnused() int {
+	b.mux.Lock()
+	b.cleanupExpiredReservations()
+	b.returned = true
+	unused := max(b.tokens-reservedUnits(b.pendingReservations), 0)
+	b.tokens -= unused
+	b.mux.Unlock()
+
+	// Called without holding the lock, the source may be slow
+	if unused > 0 {
+		b.source.Return(unused)
+	}
+	return unused
+}
+
+func (b *borrowing) TryReserve(reservationTTL *time.Duration) (Reservation, bool) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if reservation, _ := b.tryReserveLocked(context.Background(), 1, reservationTTL); reservation != nil {
+		return reservation, true
+	}
+
+	b.deny(b.limitedReason())
+	return nil, false
+}
+
+func (b *borrowing) Reserve(reservationTTL *time.Duration) Reservation {
+	reservation, err := b.ReserveContext(context.Background(), reservationTTL)
+	if err != nil {
+		return failedReservation{err: err}
+	}
+	return reservation
+}
+
+func (b *borrowing) ReserveTimeout(timeout time.Duration, reservationTTL *time.Duration) (Reservation, error) {
+	ctx, cancel := withTimeout(b.clock, timeout)
+	defer cancel()
+	return b.ReserveContext(ctx, reservationTTL)
+}
+
+func (b *borrowing) Permits(ctx context.Context) <-chan struct{} {
+	return deliverPermits(ctx, func(ctx context.Context) (permit, error) {
+		return reservePermit(ctx, b)
+	})
+}
+
+func (b *borrowing) ReserveContext(ctx context.Context, reservationTTL *time.Duration) (Reservation, error) {
+	return b.ReserveN(ctx, 1, reservationTTL)
+}
+
+func (b *borrowing) ReserveN(ctx context.Context, n int, reservationTTL *time.Duration) (Reservation, error) {
+	if err := reserveCostErr(n); err != nil {
+		return nil, err
+	}
+
+	var reservation *borrowingReservation
+	err := b.awaitReservation(ctx, func() (bool, time.Duration, error) {
+		if err := b.costErrLocked(n); err != nil {
+			return false, 0, err
+		}
+
+		var retryIn time.Duration
+		reservation, retryIn = b.tryReserveLocked(ctx, n, reservationTTL)
+		return reservation != nil, retryIn, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	b.link(ctx, reservation)
+	return reservation, nil
+}
+
+// borrowingReservation implements the Reservation interface
+type borrowingReservation struct {
+	limiter     *borrowing
+	n           int // Tokens held, one if from the trickle rate
+	reservedAt  time.Time
+	expiresAt   *time.Time
+	fromTrickle bool // Holds a request of the trickle rate rather than a borrowed token
+	consumed    bool
+	canceled    bool
+}
+
+func (r *borrowingReservation) Consume() error {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	if r.consumed {
+		return ErrReservationConsumed
+	}
+
+	if r.canceled {
+		return ErrReservationCanceled
+	}
+
+	if r.expiresAt != nil && r.limiter.clock.Now().After(*r.expiresAt) {
+		delete(r.limiter.pendingReservations, r)
+		return ErrReservationExpired
+	}
+
+	r.consumed = true
+	delete(r.limiter.pendingReservations, r)
+	if !r.fromTrickle {
+		r.limiter.tokens -= r.n
+	}
+	r.limiter.countAllowed()
+
+	return nil
+}
+
+// ConsumeContext is Consume, which never blocks.
+func (r *borrowingReservation) ConsumeContext(context.Context) error {
+	return r.Consume()
+}
+
+func (r *borrowingReservation) units() int {
+	return r.n
+}
+
+func (r *borrowingReservation) Cancel() {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	if !r.consumed {
+		r.canceled = true
+		delete(r.limiter.pendingReservations, r)
+		// The borrowed token is free again, a request of the trickle rate isn't given back
+		r.limiter.waiters.notify()
+	}
+}
+
+func (r *borrowingReservation) Detach() (ReservationHandle, error) {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	state := func() error {
+		return reservationErr(r.limiter.clock.Now(), r.consumed, r.canceled, r.expiresAt)
+	}
+	if err := state(); err != nil {
+		return ReservationHandle{}, err
+	}
+	return r.limiter.detach(r, r.expiresAt, state), nil
+}
+
+// ReadyAt is now, Consume never blocks.
+func (r *borrowingReservation) ReadyAt() time.Time {
+	return r.limiter.clock.Now()
+}
+
+func (r *borrowingReservation) Delay() time.Duration {
+	return 0
+}
+
+func (r *borrowingReservation) ExpiresAt() (time.Time, bool) {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	return expiry(r.expiresAt)
+}
+
+func (r *borrowingReservation) State() ReservationState {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	return reservationState(r.limiter.clock.Now(), r.consumed, r.canceled, r.expiresAt)
+}
@@ -0,0 +1,130 @@
+package limit_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limittest"
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingQuota is a quota source whose borrows after the first block until unblocked, like a slow network.
+type blockingQuota struct {
+	*limittest.MemoryQuota
+	unblock chan struct{}
+}
+
+func (q *blockingQuota) Borrow(n int) (int, error) {
+	if q.Borrows() > 0 {
+		<-q.unblock
+	}
+	return q.MemoryQuota.Borrow(n)
+}
+
+func TestBorrowingLimiter(t *testing.T) {
+	t.Parallel()
+
+	quota := limittest.NewMemoryQuota(250)
+	limiter := limit.NewBorrowingLimiter(quota, 100, 20)
+
+	// The first chunk is borrowed on creation
+	assert.Equal(t, 100, limiter.Borrowed())
+	assert.Equal(t, 150, quota.Available())
+
+	// Dropping below the low water mark borrows the next chunk in the background
+	for i := 0; i < 80; i++ {
+		assert.True(t, limiter.Allowed())
+	}
+	assert.Equal(t, 1, quota.Borrows())
+	assert.True(t, limiter.Allowed())
+	assert.Eventually(t, func() bool { return limiter.Borrowed() == 119 }, 1*time.Second, 1*time.Millisecond)
+	assert.Equal(t, 2, quota.Borrows())
+	assert.Equal(t, 50, quota.Available())
+
+	// Unused tokens are given back on shutdown, except those held by reservations
+	reservation := limiter.Reserve(nil)
+	assert.Equal(t, 118, limiter.ReturnUnused())
+	assert.Equal(t, 168, quota.Available())
+	assert.False(t, limiter.Allowed())
+	assert.NoError(t, reservation.Consume())
+	assert.Equal(t, 0, limiter.Borrowed())
+}
+
+func TestBorrowingLimiter_NeverBlocksOnTheSource(t *testing.T) {
+	t.Parallel()
+
+	quota := &blockingQuota{MemoryQuota: limittest.NewMemoryQuota(1000), unblock: make(chan struct{})}
+	limiter := limit.NewBorrowingLimiter(quota, 10, 5)
+
+	// Requests are served from the tokens left while the source is slow, then denied
+	for i := 0; i < 10; i++ {
+		assert.True(t, limiter.Allowed())
+	}
+	assert.False(t, limiter.Allowed())
+	assert.Equal(t, 1, limiter.Stats().DeniedByReason[limit.ReasonLimited])
+
+	// Waiters are let through once the chunk arrives
+	done := make(chan error)
+	go func() {
+		done <- limiter.WaitContext(context.Background())
+	}()
+	close(quota.unblock)
+	assert.NoError(t, <-done)
+}
+
+func TestBorrowingLimiter_Trickle(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Now())
+	quota := limittest.NewMemoryQuota(1000)
+	quota.Fail(errors.New("connection refused"))
+	limiter := limit.NewBorrowingLimiter(quota, 100, 20, limit.WithClock(clock),
+		limit.WithTrickleRate(limit.Rate{Count: 2, Per: 1 * time.Second}), limit.WithBorrowBackoff(5*time.Second))
+
+	// While the source fails, requests trickle through at the trickle rate
+	assert.True(t, limiter.Allowed())
+	assert.False(t, limiter.Allowed())
+	clock.Advance(500 * time.Millisecond)
+	assert.True(t, limiter.Allowed())
+	assert.Equal(t, clock.Now().Add(500*time.Millisecond), limiter.Stats().NextAllowedTime)
+
+	// The source isn't asked again before the backoff passes
+	quota.Fail(nil)
+	clock.Advance(1 * time.Second)
+	limiter.Allowed()
+	assert.Equal(t, 1, quota.Borrows())
+
+	clock.Advance(4 * time.Second)
+	limiter.Allowed()
+	assert.Eventually(t, func() bool { return limiter.Borrowed() == 100 }, 1*time.Second, 1*time.Millisecond)
+	assert.Equal(t, 2, quota.Borrows())
+}
+
+func TestBorrowingLimiter_ShortChunk(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Now())
+	quota := limittest.NewMemoryQuota(50)
+	limiter := limit.NewBorrowingLimiter(quota, 100, 20, limit.WithClock(clock))
+	assert.Equal(t, 50, limiter.Borrowed())
+
+	// The quota ran out, so it isn't asked again on every request
+	for limiter.Allowed() {
+	}
+	assert.Equal(t, 1, quota.Borrows())
+	assert.True(t, limiter.Stats().NextAllowedTime.IsZero())
+
+	// A waiter tries again after the backoff, borrowing what the quota got in the meantime
+	done := make(chan error)
+	go func() {
+		done <- limiter.WaitContext(context.Background())
+	}()
+	clock.BlockUntil(1)
+	quota.Add(100)
+	clock.Advance(1 * time.Second)
+	assert.NoError(t, <-done)
+	assert.Eventually(t, func() bool { return limiter.Borrowed() == 99 }, 1*time.Second, 1*time.Millisecond)
+}
@@ -0,0 +1,569 @@
+package limit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// QuotaPeriod is the period a budget is spread over.
+type QuotaPeriod int
+
+const (
+	// QuotaDaily resets the budget at midnight.
+	QuotaDaily QuotaPeriod = iota
+	// QuotaWeekly resets the budget at midnight between Sunday and Monday.
+	QuotaWeekly
+	// QuotaMonthly resets the budget at midnight of the first day of the month.
+	QuotaMonthly
+)
+
+// start returns when the period containing t started, in loc.
+func (p QuotaPeriod) start(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	switch p {
+	case QuotaWeekly:
+		daysSinceMonday := (int(t.Weekday()) + 6) % 7
+		return time.Date(t.Year(), t.Month(), t.Day()-daysSinceMonday, 0, 0, 0, 0, loc)
+	case QuotaMonthly:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	}
+}
+
+// next returns when the period starting at start ends.
+func (p QuotaPeriod) next(start time.Time) time.Time {
+	switch p {
+	case QuotaWeekly:
+		return start.AddDate(0, 0, 7)
+	case QuotaMonthly:
+		return start.AddDate(0, 1, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+// Budget is a Limiter spreading a budget evenly over each period, e.g. a vendor plan of 1M calls a month.
+type Budget interface {
+	Limiter
+	// UsedThisPeriod returns the number of requests allowed since the current period started.
+	UsedThisPeriod() int
+	// ProjectedExhaustion returns when the budget runs out at the average pace of the current period, or the zero time
+	// if it doesn't run out before the period ends.
+	ProjectedExhaustion() time.Time
+	// SetBudget changes the budget of the current and following periods, keeping what was used so far.
+	SetBudget(total int)
+}
+
+type budget struct {
+	base
+
+	// Config
+	total  int
+	period QuotaPeriod
+	loc    *time.Location
+	ahead  int
+
+	// State
+	used        int
+	periodStart time.Time
+	periodEnd   time.Time
+
+	// Reservations tracking
+	pendingReservations map[*budgetReservation]struct{}
+}
+
+// NewBudget creates a limiter allowing total requests per period, with the period boundaries in loc.
+// The allowance grows with the elapsed fraction of the period, so at any time the requests allowed so far are at most
+// the elapsed fraction of total plus the amount set with WithBudgetAhead.
+func NewBudget(total int, period QuotaPeriod, loc *time.Location, opts ...Option) Budget {
+	o := newOptions(opts)
+	b := &budget{
+		total:               total,
+		period:              period,
+		loc:                 loc,
+		ahead:               o.budgetAhead,
+		pendingReservations: make(map[*budgetReservation]struct{}),
+	}
+	b.init(o)
+	b.remaining = func() int { return b.allowanceLocked(b.clock.Now()) - b.used - reservedUnits(b.pendingReservations) }
+	b.nextAllowed = func() time.Time { return b.nextAllowedTime(1) }
+	b.periodStart = period.start(o.clock.Now(), loc)
+	b.periodEnd = period.next(b.periodStart)
+	return b
+}
+
+func (b *budget) WaitContext(ctx context.Context) error {
+	return b.WaitNContext(ctx, 1)
+}
+
+func (b *budget) WaitN(n int) error {
+	return b.WaitNContext(context.Background(), n)
+}
+
+func (b *budget) WaitNTimeout(timeout time.Duration, n int) error {
+	ctx, cancel := withTimeout(b.clock, timeout)
+	defer cancel()
+	return b.WaitNContext(ctx, n)
+}
+
+func (b *budget) WaitNContext(ctx context.Context, n int) error {
+	if n <= 0 {
+		return nil
+	}
+
+	return b.await(ctx, func() (bool, time.Duration, error) {
+		if err := b.costErrLocked(n); err != nil {
+			return false, 0, err
+		}
+
+		ok, retryIn := b.tryUseLocked(n)
+		return ok, retryIn, nil
+	}, nil)
+}
+
+// costErrLocked denies n requests and returns an error if they exceed the budget of the period, nil otherwise.
+func (b *budget) costErrLocked(n int) error {
+	// This must be called with the mutex already locked
+	if n > b.total {
+		b.deny(ReasonLimited)
+		return fmt.Errorf("cost %d exceeds the budget of %d", n, b.total)
+	}
+	return nil
+}
+
+func (b *budget) Wait() {
+	_ = b.WaitContext(context.Background())
+}
+
+func (b *budget) WaitTimeout(timeout time.Duration) error {
+	ctx, cancel := withTimeout(b.clock, timeout)
+	defer cancel()
+	return b.WaitContext(ctx)
+}
+
+func (b *budget) AllowedReport() (AdmitReport, bool) {
+	return b.allowReport(func() bool { return b.allowLocked(1) })
+}
+
+func (b *budget) WaitContextReport(ctx context.Context) (AdmitReport, error) {
+	return waitReport(ctx, b.WaitContext)
+}
+
+func (b *budget) Allowed() bool {
+	return b.AllowN(1)
+}
+
+func (b *budget) AllowN(n int) bool {
+	if n <= 0 {
+		return true
+	}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.allowLocked(n)
+}
+
+func (b *budget) allowLocked(n int) bool {
+	// This must be called with the mutex already locked
+	if b.denyHalted() {
+		return false
+	}
+	if ok, _ := b.tryUseLocked(n); ok {
+		return true
+	}
+
+	b.deny(b.limitedReason())
+	return false
+}
+
+// tryUseLocked uses n requests of the budget if the schedule allows them, otherwise it returns how long until it's
+// worth trying again.
+func (b *budget) tryUseLocked(n int) (bool, time.Duration) {
+	// This must be called with the mutex already locked
+	if !b.availableLocked(n) || !b.closedUntil().IsZero() {
+		return false, b.retryIn(b.nextAllowedTime(n), b.periodEnd.Sub(b.clock.Now()))
+	}
+
+	b.used += n
+	b.countAllowed()
+	return true, 0
+}
+
+// tryReserveLocked reserves n requests of the budget if the schedule allows them, otherwise it returns how long until
+// it's worth trying again.
+func (b *budget) tryReserveLocked(ctx context.Context, n int, reservationTTL *time.Duration) (*budgetReservation, time.Duration) {
+	// This must be called with the mutex already locked
+	if !b.availableLocked(n) || !b.closedUntil().IsZero() {
+		return nil, b.retryIn(b.nextAllowedTime(n), b.periodEnd.Sub(b.clock.Now()))
+	}
+
+	reservation := &budgetReservation{
+		limiter:    b,
+		n:          n,
+		reservedAt: b.clock.Now(),
+		expiresAt:  b.expiryFor(ctx, reservationTTL),
+	}
+	b.pendingReservations[reservation] = struct{}{}
+	b.watchAbandoned(reservation.reservedAt, func() bool {
+		return pendingAt(b.clock.Now(), reservation.consumed, reservation.canceled, reservation.expiresAt)
+	})
+	return reservation, 0
+}
+
+// availableLocked rolls the period over if it ended and reports whether the schedule allows n more requests, net of
+// pending reservations.
+func (b *budget) availableLocked(n int) bool {
+	// This must be called with the mutex already locked
+	b.rollPeriod()
+	b.cleanupExpiredReservations()
+	return b.used+reservedUnits(b.pendingReservations)+n <= b.allowanceLocked(b.clock.Now())
+}
+
+// allowanceLocked returns how many requests the schedule allows by now in the current period.
+func (b *budget) allowanceLocked(now time.Time) int {
+	// This must be called with the mutex already locked
+	start := b.scheduleStart()
+	elapsed := max(now.Sub(start), 0)
+	scheduled := 0
+	if length := b.periodEnd.Sub(start); length > 0 {
+		// A start time at the end of the period or later leaves no schedule in it
+		scheduled = int(float64(b.total) * float64(elapsed) / float64(length))
+	}
+	return min(scheduled+b.ahead, b.total)
+}
+
+// rollPeriod starts a new period with nothing used once the current one ended. The period never moves backwards, so
+// a wall clock stepping back across a boundary doesn't hand out a second budget.
+func (b *budget) rollPeriod() {
+	// This must be called with the mutex already locked
+	now := b.clock.Now()
+	if now.Before(b.periodEnd) {
+		return
+	}
+
+	b.periodStart = b.period.start(now, b.loc)
+	b.periodEnd = b.period.next(b.periodStart)
+	b.used = 0
+	for res := range b.pendingReservations {
+		res.canceled = true
+	}
+	b.pendingReservations = make(map[*budgetReservation]struct{})
+}
+
+func (b *budget) cleanupExpiredReservations() {
+	// This must be called with the mutex already locked
+	now := b.clock.Now()
+	for res := range b.pendingReservations {
+		if res.expiresAt != nil && now.After(*res.expiresAt) {
+			delete(b.pendingReservations, res)
+		}
+	}
+}
+
+// nextAllowedTime returns when the schedule allows n more requests net of pending reservations, which is the start
+// of the next period once the budget left is too small. Reservations expiring earlier can free some before that.
+func (b *budget) nextAllowedTime(n int) time.Time {
+	// This must be called with the mutex already locked
+	now := b.clock.Now()
+	needed := b.used + reservedUnits(b.pendingReservations) + n
+	if needed <= b.allowanceLocked(now) {
+		return now
+	}
+	if needed > b.total {
+		return b.periodEnd
+	}
+
+	// The schedule reaches needed-ahead requests after that fraction of the period
+	start := b.scheduleStart()
+	length := b.periodEnd.Sub(start)
+	fraction := float64(needed-b.ahead) / float64(b.total)
+	return start.Add(time.Duration(math.Ceil(fraction * float64(length))))
+}
+
+// scheduleStart returns when the schedule of the current period starts, which is the start time set with
+// WithStartTime if the period started before it, so the time before it doesn't build up a burst.
+func (b *budget) scheduleStart() time.Time {
+	// This must be called with the mutex already locked
+	if b.periodStart.Before(b.startAt) {
+		return b.startAt
+	}
+	return b.periodStart
+}
+
+func (b *budget) Clear() {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.cancelReservationsLocked()
+	b.used = 0
+	b.waiters.notify()
+}
+
+func (b *budget) Close() error {
+	return b.close(b.cancelReservationsLocked)
+}
+
+// cancelReservationsLocked cancels the pending reservations.
+func (b *budget) cancelReservationsLocked() {
+	// This must be called with the mutex already locked
+	for res := range b.pendingReservations {
+		res.canceled = true
+	}
+
+	b.pendingReservations = make(map[*budgetReservation]struct{})
+}
+
+func (b *budget) Stats() Stats {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.rollPeriod()
+	b.cleanupExpiredReservations()
+
+	stats := b.stats()
+	stats.PendingReservations = len(b.pendingReservations)
+	return stats
+}
+
+// Info reports the budget of the period as the limit and what the schedule allows right now as remaining, with Reset
+// being the end of the period.
+func (b *budget) Info() LimitInfo {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.rollPeriod()
+	b.cleanupExpiredReservations()
+
+	remaining := b.allowanceLocked(b.clock.Now()) - b.used - reservedUnits(b.pendingReservations)
+	return b.info(b.total, remaining, b.periodEnd, b.periodEnd.Sub(b.periodStart))
+}
+
+// Available returns how many more requests the schedule allows right now net of pending reservations.
+func (b *budget) Available() int {
+	return b.Info().Remaining
+}
+
+func (b *budget) EstimatedWait() time.Duration {
+	return b.waitUntil(b.Stats().NextAllowedTime)
+}
+
+// Config reports the budget per current period, whose length varies for monthly budgets.
+func (b *budget) Config() Config {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.rollPeriod()
+	return Config{Algorithm: AlgorithmBudget, Count: b.total, Per: b.periodEnd.Sub(b.periodStart)}
+}
+
+// Limit returns the budget of the current period.
+func (b *budget) Limit() Rate {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.rollPeriod()
+	return Rate{Count: b.total, Per: b.periodEnd.Sub(b.periodStart)}
+}
+
+// Burst returns how many requests the budget may get ahead of its schedule.
+func (b *budget) Burst() int {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.ahead
+}
+
+func (b *budget) PendingReservationAges(n int) []time.Duration {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.cleanupExpiredReservations()
+
+	reservedAt := make([]time.Time, 0, len(b.pendingReservations))
+	for res := range b.pendingReservations {
+		reservedAt = append(reservedAt, res.reservedAt)
+	}
+	return b.reservationAges(reservedAt, n)
+}
+
+func (b *budget) UsedThisPeriod() int {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.rollPeriod()
+	return b.used
+}
+
+func (b *budget) ProjectedExhaustion() time.Time {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.rollPeriod()
+
+	elapsed := b.clock.Now().Sub(b.periodStart)
+	if b.used == 0 || elapsed <= 0 {
+		return time.Time{}
+	}
+
+	exhaustion := b.periodStart.Add(time.Duration(float64(elapsed) * float64(b.total) / float64(b.used)))
+	if !exhaustion.Before(b.periodEnd) {
+		return time.Time{}
+	}
+	return exhaustion
+}
+
+func (b *budget) SetBudget(total int) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.total = total
+	// A bigger budget may let waiters through right away
+	b.waiters.notify()
+}
+
+func (b *budget) TryReserve(reservationTTL *time.Duration) (Reservation, bool) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if reservation, _ := b.tryReserveLocked(context.Background(), 1, reservationTTL); reservation != nil {
+		return reservation, true
+	}
+
+	b.deny(b.limitedReason())
+	return nil, false
+}
+
+func (b *budget) Reserve(reservationTTL *time.Duration) Reservation {
+	reservation, err := b.ReserveContext(context.Background(), reservationTTL)
+	if err != nil {
+		return failedReservation{err: err}
+	}
+	return reservation
+}
+
+func (b *budget) ReserveTimeout(timeout time.Duration, reservationTTL *time.Duration) (Reservation, error) {
+	ctx, cancel := withTimeout(b.clock, timeout)
+	defer cancel()
+	return b.ReserveContext(ctx, reservationTTL)
+}
+
+func (b *budget) Permits(ctx context.Context) <-chan struct{} {
+	return deliverPermits(ctx, func(ctx context.Context) (permit, error) {
+		return reservePermit(ctx, b)
+	})
+}
+
+func (b *budget) ReserveContext(ctx context.Context, reservationTTL *time.Duration) (Reservation, error) {
+	return b.ReserveN(ctx, 1, reservationTTL)
+}
+
+func (b *budget) ReserveN(ctx context.Context, n int, reservationTTL *time.Duration) (Reservation, error) {
+	if err := reserveCostErr(n); err != nil {
+		return nil, err
+	}
+
+	var reservation *budgetReservation
+	err := b.awaitReservation(ctx, func() (bool, time.Duration, error) {
+		if err := b.costErrLocked(n); err != nil {
+			return false, 0, err
+		}
+
+		var retryIn time.Duration
+		reservation, retryIn = b.tryReserveLocked(ctx, n, reservationTTL)
+		return reservation != nil, retryIn, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	b.link(ctx, reservation)
+	return reservation, nil
+}
+
+// budgetReservation implements the Reservation interface
+type budgetReservation struct {
+	limiter    *budget
+	n          int // Requests held
+	reservedAt time.Time
+	expiresAt  *time.Time
+	consumed   bool
+	canceled   bool
+}
+
+func (r *budgetReservation) Consume() error {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	// A reservation from a period that ended was canceled when rolling over
+	r.limiter.rollPeriod()
+
+	if r.consumed {
+		return ErrReservationConsumed
+	}
+
+	if r.canceled {
+		return ErrReservationCanceled
+	}
+
+	if r.expiresAt != nil && r.limiter.clock.Now().After(*r.expiresAt) {
+		delete(r.limiter.pendingReservations, r)
+		return ErrReservationExpired
+	}
+
+	r.consumed = true
+	delete(r.limiter.pendingReservations, r)
+	r.limiter.used += r.n
+	r.limiter.countAllowed()
+
+	return nil
+}
+
+// ConsumeContext is Consume, which never blocks.
+func (r *budgetReservation) ConsumeContext(context.Context) error {
+	return r.Consume()
+}
+
+func (r *budgetReservation) units() int {
+	return r.n
+}
+
+func (r *budgetReservation) Cancel() {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	if !r.consumed {
+		r.canceled = true
+		delete(r.limiter.pendingReservations, r)
+		r.limiter.waiters.notify()
+	}
+}
+
+func (r *budgetReservation) Detach() (ReservationHandle, error) {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	state := func() error {
+		return reservationErr(r.limiter.clock.Now(), r.consumed, r.canceled, r.expiresAt)
+	}
+	if err := state(); err != nil {
+		return ReservationHandle{}, err
+	}
+	return r.limiter.detach(r, r.expiresAt, state), nil
+}
+
+// ReadyAt is now, Consume never blocks.
+func (r *budgetReservation) ReadyAt() time.Time {
+	return r.limiter.clock.Now()
+}
+
+func (r *budgetReservation) Delay() time.Duration {
+	return 0
+}
+
+func (r *budgetReservation) ExpiresAt() (time.Time, bool) {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	return expiry(r.expiresAt)
+}
+
+func (r *budgetReservation) State() ReservationState {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	return reservationState(r.limiter.clock.Now(), r.consumed, r.canceled, r.expiresAt)
+}
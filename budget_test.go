@@ -0,0 +1,150 @@
+package limit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limittest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBudget_SpreadsOverMonth(t *testing.T) {
+	t.Parallel()
+
+	// June has 720 hours, so the schedule allows one request per hour
+	start := time.Date(2026, time.June, 1, 0, 0, 0, 0, time.UTC)
+	clock := limittest.NewFakeClock(start)
+	budget := limit.NewBudget(720, limit.QuotaMonthly, time.UTC, limit.WithClock(clock))
+
+	for hour := 0; hour < 720; hour++ {
+		if hour > 0 {
+			clock.Advance(1 * time.Hour)
+		}
+		assert.True(t, budget.Allowed(), "hour %d", hour)
+		assert.False(t, budget.Allowed(), "hour %d", hour)
+	}
+	assert.Equal(t, 720, budget.UsedThisPeriod())
+	assert.Equal(t, 720, budget.Stats().AllowedRequests)
+
+	// The next month starts with a fresh budget
+	clock.Advance(1 * time.Hour)
+	assert.Equal(t, time.Date(2026, time.July, 1, 0, 0, 0, 0, time.UTC), clock.Now())
+	assert.Equal(t, 0, budget.UsedThisPeriod())
+	assert.True(t, budget.Allowed())
+}
+
+func TestBudget_Exhausted_WaitsForNextPeriod(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2026, time.June, 30, 0, 0, 0, 0, time.UTC)
+	clock := limittest.NewFakeClock(start)
+	budget := limit.NewBudget(10, limit.QuotaMonthly, time.UTC, limit.WithClock(clock), limit.WithBudgetAhead(10))
+
+	for i := 0; i < 10; i++ {
+		assert.True(t, budget.Allowed())
+	}
+	assert.False(t, budget.Allowed())
+	assert.Equal(t, time.Date(2026, time.July, 1, 0, 0, 0, 0, time.UTC), budget.Stats().NextAllowedTime)
+
+	done := make(chan struct{})
+	go func() {
+		budget.Wait()
+		close(done)
+	}()
+
+	clock.BlockUntil(1)
+	clock.Advance(24 * time.Hour)
+	<-done
+	assert.Equal(t, 1, budget.UsedThisPeriod())
+}
+
+func TestBudget_AheadOfSchedule(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Date(2026, time.June, 1, 0, 0, 0, 0, time.UTC))
+	budget := limit.NewBudget(1000, limit.QuotaMonthly, time.UTC, limit.WithClock(clock), limit.WithBudgetAhead(5))
+
+	for i := 0; i < 5; i++ {
+		assert.True(t, budget.Allowed())
+	}
+	assert.False(t, budget.Allowed())
+	assert.Equal(t, 1, budget.Stats().DeniedByReason[limit.ReasonLimited])
+}
+
+func TestBudget_PeriodBoundaryInLocation(t *testing.T) {
+	t.Parallel()
+
+	// 23:59 on June 30 three hours east of UTC
+	loc := time.FixedZone("UTC+3", 3*60*60)
+	clock := limittest.NewFakeClock(time.Date(2026, time.June, 30, 20, 59, 0, 0, time.UTC))
+	budget := limit.NewBudget(1000, limit.QuotaMonthly, loc, limit.WithClock(clock), limit.WithBudgetAhead(1000))
+
+	assert.True(t, budget.Allowed())
+	assert.Equal(t, 1, budget.UsedThisPeriod())
+
+	clock.Advance(1 * time.Minute)
+	assert.Equal(t, 0, budget.UsedThisPeriod())
+}
+
+func TestBudget_SetBudget_MidPeriod(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Date(2026, time.June, 1, 0, 0, 0, 0, time.UTC))
+	budget := limit.NewBudget(100, limit.QuotaDaily, time.UTC, limit.WithClock(clock))
+
+	clock.Advance(12 * time.Hour)
+	for i := 0; i < 51; i++ {
+		assert.True(t, budget.Allowed())
+	}
+	assert.False(t, budget.Allowed())
+
+	// Half of the upgraded budget is available, keeping what was already used
+	budget.SetBudget(200)
+	for i := 0; i < 50; i++ {
+		assert.True(t, budget.Allowed())
+	}
+	assert.False(t, budget.Allowed())
+	assert.Equal(t, 101, budget.UsedThisPeriod())
+}
+
+func TestBudget_ProjectedExhaustion(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2026, time.June, 1, 0, 0, 0, 0, time.UTC)
+	clock := limittest.NewFakeClock(start)
+	budget := limit.NewBudget(100, limit.QuotaDaily, time.UTC, limit.WithClock(clock), limit.WithBudgetAhead(100))
+
+	assert.True(t, budget.ProjectedExhaustion().IsZero())
+
+	clock.Advance(6 * time.Hour)
+	for i := 0; i < 50; i++ {
+		assert.True(t, budget.Allowed())
+	}
+	assert.Equal(t, start.Add(12*time.Hour), budget.ProjectedExhaustion())
+
+	// At the slower pace the budget outlasts the day
+	clock.Advance(14 * time.Hour)
+	assert.True(t, budget.ProjectedExhaustion().IsZero())
+}
+
+func TestBudget_StartTime_NoAccumulatedBurst(t *testing.T) {
+	t.Parallel()
+
+	// Created at the start of June for a launch halfway through it
+	clock := limittest.NewFakeClock(time.Date(2026, time.June, 1, 0, 0, 0, 0, time.UTC))
+	launch := time.Date(2026, time.June, 16, 0, 0, 0, 0, time.UTC)
+	budget := limit.NewBudget(720, limit.QuotaMonthly, time.UTC, limit.WithClock(clock), limit.WithStartTime(launch))
+
+	assert.False(t, budget.Allowed())
+	assert.Equal(t, launch, budget.Stats().NextAllowedTime)
+
+	// The budget is spread over the 360 hours left from the launch, so two requests per hour
+	clock.Advance(15 * 24 * time.Hour)
+	assert.True(t, budget.Allowed())
+	assert.False(t, budget.Allowed())
+	clock.Advance(30 * time.Minute)
+	assert.True(t, budget.Allowed())
+	assert.False(t, budget.Allowed())
+	assert.Equal(t, map[limit.Reason]int{limit.ReasonNotStarted: 1, limit.ReasonLimited: 2}, budget.Stats().DeniedByReason)
+}
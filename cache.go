@@ -0,0 +1,201 @@
+package limit
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"unsafe"
+)
+
+// KeyCache stores the limiters of a KeyedLimiter, so they can live in a cache with its own admission and memory bounds
+// instead of a plain map. Its methods must be safe for concurrent use.
+//
+// A cache that evicts entries, like ristretto, must call the function registered with OnEvict before dropping one and
+// keep the entry if it returns false, which the keyed limiter does for limiters with waiters or pending reservations.
+// A cache that can only be told after the fact should store the entry again. Delete must not call it.
+type KeyCache interface {
+	// Get returns the limiter stored for key.
+	Get(key string) (Limiter, bool)
+	// Set stores the limiter of key. The cost is an estimate of the bytes the limiter holds at its most, see
+	// LimiterCost. A cache may refuse the entry and return false, the keyed limiter then reports the key to the
+	// eviction hook with EvictRefused, keeps its limiter outside the cache and offers it again on every use of the key
+	// until the cache takes it.
+	Set(key string, limiter Limiter, cost int64) bool
+	// Delete drops the limiter of key.
+	Delete(key string)
+	// OnEvict registers the function to call before evicting an entry, which vetoes the eviction by returning false.
+	OnEvict(fn func(key string, limiter Limiter) bool)
+}
+
+// NewMapCache returns a KeyCache keeping every limiter in a map until it's deleted, the default of a KeyedLimiter.
+func NewMapCache() KeyCache {
+	return &mapCache{limiters: make(map[string]Limiter)}
+}
+
+// mapCache implements KeyCache with a map, never evicting.
+type mapCache struct {
+	mux      sync.Mutex
+	limiters map[string]Limiter
+}
+
+func (c *mapCache) Get(key string) (Limiter, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	l, ok := c.limiters[key]
+	return l, ok
+}
+
+func (c *mapCache) Set(key string, limiter Limiter, _ int64) bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.limiters[key] = limiter
+	return true
+}
+
+func (c *mapCache) Delete(key string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	delete(c.limiters, key)
+}
+
+func (c *mapCache) OnEvict(func(key string, limiter Limiter) bool) {}
+
+// NewLRUCache returns a KeyCache holding at most size limiters, evicting the least recently used one the keyed limiter
+// lets go when a new key would exceed size. If it lets none go, because they are all busy or in use, Set refuses the new
+// key. Get takes constant time, and so does Set but for passing over the busy entries. It panics if size isn't
+// positive.
+func NewLRUCache(size int) KeyCache {
+	if size <= 0 {
+		panic(fmt.Sprintf("limit: NewLRUCache: size %d is not positive", size))
+	}
+	return &lruCache{size: size, entries: make(map[string]*list.Element), order: list.New()}
+}
+
+// lruCache implements KeyCache with a map and a list ordered from the most recently used entry to the least.
+type lruCache struct {
+	mux     sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	order   *list.List
+	onEvict func(key string, limiter Limiter) bool
+}
+
+// lruEntry is the value of the elements of lruCache.order.
+type lruEntry struct {
+	key     string
+	limiter Limiter
+}
+
+func (c *lruCache) Get(key string) (Limiter, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*lruEntry).limiter, true
+}
+
+func (c *lruCache) Set(key string, limiter Limiter, _ int64) bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		e.Value.(*lruEntry).limiter = limiter
+		c.order.MoveToFront(e)
+		return true
+	}
+	if len(c.entries) >= c.size && !c.evictLocked() {
+		return false
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, limiter: limiter})
+	return true
+}
+
+// evictLocked evicts the least recently used entry the eviction function lets go, and reports whether there was one.
+func (c *lruCache) evictLocked() bool {
+	// This must be called with the mutex already locked
+	for e := c.order.Back(); e != nil; e = e.Prev() {
+		entry := e.Value.(*lruEntry)
+		if c.onEvict != nil && !c.onEvict(entry.key, entry.limiter) {
+			continue
+		}
+		c.order.Remove(e)
+		delete(c.entries, entry.key)
+		return true
+	}
+	return false
+}
+
+func (c *lruCache) Delete(key string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.order.Remove(e)
+		delete(c.entries, key)
+	}
+}
+
+func (c *lruCache) OnEvict(fn func(key string, limiter Limiter) bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.onEvict = fn
+}
+
+// LimiterCost estimates the bytes a limiter of this package holds at its most, counting its full event log or queue,
+// which is the cost a KeyedLimiter stores it with. Limiters from other packages are estimated at 256 bytes.
+func LimiterCost(l Limiter) int64 {
+	switch l := l.(type) {
+	case *tokenBucket:
+		return int64(unsafe.Sizeof(*l))
+	case *rollingWindow:
+		return int64(unsafe.Sizeof(*l)) + int64(l.count)*int64(unsafe.Sizeof(eventLog{}))
+	case *leakyBucket:
+		// Each queued caller holds a reservation
+		return int64(unsafe.Sizeof(*l)) + int64(l.maxCapacity)*int64(unsafe.Sizeof(leakyBucketReservation{}))
+	case *budget:
+		return int64(unsafe.Sizeof(*l))
+	default:
+		return 256
+	}
+}
+
+// busy reports whether a limiter has waiters or pending reservations, which evicting it would strand.
+func busy(l Limiter) bool {
+	return len(l.Waiters()) > 0 || len(l.PendingReservationAges(1)) > 0
+}
+
+// vetoEviction is registered with the cache, refusing to evict limiters that are busy or in use by a call of the keyed
+// limiter, and dropping the others from the keys right away so the next use of their key creates a new one.
+func (k *KeyedLimiter) vetoEviction(key string, l Limiter) bool {
+	// The cache may be evicting from a call made with the mutex locked, so only keysMux is taken, and released while
+	// the limiter is asked whether it's busy
+	k.keysMux.Lock()
+	e, tracked := k.keys[key]
+	inUse := tracked && e.limiter == l && e.inUse > 0
+	k.keysMux.Unlock()
+	if inUse || busy(l) {
+		return false
+	}
+
+	k.keysMux.Lock()
+	defer k.keysMux.Unlock()
+	e, tracked = k.keys[key]
+	if !tracked || e.limiter != l {
+		return true
+	}
+	if e.inUse > 0 {
+		return false
+	}
+	delete(k.keys, key)
+	if km, ok := k.multiplied[key]; ok && km.limiter == l {
+		delete(k.multiplied, key)
+	}
+	k.evictions++
+	// The hook may use the keyed limiter, whose mutex may be locked, so the key is reported once it's unlocked
+	k.cacheEvicted = append(k.cacheEvicted, keyEviction{key: key, limiter: l, reason: EvictCache})
+	return true
+}
@@ -0,0 +1,296 @@
+package limit_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/stretchr/testify/assert"
+)
+
+// fifoCache is a KeyCache holding up to size limiters, evicting the oldest one the keyed limiter lets go.
+type fifoCache struct {
+	mux     sync.Mutex
+	size    int
+	keys    []string
+	entries map[string]limit.Limiter
+	costs   map[string]int64
+	onEvict func(key string, limiter limit.Limiter) bool
+}
+
+func newFIFOCache(size int) *fifoCache {
+	return &fifoCache{size: size, entries: make(map[string]limit.Limiter), costs: make(map[string]int64)}
+}
+
+func (c *fifoCache) Get(key string) (limit.Limiter, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	l, ok := c.entries[key]
+	return l, ok
+}
+
+func (c *fifoCache) Set(key string, limiter limit.Limiter, cost int64) bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	for i := 0; i < len(c.keys) && len(c.keys) >= c.size; {
+		evicted := c.keys[i]
+		if !c.onEvict(evicted, c.entries[evicted]) {
+			i++
+			continue
+		}
+		c.keys = append(c.keys[:i], c.keys[i+1:]...)
+		delete(c.entries, evicted)
+	}
+	c.keys = append(c.keys, key)
+	c.entries[key] = limiter
+	c.costs[key] = cost
+	return true
+}
+
+func (c *fifoCache) Delete(key string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	for i, k := range c.keys {
+		if k == key {
+			c.keys = append(c.keys[:i], c.keys[i+1:]...)
+		}
+	}
+	delete(c.entries, key)
+}
+
+func (c *fifoCache) OnEvict(fn func(key string, limiter limit.Limiter) bool) {
+	c.onEvict = fn
+}
+
+func TestKeyedLimiter_KeyCache(t *testing.T) {
+	t.Parallel()
+
+	cache := newFIFOCache(2)
+	type eviction struct {
+		key     string
+		allowed int
+	}
+	var evictions []eviction
+	keyed := limit.NewKeyedLimiter(userAndOrg, limit.WithKeyCache(cache),
+		limit.WithEvictionHook(func(key string, finalStats limit.Stats, reason limit.EvictReason) {
+			assert.Equal(t, limit.EvictCache, reason)
+			evictions = append(evictions, eviction{key, finalStats.AllowedRequests})
+		}))
+
+	assert.True(t, keyed.Get("a").Allowed())
+	assert.True(t, keyed.Get("b").Allowed())
+	assert.Equal(t, limit.LimiterCost(keyed.Get("a")), cache.costs["a"])
+
+	// The cache evicts the oldest key, reported before Get returns, which starts afresh when used again
+	assert.True(t, keyed.Get("c").Allowed())
+	assert.Equal(t, []eviction{{"a", 1}}, evictions)
+	assert.Equal(t, 0, keyed.Get("a").Stats().AllowedRequests)
+	assert.Equal(t, []eviction{{"a", 1}, {"b", 1}}, evictions)
+
+	// Keys with pending reservations are kept over the cache's pick
+	reservation := keyed.Get("c").Reserve(nil)
+	keyed.Get("d")
+	assert.Equal(t, []eviction{{"a", 1}, {"b", 1}, {"a", 0}}, evictions)
+	assert.Equal(t, 1, keyed.Get("c").Stats().AllowedRequests)
+	reservation.Cancel()
+	assert.Len(t, evictions, 3)
+}
+
+// countingCache counts the limiters a KeyCache holds, recording the most it ever held.
+type countingCache struct {
+	limit.KeyCache
+	held atomic.Int64
+	most atomic.Int64
+}
+
+func (c *countingCache) Set(key string, limiter limit.Limiter, cost int64) bool {
+	ok := c.KeyCache.Set(key, limiter, cost)
+	if ok {
+		held := c.held.Add(1)
+		for most := c.most.Load(); held > most && !c.most.CompareAndSwap(most, held); most = c.most.Load() {
+		}
+	}
+	return ok
+}
+
+func (c *countingCache) OnEvict(fn func(key string, limiter limit.Limiter) bool) {
+	c.KeyCache.OnEvict(func(key string, limiter limit.Limiter) bool {
+		evicted := fn(key, limiter)
+		if evicted {
+			c.held.Add(-1)
+		}
+		return evicted
+	})
+}
+
+func TestKeyedLimiter_MaxKeys(t *testing.T) {
+	t.Parallel()
+
+	var evictions []string
+	keyed := limit.NewKeyedLimiter(userAndOrg, limit.WithMaxKeys(2),
+		limit.WithEvictionHook(func(key string, _ limit.Stats, reason limit.EvictReason) {
+			assert.Equal(t, limit.EvictCache, reason)
+			evictions = append(evictions, key)
+		}))
+
+	// The least recently used key is evicted, once, before Get returns
+	keyed.Get("a")
+	keyed.Get("b")
+	keyed.Get("a")
+	keyed.Get("c")
+	assert.Equal(t, []string{"b"}, evictions)
+
+	// Unless it has waiters or pending reservations, then the next one is
+	reservation := keyed.Get("a").Reserve(nil)
+	keyed.Get("c")
+	keyed.Get("d")
+	assert.Equal(t, []string{"b", "c"}, evictions)
+	reservation.Cancel()
+	assert.Len(t, evictions, 2)
+}
+
+func TestKeyedLimiter_MaxKeys_Concurrent(t *testing.T) {
+	t.Parallel()
+
+	cache := &countingCache{KeyCache: limit.NewLRUCache(1000)}
+	keyed := limit.NewKeyedLimiter(func(string) limit.Limiter {
+		return limit.NewTokenBucket(1, 1*time.Hour)
+	}, limit.WithKeyCache(cache))
+
+	var wg sync.WaitGroup
+	for i := range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Goroutines share half their keys with the next one
+			for j := range 1250 {
+				keyed.Allowed(fmt.Sprintf("key-%d", (i*625+j)%10000))
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(1000), cache.most.Load())
+	assert.Equal(t, int64(1000), cache.held.Load())
+	assert.Eventually(t, func() bool {
+		return keyed.Stats().Keys == 1000
+	}, 1*time.Second, 1*time.Millisecond)
+}
+
+func TestKeyedLimiter_MaxKeys_AllBusy(t *testing.T) {
+	t.Parallel()
+
+	type eviction struct {
+		key    string
+		reason limit.EvictReason
+	}
+	var evictions []eviction
+	cache := &countingCache{KeyCache: limit.NewLRUCache(1)}
+	keyed := limit.NewKeyedLimiter(userAndOrg, limit.WithKeyCache(cache),
+		limit.WithEvictionHook(func(key string, _ limit.Stats, reason limit.EvictReason) {
+			evictions = append(evictions, eviction{key, reason})
+		}))
+	reservation := keyed.Get("org").Reserve(nil)
+
+	// The only key is busy, so the cache refuses the new one, which keeps its own limiter outside the cache
+	assert.True(t, keyed.Allowed("user"))
+	assert.True(t, keyed.Allowed("user"))
+	assert.False(t, keyed.Allowed("user"))
+	assert.Equal(t, []string{"org", "user"}, keyed.Keys())
+	assert.Equal(t, int64(1), cache.most.Load())
+	assert.Equal(t, []eviction{{"user", limit.EvictRefused}}, evictions)
+
+	// Once the key frees up, the next use of the refused key takes its place in the cache, still limited
+	reservation.Cancel()
+	assert.False(t, keyed.Allowed("user"))
+	assert.Equal(t, []string{"user"}, keyed.Keys())
+	assert.Equal(t, int64(1), cache.most.Load())
+	assert.Equal(t, int64(1), cache.held.Load())
+	assert.Equal(t, []eviction{{"user", limit.EvictRefused}, {"org", limit.EvictCache}}, evictions)
+}
+
+// refusingCache is a KeyCache refusing every entry.
+type refusingCache struct {
+	limit.KeyCache
+}
+
+func (refusingCache) Set(string, limit.Limiter, int64) bool {
+	return false
+}
+
+func TestKeyedLimiter_RefusedKeys(t *testing.T) {
+	t.Parallel()
+
+	var refused []string
+	keyed := limit.NewKeyedLimiter(userAndOrg, limit.WithKeyCache(refusingCache{limit.NewMapCache()}),
+		limit.WithEvictionHook(func(key string, _ limit.Stats, reason limit.EvictReason) {
+			if reason == limit.EvictRefused {
+				refused = append(refused, key)
+			}
+		}))
+
+	// Each key the cache refuses keeps its own limiter instead of starting afresh on every use
+	assert.True(t, keyed.Allowed("user"))
+	assert.True(t, keyed.Allowed("user"))
+	assert.False(t, keyed.Allowed("user"))
+	assert.True(t, keyed.Allowed("org"))
+	assert.False(t, keyed.Allowed("org"))
+	assert.Equal(t, []string{"org", "user"}, keyed.Keys())
+	// Only their first refusal is reported
+	assert.Equal(t, []string{"user", "org"}, refused)
+
+	stats, ok := keyed.Remove("user")
+	assert.True(t, ok)
+	assert.Equal(t, 2, stats.AllowedRequests)
+	assert.Equal(t, []string{"org"}, keyed.Keys())
+}
+
+// racingCache is a KeyCache that, when armed, evicts the limiter it looks up right after the lookup, as an eviction
+// racing the caller would.
+type racingCache struct {
+	limit.KeyCache
+	armed   atomic.Bool
+	onEvict func(key string, limiter limit.Limiter) bool
+}
+
+func (c *racingCache) Get(key string) (limit.Limiter, bool) {
+	l, ok := c.KeyCache.Get(key)
+	if ok && c.armed.CompareAndSwap(true, false) && c.onEvict(key, l) {
+		c.KeyCache.Delete(key)
+	}
+	return l, ok
+}
+
+func (c *racingCache) OnEvict(fn func(key string, limiter limit.Limiter) bool) {
+	c.onEvict = fn
+}
+
+func TestKeyedLimiter_EvictionRacingGet(t *testing.T) {
+	t.Parallel()
+
+	cache := &racingCache{KeyCache: limit.NewMapCache()}
+	keyed := limit.NewKeyedLimiter(userAndOrg, limit.WithKeyCache(cache))
+	assert.True(t, keyed.Allowed("user"))
+
+	// The limiter evicted after the lookup isn't waited on, the key gets a new one
+	cache.armed.Store(true)
+	assert.NoError(t, keyed.WaitContext(context.Background(), "user"))
+	assert.Equal(t, 1, keyed.Get("user").Stats().AllowedRequests)
+	assert.Equal(t, 1, keyed.Stats().Evictions)
+}
+
+func TestLimiterCost(t *testing.T) {
+	t.Parallel()
+
+	bucket := limit.LimiterCost(limit.NewTokenBucket(1000, 1*time.Second))
+	small := limit.LimiterCost(limit.NewRollingWindow(10, 1*time.Second))
+	large := limit.LimiterCost(limit.NewRollingWindow(1000, 1*time.Second))
+	assert.Greater(t, bucket, int64(0))
+	assert.Greater(t, large, small)
+	assert.Greater(t, large, bucket)
+}
@@ -0,0 +1,58 @@
+package limit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrNotAdmitted wraps the error of a Call the limiter didn't admit, to tell it apart from an error of the function.
+var ErrNotAdmitted = errors.New("limiter didn't admit the call")
+
+// Call waits for l to allow it and then calls fn with ctx, returning its result. If the limiter doesn't admit the call
+// fn isn't called and the error wraps both ErrNotAdmitted and the limiter's error. Errors of fn are returned as is.
+func Call[T any](ctx context.Context, l Limiter, fn func(context.Context) (T, error)) (T, error) {
+	if err := l.WaitContext(ctx); err != nil {
+		var zero T
+		return zero, fmt.Errorf("%w: %w", ErrNotAdmitted, err)
+	}
+	return fn(ctx)
+}
+
+// CallReserved is Call taking a reservation first, which is only consumed right before fn is called. If ctx is done
+// once the reservation is taken, the reservation is canceled instead and fn isn't called.
+func CallReserved[T any](ctx context.Context, l Limiter, fn func(context.Context) (T, error)) (T, error) {
+	var zero T
+	reservation, err := l.ReserveContext(ctx, nil)
+	if err != nil {
+		return zero, fmt.Errorf("%w: %w", ErrNotAdmitted, err)
+	}
+
+	if ctx.Err() != nil {
+		reservation.Cancel()
+		return zero, fmt.Errorf("%w: %w", ErrNotAdmitted, context.Cause(ctx))
+	}
+	if err := reservation.ConsumeContext(ctx); err != nil {
+		return zero, fmt.Errorf("%w: %w", ErrNotAdmitted, err)
+	}
+	return fn(ctx)
+}
+
+// CallWithRetries is Call recording a primary request in budget and calling fn again while it fails, up to attempts
+// calls in total, as long as budget allows the retries. Every retry waits for l like the first call does. When budget
+// turns a retry down, the last error of fn is returned wrapped with ErrRetryBudgetExhausted. Calls the limiter doesn't
+// admit and calls whose ctx is done aren't retried.
+func CallWithRetries[T any](ctx context.Context, l Limiter, budget *RetryBudget, attempts int, fn func(context.Context) (T, error)) (T, error) {
+	budget.RecordRequest()
+	result, err := Call(ctx, l, fn)
+	for attempt := 1; attempt < attempts && err != nil; attempt++ {
+		if errors.Is(err, ErrNotAdmitted) || ctx.Err() != nil {
+			break
+		}
+		if !budget.AllowRetry() {
+			return result, fmt.Errorf("%w: %w", ErrRetryBudgetExhausted, err)
+		}
+		result, err = Call(ctx, l, fn)
+	}
+	return result, err
+}
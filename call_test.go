@@ -0,0 +1,113 @@
+package limit_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/stretchr/testify/assert"
+)
+
+type callKey struct{}
+
+// cancelingLimiter cancels the caller's context right after handing out a reservation.
+type cancelingLimiter struct {
+	limit.Limiter
+	cancel context.CancelFunc
+}
+
+func (c cancelingLimiter) ReserveContext(ctx context.Context, reservationTTL *time.Duration) (limit.Reservation, error) {
+	reservation, err := c.Limiter.ReserveContext(ctx, reservationTTL)
+	c.cancel()
+	return reservation, err
+}
+
+func TestCall(t *testing.T) {
+	t.Parallel()
+
+	for name, call := range map[string]func(context.Context, limit.Limiter, func(context.Context) (string, error)) (string, error){
+		"Call":         limit.Call[string],
+		"CallReserved": limit.CallReserved[string],
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			t.Run("ReturnsResult", func(t *testing.T) {
+				t.Parallel()
+
+				limiter := limit.NewTokenBucket(1, 1*time.Hour)
+				ctx := context.WithValue(context.Background(), callKey{}, "value")
+				got, err := call(ctx, limiter, func(ctx context.Context) (string, error) {
+					// fn gets the caller's context
+					return ctx.Value(callKey{}).(string), nil
+				})
+				assert.NoError(t, err)
+				assert.Equal(t, "value", got)
+				assert.Equal(t, 1, limiter.Stats().AllowedRequests)
+			})
+
+			t.Run("CanceledWhileWaiting", func(t *testing.T) {
+				t.Parallel()
+
+				limiter := limit.NewTokenBucket(1, 1*time.Hour)
+				assert.True(t, limiter.Allowed())
+
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+				defer cancel()
+				got, err := call(ctx, limiter, func(context.Context) (string, error) {
+					t.Fatal("fn shouldn't be called")
+					return "", nil
+				})
+				assert.ErrorIs(t, err, limit.ErrNotAdmitted)
+				assert.ErrorIs(t, err, context.DeadlineExceeded)
+				assert.Empty(t, got)
+			})
+
+			t.Run("FunctionFails", func(t *testing.T) {
+				t.Parallel()
+
+				fnErr := errors.New("boom")
+				got, err := call(context.Background(), limit.NewTokenBucket(1, 1*time.Hour), func(context.Context) (string, error) {
+					return "partial", fnErr
+				})
+				assert.ErrorIs(t, err, fnErr)
+				assert.NotErrorIs(t, err, limit.ErrNotAdmitted)
+				assert.Equal(t, "partial", got)
+			})
+
+			t.Run("CanceledWhileRunning", func(t *testing.T) {
+				t.Parallel()
+
+				limiter := limit.NewTokenBucket(1, 1*time.Hour)
+				ctx, cancel := context.WithCancel(context.Background())
+				_, err := call(ctx, limiter, func(ctx context.Context) (string, error) {
+					cancel()
+					return "", ctx.Err()
+				})
+				// The call was admitted, so its capacity is used up
+				assert.ErrorIs(t, err, context.Canceled)
+				assert.NotErrorIs(t, err, limit.ErrNotAdmitted)
+				assert.False(t, limiter.Allowed())
+			})
+		})
+	}
+}
+
+func TestCallReserved_CanceledBeforeCalling(t *testing.T) {
+	t.Parallel()
+
+	limiter := limit.NewTokenBucket(1, 1*time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	got, err := limit.CallReserved(ctx, cancelingLimiter{Limiter: limiter, cancel: cancel}, func(context.Context) (int, error) {
+		t.Fatal("fn shouldn't be called")
+		return 0, nil
+	})
+	assert.ErrorIs(t, err, limit.ErrNotAdmitted)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Zero(t, got)
+
+	// The reservation was canceled, so the token is still there
+	assert.True(t, limiter.Allowed())
+}
@@ -0,0 +1,92 @@
+package limit
+
+import (
+	"context"
+	"time"
+)
+
+// Clock tells the time and creates timers for a limiter, so tests can control time. The default uses the time package.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// After waits for the duration to elapse and then sends the current time on the returned channel.
+	After(d time.Duration) <-chan time.Time
+	// NewTimer creates a Timer that sends the current time on its channel after at least d.
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is the Clock counterpart of a time.Timer.
+type Timer interface {
+	// C returns the channel on which the time is delivered.
+	C() <-chan time.Time
+	// Stop prevents the Timer from firing. It returns false if the timer already expired or was stopped.
+	Stop() bool
+	// Reset changes the timer to expire after d. It returns true if the timer had been active.
+	Reset(d time.Duration) bool
+}
+
+// ClockAnomaly describes a timestamp a limiter found ahead of its clock, e.g. after the wall clock stepped back or a
+// VM migrated. The limiter pulls such timestamps back to now, so it doesn't stop admitting until the clock catches up.
+type ClockAnomaly struct {
+	// Limiter is the name given to the limiter with WithName, empty if it wasn't named.
+	Limiter string
+	// Ahead is how far ahead of the clock the timestamp was.
+	Ahead time.Duration
+	// At is the time of the clock when the timestamp was found.
+	At time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{timer: time.NewTimer(d)}
+}
+
+type realTimer struct {
+	timer *time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.timer.C
+}
+
+func (t realTimer) Stop() bool {
+	return t.timer.Stop()
+}
+
+func (t realTimer) Reset(d time.Duration) bool {
+	return t.timer.Reset(d)
+}
+
+// withTimeout is context.WithTimeout measuring the timeout on the clock.
+// With other clocks than the default the context's cause is context.DeadlineExceeded rather than its error.
+func withTimeout(clock Clock, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := clock.(realClock); ok {
+		return context.WithTimeout(context.Background(), timeout)
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	if timeout <= 0 {
+		cancel(context.DeadlineExceeded)
+		return ctx, func() {}
+	}
+
+	timer := clock.NewTimer(timeout)
+	go func() {
+		select {
+		case <-timer.C():
+			cancel(context.DeadlineExceeded)
+		case <-ctx.Done():
+			timer.Stop()
+		}
+	}()
+	return ctx, func() { cancel(context.Canceled) }
+}
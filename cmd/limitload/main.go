@@ -0,0 +1,75 @@
+// Command limitload simulates offered load against a limiter on a virtual clock and reports how it was served.
+//
+//	limitload -limit "leaky 50/1s queue=200" -load "constant 70/1s" -duration 1m
+//
+// Limiters are "token <rate>", "window <rate>" or "leaky <rate> queue=<n>", and loads are "constant <rate>",
+// "poisson <rate>", "burst <n> every <duration>" or "file <path>" with one arrival offset per line.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/agustinbanchio/go-limit/loadgen"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "limitload:", err)
+		os.Exit(2)
+	}
+}
+
+func run(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("limitload", flag.ContinueOnError)
+	limiterSpec := flags.String("limit", "", `limiter spec, e.g. "leaky 50/1s queue=200"`)
+	loadSpec := flags.String("load", "", `offered load, e.g. "constant 70/1s"`)
+	duration := flags.Duration("duration", 1*time.Minute, "how long the load is offered for")
+	step := flags.Duration("step", 1*time.Millisecond, "resolution of the virtual clock")
+	seed := flags.Int64("seed", 1, "seed of poisson arrivals")
+	nonBlocking := flags.Bool("allow", false, "call Allowed instead of waiting for the limiter")
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	newLimiter, err := loadgen.ParseLimiter(*limiterSpec)
+	if err != nil {
+		return err
+	}
+	profile, err := loadgen.ParseProfile(*loadSpec, *seed)
+	if err != nil {
+		return err
+	}
+
+	report := loadgen.Run(loadgen.Config{
+		Limiter:     newLimiter,
+		Profile:     profile,
+		Duration:    *duration,
+		Step:        *step,
+		NonBlocking: *nonBlocking,
+	})
+
+	if *asJSON {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+
+	_, err = fmt.Fprintf(stdout, `offered         %d
+admitted        %d
+denied          %d
+unfinished      %d
+wait p50        %s
+wait p90        %s
+wait p99        %s
+wait max        %s
+max queue depth %d
+`, report.Offered, report.Admitted, report.Denied, report.Unfinished,
+		report.WaitP50, report.WaitP90, report.WaitP99, report.WaitMax, report.MaxQueueDepth)
+	return err
+}
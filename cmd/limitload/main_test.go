@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/agustinbanchio/go-limit/loadgen"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	err := run([]string{"-limit", "window 10/1s", "-load", "burst 25 every 2s", "-duration", "10s", "-allow"}, &out)
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "offered         125\nadmitted        50\ndenied          75\n")
+}
+
+func TestRun_JSON(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	err := run([]string{"-limit", "token 100/1s", "-load", "constant 10/1s", "-duration", "2s", "-json"}, &out)
+	assert.NoError(t, err)
+
+	var report loadgen.Report
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &report))
+	assert.Equal(t, loadgen.Report{Offered: 20, Admitted: 20}, report)
+}
+
+func TestRun_InvalidSpec(t *testing.T) {
+	t.Parallel()
+
+	assert.ErrorContains(t, run([]string{"-limit", "bucket 1/s", "-load", "constant 1/s"}, &bytes.Buffer{}), "unknown kind")
+	assert.ErrorContains(t, run([]string{"-limit", "token 1/s", "-load", "steady"}, &bytes.Buffer{}), "load spec")
+}
@@ -0,0 +1,557 @@
+package limit
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// releasedRetry is how often a caller waiting for a slot checks again. Releases wake it right away, the timer is only a
+// fallback.
+const releasedRetry = time.Minute
+
+// ConcurrencyStats reports the slots of a limiter created with NewConcurrencyLimiter.
+type ConcurrencyStats struct {
+	// The slots taken and not released yet
+	InFlight int `json:"in_flight"`
+	// The most slots taken at once since the limiter was created
+	PeakInFlight int `json:"peak_in_flight"`
+	// The slots the limiter has
+	Max int `json:"max"`
+}
+
+// ConcurrencyLimiter is a Limiter capping how many operations are in flight at once rather than how often they start.
+// Each slot taken must be released once the operation is over, or it stays taken for good.
+type ConcurrencyLimiter interface {
+	Limiter
+	// Acquire blocks until a slot is free or ctx is done, and returns the function releasing it. Calling release again
+	// does nothing, and so does calling it after the limiter was cleared.
+	Acquire(ctx context.Context) (release func(), err error)
+	// Release releases a slot taken with Allowed, Wait and the calls built on them, or by consuming a reservation.
+	Release()
+	// ReleaseN releases n slots, e.g. the ones taken with AllowN, WaitN or by consuming a reservation of n.
+	ReleaseN(n int)
+	// InFlight returns the slots taken and not released yet.
+	InFlight() int
+}
+
+var _ ConcurrencyLimiter = (*concurrencyLimiter)(nil)
+
+type concurrencyLimiter struct {
+	base
+
+	// Config
+	max int
+
+	// State
+	inFlight int
+	peak     int
+	// Bumped by Clear, so the release functions of Acquire from before do nothing
+	generation int
+
+	// Reservations tracking
+	pendingReservations map[*concurrencyReservation]struct{}
+}
+
+// NewConcurrencyLimiter creates a limiter allowing max operations in flight at once, a semaphore. Allowed and AllowN
+// try to take slots, Wait and WaitN block until they're free, and every slot taken must be given back with Release or
+// ReleaseN, or with the function Acquire returns. A reservation holds its slots until it's consumed, canceled or
+// expires, and consuming it takes them like Allowed does. The same goes for the permits of Permits.
+//
+// Waiting callers are served in the order they arrived, and Allowed doesn't jump them. There's no telling when slots
+// are released, so WithMaxWait caps how long a caller waits instead of turning it away up front.
+// It panics if max isn't positive.
+func NewConcurrencyLimiter(max int, opts ...Option) ConcurrencyLimiter {
+	if max <= 0 {
+		panic(fmt.Sprintf("limit: NewConcurrencyLimiter: max %d is not positive", max))
+	}
+
+	o := newOptions(opts)
+	c := &concurrencyLimiter{
+		max:                 max,
+		pendingReservations: make(map[*concurrencyReservation]struct{}),
+	}
+	c.init(o)
+	c.remaining = func() int { return c.roomLocked() }
+	c.nextAllowed = func() time.Time { return c.nextAllowedTime(1) }
+	return c
+}
+
+func (c *concurrencyLimiter) Acquire(ctx context.Context) (func(), error) {
+	if err := c.WaitContext(ctx); err != nil {
+		return nil, err
+	}
+
+	c.mux.Lock()
+	generation := c.generation
+	c.mux.Unlock()
+
+	released := false
+	return func() {
+		c.mux.Lock()
+		defer c.mux.Unlock()
+
+		if released || generation != c.generation {
+			return
+		}
+		released = true
+		c.releaseLocked(1)
+	}, nil
+}
+
+func (c *concurrencyLimiter) Release() {
+	c.ReleaseN(1)
+}
+
+func (c *concurrencyLimiter) ReleaseN(n int) {
+	if n <= 0 {
+		return
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.releaseLocked(n)
+}
+
+// releaseLocked frees n slots, never more than are taken, and wakes the blocked callers.
+func (c *concurrencyLimiter) releaseLocked(n int) {
+	// This must be called with the mutex already locked
+	c.inFlight = max(c.inFlight-n, 0)
+	c.waiters.notify()
+}
+
+func (c *concurrencyLimiter) InFlight() int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.inFlight
+}
+
+func (c *concurrencyLimiter) WaitContext(ctx context.Context) error {
+	return c.WaitNContext(ctx, 1)
+}
+
+func (c *concurrencyLimiter) WaitN(n int) error {
+	return c.WaitNContext(context.Background(), n)
+}
+
+func (c *concurrencyLimiter) WaitNTimeout(timeout time.Duration, n int) error {
+	ctx, cancel := withTimeout(c.clock, timeout)
+	defer cancel()
+	return c.WaitNContext(ctx, n)
+}
+
+func (c *concurrencyLimiter) WaitNContext(ctx context.Context, n int) error {
+	if n <= 0 {
+		return nil
+	}
+
+	return c.awaitTurn(ctx, c.newWaiter(ctx), n, func() {
+		c.take(n)
+		c.countAllowed()
+	})
+}
+
+// awaitTurn blocks w until it's first in line and n slots are free net of pending reservations, then calls admit with
+// the mutex locked.
+func (c *concurrencyLimiter) awaitTurn(ctx context.Context, w *waiter, n int, admit func()) error {
+	return c.awaitAs(ctx, w, func() (bool, time.Duration, error) {
+		if err := c.costErrLocked(n); err != nil {
+			return false, 0, err
+		}
+
+		c.cleanupExpiredReservations()
+		if c.aheadOf(w) > 0 || c.freeLocked() < n || !c.closedUntil().IsZero() {
+			return false, c.retryAfterLocked(w, n), nil
+		}
+
+		admit()
+		// Let the next in line check whether the slots left are enough for it
+		c.waiters.notify()
+		return true, 0, nil
+	}, func() {
+		// The callers behind w may be first in line now
+		c.waiters.notify()
+	})
+}
+
+// aheadOf returns how many callers are in line before w, all of them if it isn't in line yet.
+func (c *concurrencyLimiter) aheadOf(w *waiter) int {
+	// This must be called with the mutex already locked
+	if i := slices.Index(c.waiters.waiters, w); i >= 0 {
+		return i
+	}
+	return len(c.waiters.waiters)
+}
+
+// retryAfterLocked returns how long until w is worth checking again: when a reservation expires if one does, otherwise
+// releasedRetry. With WithMaxWait it's never later than the end of the wait, so that w is turned away then.
+func (c *concurrencyLimiter) retryAfterLocked(w *waiter, n int) time.Duration {
+	// This must be called with the mutex already locked
+	retryIn := c.retryIn(c.nextAllowedTime(n), releasedRetry)
+	if c.maxWait > 0 {
+		retryIn = min(retryIn, max(c.maxWait-c.clock.Now().Sub(w.since), 0))
+	}
+	return retryIn
+}
+
+// costErrLocked denies n slots and returns an error if they're more than the limiter has, nil otherwise.
+func (c *concurrencyLimiter) costErrLocked(n int) error {
+	// This must be called with the mutex already locked
+	if n > c.max {
+		c.deny(ReasonLimited)
+		return fmt.Errorf("cost %d exceeds the limit of %d in flight", n, c.max)
+	}
+	return nil
+}
+
+func (c *concurrencyLimiter) Wait() {
+	_ = c.WaitContext(context.Background())
+}
+
+func (c *concurrencyLimiter) WaitTimeout(timeout time.Duration) error {
+	ctx, cancel := withTimeout(c.clock, timeout)
+	defer cancel()
+	return c.WaitContext(ctx)
+}
+
+func (c *concurrencyLimiter) AllowedReport() (AdmitReport, bool) {
+	return c.allowReport(func() bool { return c.allowLocked(1) })
+}
+
+func (c *concurrencyLimiter) WaitContextReport(ctx context.Context) (AdmitReport, error) {
+	return waitReport(ctx, c.WaitContext)
+}
+
+func (c *concurrencyLimiter) Allowed() bool {
+	return c.AllowN(1)
+}
+
+func (c *concurrencyLimiter) AllowN(n int) bool {
+	if n <= 0 {
+		return true
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.allowLocked(n)
+}
+
+func (c *concurrencyLimiter) allowLocked(n int) bool {
+	// This must be called with the mutex already locked
+	if c.denyHalted() {
+		return false
+	}
+
+	c.cleanupExpiredReservations()
+	if len(c.waiters.waiters) > 0 || c.freeLocked() < n || !c.closedUntil().IsZero() {
+		c.deny(c.limitedReason())
+		return false
+	}
+
+	c.take(n)
+	c.countAllowed()
+	return true
+}
+
+// take puts n more slots in flight.
+func (c *concurrencyLimiter) take(n int) {
+	// This must be called with the mutex already locked
+	c.inFlight += n
+	c.peak = max(c.peak, c.inFlight)
+}
+
+// freeLocked returns the slots neither in flight nor held by pending reservations.
+func (c *concurrencyLimiter) freeLocked() int {
+	// This must be called with the mutex already locked
+	return c.max - c.inFlight - reservedUnits(c.pendingReservations)
+}
+
+// roomLocked returns the slots Allowed could take right now, none while callers are waiting since it doesn't jump them.
+func (c *concurrencyLimiter) roomLocked() int {
+	// This must be called with the mutex already locked
+	if len(c.waiters.waiters) > 0 {
+		return 0
+	}
+	return c.freeLocked()
+}
+
+func (c *concurrencyLimiter) cleanupExpiredReservations() {
+	// This must be called with the mutex already locked
+	now := c.clock.Now()
+	for res := range c.pendingReservations {
+		if res.expiresAt != nil && now.After(*res.expiresAt) {
+			delete(c.pendingReservations, res)
+		}
+	}
+}
+
+// nextAllowedTime returns now if n slots are free and nobody is waiting for them, otherwise when the next pending
+// reservation expires, the only release that can be foreseen. It returns the zero time if none expires.
+func (c *concurrencyLimiter) nextAllowedTime(n int) time.Time {
+	// This must be called with the mutex already locked
+	now := c.clock.Now()
+	if len(c.waiters.waiters) == 0 && c.freeLocked() >= n {
+		return now
+	}
+
+	var next time.Time
+	for res := range c.pendingReservations {
+		if res.expiresAt != nil && (next.IsZero() || res.expiresAt.Before(next)) {
+			next = *res.expiresAt
+		}
+	}
+	if next.IsZero() {
+		return next
+	}
+	// Reservations expire just after their expiry
+	return latest(next.Add(time.Nanosecond), now)
+}
+
+// Clear cancels the pending reservations and frees every slot in flight. The release functions Acquire returned before
+// do nothing afterwards, but Release and ReleaseN can't tell old slots from new ones.
+func (c *concurrencyLimiter) Clear() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.cancelReservationsLocked()
+	c.inFlight = 0
+	c.generation++
+	c.waiters.notify()
+}
+
+func (c *concurrencyLimiter) Close() error {
+	return c.close(c.cancelReservationsLocked)
+}
+
+// cancelReservationsLocked cancels the pending reservations.
+func (c *concurrencyLimiter) cancelReservationsLocked() {
+	// This must be called with the mutex already locked
+	for res := range c.pendingReservations {
+		res.canceled = true
+	}
+
+	c.pendingReservations = make(map[*concurrencyReservation]struct{})
+}
+
+// Stats reports the slots in flight and the peak in Concurrency.
+func (c *concurrencyLimiter) Stats() Stats {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.cleanupExpiredReservations()
+
+	stats := c.stats()
+	stats.PendingReservations = len(c.pendingReservations)
+	stats.Concurrency = &ConcurrencyStats{InFlight: c.inFlight, PeakInFlight: c.peak, Max: c.max}
+	return stats
+}
+
+// Info reports the slots as the limit, with no window. Reset is now if no slot is taken or held, and the zero time
+// otherwise, since only releases free them.
+func (c *concurrencyLimiter) Info() LimitInfo {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.cleanupExpiredReservations()
+
+	var reset time.Time
+	if c.freeLocked() == c.max {
+		reset = c.clock.Now()
+	}
+	return c.info(c.max, c.roomLocked(), reset, 0)
+}
+
+// Available returns how many slots Allowed could take right now, without taking any.
+func (c *concurrencyLimiter) Available() int {
+	return c.Info().Remaining
+}
+
+func (c *concurrencyLimiter) EstimatedWait() time.Duration {
+	return c.waitUntil(c.Stats().NextAllowedTime)
+}
+
+// Config reports the slots as the Count, with no Per.
+func (c *concurrencyLimiter) Config() Config {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return Config{Algorithm: AlgorithmConcurrency, Count: c.max}
+}
+
+func (c *concurrencyLimiter) PendingReservationAges(n int) []time.Duration {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.cleanupExpiredReservations()
+
+	reservedAt := make([]time.Time, 0, len(c.pendingReservations))
+	for res := range c.pendingReservations {
+		reservedAt = append(reservedAt, res.reservedAt)
+	}
+	return c.reservationAges(reservedAt, n)
+}
+
+func (c *concurrencyLimiter) TryReserve(reservationTTL *time.Duration) (Reservation, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.cleanupExpiredReservations()
+	if c.denyHalted() {
+		return nil, false
+	}
+	if len(c.waiters.waiters) > 0 || c.freeLocked() < 1 || !c.closedUntil().IsZero() {
+		c.deny(c.limitedReason())
+		return nil, false
+	}
+	return c.reserveLocked(context.Background(), 1, reservationTTL), true
+}
+
+// reserveLocked holds n slots for a new reservation.
+func (c *concurrencyLimiter) reserveLocked(ctx context.Context, n int, reservationTTL *time.Duration) *concurrencyReservation {
+	// This must be called with the mutex already locked
+	reservation := &concurrencyReservation{
+		limiter:    c,
+		n:          n,
+		reservedAt: c.clock.Now(),
+		expiresAt:  c.expiryFor(ctx, reservationTTL),
+	}
+	c.pendingReservations[reservation] = struct{}{}
+	c.watchAbandoned(reservation.reservedAt, func() bool {
+		return pendingAt(c.clock.Now(), reservation.consumed, reservation.canceled, reservation.expiresAt)
+	})
+	return reservation
+}
+
+func (c *concurrencyLimiter) Reserve(reservationTTL *time.Duration) Reservation {
+	reservation, err := c.ReserveContext(context.Background(), reservationTTL)
+	if err != nil {
+		return failedReservation{err: err}
+	}
+	return reservation
+}
+
+func (c *concurrencyLimiter) ReserveTimeout(timeout time.Duration, reservationTTL *time.Duration) (Reservation, error) {
+	ctx, cancel := withTimeout(c.clock, timeout)
+	defer cancel()
+	return c.ReserveContext(ctx, reservationTTL)
+}
+
+// Permits delivers a permit per slot, which stays taken until it's released like the ones of Allowed.
+func (c *concurrencyLimiter) Permits(ctx context.Context) <-chan struct{} {
+	return deliverPermits(ctx, func(ctx context.Context) (permit, error) {
+		return reservePermit(ctx, c)
+	})
+}
+
+func (c *concurrencyLimiter) ReserveContext(ctx context.Context, reservationTTL *time.Duration) (Reservation, error) {
+	return c.ReserveN(ctx, 1, reservationTTL)
+}
+
+func (c *concurrencyLimiter) ReserveN(ctx context.Context, n int, reservationTTL *time.Duration) (Reservation, error) {
+	if err := reserveCostErr(n); err != nil {
+		return nil, err
+	}
+
+	// Reservations wait in the same line as the other callers, following the pause policy like awaitReservation
+	w := c.newWaiter(ctx)
+	w.reserving = true
+	var reservation *concurrencyReservation
+	err := c.awaitTurn(ctx, w, n, func() {
+		reservation = c.reserveLocked(ctx, n, reservationTTL)
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.link(ctx, reservation)
+	return reservation, nil
+}
+
+// concurrencyReservation implements the Reservation interface
+type concurrencyReservation struct {
+	limiter    *concurrencyLimiter
+	n          int // Slots held
+	reservedAt time.Time
+	expiresAt  *time.Time
+	consumed   bool
+	canceled   bool
+}
+
+// Consume puts the slots held in flight, they must be released with Release or ReleaseN once the operation is over.
+func (r *concurrencyReservation) Consume() error {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	if r.consumed {
+		return ErrReservationConsumed
+	}
+
+	if r.canceled {
+		return ErrReservationCanceled
+	}
+
+	if r.expiresAt != nil && r.limiter.clock.Now().After(*r.expiresAt) {
+		delete(r.limiter.pendingReservations, r)
+		r.limiter.waiters.notify()
+		return ErrReservationExpired
+	}
+
+	r.consumed = true
+	delete(r.limiter.pendingReservations, r)
+	r.limiter.take(r.n)
+	r.limiter.countAllowed()
+
+	return nil
+}
+
+// ConsumeContext is Consume, which never blocks.
+func (r *concurrencyReservation) ConsumeContext(context.Context) error {
+	return r.Consume()
+}
+
+func (r *concurrencyReservation) units() int {
+	return r.n
+}
+
+func (r *concurrencyReservation) Cancel() {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	if !r.consumed {
+		r.canceled = true
+		delete(r.limiter.pendingReservations, r)
+		r.limiter.waiters.notify()
+	}
+}
+
+func (r *concurrencyReservation) Detach() (ReservationHandle, error) {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	state := func() error {
+		return reservationErr(r.limiter.clock.Now(), r.consumed, r.canceled, r.expiresAt)
+	}
+	if err := state(); err != nil {
+		return ReservationHandle{}, err
+	}
+	return r.limiter.detach(r, r.expiresAt, state), nil
+}
+
+// ReadyAt is now, Consume never blocks.
+func (r *concurrencyReservation) ReadyAt() time.Time {
+	return r.limiter.clock.Now()
+}
+
+func (r *concurrencyReservation) Delay() time.Duration {
+	return 0
+}
+
+func (r *concurrencyReservation) ExpiresAt() (time.Time, bool) {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	return expiry(r.expiresAt)
+}
+
+func (r *concurrencyReservation) State() ReservationState {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	return reservationState(r.limiter.clock.Now(), r.consumed, r.canceled, r.expiresAt)
+}
@@ -0,0 +1,144 @@
+package limit_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limittest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConcurrencyLimiter_Allow(t *testing.T) {
+	t.Parallel()
+
+	limiter := limit.NewConcurrencyLimiter(2)
+	assert.True(t, limiter.Allowed())
+	assert.True(t, limiter.Allowed())
+	assert.False(t, limiter.Allowed())
+	assert.Equal(t, 2, limiter.InFlight())
+
+	// A released slot can be taken again
+	limiter.Release()
+	assert.True(t, limiter.Allowed())
+
+	stats := limiter.Stats()
+	assert.Equal(t, &limit.ConcurrencyStats{InFlight: 2, PeakInFlight: 2, Max: 2}, stats.Concurrency)
+	assert.Equal(t, limit.Config{Algorithm: limit.AlgorithmConcurrency, Count: 2}, limiter.Config())
+}
+
+func TestConcurrencyLimiter_Acquire(t *testing.T) {
+	t.Parallel()
+
+	limiter := limit.NewConcurrencyLimiter(1)
+	release, err := limiter.Acquire(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, limiter.Available())
+
+	acquired := make(chan func())
+	go func() {
+		release, err := limiter.Acquire(context.Background())
+		assert.NoError(t, err)
+		acquired <- release
+	}()
+	assert.Eventually(t, func() bool { return len(limiter.Waiters()) == 1 }, time.Second, time.Millisecond)
+
+	// Releasing hands the slot to the waiter, releasing again does nothing
+	release()
+	release()
+	next := <-acquired
+	assert.Equal(t, 1, limiter.InFlight())
+	next()
+	assert.Equal(t, 0, limiter.InFlight())
+
+	// A canceled context gives up
+	_, _ = limiter.Acquire(context.Background())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = limiter.Acquire(ctx)
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestConcurrencyLimiter_FIFO(t *testing.T) {
+	t.Parallel()
+
+	limiter := limit.NewConcurrencyLimiter(2)
+	assert.True(t, limiter.AllowN(2))
+
+	// A caller wanting both slots arrives first, one wanting a single slot doesn't jump it
+	order := make(chan int, 2)
+	go func() {
+		assert.NoError(t, limiter.WaitN(2))
+		order <- 2
+	}()
+	assert.Eventually(t, func() bool { return len(limiter.Waiters()) == 1 }, time.Second, time.Millisecond)
+	go func() {
+		assert.NoError(t, limiter.WaitN(1))
+		order <- 1
+	}()
+	assert.Eventually(t, func() bool { return len(limiter.Waiters()) == 2 }, time.Second, time.Millisecond)
+
+	limiter.Release()
+	assert.False(t, limiter.Allowed(), "Allowed doesn't jump the waiters")
+	limiter.Release()
+	assert.Equal(t, 2, <-order)
+
+	limiter.ReleaseN(2)
+	assert.Equal(t, 1, <-order)
+	assert.Equal(t, 2, limiter.Stats().Concurrency.PeakInFlight)
+}
+
+func TestConcurrencyLimiter_Reservations(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	limiter := limit.NewConcurrencyLimiter(2, limit.WithClock(clock))
+
+	ttl := 100 * time.Millisecond
+	expiring := limiter.Reserve(&ttl)
+	held := limiter.Reserve(nil)
+	assert.False(t, limiter.Allowed())
+	assert.Equal(t, ttl+time.Nanosecond, limiter.EstimatedWait())
+
+	// An expired reservation frees its slot
+	clock.Advance(ttl + time.Millisecond)
+	assert.ErrorIs(t, expiring.Consume(), limit.ErrReservationExpired)
+	assert.Equal(t, 1, limiter.Available())
+
+	// A consumed one holds its slot until released
+	assert.NoError(t, held.Consume())
+	assert.Equal(t, 1, limiter.InFlight())
+	assert.Equal(t, 0, limiter.Stats().PendingReservations)
+
+	// A canceled one gives it back
+	reservation, ok := limiter.TryReserve(nil)
+	assert.True(t, ok)
+	assert.Equal(t, 0, limiter.Available())
+	reservation.Cancel()
+	assert.Equal(t, 1, limiter.Available())
+	limiter.Release()
+	assert.Equal(t, 2, limiter.Available())
+}
+
+func TestConcurrencyLimiter_MaxWait(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	limiter := limit.NewConcurrencyLimiter(1, limit.WithClock(clock), limit.WithMaxWait(time.Second))
+	assert.True(t, limiter.Allowed())
+
+	// There's no telling when the slot is released, the caller waits up to the max wait
+	waited := make(chan error)
+	go func() {
+		waited <- limiter.WaitContext(context.Background())
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	clock.BlockUntil(1)
+	clock.Advance(time.Nanosecond)
+
+	var tooLong *limit.WaitTooLongError
+	assert.ErrorAs(t, <-waited, &tooLong)
+}
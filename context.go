@@ -0,0 +1,51 @@
+package limit
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNoLimiter is returned by WaitFromContext, with WithRequiredLimiter, when the context carries no limiter.
+var ErrNoLimiter = errors.New("no limiter in the context")
+
+// limiterKey is the context key of the limiter set with NewContext.
+type limiterKey struct{}
+
+// rateKeyKey is the context key of the rate limit key set with NewKeyContext.
+type rateKeyKey struct{}
+
+// NewContext returns a context carrying l, for the code handling a request deep in the stack to get with FromContext.
+func NewContext(ctx context.Context, l Limiter) context.Context {
+	return context.WithValue(ctx, limiterKey{}, l)
+}
+
+// FromContext returns the limiter set on ctx with NewContext, if any.
+func FromContext(ctx context.Context) (Limiter, bool) {
+	l, ok := ctx.Value(limiterKey{}).(Limiter)
+	return l, ok && l != nil
+}
+
+// NewKeyContext returns a context carrying the rate limit key of a request, e.g. its tenant, to get with
+// KeyFromContext.
+func NewKeyContext(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, rateKeyKey{}, key)
+}
+
+// KeyFromContext returns the rate limit key set on ctx with NewKeyContext, if any.
+func KeyFromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(rateKeyKey{}).(string)
+	return key, ok
+}
+
+// WaitFromContext waits on the limiter set on ctx with NewContext. It returns right away if there is none, or
+// ErrNoLimiter with WithRequiredLimiter.
+func WaitFromContext(ctx context.Context, opts ...Option) error {
+	l, ok := FromContext(ctx)
+	if !ok {
+		if newOptions(opts).requireLimiter {
+			return ErrNoLimiter
+		}
+		return nil
+	}
+	return l.WaitContext(ctx)
+}
@@ -0,0 +1,70 @@
+package limit_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/stretchr/testify/assert"
+)
+
+type otherKey struct{}
+
+func TestNewContext(t *testing.T) {
+	t.Parallel()
+
+	limiter := limit.NewTokenBucket(1, 1*time.Hour)
+	ctx := limit.NewKeyContext(limit.NewContext(context.Background(), limiter), "tenant-a")
+
+	// Both survive nested contexts
+	nested, cancel := context.WithCancel(context.WithValue(ctx, otherKey{}, "value"))
+	defer cancel()
+	got, ok := limit.FromContext(nested)
+	assert.True(t, ok)
+	assert.Same(t, limiter, got)
+	key, ok := limit.KeyFromContext(nested)
+	assert.True(t, ok)
+	assert.Equal(t, "tenant-a", key)
+
+	// The innermost one wins
+	inner := limit.NewTokenBucket(1, 1*time.Hour)
+	got, _ = limit.FromContext(limit.NewContext(nested, inner))
+	assert.Same(t, inner, got)
+
+	assert.NoError(t, limit.WaitFromContext(nested))
+	assert.Equal(t, 1, limiter.Stats().AllowedRequests)
+}
+
+func TestWaitFromContext_NoLimiter(t *testing.T) {
+	t.Parallel()
+
+	_, ok := limit.FromContext(context.Background())
+	assert.False(t, ok)
+	_, ok = limit.KeyFromContext(context.Background())
+	assert.False(t, ok)
+
+	assert.NoError(t, limit.WaitFromContext(context.Background()))
+	assert.ErrorIs(t, limit.WaitFromContext(context.Background(), limit.WithRequiredLimiter()), limit.ErrNoLimiter)
+	assert.ErrorIs(t, limit.WaitFromContext(limit.NewContext(context.Background(), nil), limit.WithRequiredLimiter()), limit.ErrNoLimiter)
+}
+
+func TestMiddleware_LimiterInContext(t *testing.T) {
+	t.Parallel()
+
+	limiter := limit.NewTokenBucket(3, 1*time.Hour)
+	handler := limit.Middleware(limit.Routes{
+		{Name: "search", Pattern: "/search", Limiter: limiter},
+	}, limit.WithLimiterInContext())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The handler takes one more permit of the same limiter
+		key, _ := limit.KeyFromContext(r.Context())
+		assert.Equal(t, "search", key)
+		assert.NoError(t, limit.WaitFromContext(r.Context(), limit.WithRequiredLimiter()))
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/search", nil))
+	assert.Equal(t, 2, limiter.Stats().AllowedRequests)
+	assert.Equal(t, 1, limiter.Info().Remaining)
+}
@@ -0,0 +1,106 @@
+package limit
+
+import (
+	"context"
+	"maps"
+	"sync"
+)
+
+// CostStats are the counters CostMap keeps for each name.
+type CostStats struct {
+	AllowedRequests int
+	DeniedRequests  int
+	// Units consumed by the allowed requests
+	Units int
+}
+
+// CostMap charges each named operation its own cost against a shared limiter, e.g. a search costing 5 units of a
+// vendor's quota while a get costs 1.
+type CostMap struct {
+	// Mutex
+	mux sync.Mutex
+
+	// Config
+	limiter     Limiter
+	costs       map[string]int
+	defaultCost int
+
+	// State
+	stats map[string]CostStats
+}
+
+// NewCosted returns a CostMap consuming costs[name] units of l for each operation, or defaultCost for names not in
+// costs. Operations costing zero bypass the limiter.
+func NewCosted(l Limiter, costs map[string]int, defaultCost int) *CostMap {
+	return &CostMap{
+		limiter:     l,
+		costs:       maps.Clone(costs),
+		defaultCost: defaultCost,
+		stats:       make(map[string]CostStats),
+	}
+}
+
+// Cost returns the units charged for name.
+func (c *CostMap) Cost(name string) int {
+	if cost, ok := c.costs[name]; ok {
+		return cost
+	}
+	return c.defaultCost
+}
+
+// WaitFor blocks until the limiter admits the cost of name or the context is done.
+func (c *CostMap) WaitFor(ctx context.Context, name string) error {
+	cost := c.Cost(name)
+	err := c.wait(ctx, cost)
+	c.record(name, cost, err == nil)
+	return err
+}
+
+// AllowedFor reports whether the limiter admits the cost of name right now, consuming it if so.
+func (c *CostMap) AllowedFor(name string) bool {
+	cost := c.Cost(name)
+	ok := c.allow(cost)
+	c.record(name, cost, ok)
+	return ok
+}
+
+// Stats returns the counters of every name used so far.
+func (c *CostMap) Stats() map[string]CostStats {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return maps.Clone(c.stats)
+}
+
+func (c *CostMap) wait(ctx context.Context, cost int) error {
+	if cost <= 0 {
+		return nil
+	}
+
+	return c.limiter.WaitNContext(ctx, cost)
+}
+
+func (c *CostMap) allow(cost int) bool {
+	return allowCost(c.limiter, cost)
+}
+
+// allowCost reports whether l admits cost units right now, consuming them if so.
+func allowCost(l Limiter, cost int) bool {
+	if cost <= 0 {
+		return true
+	}
+	return l.AllowN(cost)
+}
+
+func (c *CostMap) record(name string, cost int, allowed bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	stats := c.stats[name]
+	if allowed {
+		stats.AllowedRequests++
+		stats.Units += max(cost, 0)
+	} else {
+		stats.DeniedRequests++
+	}
+	c.stats[name] = stats
+}
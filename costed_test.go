@@ -0,0 +1,78 @@
+package limit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCostMap_AllowedFor(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range map[string]func(count int, duration time.Duration, opts ...limit.Option) limit.Limiter{
+		"RollingWindow": limit.NewRollingWindow,
+		"TokenBucket":   limit.NewTokenBucket,
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			costs := limit.NewCosted(newLimiter(10, 1*time.Second), map[string]int{"search": 5, "get": 1, "health": 0}, 2)
+
+			assert.True(t, costs.AllowedFor("search"))
+			assert.True(t, costs.AllowedFor("get"))
+			assert.True(t, costs.AllowedFor("list")) // Default cost
+			assert.False(t, costs.AllowedFor("search"))
+			assert.True(t, costs.AllowedFor("list"))
+			assert.False(t, costs.AllowedFor("get"))
+
+			// Zero cost bypasses the limiter
+			assert.True(t, costs.AllowedFor("health"))
+
+			stats := costs.Stats()
+			assert.Equal(t, limit.CostStats{AllowedRequests: 1, DeniedRequests: 1, Units: 5}, stats["search"])
+			assert.Equal(t, limit.CostStats{AllowedRequests: 1, DeniedRequests: 1, Units: 1}, stats["get"])
+			assert.Equal(t, limit.CostStats{AllowedRequests: 2, Units: 4}, stats["list"])
+			assert.Equal(t, limit.CostStats{AllowedRequests: 1}, stats["health"])
+		})
+	}
+}
+
+func TestCostMap_WaitFor(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range limiterConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			costs := limit.NewCosted(newLimiter(10, 100*time.Millisecond), map[string]int{"search": 5}, 1)
+
+			start := time.Now()
+			assert.NoError(t, costs.WaitFor(context.Background(), "search"))
+			assert.NoError(t, costs.WaitFor(context.Background(), "search"))
+			assert.NoError(t, costs.WaitFor(context.Background(), "search"))
+			// The third search had to wait for the first units to free up
+			assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+
+			assert.Equal(t, limit.CostStats{AllowedRequests: 3, Units: 15}, costs.Stats()["search"])
+		})
+	}
+}
+
+func TestCostMap_WaitFor_CostAboveLimit(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range limiterConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			costs := limit.NewCosted(newLimiter(3, 1*time.Second), map[string]int{"export": 4}, 1)
+
+			// It could never be admitted, so it fails right away instead of waiting forever
+			assert.Error(t, costs.WaitFor(context.Background(), "export"))
+			assert.Equal(t, limit.CostStats{DeniedRequests: 1}, costs.Stats()["export"])
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package limit
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+// DelayedReserver is implemented by the limiters that can book a reservation ahead of their capacity, like the Reserve
+// of golang.org/x/time/rate: the token bucket, the rolling window and the leaky bucket.
+type DelayedReserver interface {
+	// ReserveDelayed reserves right away even if the limiter is exhausted, and returns the Delay of the reservation,
+	// how long until Consume completes without waiting. Consuming it sooner waits the delay out. The reservation holds
+	// its place ahead of later requests until it's consumed, canceled or expires, and canceling it gives the place
+	// back. It only fails while the limiter is closed or paused, or if it can never allow the request, returning a
+	// reservation whose Consume returns why.
+	ReserveDelayed(reservationTTL *time.Duration) (Reservation, time.Duration)
+}
+
+var (
+	_ DelayedReserver = (*tokenBucket)(nil)
+	_ DelayedReserver = (*rollingWindow)(nil)
+	_ DelayedReserver = (*leakyBucket)(nil)
+)
+
+// ReserveDelayed lets the tokens held by reservations exceed the ones in the bucket, the missing ones are ready as the
+// bucket refills after the reservations before them are consumed.
+func (t *tokenBucket) ReserveDelayed(reservationTTL *time.Duration) (Reservation, time.Duration) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	t.availableLocked(0)
+	if err := t.delayedErrLocked(1, t.costErrLocked); err != nil {
+		return failedReservation{err: err}, 0
+	}
+
+	now := t.clock.Now()
+	readyAt := now
+	if missing := reservedUnits(t.pendingReservations) + 1 - t.currentCapacity; missing > 0 {
+		readyAt = t.lastRefill.Add(time.Duration(missing) * t.refillRate)
+	}
+	readyAt = latest(t.afterClosed(readyAt), now)
+
+	reservation := &tokenBucketReservation{
+		limiter:    t,
+		n:          1,
+		reservedAt: now,
+		expiresAt:  t.expiryFor(context.Background(), reservationTTL),
+		readyAt:    readyAt,
+	}
+	t.pendingReservations[reservation] = struct{}{}
+	t.watchAbandoned(reservation.reservedAt, func() bool {
+		return pendingAt(t.clock.Now(), reservation.consumed, reservation.canceled, reservation.expiresAt)
+	})
+	return reservation, readyAt.Sub(now)
+}
+
+// ReserveDelayed projects when the oldest events leave the window, counting the pending reservations as events
+// recorded once they're ready. The reservation records its event when consumed, whatever the ReservationMode.
+func (r *rollingWindow) ReserveDelayed(reservationTTL *time.Duration) (Reservation, time.Duration) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.availableLocked(0)
+	if err := r.delayedErrLocked(1, r.costErrLocked); err != nil {
+		return failedReservation{err: err}, 0
+	}
+
+	now := r.clock.Now()
+	readyAt := latest(r.afterClosed(r.delayedReadyAt(now, 1)), now)
+
+	reservation := &rollingWindowReservation{
+		limiter:    r,
+		n:          1,
+		reservedAt: now,
+		expiresAt:  r.expiryFor(context.Background(), reservationTTL),
+		readyAt:    readyAt,
+	}
+	r.pendingReservations[reservation] = struct{}{}
+	r.watchAbandoned(reservation.reservedAt, func() bool {
+		return pendingAt(r.clock.Now(), reservation.consumed, reservation.canceled, reservation.expiresAt)
+	})
+	return reservation, readyAt.Sub(now)
+}
+
+// delayedReadyAt returns when n more events fit in the window, counting the pending reservations as events recorded
+// once they're ready.
+func (r *rollingWindow) delayedReadyAt(now time.Time, n int) time.Time {
+	// This must be called with the mutex already locked
+	recorded := make([]time.Time, 0, len(r.rollingWindow))
+	for _, event := range r.rollingWindow {
+		recorded = append(recorded, event.timestamp)
+	}
+	for res := range r.pendingReservations {
+		for range res.n {
+			recorded = append(recorded, latest(res.readyAt, now))
+		}
+	}
+
+	excess := len(recorded) + n - r.maxEventCount
+	if excess <= 0 {
+		return now
+	}
+	slices.SortFunc(recorded, time.Time.Compare)
+	// Events leave the window just after its duration
+	return recorded[excess-1].Add(r.rateDuration + time.Nanosecond)
+}
+
+// ReserveDelayed books room in the queue even if it's full, the reservation is ready once the queued events and the
+// ones of the reservations before it have leaked. Allowed doesn't jump it, but callers queuing later may leak first
+// while it isn't consumed.
+func (l *leakyBucket) ReserveDelayed(reservationTTL *time.Duration) (Reservation, time.Duration) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	l.tuneQueue()
+	l.cleanupExpiredReservations()
+	if err := l.delayedErrLocked(1, l.costErrLocked); err != nil {
+		return failedReservation{err: err}, 0
+	}
+
+	now := l.clock.Now()
+	l.sinceLastLeak() // Bring a last leak from the future back to now
+	position := l.currentCapacity + reservedUnits(l.pendingReservations) + 1
+	readyAt := latest(l.afterClosed(l.lastLeak.Add(time.Duration(position)*l.leakRate)), now)
+
+	reservation := &leakyBucketReservation{
+		limiter:    l,
+		n:          1,
+		reservedAt: now,
+		expiresAt:  l.expiryFor(context.Background(), reservationTTL),
+		readyAt:    readyAt,
+	}
+	l.pendingReservations[reservation] = struct{}{}
+	l.watchAbandoned(reservation.reservedAt, func() bool {
+		return pendingAt(l.clock.Now(), reservation.consumed, reservation.canceled, reservation.expiresAt)
+	})
+	return reservation, readyAt.Sub(now)
+}
+
+// delayedAhead reports whether a reservation taken with ReserveDelayed is waiting for its turn to leak, which Allowed
+// doesn't jump.
+func (l *leakyBucket) delayedAhead() bool {
+	// This must be called with the mutex already locked
+	l.cleanupExpiredReservations()
+	for res := range l.pendingReservations {
+		if !res.readyAt.IsZero() {
+			return true
+		}
+	}
+	return false
+}
+
+// delayedErrLocked denies a delayed reservation of n units and returns why if the limiter is closed or paused, or if
+// costErr turns the cost down.
+func (b *base) delayedErrLocked(n int, costErr func(n int) error) error {
+	// This must be called with the mutex already locked
+	switch {
+	case b.stopped:
+		b.deny(ReasonClosed)
+		return ErrLimiterClosed
+	case b.paused:
+		b.deny(ReasonPaused)
+		return ErrPaused
+	}
+	return costErr(n)
+}
+
+// sleepUntil blocks until readyAt, the time a reservation taken with ReserveDelayed is ready. If ctx is done first it
+// calls giveUp, counts the request as denied and returns why.
+func (b *base) sleepUntil(ctx context.Context, readyAt time.Time, giveUp func()) error {
+	start := b.clock.Now()
+	wait := readyAt.Sub(start)
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := b.clock.NewTimer(b.jitter.stretch(wait))
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+		return nil
+	case <-ctx.Done():
+	}
+
+	giveUp()
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.deny(ReasonContext)
+	return contextError(ctx, b.name, b.clock.Now().Sub(start), b.retryAfter())
+}
@@ -0,0 +1,141 @@
+package limit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrQueueFull is returned when there is no room in a queue, with RejectNew, the default OverflowPolicy.
+var ErrQueueFull = errors.New("max allowed queue reached")
+
+// ErrReservationExpired is returned when consuming a reservation after its TTL, or when it expires while waiting to be
+// consumed.
+var ErrReservationExpired = errors.New("reservation expired")
+
+// ErrReservationConsumed is returned when consuming a reservation, or detaching it, after it was already consumed.
+var ErrReservationConsumed = errors.New("reservation already consumed")
+
+// ErrEvicted is returned to a queued caller evicted to make room for a newer one, see DropOldest.
+var ErrEvicted = errors.New("evicted from the queue")
+
+// ErrDropped is returned to a caller dropped because the queue was full, see DropNewest.
+var ErrDropped = errors.New("dropped, the queue is full")
+
+// ErrWaitCanceled is returned to the callers ejected by CancelWaiters when no other error is given.
+var ErrWaitCanceled = errors.New("wait canceled")
+
+// ErrLimiterClosed is returned by a limiter once it was closed with Close, to the blocked callers it wakes and to every
+// later call.
+var ErrLimiterClosed = errors.New("limiter closed")
+
+// ErrPaused is returned to reservations requested while the limiter is paused with FailWhilePaused, see Pauser.
+var ErrPaused = errors.New("limiter paused")
+
+// ErrReservationCanceled is returned when consuming a reservation that was canceled, by Cancel, Clear, Close or its
+// context ending with WithLinkedReservations.
+var ErrReservationCanceled = errors.New("reservation was canceled")
+
+// ErrOversubscribed is returned when consuming a reservation taken beyond the capacity, as allowed by
+// WithReservationOversubscription, while there is no capacity for it. The reservation stays pending, so Consume can be
+// tried again later, or it can be canceled.
+var ErrOversubscribed = errors.New("reservation is oversubscribed, no capacity to consume it")
+
+// ErrUnknownReservation is returned when attaching a handle the limiter doesn't know of, see Attacher.
+var ErrUnknownReservation = errors.New("unknown reservation")
+
+// ErrWaitTooLong is wrapped by WaitTooLongError.
+var ErrWaitTooLong = errors.New("wait would take too long")
+
+// WaitTooLongError is returned to a caller expected to wait longer than the limit set with WithMaxWait.
+type WaitTooLongError struct {
+	// Limiter is the name given to the limiter with WithName, empty if it wasn't named.
+	Limiter string
+	// Estimate is how long the caller was expected to wait in total.
+	Estimate time.Duration
+	// MaxWait is the limit set with WithMaxWait.
+	MaxWait time.Duration
+}
+
+func (e *WaitTooLongError) Error() string {
+	if e.Limiter == "" {
+		return fmt.Sprintf("%v: expected %s, max %s", ErrWaitTooLong, e.Estimate, e.MaxWait)
+	}
+	return fmt.Sprintf("limiter %q: %v: expected %s, max %s", e.Limiter, ErrWaitTooLong, e.Estimate, e.MaxWait)
+}
+
+func (e *WaitTooLongError) Unwrap() error {
+	return ErrWaitTooLong
+}
+
+// LimitError is returned when a limiter gives up on a caller: when its context is done, or for the leaky bucket when
+// the queue is full or a reservation expires while waiting to leak. RetryAfter tells when to try again, e.g. for a
+// Retry-After header. It unwraps to both the context's error and its cause, so
+// errors.Is(err, context.DeadlineExceeded) keeps working.
+type LimitError struct {
+	// Limiter is the name given to the limiter with WithName, empty if it wasn't named.
+	Limiter string
+	// Reason is why the limiter gave up: ReasonContext, ReasonQueueFull or ReasonExpired.
+	Reason Reason
+	// Waited is how long the caller waited before the limiter gave up.
+	Waited time.Duration
+	// RetryAfter is how long until the limiter could allow the next request when it gave up, the NextAllowedTime of its
+	// stats. Zero if it could right away or it's unknown, e.g. until reservations are consumed or canceled.
+	RetryAfter time.Duration
+	// Err is the reason the limiter gave up, the context's cause when it comes from a context.
+	Err error
+
+	ctxErr error
+}
+
+func (e *LimitError) Error() string {
+	msg := fmt.Sprintf("limiter gave up after %s: %v", e.Waited, e.Err)
+	if e.Limiter != "" {
+		msg = fmt.Sprintf("limiter %q gave up after %s: %v", e.Limiter, e.Waited, e.Err)
+	}
+	if e.RetryAfter > 0 {
+		msg += fmt.Sprintf(", retry after %s", e.RetryAfter)
+	}
+	return msg
+}
+
+func (e *LimitError) Unwrap() []error {
+	if e.ctxErr == nil || e.ctxErr == e.Err {
+		return []error{e.Err}
+	}
+	return []error{e.Err, e.ctxErr}
+}
+
+// BackendError is returned by a limiter that couldn't decide on a request because its backend failed, e.g. a remote
+// store timing out, as opposed to its limit turning the request down. NewFallbackLimiter switches to its secondary
+// limiter on it.
+type BackendError struct {
+	// Limiter is the name given to the limiter with WithName, empty if it wasn't named.
+	Limiter string
+	// Err is the error of the backend.
+	Err error
+}
+
+func (e *BackendError) Error() string {
+	if e.Limiter == "" {
+		return fmt.Sprintf("limiter backend failed: %v", e.Err)
+	}
+	return fmt.Sprintf("limiter %q backend failed: %v", e.Limiter, e.Err)
+}
+
+func (e *BackendError) Unwrap() error {
+	return e.Err
+}
+
+// contextError builds the error returned when ctx is done after waiting for the given duration.
+func contextError(ctx context.Context, name string, waited, retryAfter time.Duration) error {
+	return &LimitError{
+		Limiter:    name,
+		Reason:     ReasonContext,
+		Waited:     waited,
+		RetryAfter: retryAfter,
+		Err:        context.Cause(ctx),
+		ctxErr:     ctx.Err(),
+	}
+}
@@ -0,0 +1,198 @@
+package limit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// eventsBuffer is how many decisions EventsHandler buffers for a connection before dropping its backlog.
+const eventsBuffer = 100
+
+// Decision is a request a limiter allowed or denied, streamed by EventsHandler.
+type Decision struct {
+	Time time.Time `json:"time"`
+	// The name of the limiter in the map given to EventsHandler
+	Limiter string `json:"limiter"`
+	Allowed bool   `json:"allowed"`
+	// Why the request was denied, empty if it was allowed
+	Reason Reason `json:"reason,omitempty"`
+}
+
+// decisionListener is called with every request a limiter allows or denies, with the limiter's mutex locked, so it
+// must not block.
+type decisionListener func(at time.Time, allowed bool, reason Reason)
+
+// decisionSource is implemented by the limiters in this package, which can call listeners with their decisions.
+type decisionSource interface {
+	// listen calls fn with every decision until the returned function is called.
+	listen(fn decisionListener) (stop func())
+}
+
+func (b *base) listen(fn decisionListener) func() {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if b.listeners == nil {
+		b.listeners = make(map[int]decisionListener)
+	}
+	id := b.nextListenerID
+	b.nextListenerID++
+	b.listeners[id] = fn
+	return func() {
+		b.mux.Lock()
+		defer b.mux.Unlock()
+		delete(b.listeners, id)
+	}
+}
+
+// publish calls the listeners with a decision.
+func (b *base) publish(allowed bool, reason Reason) {
+	// This must be called with the mutex already locked
+	if len(b.listeners) == 0 {
+		return
+	}
+	now := b.clock.Now()
+	for _, fn := range b.listeners {
+		fn(now, allowed, reason)
+	}
+}
+
+// EventsHandler serves a Server-Sent Events stream of the decisions of limiters, by name, for live dashboards. Each
+// decision is sent as a "decision" event with a Decision in JSON, and the Stats of every limiter as a "stats" event
+// every 5 seconds, or as set with WithStatsHeartbeat. Limiters not built by this package only show up in the stats.
+//
+// The "limiter" query parameter, which can be repeated, limits the stream to the named limiters, and "outcome",
+// "allowed" or "denied", to one kind of decision. A connection that can't keep up gets its backlog dropped, so it never
+// blocks the limiters, and a "dropped" event with how many decisions it missed. The stream ends when the client
+// disconnects. It accepts WithStatsHeartbeat and WithClock.
+func EventsHandler(limiters map[string]Limiter, opts ...Option) http.Handler {
+	o := newOptions(opts)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		names := r.URL.Query()["limiter"]
+		outcome := r.URL.Query().Get("outcome")
+		if outcome != "" && outcome != "allowed" && outcome != "denied" {
+			http.Error(w, fmt.Sprintf("unknown outcome %q", outcome), http.StatusBadRequest)
+			return
+		}
+
+		stream := newDecisionStream()
+		for name, l := range limiters {
+			source, ok := l.(decisionSource)
+			if !ok || (len(names) > 0 && !slices.Contains(names, name)) {
+				continue
+			}
+			stop := source.listen(func(at time.Time, allowed bool, reason Reason) {
+				if outcome == "" || allowed == (outcome == "allowed") {
+					stream.send(Decision{Time: at, Limiter: name, Allowed: allowed, Reason: reason})
+				}
+			})
+			defer stop()
+		}
+
+		rc := http.NewResponseController(w)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		heartbeat := o.clock.NewTimer(o.statsHeartbeat)
+		defer heartbeat.Stop()
+		for {
+			var err error
+			select {
+			case <-r.Context().Done():
+				return
+			case d := <-stream.decisions:
+				if dropped := stream.takeDropped(); dropped > 0 {
+					err = writeEvent(w, "dropped", map[string]int{"dropped": dropped})
+				}
+				if err == nil {
+					err = writeEvent(w, "decision", d)
+				}
+			case <-heartbeat.C():
+				heartbeat.Reset(o.statsHeartbeat)
+				stats := make(map[string]Stats, len(limiters))
+				for name, l := range limiters {
+					if len(names) == 0 || slices.Contains(names, name) {
+						stats[name] = l.Stats()
+					}
+				}
+				err = writeEvent(w, "stats", stats)
+			}
+			if err == nil {
+				err = rc.Flush()
+			}
+			if err != nil {
+				return
+			}
+		}
+	})
+}
+
+// decisionStream buffers the decisions of a connection of EventsHandler, dropping the backlog when it's full.
+type decisionStream struct {
+	decisions chan Decision
+
+	// Mutex
+	mux sync.Mutex
+
+	// State
+	dropped int // Not reported yet
+}
+
+func newDecisionStream() *decisionStream {
+	return &decisionStream{decisions: make(chan Decision, eventsBuffer)}
+}
+
+// send buffers d, dropping the buffered decisions first if there is no room. It never blocks, it's called with a
+// limiter's mutex locked.
+func (s *decisionStream) send(d Decision) {
+	select {
+	case s.decisions <- d:
+		return
+	default:
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for drained := false; !drained; {
+		select {
+		case <-s.decisions:
+			s.dropped++
+		default:
+			drained = true
+		}
+	}
+	select {
+	case s.decisions <- d:
+	default:
+		// Another limiter filled the buffer again meanwhile
+		s.dropped++
+	}
+}
+
+// takeDropped returns the decisions dropped since it was last called.
+func (s *decisionStream) takeDropped() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	dropped := s.dropped
+	s.dropped = 0
+	return dropped
+}
+
+// writeEvent writes an event named name with v in JSON as its data.
+func writeEvent(w io.Writer, name string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data)
+	return err
+}
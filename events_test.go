@@ -0,0 +1,156 @@
+package limit_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limittest"
+	"github.com/stretchr/testify/assert"
+)
+
+type sseEvent struct {
+	name string
+	data string
+}
+
+// readEvent reads the next event of a Server-Sent Events stream.
+func readEvent(t *testing.T, r *bufio.Reader) sseEvent {
+	t.Helper()
+	var e sseEvent
+	for {
+		line, err := r.ReadString('\n')
+		if !assert.NoError(t, err) {
+			return e
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			return e
+		case strings.HasPrefix(line, "event: "):
+			e.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			e.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestEventsHandler(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	search := limit.NewTokenBucket(1, 1*time.Hour, limit.WithClock(clock))
+	other := limit.NewTokenBucket(1, 1*time.Hour, limit.WithClock(clock))
+	server := httptest.NewServer(limit.EventsHandler(map[string]limit.Limiter{"search": search, "other": other},
+		limit.WithClock(clock), limit.WithStatsHeartbeat(10*time.Second)))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"?limiter=search&outcome=denied", nil)
+	resp, err := server.Client().Do(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	stream := bufio.NewReader(resp.Body)
+
+	// Only the denials of the search limiter are streamed
+	assert.True(t, other.Allowed())
+	assert.False(t, other.Allowed())
+	assert.True(t, search.Allowed())
+	assert.False(t, search.Allowed())
+	event := readEvent(t, stream)
+	assert.Equal(t, "decision", event.name)
+	var decision limit.Decision
+	assert.NoError(t, json.Unmarshal([]byte(event.data), &decision))
+	assert.True(t, decision.Time.Equal(time.Unix(0, 0)))
+	decision.Time = time.Time{}
+	assert.Equal(t, limit.Decision{Limiter: "search", Reason: limit.ReasonLimited}, decision)
+
+	// The stats heartbeat
+	clock.BlockUntil(1)
+	clock.Advance(10 * time.Second)
+	event = readEvent(t, stream)
+	assert.Equal(t, "stats", event.name)
+	var stats map[string]limit.Stats
+	assert.NoError(t, json.Unmarshal([]byte(event.data), &stats))
+	assert.Len(t, stats, 1)
+	assert.Equal(t, 1, stats["search"].DeniedRequests)
+}
+
+func TestEventsHandler_BadOutcome(t *testing.T) {
+	t.Parallel()
+
+	rec := httptest.NewRecorder()
+	limit.EventsHandler(nil).ServeHTTP(rec, httptest.NewRequest("GET", "/?outcome=maybe", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+// stalledWriter is a ResponseWriter whose writes block until it's released, like a client that stopped reading.
+type stalledWriter struct {
+	header  http.Header
+	flushed chan struct{}
+	release chan struct{}
+
+	mux  sync.Mutex
+	body strings.Builder
+	once sync.Once
+}
+
+func (w *stalledWriter) Header() http.Header { return w.header }
+
+func (w *stalledWriter) WriteHeader(int) {}
+
+func (w *stalledWriter) Write(p []byte) (int, error) {
+	<-w.release
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	return w.body.Write(p)
+}
+
+func (w *stalledWriter) Flush() {
+	w.once.Do(func() { close(w.flushed) })
+}
+
+func (w *stalledWriter) String() string {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	return w.body.String()
+}
+
+func TestEventsHandler_SlowConsumer(t *testing.T) {
+	t.Parallel()
+
+	limiter := limit.NewTokenBucket(1, 1*time.Hour)
+	w := &stalledWriter{header: make(http.Header), flushed: make(chan struct{}), release: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		limit.EventsHandler(map[string]limit.Limiter{"search": limiter}).ServeHTTP(w, httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+	}()
+	<-w.flushed
+
+	// The limiter never blocks on the stalled client
+	for i := 0; i < 1000; i++ {
+		limiter.Allowed()
+	}
+	close(w.release)
+
+	// The client learns how many decisions it missed
+	assert.Eventually(t, func() bool {
+		return strings.Contains(w.String(), "event: dropped\n")
+	}, 1*time.Second, 1*time.Millisecond)
+	cancel()
+	<-done
+	assert.LessOrEqual(t, strings.Count(w.String(), "event: decision\n"), 101)
+}
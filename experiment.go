@@ -0,0 +1,341 @@
+package limit
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"hash/fnv"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+)
+
+// experimentSamples is how many of the latest waits of each arm an experiment keeps to estimate their p95.
+const experimentSamples = 1024
+
+// assignmentKey is the context key of the key set with WithAssignmentKey.
+type assignmentKey struct{}
+
+// WithAssignmentKey returns a context making an experiment assign the calls made with it by key, so every call with the
+// same key goes to the same arm. Calls without a key are assigned at random.
+func WithAssignmentKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, assignmentKey{}, key)
+}
+
+// ArmReport describes the calls an experiment sent to one of its arms.
+type ArmReport struct {
+	// The calls assigned to the arm, made through the experiment
+	Calls int
+	// The calls the arm denied or gave up on
+	Denied int
+	// Denied over Calls, zero without calls
+	DenialRate float64
+	// The 95th percentile of how long the admitted calls waited, over the latest 1024 of them
+	WaitP95 time.Duration
+	// The stats of the arm's limiter, which include calls not made through the experiment if it's shared
+	Stats Stats
+}
+
+// ExperimentReport compares the arms of an experiment, see Experiment.
+type ExperimentReport struct {
+	// The fraction of calls currently assigned to the candidate
+	Fraction  float64
+	Control   ArmReport
+	Candidate ArmReport
+	// The candidate's denial rate minus the control's
+	DenialRateDelta float64
+	// The candidate's wait p95 minus the control's
+	WaitP95Delta time.Duration
+}
+
+// ExperimentLimiter is a Limiter splitting calls between a control and a candidate limiter, see Experiment.
+type ExperimentLimiter interface {
+	Limiter
+	// Report compares the outcomes of the calls sent to each arm.
+	Report() ExperimentReport
+	// SetFraction changes the fraction of calls assigned to the candidate, clamped to [0, 1]. Keys only move from the
+	// control to the candidate as the fraction grows, and back as it shrinks.
+	SetFraction(fraction float64)
+}
+
+// Experiment returns a limiter trialing candidate against control: each call is assigned to one of them, with
+// probability fraction for the candidate, and fully served by it. Calls made with a context carrying a key set with
+// WithAssignmentKey are assigned by a hash of the key, so a client keeps its arm. Other calls are assigned at random.
+//
+// The experiment's Stats, Waiters and reservation ages combine both arms, while Info and Labels are the control's.
+// Clear, Close and CancelWaiters apply to both. It accepts WithClock, used to time waits.
+func Experiment(control, candidate Limiter, fraction float64, opts ...Option) ExperimentLimiter {
+	o := newOptions(opts)
+	e := &experiment{
+		clock: o.clock,
+		arms:  [2]*experimentArm{{limiter: control}, {limiter: candidate}},
+	}
+	e.SetFraction(fraction)
+	return e
+}
+
+// experiment implements ExperimentLimiter.
+type experiment struct {
+	// Mutex
+	mux sync.Mutex
+
+	// Config
+	clock    Clock
+	fraction float64
+
+	// State, the control then the candidate
+	arms [2]*experimentArm
+}
+
+// experimentArm is a limiter of an experiment with the outcomes of the calls assigned to it.
+type experimentArm struct {
+	limiter Limiter
+	calls   int
+	denied  int
+	// The latest waits, in a ring of experimentSamples
+	waits []time.Duration
+	next  int
+}
+
+func (e *experiment) SetFraction(fraction float64) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.fraction = min(max(fraction, 0), 1)
+}
+
+// assign returns the arm of a call made with ctx, chosen at random unless ctx carries an assignment key.
+func (e *experiment) assign(ctx context.Context) *experimentArm {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	var p float64
+	if key, ok := ctx.Value(assignmentKey{}).(string); ok {
+		p = keyPoint(key)
+	} else {
+		p = rand.Float64()
+	}
+	if p < e.fraction {
+		return e.arms[1]
+	}
+	return e.arms[0]
+}
+
+// keyPoint maps key to a point of [0, 1), the same in every process.
+func keyPoint(key string) float64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	// FNV barely mixes the high bits of similar keys, so they go through the finalizer of MurmurHash3
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	// The top 53 bits, which a float64 holds exactly, so the point stays below 1
+	return float64(x>>11) / (1 << 53)
+}
+
+// record counts the outcome of a call assigned to arm, which waited since start if it was admitted.
+func (e *experiment) record(arm *experimentArm, start time.Time, admitted bool) {
+	waited := e.clock.Now().Sub(start)
+
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	arm.calls++
+	if !admitted {
+		arm.denied++
+		return
+	}
+	if len(arm.waits) < experimentSamples {
+		arm.waits = append(arm.waits, waited)
+		return
+	}
+	arm.waits[arm.next] = waited
+	arm.next = (arm.next + 1) % experimentSamples
+}
+
+func (e *experiment) Wait() {
+	arm, start := e.assign(context.Background()), e.clock.Now()
+	arm.limiter.Wait()
+	e.record(arm, start, true)
+}
+
+func (e *experiment) WaitTimeout(timeout time.Duration) error {
+	arm, start := e.assign(context.Background()), e.clock.Now()
+	err := arm.limiter.WaitTimeout(timeout)
+	e.record(arm, start, err == nil)
+	return err
+}
+
+func (e *experiment) WaitContext(ctx context.Context) error {
+	arm, start := e.assign(ctx), e.clock.Now()
+	err := arm.limiter.WaitContext(ctx)
+	e.record(arm, start, err == nil)
+	return err
+}
+
+func (e *experiment) WaitN(n int) error {
+	arm, start := e.assign(context.Background()), e.clock.Now()
+	err := arm.limiter.WaitN(n)
+	e.record(arm, start, err == nil)
+	return err
+}
+
+func (e *experiment) WaitNTimeout(timeout time.Duration, n int) error {
+	arm, start := e.assign(context.Background()), e.clock.Now()
+	err := arm.limiter.WaitNTimeout(timeout, n)
+	e.record(arm, start, err == nil)
+	return err
+}
+
+func (e *experiment) WaitNContext(ctx context.Context, n int) error {
+	arm, start := e.assign(ctx), e.clock.Now()
+	err := arm.limiter.WaitNContext(ctx, n)
+	e.record(arm, start, err == nil)
+	return err
+}
+
+func (e *experiment) Allowed() bool {
+	arm, start := e.assign(context.Background()), e.clock.Now()
+	ok := arm.limiter.Allowed()
+	e.record(arm, start, ok)
+	return ok
+}
+
+func (e *experiment) AllowN(n int) bool {
+	arm, start := e.assign(context.Background()), e.clock.Now()
+	ok := arm.limiter.AllowN(n)
+	e.record(arm, start, ok)
+	return ok
+}
+
+func (e *experiment) Reserve(reservationTTL *time.Duration) Reservation {
+	arm, start := e.assign(context.Background()), e.clock.Now()
+	r := arm.limiter.Reserve(reservationTTL)
+	e.record(arm, start, true)
+	return r
+}
+
+func (e *experiment) TryReserve(reservationTTL *time.Duration) (Reservation, bool) {
+	arm, start := e.assign(context.Background()), e.clock.Now()
+	r, ok := arm.limiter.TryReserve(reservationTTL)
+	e.record(arm, start, ok)
+	return r, ok
+}
+
+func (e *experiment) ReserveTimeout(timeout time.Duration, reservationTTL *time.Duration) (Reservation, error) {
+	arm, start := e.assign(context.Background()), e.clock.Now()
+	r, err := arm.limiter.ReserveTimeout(timeout, reservationTTL)
+	e.record(arm, start, err == nil)
+	return r, err
+}
+
+func (e *experiment) ReserveContext(ctx context.Context, reservationTTL *time.Duration) (Reservation, error) {
+	arm, start := e.assign(ctx), e.clock.Now()
+	r, err := arm.limiter.ReserveContext(ctx, reservationTTL)
+	e.record(arm, start, err == nil)
+	return r, err
+}
+
+func (e *experiment) ReserveN(ctx context.Context, n int, reservationTTL *time.Duration) (Reservation, error) {
+	arm, start := e.assign(ctx), e.clock.Now()
+	r, err := arm.limiter.ReserveN(ctx, n, reservationTTL)
+	e.record(arm, start, err == nil)
+	return r, err
+}
+
+// Permits delivers the permits of the arm ctx is assigned to. They aren't counted in the report.
+func (e *experiment) Permits(ctx context.Context) <-chan struct{} {
+	return e.assign(ctx).limiter.Permits(ctx)
+}
+
+func (e *experiment) Clear() {
+	for _, arm := range e.arms {
+		arm.limiter.Clear()
+	}
+}
+
+// Close closes both arms.
+func (e *experiment) Close() error {
+	return errors.Join(e.arms[0].limiter.Close(), e.arms[1].limiter.Close())
+}
+
+// Stats sums the stats of both arms. NextAllowedTime, Remaining, Paused and Partition are the control's.
+func (e *experiment) Stats() Stats {
+	stats := e.arms[0].limiter.Stats()
+	addStats(&stats, e.arms[1].limiter.Stats())
+	return stats
+}
+
+func (e *experiment) Info() LimitInfo {
+	return e.arms[0].limiter.Info()
+}
+
+// Available returns the control's.
+func (e *experiment) Available() int {
+	return e.Info().Remaining
+}
+
+// EstimatedWait returns the control's.
+func (e *experiment) EstimatedWait() time.Duration {
+	return e.arms[0].limiter.EstimatedWait()
+}
+
+// Config returns the control's.
+func (e *experiment) Config() Config {
+	return e.arms[0].limiter.Config()
+}
+
+func (e *experiment) Labels() map[string]string {
+	return e.arms[0].limiter.Labels()
+}
+
+func (e *experiment) PendingReservationAges(n int) []time.Duration {
+	ages := append(e.arms[0].limiter.PendingReservationAges(n), e.arms[1].limiter.PendingReservationAges(n)...)
+	slices.SortFunc(ages, func(a, b time.Duration) int {
+		return cmp.Compare(b, a)
+	})
+	return ages[:min(n, len(ages))]
+}
+
+// Waiters returns the waiters of the control followed by those of the candidate.
+func (e *experiment) Waiters() []WaiterInfo {
+	return append(e.arms[0].limiter.Waiters(), e.arms[1].limiter.Waiters()...)
+}
+
+func (e *experiment) CancelWaiters(err error) int {
+	return e.arms[0].limiter.CancelWaiters(err) + e.arms[1].limiter.CancelWaiters(err)
+}
+
+func (e *experiment) Report() ExperimentReport {
+	e.mux.Lock()
+	report := ExperimentReport{
+		Fraction:  e.fraction,
+		Control:   e.arms[0].report(),
+		Candidate: e.arms[1].report(),
+	}
+	e.mux.Unlock()
+
+	report.Control.Stats = e.arms[0].limiter.Stats()
+	report.Candidate.Stats = e.arms[1].limiter.Stats()
+	report.DenialRateDelta = report.Candidate.DenialRate - report.Control.DenialRate
+	report.WaitP95Delta = report.Candidate.WaitP95 - report.Control.WaitP95
+	return report
+}
+
+// report returns the counters of the arm, without its stats.
+// This must be called with the mutex already locked
+func (a *experimentArm) report() ArmReport {
+	r := ArmReport{Calls: a.calls, Denied: a.denied}
+	if a.calls > 0 {
+		r.DenialRate = float64(a.denied) / float64(a.calls)
+	}
+	if len(a.waits) > 0 {
+		waits := slices.Clone(a.waits)
+		slices.Sort(waits)
+		// By the nearest rank
+		r.WaitP95 = waits[(95*len(waits)+99)/100-1]
+	}
+	return r
+}
@@ -0,0 +1,102 @@
+package limit_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limittest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExperiment_StickyKeys(t *testing.T) {
+	t.Parallel()
+
+	control := limit.NewTokenBucket(1000, 1*time.Hour)
+	candidate := limit.NewTokenBucket(1000, 1*time.Hour)
+	experiment := limit.Experiment(control, candidate, 0.5)
+
+	// Every call of a key goes to the same arm
+	armOf := func(key string) limit.Limiter {
+		ctx := limit.WithAssignmentKey(context.Background(), key)
+		before := candidate.Stats().AllowedRequests
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, experiment.WaitContext(ctx))
+		}
+		switch candidate.Stats().AllowedRequests - before {
+		case 0:
+			return control
+		case 3:
+			return candidate
+		}
+		t.Fatalf("key %q was split between the arms", key)
+		return nil
+	}
+	onCandidate := 0
+	arms := make(map[string]limit.Limiter)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprint("client-", i)
+		arms[key] = armOf(key)
+		if arms[key] == candidate {
+			onCandidate++
+		}
+	}
+	assert.InDelta(t, 50, onCandidate, 15)
+
+	// Growing the fraction only moves keys to the candidate
+	experiment.SetFraction(0.8)
+	for key, arm := range arms {
+		if arm == candidate {
+			assert.Same(t, candidate, armOf(key), key)
+		}
+	}
+
+	experiment.SetFraction(0)
+	for key := range arms {
+		assert.Same(t, control, armOf(key), key)
+	}
+	assert.Equal(t, 600+3*onCandidate, experiment.Stats().AllowedRequests)
+}
+
+func TestExperiment_Report(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	control := limit.NewTokenBucket(1, 1*time.Hour, limit.WithClock(clock))
+	candidate := limit.NewTokenBucket(1, 1*time.Second, limit.WithClock(clock))
+	experiment := limit.Experiment(control, candidate, 0, limit.WithClock(clock))
+
+	for i := 0; i < 4; i++ {
+		experiment.Allowed()
+	}
+
+	// The candidate's second caller waits for a refill
+	experiment.SetFraction(1)
+	assert.True(t, experiment.Allowed())
+	done := make(chan error)
+	go func() {
+		done <- experiment.WaitContext(context.Background())
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(1 * time.Second)
+	assert.NoError(t, <-done)
+
+	report := experiment.Report()
+	assert.Equal(t, 1.0, report.Fraction)
+	assert.Equal(t, 4, report.Control.Calls)
+	assert.Equal(t, 3, report.Control.Denied)
+	assert.Equal(t, 0.75, report.Control.DenialRate)
+	assert.Equal(t, time.Duration(0), report.Control.WaitP95)
+	assert.Equal(t, 1, report.Control.Stats.AllowedRequests)
+	assert.Equal(t, 2, report.Candidate.Calls)
+	assert.Equal(t, 0.0, report.Candidate.DenialRate)
+	assert.Equal(t, 1*time.Second, report.Candidate.WaitP95)
+	assert.Equal(t, -0.75, report.DenialRateDelta)
+	assert.Equal(t, 1*time.Second, report.WaitP95Delta)
+
+	stats := experiment.Stats()
+	assert.Equal(t, 3, stats.AllowedRequests)
+	assert.Equal(t, 3, stats.DeniedRequests)
+}
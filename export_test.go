@@ -0,0 +1,31 @@
+package limit
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// QueueDepth returns the number of events queued in a leaky bucket.
+func QueueDepth(l Limiter) int {
+	bucket := l.(*leakyBucket)
+	bucket.mux.Lock()
+	defer bucket.mux.Unlock()
+	return bucket.currentCapacity
+}
+
+// DebouncePending reports whether a Debounce is waiting for the end of a burst.
+func DebouncePending(d *Debounce) bool {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	return d.pending
+}
+
+// SetLeakRate changes the rate a leaky bucket leaks at.
+func SetLeakRate(l Limiter, rate Rate) {
+	l.(*leakyBucket).setLeakRate(rate)
+}
+
+// Stretch lengthens d like the waits of a limiter created with WithJitter(fraction) and WithJitterSource(source).
+func Stretch(fraction float64, source rand.Source, d time.Duration) time.Duration {
+	return newJitter(options{jitter: fraction, jitterSource: source}).stretch(d)
+}
@@ -0,0 +1,254 @@
+package limit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Event is a limiter decision shipped by an Exporter.
+type Event struct {
+	Time time.Time `json:"time"`
+	// The key or route the decision was made for
+	Key string `json:"key"`
+	// Whether the request was denied
+	Limited bool `json:"limited"`
+	// How long the request waited before the decision
+	Wait time.Duration `json:"wait_ns"`
+	// The labels of the limiter, see WithLabels
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// Sink delivers a batch of events, e.g. to a SIEM. A failed batch is retried as set with WithExportRetries, so sinks
+// should tolerate receiving a batch more than once.
+type Sink func(ctx context.Context, events []Event) error
+
+// Exporter ships limiter decisions to a Sink in batches from a single goroutine, so recording an event never blocks
+// on the sink.
+type Exporter struct {
+	// Mutex
+	mux sync.Mutex
+
+	// Config
+	sink       Sink
+	clock      Clock
+	batchSize  int
+	flushEvery time.Duration
+	maxBuffer  int
+	attempts   int
+	backoff    time.Duration
+
+	// State
+	events    []Event
+	closed    bool
+	delivered int
+	dropped   int
+	wake      chan struct{} // Signaled when a batch fills up or the exporter is closed
+	ctx       context.Context
+	cancel    context.CancelFunc
+	done      chan struct{} // Closed once the export goroutine exits
+}
+
+// NewExporter starts an exporter delivering events to sink in batches of up to batchSize, as soon as a batch is full
+// and otherwise every flushEvery. It buffers up to 10 batches, or as set with WithExportBuffer, dropping the events
+// recorded while the buffer is full, so a denial storm with a slow sink doesn't grow memory without bound.
+// It accepts WithExportBuffer, WithExportRetries and WithClock.
+func NewExporter(sink Sink, batchSize int, flushEvery time.Duration, opts ...Option) *Exporter {
+	o := newOptions(opts)
+	ctx, cancel := context.WithCancel(context.Background())
+	e := &Exporter{
+		sink:       sink,
+		clock:      o.clock,
+		batchSize:  batchSize,
+		flushEvery: flushEvery,
+		maxBuffer:  o.exportBuffer,
+		attempts:   max(o.exportAttempts, 1),
+		backoff:    o.exportBackoff,
+		wake:       make(chan struct{}, 1),
+		ctx:        ctx,
+		cancel:     cancel,
+		done:       make(chan struct{}),
+	}
+	if e.maxBuffer <= 0 {
+		e.maxBuffer = 10 * batchSize
+	}
+	go e.run()
+	return e
+}
+
+// Record buffers event for the next batch. It's dropped if the buffer is full or the exporter is closed.
+func (e *Exporter) Record(event Event) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	if e.closed || len(e.events) >= e.maxBuffer {
+		e.dropped++
+		return
+	}
+	e.events = append(e.events, event)
+	if len(e.events) >= e.batchSize {
+		e.signal()
+	}
+}
+
+// Hook returns an AdmissionHook recording every admission it's called with, to pass to WithAdmissionHook.
+func (e *Exporter) Hook() AdmissionHook {
+	return func(_ context.Context, admission Admission) {
+		event := Event{Time: e.clock.Now(), Key: admission.Key, Limited: admission.Limited, Wait: admission.Wait}
+		if admission.Limiter != nil {
+			event.Labels = admission.Limiter.Labels()
+		}
+		e.Record(event)
+	}
+}
+
+// Delivered returns the number of events the sink accepted.
+func (e *Exporter) Delivered() int {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	return e.delivered
+}
+
+// Dropped returns the number of events dropped, because the buffer was full, the exporter was closed or the sink kept
+// failing their batch.
+func (e *Exporter) Dropped() int {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	return e.dropped
+}
+
+// Close stops accepting events and waits until the buffered ones are delivered. If ctx is done first, the batch being
+// delivered is abandoned, the rest is dropped and the context's error is returned. It's safe to call more than once.
+func (e *Exporter) Close(ctx context.Context) error {
+	e.mux.Lock()
+	e.closed = true
+	e.signal()
+	e.mux.Unlock()
+
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		e.cancel()
+		<-e.done
+		return ctx.Err()
+	}
+}
+
+func (e *Exporter) signal() {
+	// This must be called with the mutex already locked
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (e *Exporter) run() {
+	defer close(e.done)
+	defer e.cancel()
+
+	timer := e.clock.NewTimer(e.flushEvery)
+	defer timer.Stop()
+	for {
+		force := false
+		select {
+		case <-e.wake:
+		case <-timer.C():
+			force = true
+			timer.Reset(e.flushEvery)
+		}
+
+		for {
+			batch, closed := e.take(force)
+			if len(batch) == 0 {
+				if closed {
+					return
+				}
+				break
+			}
+			e.deliver(batch)
+		}
+	}
+}
+
+// take removes the next batch from the buffer, only if it's full unless force is set or the exporter is closed.
+func (e *Exporter) take(force bool) ([]Event, bool) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	if e.ctx.Err() != nil {
+		// Closing timed out
+		e.dropped += len(e.events)
+		e.events = nil
+		return nil, true
+	}
+	n := min(len(e.events), e.batchSize)
+	if n < e.batchSize && !force && !e.closed {
+		return nil, e.closed
+	}
+	batch := e.events[:n:n]
+	e.events = e.events[n:]
+	return batch, e.closed
+}
+
+// deliver hands batch to the sink, retrying with an exponential backoff until it runs out of attempts.
+func (e *Exporter) deliver(batch []Event) {
+	backoff := e.backoff
+	for attempt := 1; ; attempt++ {
+		err := e.sink(e.ctx, batch)
+		if err == nil {
+			e.mux.Lock()
+			e.delivered += len(batch)
+			e.mux.Unlock()
+			return
+		}
+		if attempt == e.attempts || e.ctx.Err() != nil {
+			break
+		}
+
+		select {
+		case <-e.clock.After(backoff):
+		case <-e.ctx.Done():
+		}
+		backoff *= 2
+	}
+
+	e.mux.Lock()
+	e.dropped += len(batch)
+	e.mux.Unlock()
+}
+
+// HTTPSink returns a Sink posting each batch as a JSON array to url with client, http.DefaultClient if nil. Responses
+// other than 2xx fail the batch.
+func HTTPSink(client *http.Client, url string) Sink {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return func(ctx context.Context, events []Event) error {
+		body, err := json.Marshal(events)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, _ = io.Copy(io.Discard, resp.Body)
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("posting %d events: %s", len(events), resp.Status)
+		}
+		return nil
+	}
+}
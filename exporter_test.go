@@ -0,0 +1,176 @@
+package limit_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limittest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExporter_Batches(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	batches := make(chan []limit.Event, 10)
+	exporter := limit.NewExporter(func(_ context.Context, events []limit.Event) error {
+		batches <- events
+		return nil
+	}, 3, 1*time.Second, limit.WithClock(clock))
+	clock.BlockUntil(1)
+
+	// A full batch is delivered right away
+	for _, key := range []string{"a", "b", "c", "d"} {
+		exporter.Record(limit.Event{Key: key, Limited: true})
+	}
+	batch := <-batches
+	assert.Equal(t, []string{"a", "b", "c"}, keys(batch))
+
+	// The rest once the flush interval passes
+	clock.Advance(1 * time.Second)
+	assert.Equal(t, []string{"d"}, keys(<-batches))
+
+	// Closing flushes the tail
+	exporter.Record(limit.Event{Key: "e"})
+	assert.NoError(t, exporter.Close(context.Background()))
+	assert.Equal(t, []string{"e"}, keys(<-batches))
+	assert.Equal(t, 5, exporter.Delivered())
+	assert.Equal(t, 0, exporter.Dropped())
+
+	// Events recorded after closing are dropped
+	exporter.Record(limit.Event{Key: "f"})
+	assert.Equal(t, 1, exporter.Dropped())
+}
+
+func TestExporter_Retries(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	var mux sync.Mutex
+	failures := 2
+	attempts := make(chan []limit.Event, 10)
+	exporter := limit.NewExporter(func(_ context.Context, events []limit.Event) error {
+		attempts <- events
+		mux.Lock()
+		defer mux.Unlock()
+		if failures > 0 {
+			failures--
+			return errors.New("sink unavailable")
+		}
+		return nil
+	}, 1, 1*time.Hour, limit.WithClock(clock), limit.WithExportRetries(3, 100*time.Millisecond))
+	clock.BlockUntil(1)
+
+	// Two failures, retried after 100ms and then 200ms
+	exporter.Record(limit.Event{Key: "a"})
+	<-attempts
+	clock.BlockUntil(2)
+	clock.Advance(100 * time.Millisecond)
+	<-attempts
+	clock.BlockUntil(2)
+	clock.Advance(200 * time.Millisecond)
+	<-attempts
+
+	// A batch failing every attempt is dropped
+	mux.Lock()
+	failures = 3
+	mux.Unlock()
+	exporter.Record(limit.Event{Key: "b"})
+	for i := 0; i < 2; i++ {
+		<-attempts
+		clock.BlockUntil(2)
+		clock.Advance(1 * time.Second)
+	}
+	<-attempts
+
+	assert.NoError(t, exporter.Close(context.Background()))
+	assert.Equal(t, 1, exporter.Delivered())
+	assert.Equal(t, 1, exporter.Dropped())
+}
+
+func TestExporter_BoundedUnderDenialStorm(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	exporter := limit.NewExporter(func(ctx context.Context, events []limit.Event) error {
+		select {
+		case <-release:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}, 5, 1*time.Hour, limit.WithExportBuffer(10))
+
+	// The sink is stuck, so at most a batch in flight and a full buffer are kept
+	hook := exporter.Hook()
+	limiter := limit.NewTokenBucket(1, 1*time.Hour, limit.WithLabels(map[string]string{"team": "search"}))
+	for i := 0; i < 1000; i++ {
+		hook(context.Background(), limit.Admission{Key: "search", Limiter: limiter, Limited: true})
+	}
+	assert.GreaterOrEqual(t, exporter.Dropped(), 1000-15)
+
+	close(release)
+	assert.NoError(t, exporter.Close(context.Background()))
+	assert.Equal(t, 1000, exporter.Delivered()+exporter.Dropped())
+	assert.LessOrEqual(t, exporter.Delivered(), 15)
+}
+
+func TestExporter_CloseTimesOut(t *testing.T) {
+	t.Parallel()
+
+	exporter := limit.NewExporter(func(ctx context.Context, events []limit.Event) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, 1, 1*time.Hour)
+	exporter.Record(limit.Event{Key: "a"})
+	exporter.Record(limit.Event{Key: "b"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, exporter.Close(ctx), context.DeadlineExceeded)
+	assert.Equal(t, 0, exporter.Delivered())
+	assert.Equal(t, 2, exporter.Dropped())
+}
+
+func TestHTTPSink(t *testing.T) {
+	t.Parallel()
+
+	var received []limit.Event
+	status := http.StatusAccepted
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	sink := limit.HTTPSink(server.Client(), server.URL)
+	events := []limit.Event{{
+		Time:    time.Unix(10, 0).UTC(),
+		Key:     "search",
+		Limited: true,
+		Wait:    5 * time.Millisecond,
+		Labels:  map[string]string{"team": "search"},
+	}}
+	assert.NoError(t, sink(context.Background(), events))
+	assert.Equal(t, events, received)
+
+	status = http.StatusServiceUnavailable
+	assert.ErrorContains(t, sink(context.Background(), events), "503 Service Unavailable")
+}
+
+func keys(events []limit.Event) []string {
+	var keys []string
+	for _, e := range events {
+		keys = append(keys, e.Key)
+	}
+	return keys
+}
@@ -0,0 +1,263 @@
+package limit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// FallibleAllower is implemented by the limiters whose backend can fail, e.g. a remote store, so that AllowN can tell
+// a broken backend from a denial, which its bool can't.
+type FallibleAllower interface {
+	// AllowNErr is AllowN returning a *BackendError if the backend failed to decide.
+	AllowNErr(n int) (bool, error)
+}
+
+// FallbackStats reports the limiter serving the calls of a limiter created with NewFallbackLimiter.
+type FallbackStats struct {
+	// Whether the secondary limiter is serving the calls
+	Secondary bool `json:"secondary"`
+	// When the primary limiter is probed again, zero while it serves the calls
+	ProbeAt time.Time `json:"probe_at,omitempty"`
+	// How many times the primary limiter failed over to the secondary since the limiter was created
+	Fallbacks int `json:"fallbacks"`
+}
+
+var _ Limiter = (*fallbackLimiter)(nil)
+
+type fallbackLimiter struct {
+	// Mutex
+	mux sync.Mutex
+
+	// Config
+	primary    Limiter
+	secondary  Limiter
+	onFallback func(err error)
+	clock      Clock
+	cooldown   time.Duration
+
+	// State
+	probeAt   time.Time // Set while the secondary serves the calls
+	fallbacks int
+}
+
+// NewFallbackLimiter returns a limiter serving the calls with primary, and with secondary, e.g. a local limiter, for a
+// cool-down of 30s, or as set with WithFallbackCooldown, whenever primary fails with a *BackendError. The call that
+// failed is served by secondary right away, and onFallback, if not nil, is called with the error. Once the cool-down
+// is over primary serves the calls again, failing over again if it's still broken. Denials by primary's limit don't
+// fail over. Allowed and AllowN can only tell a broken backend if primary implements FallibleAllower.
+//
+// Stats adds up the stats of both limiters and reports the one serving the calls in Fallback. Info, Available,
+// EstimatedWait, Config, Labels and the reservation ages are the serving one's. Clear, Close and CancelWaiters apply to
+// both. It accepts WithClock and WithFallbackCooldown.
+func NewFallbackLimiter(primary, secondary Limiter, onFallback func(err error), opts ...Option) Limiter {
+	o := newOptions(opts)
+	return &fallbackLimiter{
+		primary:    primary,
+		secondary:  secondary,
+		onFallback: onFallback,
+		clock:      o.clock,
+		cooldown:   o.fallbackCooldown,
+	}
+}
+
+// serving returns the limiter serving the calls and whether it's the primary, probing the primary again once the
+// cool-down is over.
+func (f *fallbackLimiter) serving() (Limiter, bool) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	if !f.probeAt.IsZero() && !f.clock.Now().Before(f.probeAt) {
+		f.probeAt = time.Time{}
+	}
+	if f.probeAt.IsZero() {
+		return f.primary, true
+	}
+	return f.secondary, false
+}
+
+// failedOver reports whether err is a *BackendError, switching to the secondary for the cool-down if so. It calls
+// onFallback unless another call already switched.
+func (f *fallbackLimiter) failedOver(err error) bool {
+	var backendErr *BackendError
+	if !errors.As(err, &backendErr) {
+		return false
+	}
+
+	f.mux.Lock()
+	switched := f.probeAt.IsZero()
+	if switched {
+		f.probeAt = f.clock.Now().Add(f.cooldown)
+		f.fallbacks++
+	}
+	f.mux.Unlock()
+
+	if switched && f.onFallback != nil {
+		f.onFallback(err)
+	}
+	return true
+}
+
+// do runs call with the limiter serving the calls, and again with the secondary if the primary's backend failed.
+func (f *fallbackLimiter) do(call func(l Limiter) error) error {
+	l, primary := f.serving()
+	err := call(l)
+	if primary && f.failedOver(err) {
+		return call(f.secondary)
+	}
+	return err
+}
+
+func (f *fallbackLimiter) Wait() {
+	_ = f.WaitContext(context.Background())
+}
+
+func (f *fallbackLimiter) WaitTimeout(timeout time.Duration) error {
+	return f.WaitNTimeout(timeout, 1)
+}
+
+func (f *fallbackLimiter) WaitContext(ctx context.Context) error {
+	return f.WaitNContext(ctx, 1)
+}
+
+func (f *fallbackLimiter) WaitN(n int) error {
+	return f.WaitNContext(context.Background(), n)
+}
+
+func (f *fallbackLimiter) WaitNTimeout(timeout time.Duration, n int) error {
+	ctx, cancel := withTimeout(f.clock, timeout)
+	defer cancel()
+	return f.WaitNContext(ctx, n)
+}
+
+func (f *fallbackLimiter) WaitNContext(ctx context.Context, n int) error {
+	return f.do(func(l Limiter) error {
+		return l.WaitNContext(ctx, n)
+	})
+}
+
+func (f *fallbackLimiter) Allowed() bool {
+	return f.AllowN(1)
+}
+
+func (f *fallbackLimiter) AllowN(n int) bool {
+	allowed := false
+	_ = f.do(func(l Limiter) error {
+		if fallible, ok := l.(FallibleAllower); ok {
+			var err error
+			allowed, err = fallible.AllowNErr(n)
+			return err
+		}
+		allowed = l.AllowN(n)
+		return nil
+	})
+	return allowed
+}
+
+func (f *fallbackLimiter) Clear() {
+	f.primary.Clear()
+	f.secondary.Clear()
+}
+
+func (f *fallbackLimiter) Close() error {
+	return errors.Join(f.primary.Close(), f.secondary.Close())
+}
+
+func (f *fallbackLimiter) CancelWaiters(err error) int {
+	return f.primary.CancelWaiters(err) + f.secondary.CancelWaiters(err)
+}
+
+// Stats adds up the stats of both limiters, with the NextAllowedTime and Remaining of the one serving the calls.
+func (f *fallbackLimiter) Stats() Stats {
+	l, primary := f.serving()
+	stats := l.Stats()
+	other := f.secondary
+	if !primary {
+		other = f.primary
+	}
+	addStats(&stats, other.Stats())
+
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	stats.Fallback = &FallbackStats{Secondary: !primary, ProbeAt: f.probeAt, Fallbacks: f.fallbacks}
+	return stats
+}
+
+func (f *fallbackLimiter) Info() LimitInfo {
+	l, _ := f.serving()
+	return l.Info()
+}
+
+func (f *fallbackLimiter) Available() int {
+	l, _ := f.serving()
+	return l.Available()
+}
+
+func (f *fallbackLimiter) EstimatedWait() time.Duration {
+	l, _ := f.serving()
+	return l.EstimatedWait()
+}
+
+func (f *fallbackLimiter) Config() Config {
+	l, _ := f.serving()
+	return l.Config()
+}
+
+func (f *fallbackLimiter) Labels() map[string]string {
+	l, _ := f.serving()
+	return l.Labels()
+}
+
+func (f *fallbackLimiter) PendingReservationAges(n int) []time.Duration {
+	l, _ := f.serving()
+	return l.PendingReservationAges(n)
+}
+
+// Waiters returns the callers blocked in the primary, then in the secondary.
+func (f *fallbackLimiter) Waiters() []WaiterInfo {
+	return append(f.primary.Waiters(), f.secondary.Waiters()...)
+}
+
+// TryReserve can't tell a broken backend from a denial, it fails over only once another call did.
+func (f *fallbackLimiter) TryReserve(reservationTTL *time.Duration) (Reservation, bool) {
+	l, _ := f.serving()
+	return l.TryReserve(reservationTTL)
+}
+
+func (f *fallbackLimiter) Reserve(reservationTTL *time.Duration) Reservation {
+	reservation, err := f.ReserveContext(context.Background(), reservationTTL)
+	if err != nil {
+		return failedReservation{err: err}
+	}
+	return reservation
+}
+
+func (f *fallbackLimiter) ReserveTimeout(timeout time.Duration, reservationTTL *time.Duration) (Reservation, error) {
+	ctx, cancel := withTimeout(f.clock, timeout)
+	defer cancel()
+	return f.ReserveContext(ctx, reservationTTL)
+}
+
+func (f *fallbackLimiter) ReserveContext(ctx context.Context, reservationTTL *time.Duration) (Reservation, error) {
+	return f.ReserveN(ctx, 1, reservationTTL)
+}
+
+func (f *fallbackLimiter) ReserveN(ctx context.Context, n int, reservationTTL *time.Duration) (Reservation, error) {
+	var reservation Reservation
+	err := f.do(func(l Limiter) error {
+		var err error
+		reservation, err = l.ReserveN(ctx, n, reservationTTL)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reservation, nil
+}
+
+func (f *fallbackLimiter) Permits(ctx context.Context) <-chan struct{} {
+	return deliverPermits(ctx, func(ctx context.Context) (permit, error) {
+		return reservePermit(ctx, f)
+	})
+}
@@ -0,0 +1,97 @@
+package limit_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limittest"
+	"github.com/stretchr/testify/assert"
+)
+
+var errUnreachable = errors.New("connection refused")
+
+// remoteLimiter is a limiter whose backend can be broken on demand.
+type remoteLimiter struct {
+	limit.Limiter
+	broken atomic.Bool
+}
+
+func (r *remoteLimiter) AllowNErr(n int) (bool, error) {
+	if r.broken.Load() {
+		return false, &limit.BackendError{Limiter: "remote", Err: errUnreachable}
+	}
+	return r.Limiter.AllowN(n), nil
+}
+
+func (r *remoteLimiter) WaitNContext(ctx context.Context, n int) error {
+	if r.broken.Load() {
+		return &limit.BackendError{Limiter: "remote", Err: errUnreachable}
+	}
+	return r.Limiter.WaitNContext(ctx, n)
+}
+
+func TestFallbackLimiter_Allowed(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	primary := &remoteLimiter{Limiter: limit.NewTokenBucket(1, 1*time.Minute, limit.WithClock(clock))}
+	secondary := limit.NewTokenBucket(10, 1*time.Minute, limit.WithClock(clock))
+	var fallbacks []error
+	limiter := limit.NewFallbackLimiter(primary, secondary, func(err error) { fallbacks = append(fallbacks, err) },
+		limit.WithClock(clock), limit.WithFallbackCooldown(10*time.Second))
+
+	// A denial by the primary's limit doesn't fail over
+	assert.True(t, limiter.Allowed())
+	assert.False(t, limiter.Allowed())
+	assert.Empty(t, fallbacks)
+	assert.Equal(t, 10, secondary.Available())
+
+	// A broken backend does, and the call is served by the secondary
+	primary.broken.Store(true)
+	assert.True(t, limiter.Allowed())
+	assert.True(t, limiter.Allowed())
+	assert.Equal(t, 8, secondary.Available())
+	if assert.Len(t, fallbacks, 1) {
+		assert.ErrorIs(t, fallbacks[0], errUnreachable)
+	}
+
+	stats := limiter.Stats()
+	assert.Equal(t, &limit.FallbackStats{Secondary: true, ProbeAt: time.Unix(10, 0), Fallbacks: 1}, stats.Fallback)
+	assert.Equal(t, 3, stats.AllowedRequests)
+	assert.Equal(t, 1, stats.DeniedRequests)
+
+	// Once the cool-down is over the primary is probed again
+	primary.broken.Store(false)
+	clock.Advance(1 * time.Minute)
+	assert.True(t, limiter.Allowed())
+	assert.Equal(t, 0, primary.Available())
+	assert.Equal(t, 10, secondary.Available())
+	assert.Equal(t, &limit.FallbackStats{Fallbacks: 1}, limiter.Stats().Fallback)
+}
+
+func TestFallbackLimiter_Wait(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	primary := &remoteLimiter{Limiter: limit.NewTokenBucket(1, 1*time.Minute, limit.WithClock(clock))}
+	secondary := limit.NewTokenBucket(1, 1*time.Minute, limit.WithClock(clock))
+	fallbacks := 0
+	limiter := limit.NewFallbackLimiter(primary, secondary, func(error) { fallbacks++ }, limit.WithClock(clock))
+
+	primary.broken.Store(true)
+	assert.NoError(t, limiter.WaitTimeout(1*time.Second))
+	assert.Equal(t, 0, secondary.Available())
+	assert.Equal(t, 1, primary.Available())
+
+	// Errors of the secondary aren't failed over again
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := limiter.WaitContext(ctx)
+	var limitErr *limit.LimitError
+	assert.ErrorAs(t, err, &limitErr)
+	assert.Equal(t, 1, fallbacks)
+}
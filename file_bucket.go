@@ -0,0 +1,561 @@
+package limit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// fileState is the bucket kept in the state file of a file token bucket.
+type fileState struct {
+	Tokens     float64   `json:"tokens"`
+	RefilledAt time.Time `json:"refilled_at"`
+}
+
+type fileBucket struct {
+	base
+
+	// Config
+	path     string
+	count    int
+	duration time.Duration
+
+	// State
+	tokens float64 // As last read from the file
+
+	// Reservations tracking
+	pendingReservations map[*fileBucketReservation]struct{}
+}
+
+// NewFileTokenBucket creates a token bucket of count tokens refilled over duration whose state lives in the file at
+// path, so every process on the machine creating one with the same path shares it. Each attempt to allow a request
+// locks the file, reads it, refills the bucket, takes a token and writes it back, so the limiter costs a few system
+// calls per request, see the readme for the throughput it can sustain. The file is locked through a sidecar file at
+// path plus ".lock", and written atomically by renaming a temporary file over it.
+//
+// A missing or unreadable state file is taken as a full bucket. If the file can't be locked or written, Allowed denies
+// the request and the waiting calls return the error. Reserving takes the token right away, and canceling or letting
+// the reservation expire puts it back. Clear refills the bucket of every process. It panics if count or duration isn't
+// positive, see NewFileTokenBucketE.
+func NewFileTokenBucket(path string, count int, duration time.Duration, opts ...Option) Limiter {
+	if err := validateRate(count, duration, false); err != nil {
+		panic(fmt.Sprintf("limit: NewFileTokenBucket: %v", err))
+	}
+
+	o := newOptions(opts)
+	f := &fileBucket{
+		path:                path,
+		count:               count,
+		duration:            duration,
+		tokens:              float64(count),
+		pendingReservations: make(map[*fileBucketReservation]struct{}),
+	}
+	f.init(o)
+	f.remaining = func() int { return int(f.tokens) }
+	f.nextAllowed = func() time.Time { return f.nextAllowedTime(1) }
+	return f
+}
+
+// NewFileTokenBucketE is NewFileTokenBucket returning an error instead of panicking on an invalid rate.
+func NewFileTokenBucketE(path string, count int, duration time.Duration, opts ...Option) (Limiter, error) {
+	if err := validateRate(count, duration, false); err != nil {
+		return nil, err
+	}
+	return NewFileTokenBucket(path, count, duration, opts...), nil
+}
+
+// update locks the state file, refills the bucket it holds, puts back the tokens of expired reservations and passes it
+// to change, writing it back if change reports it changed it. change can be nil to only read the bucket.
+func (f *fileBucket) update(change func(state *fileState) bool) error {
+	// This must be called with the mutex already locked
+	unlock, err := lockFile(f.path + ".lock")
+	if err != nil {
+		return fmt.Errorf("locking %s: %w", f.path, err)
+	}
+	defer unlock()
+
+	now := f.clock.Now()
+	state := f.read(now)
+	changed := f.refill(&state, now)
+	if expired := f.cleanupExpiredReservations(); expired > 0 {
+		state.Tokens = min(state.Tokens+float64(expired), float64(f.count))
+		changed = true
+	}
+	if change != nil && change(&state) {
+		changed = true
+	}
+	f.tokens = state.Tokens
+
+	if !changed {
+		return nil
+	}
+	return f.write(state)
+}
+
+// read returns the bucket in the state file, a full one if it's missing or can't be parsed.
+func (f *fileBucket) read(now time.Time) fileState {
+	full := fileState{Tokens: float64(f.count), RefilledAt: now}
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return full
+	}
+	var state fileState
+	if err := json.Unmarshal(data, &state); err != nil || state.RefilledAt.IsZero() {
+		return full
+	}
+	return state
+}
+
+// write replaces the state file with state through a temporary file, so a process reading it never sees half of it.
+// The file isn't synced, a crash may lose the last writes and leave the bucket full.
+func (f *fileBucket) write(state fileState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// refill adds the tokens refilled since the bucket was last refilled, reporting whether it changed.
+func (f *fileBucket) refill(state *fileState, now time.Time) bool {
+	elapsed := now.Sub(state.RefilledAt)
+	if elapsed < 0 {
+		// The wall clock stepped backwards, or another process' clock is ahead, refill from now
+		f.clockStepped(-elapsed)
+		state.RefilledAt = now
+		return true
+	}
+	if elapsed == 0 || f.count <= 0 {
+		return false
+	}
+
+	state.Tokens = min(state.Tokens+float64(f.count)*elapsed.Seconds()/f.duration.Seconds(), float64(f.count))
+	state.RefilledAt = now
+	return true
+}
+
+func (f *fileBucket) WaitContext(ctx context.Context) error {
+	return f.WaitNContext(ctx, 1)
+}
+
+func (f *fileBucket) WaitN(n int) error {
+	return f.WaitNContext(context.Background(), n)
+}
+
+func (f *fileBucket) WaitNTimeout(timeout time.Duration, n int) error {
+	ctx, cancel := withTimeout(f.clock, timeout)
+	defer cancel()
+	return f.WaitNContext(ctx, n)
+}
+
+func (f *fileBucket) WaitNContext(ctx context.Context, n int) error {
+	if n <= 0 {
+		return nil
+	}
+
+	return f.await(ctx, func() (bool, time.Duration, error) {
+		if err := f.costErrLocked(n); err != nil {
+			return false, 0, err
+		}
+
+		return f.tryTakeLocked(n)
+	}, nil)
+}
+
+// costErrLocked denies n tokens and returns an error if they exceed the bucket capacity, nil otherwise.
+func (f *fileBucket) costErrLocked(n int) error {
+	// This must be called with the mutex already locked
+	if n > f.count {
+		f.deny(ReasonLimited)
+		return fmt.Errorf("cost %d exceeds the bucket capacity of %d", n, f.count)
+	}
+	return nil
+}
+
+func (f *fileBucket) Wait() {
+	_ = f.WaitContext(context.Background())
+}
+
+func (f *fileBucket) WaitTimeout(timeout time.Duration) error {
+	ctx, cancel := withTimeout(f.clock, timeout)
+	defer cancel()
+	return f.WaitContext(ctx)
+}
+
+func (f *fileBucket) Allowed() bool {
+	return f.AllowN(1)
+}
+
+func (f *fileBucket) AllowN(n int) bool {
+	if n <= 0 {
+		return true
+	}
+
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return f.allowLocked(n)
+}
+
+func (f *fileBucket) AllowedReport() (AdmitReport, bool) {
+	return f.allowReport(func() bool { return f.allowLocked(1) })
+}
+
+func (f *fileBucket) WaitContextReport(ctx context.Context) (AdmitReport, error) {
+	return waitReport(ctx, f.WaitContext)
+}
+
+func (f *fileBucket) allowLocked(n int) bool {
+	// This must be called with the mutex already locked
+	if f.denyHalted() {
+		return false
+	}
+	if ok, _, err := f.tryTakeLocked(n); ok && err == nil {
+		return true
+	}
+
+	f.deny(f.limitedReason())
+	return false
+}
+
+// tryTakeLocked takes n tokens from the state file if they are available, otherwise it returns how long until it's
+// worth trying again.
+func (f *fileBucket) tryTakeLocked(n int) (bool, time.Duration, error) {
+	// This must be called with the mutex already locked
+	taken, retryIn, err := f.takeLocked(n)
+	if taken {
+		f.countAllowed()
+	}
+	return taken, retryIn, err
+}
+
+// takeLocked takes n tokens from the state file if they are available and the limiter is open, otherwise it returns
+// how long until it's worth trying again.
+func (f *fileBucket) takeLocked(n int) (bool, time.Duration, error) {
+	// This must be called with the mutex already locked
+	taken := false
+	err := f.update(func(state *fileState) bool {
+		if state.Tokens < float64(n) || !f.closedUntil().IsZero() {
+			return false
+		}
+		state.Tokens -= float64(n)
+		taken = true
+		return true
+	})
+	if err != nil {
+		return false, 0, err
+	}
+	if !taken {
+		return false, f.retryIn(f.nextAllowedTime(n), f.duration), nil
+	}
+	return true, 0, nil
+}
+
+// nextAllowedTime returns when the bucket holds n tokens again as of the last read, or the zero time if it never
+// refills them.
+func (f *fileBucket) nextAllowedTime(n int) time.Time {
+	// This must be called with the mutex already locked
+	now := f.clock.Now()
+	if f.tokens >= float64(n) {
+		return now
+	}
+	if f.count < n {
+		return time.Time{}
+	}
+	return now.Add(time.Duration((float64(n) - f.tokens) * float64(f.duration) / float64(f.count)))
+}
+
+// Clear refills the bucket in the state file, for every process sharing it, and cancels the pending reservations of
+// this one.
+func (f *fileBucket) Clear() {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	f.cancelReservationsLocked()
+	_ = f.update(func(state *fileState) bool {
+		state.Tokens = float64(f.count)
+		return true
+	})
+	f.waiters.notify()
+}
+
+// Close puts the tokens of the pending reservations back for the other processes sharing the file, they are lost if it
+// can't be written.
+func (f *fileBucket) Close() error {
+	return f.close(func() {
+		reserved := 0
+		for res := range f.pendingReservations {
+			reserved += res.n
+		}
+		f.cancelReservationsLocked()
+		if reserved > 0 {
+			_ = f.update(func(state *fileState) bool {
+				state.Tokens = min(state.Tokens+float64(reserved), float64(f.count))
+				return true
+			})
+		}
+	})
+}
+
+// cancelReservationsLocked cancels the pending reservations.
+func (f *fileBucket) cancelReservationsLocked() {
+	// This must be called with the mutex already locked
+	for res := range f.pendingReservations {
+		res.canceled = true
+	}
+	f.pendingReservations = make(map[*fileBucketReservation]struct{})
+}
+
+func (f *fileBucket) Stats() Stats {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	_ = f.update(nil)
+
+	stats := f.stats()
+	stats.PendingReservations = len(f.pendingReservations)
+	return stats
+}
+
+// Info reports the bucket capacity as the limit, with Reset being when the bucket would be full again.
+func (f *fileBucket) Info() LimitInfo {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	_ = f.update(nil)
+
+	reset := f.clock.Now()
+	if missing := float64(f.count) - f.tokens; missing > 0 && f.count > 0 {
+		reset = reset.Add(time.Duration(missing * float64(f.duration) / float64(f.count)))
+	}
+	return f.info(f.count, int(f.tokens), reset, f.duration)
+}
+
+// Available returns the whole tokens in the state file, without taking any.
+func (f *fileBucket) Available() int {
+	return f.Info().Remaining
+}
+
+func (f *fileBucket) EstimatedWait() time.Duration {
+	return f.waitUntil(f.Stats().NextAllowedTime)
+}
+
+func (f *fileBucket) Config() Config {
+	return Config{Algorithm: AlgorithmFileTokenBucket, Count: f.count, Per: f.duration}
+}
+
+// Limit returns the rate the bucket refills at.
+func (f *fileBucket) Limit() Rate {
+	return Rate{Count: f.count, Per: f.duration}
+}
+
+// Burst returns the bucket capacity.
+func (f *fileBucket) Burst() int {
+	return f.count
+}
+
+func (f *fileBucket) PendingReservationAges(n int) []time.Duration {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	_ = f.update(nil)
+
+	reservedAt := make([]time.Time, 0, len(f.pendingReservations))
+	for res := range f.pendingReservations {
+		reservedAt = append(reservedAt, res.reservedAt)
+	}
+	return f.reservationAges(reservedAt, n)
+}
+
+// cleanupExpiredReservations forgets the expired reservations, returning how many tokens they held.
+func (f *fileBucket) cleanupExpiredReservations() int {
+	// This must be called with the mutex already locked
+	now := f.clock.Now()
+	expired := 0
+	for res := range f.pendingReservations {
+		if res.expiresAt != nil && now.After(*res.expiresAt) {
+			delete(f.pendingReservations, res)
+			expired += res.n
+		}
+	}
+	return expired
+}
+
+// tryReserveLocked takes n tokens from the state file for a reservation if they are available, otherwise it returns
+// how long until it's worth trying again.
+func (f *fileBucket) tryReserveLocked(ctx context.Context, n int, reservationTTL *time.Duration) (*fileBucketReservation, time.Duration, error) {
+	// This must be called with the mutex already locked
+	taken, retryIn, err := f.takeLocked(n)
+	if !taken {
+		return nil, retryIn, err
+	}
+
+	reservation := &fileBucketReservation{
+		limiter:    f,
+		n:          n,
+		reservedAt: f.clock.Now(),
+		expiresAt:  f.expiryFor(ctx, reservationTTL),
+	}
+	f.pendingReservations[reservation] = struct{}{}
+	f.watchAbandoned(reservation.reservedAt, func() bool {
+		return pendingAt(f.clock.Now(), reservation.consumed, reservation.canceled, reservation.expiresAt)
+	})
+	return reservation, 0, nil
+}
+
+func (f *fileBucket) TryReserve(reservationTTL *time.Duration) (Reservation, bool) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	if reservation, _, err := f.tryReserveLocked(context.Background(), 1, reservationTTL); reservation != nil && err == nil {
+		return reservation, true
+	}
+
+	f.deny(f.limitedReason())
+	return nil, false
+}
+
+func (f *fileBucket) Reserve(reservationTTL *time.Duration) Reservation {
+	reservation, err := f.ReserveContext(context.Background(), reservationTTL)
+	if err != nil {
+		return failedReservation{err: err}
+	}
+	return reservation
+}
+
+func (f *fileBucket) ReserveTimeout(timeout time.Duration, reservationTTL *time.Duration) (Reservation, error) {
+	ctx, cancel := withTimeout(f.clock, timeout)
+	defer cancel()
+	return f.ReserveContext(ctx, reservationTTL)
+}
+
+func (f *fileBucket) ReserveContext(ctx context.Context, reservationTTL *time.Duration) (Reservation, error) {
+	return f.ReserveN(ctx, 1, reservationTTL)
+}
+
+func (f *fileBucket) ReserveN(ctx context.Context, n int, reservationTTL *time.Duration) (Reservation, error) {
+	if err := reserveCostErr(n); err != nil {
+		return nil, err
+	}
+
+	var reservation *fileBucketReservation
+	err := f.awaitReservation(ctx, func() (bool, time.Duration, error) {
+		if err := f.costErrLocked(n); err != nil {
+			return false, 0, err
+		}
+
+		var retryIn time.Duration
+		var err error
+		reservation, retryIn, err = f.tryReserveLocked(ctx, n, reservationTTL)
+		return reservation != nil, retryIn, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	f.link(ctx, reservation)
+	return reservation, nil
+}
+
+func (f *fileBucket) Permits(ctx context.Context) <-chan struct{} {
+	return deliverPermits(ctx, func(ctx context.Context) (permit, error) {
+		return reservePermit(ctx, f)
+	})
+}
+
+// fileBucketReservation implements the Reservation interface. Its token was taken from the state file when reserving.
+type fileBucketReservation struct {
+	limiter    *fileBucket
+	n          int // Tokens taken for it
+	reservedAt time.Time
+	expiresAt  *time.Time
+	consumed   bool
+	canceled   bool
+}
+
+func (r *fileBucketReservation) Consume() error {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	if err := reservationErr(r.limiter.clock.Now(), r.consumed, r.canceled, r.expiresAt); err != nil {
+		return err
+	}
+
+	r.consumed = true
+	delete(r.limiter.pendingReservations, r)
+	r.limiter.countAllowed()
+	return nil
+}
+
+// ConsumeContext is Consume, which never blocks.
+func (r *fileBucketReservation) ConsumeContext(context.Context) error {
+	return r.Consume()
+}
+
+func (r *fileBucketReservation) Cancel() {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	if _, pending := r.limiter.pendingReservations[r]; !pending || r.consumed {
+		return
+	}
+	r.canceled = true
+	delete(r.limiter.pendingReservations, r)
+	// Put the reserved tokens back, they are lost if the file can't be written
+	_ = r.limiter.update(func(state *fileState) bool {
+		state.Tokens = min(state.Tokens+float64(r.n), float64(r.limiter.count))
+		return true
+	})
+	r.limiter.waiters.notify()
+}
+
+func (r *fileBucketReservation) Detach() (ReservationHandle, error) {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	state := func() error {
+		return reservationErr(r.limiter.clock.Now(), r.consumed, r.canceled, r.expiresAt)
+	}
+	if err := state(); err != nil {
+		return ReservationHandle{}, err
+	}
+	return r.limiter.detach(r, r.expiresAt, state), nil
+}
+
+// ReadyAt is now, Consume never blocks.
+func (r *fileBucketReservation) ReadyAt() time.Time {
+	return r.limiter.clock.Now()
+}
+
+func (r *fileBucketReservation) Delay() time.Duration {
+	return 0
+}
+
+func (r *fileBucketReservation) ExpiresAt() (time.Time, bool) {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	return expiry(r.expiresAt)
+}
+
+func (r *fileBucketReservation) State() ReservationState {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	return reservationState(r.limiter.clock.Now(), r.consumed, r.canceled, r.expiresAt)
+}
@@ -0,0 +1,137 @@
+package limit_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limittest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileTokenBucket_Shared(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	path := filepath.Join(t.TempDir(), "bucket")
+	first := limit.NewFileTokenBucket(path, 3, 3*time.Second, limit.WithClock(clock))
+	second := limit.NewFileTokenBucket(path, 3, 3*time.Second, limit.WithClock(clock))
+
+	// Both limiters take from the same tokens
+	assert.True(t, first.Allowed())
+	assert.True(t, second.Allowed())
+	reservation := second.Reserve(nil)
+	assert.False(t, first.Allowed())
+	assert.Equal(t, 0, second.Info().Remaining)
+
+	// Canceling puts the token back
+	reservation.Cancel()
+	assert.True(t, first.Allowed())
+
+	done := make(chan error)
+	go func() {
+		done <- second.WaitContext(context.Background())
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(1 * time.Second)
+	assert.NoError(t, <-done)
+	assert.Equal(t, 2, first.Stats().AllowedRequests)
+	assert.Equal(t, 1, first.Stats().DeniedRequests)
+	assert.Equal(t, 2, second.Stats().AllowedRequests)
+
+	// Clearing refills the bucket for everyone
+	first.Clear()
+	assert.Equal(t, 3, second.Info().Remaining)
+}
+
+func TestFileTokenBucket_Unwritable(t *testing.T) {
+	t.Parallel()
+
+	bucket := limit.NewFileTokenBucket(filepath.Join(t.TempDir(), "missing", "bucket"), 3, 1*time.Second)
+	assert.False(t, bucket.Allowed())
+	assert.Error(t, bucket.WaitContext(context.Background()))
+}
+
+func TestFileTokenBucket_InvalidRate(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "bucket")
+	for _, rate := range []struct {
+		count    int
+		duration time.Duration
+	}{{0, 1 * time.Second}, {-1, 1 * time.Second}, {3, 0}, {3, -1 * time.Second}} {
+		limiter, err := limit.NewFileTokenBucketE(path, rate.count, rate.duration)
+		assert.Error(t, err)
+		assert.Nil(t, limiter)
+		assert.PanicsWithValue(t, "limit: NewFileTokenBucket: "+err.Error(), func() {
+			limit.NewFileTokenBucket(path, rate.count, rate.duration)
+		})
+	}
+
+	limiter, err := limit.NewFileTokenBucketE(path, 3, 1*time.Second)
+	assert.NoError(t, err)
+	assert.True(t, limiter.Allowed())
+}
+
+// TestFileTokenBucket_Processes runs several processes taking from the same bucket as fast as they can, checking they
+// are allowed the rate of the bucket between them. Each of them is this test binary running this test.
+func TestFileTokenBucket_Processes(t *testing.T) {
+	if path := os.Getenv("GO_LIMIT_FILE_BUCKET"); path != "" {
+		bucket := limit.NewFileTokenBucket(path, 20, 1*time.Second)
+		allowed := 0
+		for end := time.Now().Add(1 * time.Second); time.Now().Before(end); {
+			if bucket.Allowed() {
+				allowed++
+			}
+			time.Sleep(1 * time.Millisecond)
+		}
+		fmt.Printf("allowed %d\n", allowed)
+		return
+	}
+	if testing.Short() {
+		t.Skip("spawns processes")
+	}
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "bucket")
+	start := time.Now()
+	var wg sync.WaitGroup
+	var mux sync.Mutex
+	total := 0
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cmd := exec.Command(os.Args[0], "-test.run=^TestFileTokenBucket_Processes$")
+			cmd.Env = append(os.Environ(), "GO_LIMIT_FILE_BUCKET="+path)
+			out, err := cmd.Output()
+			if !assert.NoError(t, err) {
+				return
+			}
+			allowed := -1
+			scanner := bufio.NewScanner(bytes.NewReader(out))
+			for scanner.Scan() {
+				_, _ = fmt.Sscanf(scanner.Text(), "allowed %d", &allowed)
+			}
+			if assert.GreaterOrEqual(t, allowed, 0, string(out)) {
+				mux.Lock()
+				total += allowed
+				mux.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	// The bucket starts full and refills at 20 per second for at least the second every process runs
+	assert.GreaterOrEqual(t, total, 20+18)
+	assert.LessOrEqual(t, total, 20+int(20*elapsed.Seconds())+1)
+}
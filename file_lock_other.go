@@ -0,0 +1,34 @@
+//go:build !unix
+
+package limit
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// staleLockAge is how old a lock file must be to be taken as left behind by a process that died holding it.
+const staleLockAge = 10 * time.Second
+
+// lockFile takes the lock at path by creating the file exclusively, polling while another process holds it, and
+// returns the function releasing it. A lock file older than staleLockAge is removed.
+func lockFile(path string) (func(), error) {
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			_ = file.Close()
+			return func() { _ = os.Remove(path) }, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > staleLockAge {
+			_ = os.Remove(path)
+			continue
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
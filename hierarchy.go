@@ -0,0 +1,172 @@
+package limit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// HierarchyStats describes a HierarchicalLimiter.
+type HierarchyStats struct {
+	// The stats of the parent, which include requests not made through the children if it's shared
+	Parent Stats `json:"parent"`
+	// The stats of the children added up, with the NextAllowedTime and Remaining of none of them
+	Children Stats `json:"children"`
+	// The stats of each child, in the order they were created
+	PerChild []Stats `json:"per_child"`
+}
+
+// HierarchicalLimiter splits a shared parent limiter into children with limits of their own, e.g. a global rate of
+// 1000/s and a rate of 100/s per tenant. The children only admit a request if the parent admits it too.
+type HierarchicalLimiter struct {
+	// Mutex
+	mux sync.Mutex
+
+	// Config
+	parent Limiter
+	opts   []Option
+
+	// State
+	children []*hierarchicalChild
+}
+
+// NewHierarchicalLimiter returns a HierarchicalLimiter sharing parent between its children. The options apply to the
+// token buckets of the children.
+func NewHierarchicalLimiter(parent Limiter, opts ...Option) *HierarchicalLimiter {
+	return &HierarchicalLimiter{parent: parent, opts: opts}
+}
+
+// Child returns a limiter allowing count requests per duration, and only those the parent allows as well. The child's
+// capacity is reserved first and given back if the parent turns the request down, so neither spends a token for a
+// request the other denied and only the one that turned it down counts it as denied. Its reservations hold room in
+// both, and its Stats, Info and Config are its own token bucket's, with Remaining and EstimatedWait also accounting for
+// the parent.
+// It panics if count or duration isn't positive, like NewTokenBucket.
+func (h *HierarchicalLimiter) Child(count int, duration time.Duration) Limiter {
+	child := &hierarchicalChild{
+		Limiter: NewTokenBucket(count, duration, h.opts...),
+		parent:  h.parent,
+		clock:   newOptions(h.opts).clock,
+	}
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.children = append(h.children, child)
+	return child
+}
+
+// Stats returns the stats of the parent and of the children.
+func (h *HierarchicalLimiter) Stats() HierarchyStats {
+	h.mux.Lock()
+	children := h.children
+	h.mux.Unlock()
+
+	stats := HierarchyStats{Parent: h.parent.Stats(), PerChild: make([]Stats, 0, len(children))}
+	for _, child := range children {
+		childStats := child.Stats()
+		stats.PerChild = append(stats.PerChild, childStats)
+		addStats(&stats.Children, childStats)
+	}
+	return stats
+}
+
+var _ Limiter = (*hierarchicalChild)(nil)
+
+// hierarchicalChild is a child of a HierarchicalLimiter.
+type hierarchicalChild struct {
+	Limiter
+	parent Limiter
+	clock  Clock
+}
+
+func (c *hierarchicalChild) Wait() {
+	_ = c.WaitContext(context.Background())
+}
+
+func (c *hierarchicalChild) WaitTimeout(timeout time.Duration) error {
+	return c.WaitNTimeout(timeout, 1)
+}
+
+func (c *hierarchicalChild) WaitContext(ctx context.Context) error {
+	return c.WaitNContext(ctx, 1)
+}
+
+func (c *hierarchicalChild) WaitN(n int) error {
+	return c.WaitNContext(context.Background(), n)
+}
+
+func (c *hierarchicalChild) WaitNTimeout(timeout time.Duration, n int) error {
+	ctx, cancel := withTimeout(c.clock, timeout)
+	defer cancel()
+	return c.WaitNContext(ctx, n)
+}
+
+// WaitNContext holds n units of the child while it waits for the parent, and gives them back if the parent fails.
+func (c *hierarchicalChild) WaitNContext(ctx context.Context, n int) error {
+	if n <= 0 {
+		return nil
+	}
+
+	return waitAll(ctx, []Limiter{c.Limiter, c.parent}, n)
+}
+
+func (c *hierarchicalChild) Allowed() bool {
+	return c.AllowN(1)
+}
+
+// AllowN reserves n units of the child and only consumes them if the parent allows n units too.
+func (c *hierarchicalChild) AllowN(n int) bool {
+	if n <= 0 {
+		return true
+	}
+
+	return allowAll([]Limiter{c.Limiter, c.parent}, n)
+}
+
+// Info is the child's, with the Remaining the parent leaves.
+func (c *hierarchicalChild) Info() LimitInfo {
+	info := c.Limiter.Info()
+	info.Remaining = min(info.Remaining, c.parent.Available())
+	return info
+}
+
+func (c *hierarchicalChild) Available() int {
+	return min(c.Limiter.Available(), c.parent.Available())
+}
+
+func (c *hierarchicalChild) EstimatedWait() time.Duration {
+	return max(c.Limiter.EstimatedWait(), c.parent.EstimatedWait())
+}
+
+func (c *hierarchicalChild) TryReserve(reservationTTL *time.Duration) (Reservation, bool) {
+	return tryReserveAll([]Limiter{c.Limiter, c.parent}, reservationTTL)
+}
+
+func (c *hierarchicalChild) Reserve(reservationTTL *time.Duration) Reservation {
+	reservation, err := c.ReserveContext(context.Background(), reservationTTL)
+	if err != nil {
+		return failedReservation{err: err}
+	}
+	return reservation
+}
+
+func (c *hierarchicalChild) ReserveTimeout(timeout time.Duration, reservationTTL *time.Duration) (Reservation, error) {
+	ctx, cancel := withTimeout(c.clock, timeout)
+	defer cancel()
+	return c.ReserveContext(ctx, reservationTTL)
+}
+
+func (c *hierarchicalChild) ReserveContext(ctx context.Context, reservationTTL *time.Duration) (Reservation, error) {
+	return c.ReserveN(ctx, 1, reservationTTL)
+}
+
+// ReserveN holds the child's reservation while it waits for the parent's, and cancels it if the parent fails.
+func (c *hierarchicalChild) ReserveN(ctx context.Context, n int, reservationTTL *time.Duration) (Reservation, error) {
+	return reserveAll(ctx, []Limiter{c.Limiter, c.parent}, n, reservationTTL)
+}
+
+func (c *hierarchicalChild) Permits(ctx context.Context) <-chan struct{} {
+	return deliverPermits(ctx, func(ctx context.Context) (permit, error) {
+		return reservePermit(ctx, c)
+	})
+}
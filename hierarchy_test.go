@@ -0,0 +1,126 @@
+package limit_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limittest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHierarchicalLimiter_BothAdmit(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	parent := limit.NewTokenBucket(3, 1*time.Minute, limit.WithClock(clock))
+	hierarchy := limit.NewHierarchicalLimiter(parent, limit.WithClock(clock))
+	a := hierarchy.Child(2, 1*time.Minute)
+	b := hierarchy.Child(2, 1*time.Minute)
+
+	// The child turns the third request down
+	assert.True(t, a.Allowed())
+	assert.True(t, a.Allowed())
+	assert.False(t, a.Allowed())
+
+	// The parent turns the second one down, the child keeps its token
+	assert.True(t, b.Allowed())
+	assert.False(t, b.Allowed())
+	assert.Equal(t, 0, b.Available())
+
+	stats := hierarchy.Stats()
+	assert.Equal(t, 3, stats.Parent.AllowedRequests)
+	assert.Equal(t, 1, stats.Parent.DeniedRequests)
+	assert.Equal(t, 3, stats.Children.AllowedRequests)
+	assert.Equal(t, 1, stats.Children.DeniedRequests)
+	assert.Equal(t, 1, stats.PerChild[1].Remaining)
+}
+
+func TestHierarchicalLimiter_NoLeakedTokens(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	parent := limit.NewTokenBucket(100, 1*time.Hour, limit.WithClock(clock))
+	hierarchy := limit.NewHierarchicalLimiter(parent, limit.WithClock(clock))
+
+	children := make([]limit.Limiter, 5)
+	allowed := make([]int, len(children))
+	var mux sync.Mutex
+	var wg sync.WaitGroup
+	for i := range children {
+		children[i] = hierarchy.Child(30, 1*time.Hour)
+		for range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := range 50 {
+					var ok bool
+					switch j % 3 {
+					case 0:
+						ok = children[i].Allowed()
+					case 1:
+						ok = children[i].WaitTimeout(0) == nil
+					default:
+						// A reservation given back mustn't spend anything
+						if reservation, reserved := children[i].TryReserve(nil); reserved {
+							reservation.Cancel()
+						}
+						continue
+					}
+					if ok {
+						mux.Lock()
+						allowed[i]++
+						mux.Unlock()
+					}
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	// Every request allowed by a child was allowed by the parent, and every token not spent is still there
+	stats := hierarchy.Stats()
+	total := 0
+	for i, n := range allowed {
+		total += n
+		assert.LessOrEqual(t, n, 30)
+		assert.Equal(t, n, stats.PerChild[i].AllowedRequests)
+		assert.Equal(t, 30-n, stats.PerChild[i].Remaining)
+		assert.Equal(t, 0, stats.PerChild[i].PendingReservations)
+	}
+	assert.Equal(t, total, stats.Parent.AllowedRequests)
+	assert.Equal(t, total, stats.Children.AllowedRequests)
+	assert.Equal(t, 100-total, stats.Parent.Remaining)
+	assert.Equal(t, 0, stats.Parent.PendingReservations)
+}
+
+func TestHierarchicalLimiter_FailedParentGivesBack(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	parent := limit.NewTokenBucket(1, 1*time.Minute, limit.WithClock(clock))
+	hierarchy := limit.NewHierarchicalLimiter(parent, limit.WithClock(clock))
+	child := hierarchy.Child(5, 1*time.Minute)
+
+	// The child's tokens are held while waiting for the parent, and given back when the wait fails
+	assert.True(t, child.Allowed())
+	ctx, cancel := context.WithCancel(context.Background())
+	waited := make(chan error)
+	go func() {
+		waited <- child.WaitNContext(ctx, 1)
+	}()
+	clock.BlockUntil(1)
+	assert.Equal(t, 3, hierarchy.Stats().PerChild[0].Remaining)
+	cancel()
+	assert.ErrorIs(t, <-waited, context.Canceled)
+	assert.Equal(t, 4, hierarchy.Stats().PerChild[0].Remaining)
+
+	// So are the ones of a reservation the parent turns down
+	assert.NoError(t, parent.Close())
+	reservation, err := child.ReserveContext(context.Background(), nil)
+	assert.ErrorIs(t, err, limit.ErrLimiterClosed)
+	assert.Nil(t, reservation)
+	assert.Equal(t, 4, hierarchy.Stats().PerChild[0].Remaining)
+}
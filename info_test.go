@@ -0,0 +1,201 @@
+package limit_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limittest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucket_Info(t *testing.T) {
+	t.Parallel()
+
+	start := time.Now()
+	clock := limittest.NewFakeClock(start)
+	limiter := limit.NewTokenBucket(10, 10*time.Second, limit.WithClock(clock))
+	assert.Equal(t, limit.LimitInfo{Limit: 10, Remaining: 10, Reset: start, Window: 10 * time.Second}, limiter.Info())
+
+	for i := 0; i < 3; i++ {
+		assert.True(t, limiter.Allowed())
+	}
+	_ = limiter.Reserve(nil)
+	assert.Equal(t, limit.LimitInfo{Limit: 10, Remaining: 6, Reset: start.Add(3 * time.Second), Window: 10 * time.Second}, limiter.Info())
+
+	// A refill brings one token back without moving the time the bucket is full again
+	clock.Advance(1 * time.Second)
+	assert.Equal(t, limit.LimitInfo{Limit: 10, Remaining: 7, Reset: start.Add(3 * time.Second), Window: 10 * time.Second}, limiter.Info())
+}
+
+func TestRollingWindow_Info(t *testing.T) {
+	t.Parallel()
+
+	start := time.Now()
+	clock := limittest.NewFakeClock(start)
+	limiter := limit.NewRollingWindow(5, 1*time.Minute, limit.WithClock(clock))
+	assert.Equal(t, limit.LimitInfo{Limit: 5, Remaining: 5, Reset: start, Window: 1 * time.Minute}, limiter.Info())
+
+	assert.True(t, limiter.Allowed())
+	clock.Advance(10 * time.Second)
+	assert.True(t, limiter.Allowed())
+	assert.Equal(t, limit.LimitInfo{Limit: 5, Remaining: 3, Reset: start.Add(1 * time.Minute), Window: 1 * time.Minute}, limiter.Info())
+}
+
+func TestLeakyBucket_Info(t *testing.T) {
+	t.Parallel()
+
+	start := time.Now()
+	clock := limittest.NewFakeClock(start)
+	limiter := limit.NewLeakyBucket(1, 1*time.Second, 3, limit.WithClock(clock))
+	assert.True(t, limiter.Allowed())
+	assert.Equal(t, limit.LimitInfo{Limit: 3, Remaining: 3, Reset: start, Window: 3 * time.Second}, limiter.Info())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = limiter.WaitContext(ctx) }()
+	assert.Eventually(t, func() bool { return limit.QueueDepth(limiter) == 1 }, 1*time.Second, 1*time.Millisecond)
+
+	// The queued event leaks a second after the last one
+	assert.Equal(t, limit.LimitInfo{Limit: 3, Remaining: 2, Reset: start.Add(1 * time.Second), Window: 3 * time.Second}, limiter.Info())
+}
+
+func TestBudget_Info(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Date(2026, time.June, 1, 0, 0, 0, 0, time.UTC))
+	budget := limit.NewBudget(720, limit.QuotaMonthly, time.UTC, limit.WithClock(clock), limit.WithBudgetAhead(5))
+	assert.True(t, budget.Allowed())
+
+	assert.Equal(t, limit.LimitInfo{
+		Limit:     720,
+		Remaining: 4,
+		Reset:     time.Date(2026, time.July, 1, 0, 0, 0, 0, time.UTC),
+		Window:    720 * time.Hour,
+	}, budget.Info())
+}
+
+func TestLimiter_Info_Blackout(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Date(2026, time.June, 1, 0, 30, 0, 0, time.UTC))
+	limiter := limit.NewTokenBucket(10, 1*time.Second, limit.WithClock(clock), limit.WithBlackouts(maintenance, time.UTC))
+	assert.Equal(t, 0, limiter.Info().Remaining)
+
+	clock.Advance(15 * time.Minute)
+	assert.Equal(t, 10, limiter.Info().Remaining)
+}
+
+func TestLimiter_Info_ConsistentUnderConcurrentConsumption(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range leaserConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			limiter := newLimiter(100, 1*time.Hour)
+
+			var allowed atomic.Int64
+			var wg sync.WaitGroup
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 20; j++ {
+						if limiter.Allowed() {
+							allowed.Add(1)
+						}
+					}
+				}()
+			}
+
+			done := make(chan struct{})
+			go func() {
+				wg.Wait()
+				close(done)
+			}()
+
+			// Nothing frees up within the hour, so the remaining requests only go down and the reset never moves back
+			last := limiter.Info()
+			for running := true; running; {
+				select {
+				case <-done:
+					running = false
+				default:
+				}
+
+				info := limiter.Info()
+				assert.Equal(t, 100, info.Limit)
+				assert.LessOrEqual(t, info.Remaining, last.Remaining)
+				assert.GreaterOrEqual(t, info.Remaining, 0)
+				assert.False(t, info.Reset.Before(last.Reset))
+				last = info
+			}
+
+			assert.Equal(t, int64(100), allowed.Load())
+			assert.Equal(t, 0, limiter.Info().Remaining)
+		})
+	}
+}
+
+func TestLimiter_Configurer(t *testing.T) {
+	t.Parallel()
+
+	for name, tc := range map[string]struct {
+		limiter limit.Limiter
+		limit   limit.Rate
+		burst   int
+	}{
+		"TokenBucket":   {limit.NewTokenBucket(10, 1*time.Second), limit.Rate{Count: 10, Per: 1 * time.Second}, 10},
+		"RollingWindow": {limit.NewRollingWindow(600, 1*time.Minute, limit.WithSmoothing(1*time.Second)), limit.Rate{Count: 600, Per: 1 * time.Minute}, 10},
+		"LeakyBucket":   {limit.NewLeakyBucket(5, 1*time.Second, 20), limit.Rate{Count: 5, Per: 1 * time.Second}, 1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			configurer := tc.limiter.(limit.Configurer)
+			assert.Equal(t, tc.limit, configurer.Limit())
+			assert.Equal(t, tc.burst, configurer.Burst())
+		})
+	}
+
+	assert.Equal(t, 20, limit.NewLeakyBucket(5, 1*time.Second, 20).(limit.QueueConfigurer).MaxQueue())
+}
+
+func TestBudget_Configurer_FollowsSetBudget(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Date(2026, time.June, 1, 0, 0, 0, 0, time.UTC))
+	budget := limit.NewBudget(720, limit.QuotaMonthly, time.UTC, limit.WithClock(clock), limit.WithBudgetAhead(5))
+	configurer := budget.(limit.Configurer)
+	assert.Equal(t, limit.Rate{Count: 720, Per: 720 * time.Hour}, configurer.Limit())
+	assert.Equal(t, 5, configurer.Burst())
+
+	budget.SetBudget(100)
+	assert.Equal(t, limit.Rate{Count: 100, Per: 720 * time.Hour}, configurer.Limit())
+}
+
+func TestLease_Configurer(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range leaserConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			parent := newLimiter(10, 1*time.Second)
+			lease, err := parent.(limit.Leaser).AcquireLease(limit.Rate{Count: 4, Per: 1 * time.Second}, 1*time.Minute)
+			assert.NoError(t, err)
+
+			// The parent's rate follows the lease
+			assert.Equal(t, limit.Rate{Count: 6, Per: 1 * time.Second}, parent.(limit.Configurer).Limit())
+			assert.Equal(t, limit.Rate{Count: 4, Per: 1 * time.Second}, lease.(limit.Configurer).Limit())
+			assert.Equal(t, 4, lease.(limit.Configurer).Burst())
+
+			lease.Release()
+			assert.Equal(t, limit.Rate{Count: 10, Per: 1 * time.Second}, parent.(limit.Configurer).Limit())
+		})
+	}
+}
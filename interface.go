@@ -5,14 +5,95 @@ import (
 	"time"
 )
 
-// Stats represents the current statistics of a rate limiter.
+// Reason describes why a request was denied.
+type Reason string
+
+const (
+	// ReasonLimited means the limiter had no capacity for the request.
+	ReasonLimited Reason = "limited"
+	// ReasonQueueFull means the limiter's queue had no room for the request.
+	ReasonQueueFull Reason = "queue_full"
+	// ReasonContext means the context was done before the limiter allowed the request.
+	ReasonContext Reason = "context"
+	// ReasonExpired means a reservation expired while waiting to be consumed.
+	ReasonExpired Reason = "expired"
+	// ReasonEvicted means a queued request was evicted to make room for a newer one.
+	ReasonEvicted Reason = "evicted"
+	// ReasonDropped means a request was dropped because the queue was full.
+	ReasonDropped Reason = "dropped"
+	// ReasonBlackout means the request arrived during one of the limiter's blackout windows.
+	ReasonBlackout Reason = "blackout"
+	// ReasonSmoothing means the window had room for the request but the smoothing cap of its sub-interval didn't.
+	ReasonSmoothing Reason = "smoothing"
+	// ReasonCanceled means the request was waiting when CancelWaiters ejected it.
+	ReasonCanceled Reason = "canceled"
+	// ReasonNotStarted means the request arrived before the start time set with WithStartTime.
+	ReasonNotStarted Reason = "not_started"
+	// ReasonClosed means the request arrived or was waiting when the limiter was closed with Close.
+	ReasonClosed Reason = "closed"
+	// ReasonPaused means the request arrived while the limiter was paused, see Pauser.
+	ReasonPaused Reason = "paused"
+	// ReasonWaitTooLong means the request would have waited longer than the limit set with WithMaxWait.
+	ReasonWaitTooLong Reason = "wait_too_long"
+)
+
+// Stats represents the current statistics of a rate limiter. It encodes to JSON with NextAllowedTime in RFC 3339 with
+// millisecond precision, see MarshalJSON.
 type Stats struct {
 	// The total number of requests allowed since the limiter was created. Doesn't get reset when the limiter is cleared.
-	AllowedRequests int
+	AllowedRequests int `json:"allowed_requests"`
 	// The total number of requests denied since the limiter was created. This includes requests that were waiting but timed out.
-	DeniedRequests int
-	// The time when the next request will be allowed.
-	NextAllowedTime time.Time
+	DeniedRequests int `json:"denied_requests"`
+	// The denied requests broken down by the reason they were denied.
+	DeniedByReason map[Reason]int `json:"denied_by_reason,omitempty"`
+	// The time when the next request will be allowed, net of pending reservations. Zero if no request can be allowed
+	// until pending reservations without a TTL are consumed or canceled, or while the limiter is paused.
+	NextAllowedTime time.Time `json:"next_allowed_time,omitempty"`
+	// Whether the limiter is paused, see Pauser.
+	Paused bool `json:"paused"`
+	// The requests that could be allowed right now net of pending reservations, like the Remaining of Info: the tokens,
+	// the room in the window or the room in the leaky bucket's queue. Zero during a blackout or a pause.
+	Remaining int `json:"remaining"`
+	// The reservations taken and not consumed, canceled or expired yet, however many units each holds.
+	PendingReservations int `json:"pending_reservations"`
+	// The callers blocked waiting for the limiter. For the leaky bucket it's the events in its queue instead, which
+	// includes consumed reservations waiting to leak.
+	QueueDepth int `json:"queue_depth"`
+	// The active leases carved out of the limiter's rate, ordered by expiry.
+	Leases []LeaseStats `json:"leases,omitempty"`
+	// The reservations reported by the detector set with WithAbandonedReservationDetector.
+	AbandonedReservations int `json:"abandoned_reservations"`
+	// The timestamps found ahead of the clock by more than the tolerance set with WithClockAnomalies, see ClockAnomaly.
+	ClockAnomalies int `json:"clock_anomalies"`
+	// The consumes that failed with ErrOversubscribed, see WithReservationOversubscription.
+	Oversubscribed int `json:"oversubscribed"`
+	// The multiplier applied to the key, only set by KeyedLimiter.KeyStats with WithMultiplier.
+	Multiplier *MultiplierStats `json:"multiplier,omitempty"`
+	// The share of the global rate a limiter created with NewPartitioned enforces, nil for other limiters.
+	Partition *PartitionStats `json:"partition,omitempty"`
+	// The queue size of a leaky bucket created with WithAdaptiveQueue, nil for other limiters.
+	Queue *QueueStats `json:"queue,omitempty"`
+	// The slots in flight of a limiter created with NewConcurrencyLimiter, nil for other limiters.
+	Concurrency *ConcurrencyStats `json:"concurrency,omitempty"`
+	// The rate a limiter created with NewAdaptiveLimiter settled on, nil for other limiters.
+	Adaptive *AdaptiveStats `json:"adaptive,omitempty"`
+	// The limiter serving the calls of a limiter created with NewFallbackLimiter, nil for other limiters.
+	Fallback *FallbackStats `json:"fallback,omitempty"`
+	// The ramp of a limiter created with NewWarmupTokenBucket, nil for other limiters.
+	Warmup *WarmupStats `json:"warmup,omitempty"`
+}
+
+// LimitInfo is a consistent view of a limiter's quota, taken at once so its fields agree with each other.
+type LimitInfo struct {
+	// The number of requests the limiter allows per window, net of active leases.
+	Limit int
+	// The requests that could be allowed right now, net of pending reservations. Zero during a blackout or a pause.
+	Remaining int
+	// When the limiter would be back to its full limit if no more requests arrived, or the next step towards it. The
+	// meaning depends on the limiter, see Info on each constructor.
+	Reset time.Time
+	// The window the limit applies to.
+	Window time.Duration
 }
 
 // Limiter is the interface that wraps the basic methods of a rate limiter.
@@ -24,24 +105,133 @@ type Limiter interface {
 	WaitTimeout(timeout time.Duration) error
 	// WaitContext blocks until the limiter allows the operation to proceed or the context is done.
 	WaitContext(ctx context.Context) error
+	// WaitN blocks until the limiter allows n units of the operation to proceed, taking them all at once. It fails
+	// right away if n is more than the limiter can ever allow at once, and returns nil right away if n is 0.
+	WaitN(n int) error
+	// WaitNTimeout is WaitN, giving up when the timeout expires.
+	WaitNTimeout(timeout time.Duration, n int) error
+	// WaitNContext is WaitN, giving up when the context is done.
+	WaitNContext(ctx context.Context, n int) error
 	// Allowed returns true if the operation is allowed to proceed. It's non-blocking.
 	Allowed() bool
+	// AllowN returns true if n units of the operation are allowed to proceed, taking all of them or none, e.g. a batch
+	// of n rows against a quota of rows. It's non-blocking, AllowN(1) is Allowed and AllowN(0) always returns true.
+	AllowN(n int) bool
 	// Clear clears the limiter.
 	Clear()
 	// Stats returns the current stats of the limiter.
 	Stats() Stats
-	// Reserve blocks until the limiter can return a Reservation object. The Reservation has its own expiry duration or TTL. If nil it does not expire.
+	// Info returns the limit, remaining requests and reset time of the limiter, taken under a single lock.
+	Info() LimitInfo
+	// Available returns how many requests the limiter would allow right now net of pending reservations, the Remaining
+	// of Info. It's read-only, it takes nothing and isn't counted in the stats.
+	Available() int
+	// EstimatedWait returns how long a Wait would block right now, net of pending reservations: zero if it would be
+	// allowed right away, and the longest duration if only consuming or canceling reservations can allow it. It's
+	// read-only like Available, and only an estimate, other callers may take the capacity first.
+	EstimatedWait() time.Duration
+	// Config returns the configuration the limiter enforces, including changes made since it was created, and before
+	// leases carve out their share.
+	Config() Config
+	// Labels returns a copy of the labels the limiter was created with.
+	Labels() map[string]string
+	// PendingReservationAges returns how long ago the pending reservations were taken, oldest first and at most n of
+	// them. It's a snapshot meant for debugging, the reservations may be consumed or canceled right after.
+	PendingReservationAges(n int) []time.Duration
+	// Waiters returns the callers blocked in WaitContext, ReserveContext and the calls built on them, in the order they
+	// started waiting.
+	Waiters() []WaiterInfo
+	// CancelWaiters stops every blocked caller with err, ErrWaitCanceled if nil, and returns how many there were.
+	// Unlike Clear it leaves the limiter's state untouched.
+	CancelWaiters(err error) int
+	// Close closes the limiter for good: the callers blocked in it and every later call fail with ErrLimiterClosed,
+	// Allowed returns false and pending reservations are canceled. Closing it again does nothing and returns nil.
+	Close() error
+	// Reserve blocks until the limiter can return a Reservation object, it never returns nil. The Reservation has its own expiry duration or TTL. If nil it does not expire.
 	Reserve(reservationTTL *time.Duration) Reservation
 	// ReserveTimeout blocks until the limiter can return a Reservation object or the timeout expires. The Reservation has its own expiry duration or TTL. If nil it does not expire.
 	ReserveTimeout(timeout time.Duration, reservationTTL *time.Duration) (Reservation, error)
-	// ReserveContext requests a reservation with a context and returns a Reservation object.  The Reservation has its own expiry duration or TTL. If nil it does not expire. Context cancellation will only impact getting the reservation but will not expire the reservation itself.
+	// ReserveContext requests a reservation with a context and returns a Reservation object.  The Reservation has its own expiry duration or TTL. If nil it does not expire. Context cancellation will only impact getting the reservation but will not expire the reservation itself, unless the limiter was created with WithLinkedReservations.
 	ReserveContext(ctx context.Context, reservationTTL *time.Duration) (Reservation, error)
+	// TryReserve returns a reservation and true if one can be taken right now, without blocking, otherwise nil and
+	// false, counting the request as denied. The leaky bucket's reservations hold room in its queue, so it fails only
+	// when the queue is full, like its ReserveContext.
+	TryReserve(reservationTTL *time.Duration) (Reservation, bool)
+	// ReserveN is ReserveContext for n units, held by the reservation until it's consumed, canceled or expires, and
+	// counted with their weight against other callers meanwhile. Consuming it uses all n at once. It fails right away
+	// if n isn't positive or is more than the limiter can ever allow at once.
+	ReserveN(ctx context.Context, n int, reservationTTL *time.Duration) (Reservation, error)
+	// Permits returns a channel delivering one value per permit at the limiter's pace, closed once the context is done.
+	// Permits aren't taken ahead of the receiver, so they never pile up beyond what the limiter allows at once.
+	Permits(ctx context.Context) <-chan struct{}
+}
+
+// Configurer is implemented by the limiters in this package, and wrappers can forward it, to tell how they were
+// configured, e.g. for logging or rate limit headers. The values follow changes made after creation, like leases and
+// SetBudget.
+type Configurer interface {
+	// Limit returns the rate the limiter allows, net of active leases.
+	Limit() Rate
+	// Burst returns how many requests the limiter allows at once when it's idle.
+	Burst() int
+}
+
+// RateSetter is implemented by the limiters whose rate can change while they're in use, the token bucket.
+type RateSetter interface {
+	// SetRate changes the rate to count per duration, keeping the state of the limiter, and wakes the blocked callers
+	// to check it again. A count of zero stops the limiter until a rate is set again.
+	SetRate(count int, per time.Duration)
+}
+
+// LimitSetter is implemented by the limiters whose window can change while they're in use, the rolling window.
+type LimitSetter interface {
+	// SetLimit changes the limit to count events per duration, keeping the events already recorded, and wakes the
+	// blocked callers to check it again.
+	SetLimit(count int, duration time.Duration)
+}
+
+// QueueTuner is implemented by the limiters that queue requests and can be tuned while they're in use, the leaky
+// bucket.
+type QueueTuner interface {
+	// SetLeakRate changes the rate events leak at to count per duration, from the next leak on, and wakes the blocked
+	// callers to check it again.
+	SetLeakRate(count int, per time.Duration)
+	// SetMaxQueue changes the number of events the limiter queues. Events already queued stay queued, a queue longer
+	// than n turns new events away until it drains, and a longer one wakes the callers waiting for room.
+	SetMaxQueue(n int)
+}
+
+// QueueConfigurer is implemented by the limiters that queue requests, the leaky bucket.
+type QueueConfigurer interface {
+	Configurer
+	// MaxQueue returns the number of requests the limiter queues.
+	MaxQueue() int
 }
 
-// Reservation represents a reservation against a rate limiter that can be consumed or canceled
+// Reservation represents a reservation against a rate limiter that can be consumed or canceled.
+// A reservation expires after the TTL it was requested with, or if it was nil after the default TTL set with
+// WithDefaultReservationTTL, never without one. The context a reservation is requested with doesn't affect its expiry
+// unless the limiter was created with WithReservationTTLFromContext, in which case the context deadline caps it too.
 type Reservation interface {
 	// Consume uses the reservation, returning an error if the reservation expired
 	Consume() error
+	// ConsumeContext is Consume giving up once ctx is done. Only the leaky bucket's reservations block, waiting for the
+	// event to leak, and the ones taken with ReserveDelayed, waiting out their delay, the others ignore ctx.
+	ConsumeContext(ctx context.Context) error
 	// Cancel releases the reservation without using it
 	Cancel()
+	// Detach returns a handle to attach the reservation again with the limiter's Attach, e.g. in another goroutine
+	// that only gets the handle. It fails if the reservation is no longer pending.
+	Detach() (ReservationHandle, error)
+	// ReadyAt returns when Consume would complete without blocking if called then, not after now if it would right
+	// away. Only the leaky bucket's reservations and the ones taken with ReserveDelayed block.
+	ReadyAt() time.Time
+	// Delay returns how long until ReadyAt, zero if Consume would complete right away.
+	Delay() time.Duration
+	// ExpiresAt returns when the reservation expires, its TTL or the context deadline capping it, and false if it
+	// never does.
+	ExpiresAt() (time.Time, bool)
+	// State returns where the reservation is in its lifecycle. It's read-only, it doesn't count in the stats nor clean up
+	// an expired reservation.
+	State() ReservationState
 }
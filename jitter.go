@@ -0,0 +1,116 @@
+package limit
+
+import (
+	"context"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// jitter stretches the sleeps of waiting callers by a random share, so callers of limiters with the same parameters
+// don't all wake at the same instants.
+type jitter struct {
+	// Mutex, the rand.Rand of a source isn't safe for concurrent use
+	mux sync.Mutex
+
+	// Config
+	fraction float64
+	rand     *rand.Rand // Nil for the global source
+}
+
+// newJitter returns the jitter set with WithJitter and WithJitterSource, nil without one.
+func newJitter(o options) *jitter {
+	if o.jitter <= 0 {
+		return nil
+	}
+	j := &jitter{fraction: o.jitter}
+	if o.jitterSource != nil {
+		j.rand = rand.New(o.jitterSource)
+	}
+	return j
+}
+
+// stretch returns d lengthened by a random share of up to fraction of it. It never shortens d, so a caller sleeping
+// for it never tries again earlier than it would without jitter.
+func (j *jitter) stretch(d time.Duration) time.Duration {
+	if j == nil || d <= 0 {
+		return d
+	}
+
+	var u float64
+	if j.rand == nil {
+		u = rand.Float64()
+	} else {
+		j.mux.Lock()
+		u = j.rand.Float64()
+		j.mux.Unlock()
+	}
+	// Waits this long, e.g. for a token bucket with a rate of 0, would overflow
+	extra := float64(d) * j.fraction * u
+	if extra >= float64(math.MaxInt64-d) || d+time.Duration(extra) < d {
+		return math.MaxInt64
+	}
+	return d + time.Duration(extra)
+}
+
+var _ Limiter = (*jitterLimiter)(nil)
+
+// jitterLimiter stretches the waits of a limiter.
+type jitterLimiter struct {
+	Limiter
+	jitter *jitter
+	clock  Clock
+}
+
+// NewJitterLimiter returns l with its waits stretched by a random share of up to fraction, for limiters that don't
+// take WithJitter, e.g. ones from other packages. Before waiting on l it sleeps for l's EstimatedWait stretched by the
+// jitter, so it's never admitted earlier than l would admit it. Reservations aren't jittered. It accepts WithClock and
+// WithJitterSource.
+func NewJitterLimiter(l Limiter, fraction float64, opts ...Option) Limiter {
+	o := newOptions(opts)
+	o.jitter = fraction
+	return &jitterLimiter{Limiter: l, jitter: newJitter(o), clock: o.clock}
+}
+
+func (j *jitterLimiter) Wait() {
+	_ = j.WaitContext(context.Background())
+}
+
+func (j *jitterLimiter) WaitTimeout(timeout time.Duration) error {
+	return j.WaitNTimeout(timeout, 1)
+}
+
+func (j *jitterLimiter) WaitContext(ctx context.Context) error {
+	return j.WaitNContext(ctx, 1)
+}
+
+func (j *jitterLimiter) WaitN(n int) error {
+	return j.WaitNContext(context.Background(), n)
+}
+
+func (j *jitterLimiter) WaitNTimeout(timeout time.Duration, n int) error {
+	ctx, cancel := withTimeout(j.clock, timeout)
+	defer cancel()
+	return j.WaitNContext(ctx, n)
+}
+
+// WaitNContext sleeps for the jittered estimated wait of the limiter, then waits on it. If ctx is done during the sleep
+// the limiter is still asked, so it counts the denial and returns its own error.
+func (j *jitterLimiter) WaitNContext(ctx context.Context, n int) error {
+	if wait := j.jitter.stretch(j.Limiter.EstimatedWait()); n > 0 && wait > 0 {
+		timer := j.clock.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+		case <-timer.C():
+		}
+		timer.Stop()
+	}
+	return j.Limiter.WaitNContext(ctx, n)
+}
+
+func (j *jitterLimiter) Permits(ctx context.Context) <-chan struct{} {
+	return deliverPermits(ctx, func(ctx context.Context) (permit, error) {
+		return reservePermit(ctx, j)
+	})
+}
@@ -0,0 +1,84 @@
+package limit_test
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limittest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithJitter(t *testing.T) {
+	t.Parallel()
+
+	limiters := []struct {
+		name    string
+		limiter func(opts ...limit.Option) limit.Limiter
+	}{
+		{"TokenBucket", func(opts ...limit.Option) limit.Limiter { return limit.NewTokenBucket(1, 1*time.Second, opts...) }},
+		{"RollingWindow", func(opts ...limit.Option) limit.Limiter { return limit.NewRollingWindow(1, 1*time.Second, opts...) }},
+		{"LeakyBucket", func(opts ...limit.Option) limit.Limiter { return limit.NewLeakyBucket(1, 1*time.Second, 1, opts...) }},
+	}
+	for _, l := range limiters {
+		t.Run(l.name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := limittest.NewFakeClock(time.Unix(0, 0))
+			limiter := l.limiter(limit.WithClock(clock), limit.WithJitter(0.5), limit.WithJitterSource(rand.NewPCG(1, 2)))
+			assert.NoError(t, limiter.WaitN(1))
+
+			// The same seed draws the same stretch as the limiter
+			wait := limiter.EstimatedWait()
+			jittered := wait + time.Duration(float64(wait)*0.5*rand.New(rand.NewPCG(1, 2)).Float64())
+			assert.Greater(t, jittered, wait)
+
+			done := make(chan error, 1)
+			go func() {
+				done <- limiter.WaitN(1)
+			}()
+			clock.BlockUntil(1)
+
+			// Not admitted when the limiter would allow it, only once the jittered sleep is over
+			clock.Advance(wait)
+			assert.Equal(t, 1, clock.Timers())
+			assert.Empty(t, done)
+			clock.Advance(jittered - wait)
+			assert.NoError(t, <-done)
+		})
+	}
+}
+
+func TestNewJitterLimiter(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	limiter := limit.NewJitterLimiter(limit.NewTokenBucket(1, 1*time.Second, limit.WithClock(clock)), 0.5,
+		limit.WithClock(clock), limit.WithJitterSource(rand.NewPCG(1, 2)))
+	assert.NoError(t, limiter.WaitN(1))
+
+	jittered := time.Second + time.Duration(float64(time.Second)*0.5*rand.New(rand.NewPCG(1, 2)).Float64())
+	done := make(chan error, 1)
+	go func() {
+		done <- limiter.WaitN(1)
+	}()
+	clock.BlockUntil(1)
+
+	clock.Advance(jittered - 1)
+	assert.Equal(t, 1, clock.Timers())
+	assert.Empty(t, done)
+	clock.Advance(1)
+	assert.NoError(t, <-done)
+	assert.Equal(t, 2, limiter.Stats().AllowedRequests)
+}
+
+func TestWithJitter_Overflow(t *testing.T) {
+	t.Parallel()
+
+	// A token bucket with a rate of 0 waits math.MaxInt64 for its next token, which can't be stretched any further
+	assert.Equal(t, time.Duration(math.MaxInt64), limit.Stretch(0.5, rand.NewPCG(1, 2), math.MaxInt64))
+	assert.Equal(t, time.Duration(math.MaxInt64), limit.Stretch(1, rand.NewPCG(1, 2), math.MaxInt64-time.Second))
+	assert.Greater(t, limit.Stretch(1, rand.NewPCG(1, 2), math.MaxInt64/2), time.Duration(math.MaxInt64/2))
+}
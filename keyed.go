@@ -0,0 +1,499 @@
+package limit
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// KeyError is returned by the calls acquiring several keys at once, naming the key that couldn't be acquired.
+type KeyError struct {
+	Key string
+	Err error
+}
+
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("key %q: %v", e.Key, e.Err)
+}
+
+func (e *KeyError) Unwrap() error {
+	return e.Err
+}
+
+// EvictReason tells why a KeyedLimiter dropped a key.
+type EvictReason string
+
+const (
+	// EvictRemoved means the key was dropped with Remove.
+	EvictRemoved EvictReason = "removed"
+	// EvictCache means the KeyCache evicted the key.
+	EvictCache EvictReason = "cache"
+	// EvictIdle means the key went unused for longer than the time set with WithIdleTimeout.
+	EvictIdle EvictReason = "idle"
+	// EvictRefused means the KeyCache refused to store the new limiter of the key. Unlike the other reasons the key
+	// keeps its limiter, outside the cache, until the cache takes it on a later use or the key is dropped.
+	EvictRefused EvictReason = "refused"
+)
+
+// EvictionHook is called with the final stats of a key a KeyedLimiter dropped. It's called without holding the
+// keyed limiter's lock, so it may use it, but it isn't given the dropped limiter: using the key again creates a new one.
+// Keys the KeyCache evicts are reported by the call that made it evict them, before it returns, or by the next call if
+// the cache evicted them on its own.
+type EvictionHook func(key string, finalStats Stats, reason EvictReason)
+
+// KeyedStats describes a KeyedLimiter.
+type KeyedStats struct {
+	// The keys in use
+	Keys int `json:"keys"`
+	// The keys evicted since the keyed limiter was created, idle or by the KeyCache. Keys dropped with Remove don't count.
+	Evictions int `json:"evictions"`
+	// The stats of the limiters of every key in use added up, with the NextAllowedTime and Remaining of none of them
+	Total Stats `json:"total"`
+}
+
+// KeyedLimiter holds a limiter per key, e.g. per user or per organization, created on first use.
+type KeyedLimiter struct {
+	// Mutexes, mux serializes the calls to the cache and keysMux guards the keys. They're taken in the order mux, the
+	// cache's, keysMux, as the cache may veto an eviction from a call made with mux locked. The mutexes of the limiters
+	// are taken after the cache's, to check whether they're busy, but never with keysMux locked
+	mux     sync.Mutex
+	keysMux sync.Mutex
+
+	// Config
+	factory      func(key string) Limiter
+	evictionHook EvictionHook
+	clock        Clock
+	multiplier   *multiplier // Set by WithMultiplier
+	idleTimeout  time.Duration
+
+	// State
+	limiters   KeyCache
+	nextSweep  time.Time
+	keys       map[string]*keyEntry      // The limiters of the keys in use, refused by the cache or not, guarded by keysMux
+	multiplied map[string]*keyMultiplier // Guarded by keysMux, the multipliers themselves by mux
+	evictions  int                       // Guarded by keysMux
+	// The keys the cache evicted or refused, guarded by keysMux until they're reported
+	cacheEvicted []keyEviction
+}
+
+// keyEntry tracks the use of the limiter of a key.
+type keyEntry struct {
+	limiter  Limiter
+	lastUsed time.Time
+	// The calls of the keyed limiter blocked on the limiter
+	inUse int
+}
+
+// keyEviction is a key the cache evicted or refused, to report once the mutex is unlocked.
+type keyEviction struct {
+	key     string
+	limiter Limiter
+	reason  EvictReason
+}
+
+// NewKeyedLimiter returns a KeyedLimiter creating the limiter of each key with factory the first time the key is used.
+// It accepts WithEvictionHook, WithKeyCache, WithMaxKeys, WithIdleTimeout, WithMultiplier, WithMultiplierEpsilon and
+// WithClock.
+//
+// With WithIdleTimeout, keys unused for the timeout are dropped on the next use of the keyed limiter, which looks for
+// them at most once per timeout, or by the sweeper started with Start. Keys whose limiter has waiters or pending
+// reservations are kept. A caller holding the limiter Get returned longer than the timeout may be left with a dropped
+// one.
+func NewKeyedLimiter(factory func(key string) Limiter, opts ...Option) *KeyedLimiter {
+	o := newOptions(opts)
+	k := &KeyedLimiter{
+		factory:      factory,
+		evictionHook: o.evictionHook,
+		clock:        o.clock,
+		idleTimeout:  o.idleTimeout,
+		limiters:     o.keyCache,
+		keys:         make(map[string]*keyEntry),
+		multiplied:   make(map[string]*keyMultiplier),
+	}
+	switch {
+	case k.limiters != nil:
+	case o.maxKeys > 0:
+		k.limiters = NewLRUCache(o.maxKeys)
+	default:
+		k.limiters = NewMapCache()
+	}
+	k.limiters.OnEvict(k.vetoEviction)
+	if o.multiplier != nil {
+		k.multiplier = &multiplier{fn: o.multiplier, refresh: o.multiplierRefresh, epsilon: o.multiplierEpsilon}
+	}
+	return k
+}
+
+// Get returns the limiter of key, creating it if it's the first time key is used. With WithMultiplier, it also starts
+// refreshing the multiplier of key in the background when it's due.
+func (k *KeyedLimiter) Get(key string) Limiter {
+	limiters, _ := k.getMany([]string{key}, false)
+	return limiters[0]
+}
+
+// getMany returns the limiters of keys, in order, looking all of them up under a single lock. With hold, they're
+// marked in use as they're looked up until the returned function is called, so they aren't evicted while a call of
+// the keyed limiter is blocked on them.
+func (k *KeyedLimiter) getMany(keys []string, hold bool) ([]Limiter, func()) {
+	limiters := make([]Limiter, len(keys))
+	held := make([]*keyEntry, 0, len(keys))
+	var refresh []string
+	k.mux.Lock()
+	now := k.clock.Now()
+	var idle map[string]Limiter
+	if k.idleTimeout > 0 && !now.Before(k.nextSweep) {
+		idle = k.sweepLocked(now)
+	}
+	for i, key := range keys {
+		e := k.entryLocked(key)
+		k.keysMux.Lock()
+		if hold {
+			held = append(held, e)
+		} else {
+			e.inUse--
+		}
+		e.lastUsed = now
+		if k.multiplier != nil && k.refreshDue(key) {
+			refresh = append(refresh, key)
+		}
+		k.keysMux.Unlock()
+		limiters[i] = e.limiter
+	}
+	evicted := k.takeCacheEvicted()
+	k.mux.Unlock()
+
+	k.evictedIdle(idle)
+	k.evictedCache(evicted)
+	for _, key := range refresh {
+		go k.RefreshKey(key)
+	}
+	return limiters, func() {
+		k.keysMux.Lock()
+		defer k.keysMux.Unlock()
+
+		now := k.clock.Now()
+		for _, e := range held {
+			e.inUse--
+			e.lastUsed = now
+		}
+	}
+}
+
+// entryLocked returns the entry of key marked in use, creating its limiter if the cache doesn't hold one. The entry
+// is marked in use the moment it's looked up, so the cache can't evict it before the caller is done with it.
+func (k *KeyedLimiter) entryLocked(key string) *keyEntry {
+	// This must be called with the mutex already locked, and keysMux unlocked
+	for {
+		l, ok := k.limiters.Get(key)
+		if !ok {
+			return k.uncachedLocked(key)
+		}
+
+		k.keysMux.Lock()
+		e, tracked := k.keys[key]
+		if tracked && e.limiter == l {
+			e.inUse++
+			k.keysMux.Unlock()
+			return e
+		}
+		k.keysMux.Unlock()
+		// The cache evicted it since the lookup, and must drop it before the key gets a new one
+		k.limiters.Delete(key)
+	}
+}
+
+// uncachedLocked returns the entry of a key the cache doesn't hold marked in use, creating its limiter if it's new, and
+// offers the limiter to the cache. A key the cache refused keeps its limiter outside of it until the cache takes it on a
+// later use, since a new limiter on every use would let the key through with a full allowance each time. The first
+// refusal is reported to the eviction hook with EvictRefused.
+func (k *KeyedLimiter) uncachedLocked(key string) *keyEntry {
+	// This must be called with the mutex already locked, and keysMux unlocked
+	k.keysMux.Lock()
+	e, refused := k.keys[key]
+	if refused {
+		e.inUse++
+	}
+	k.keysMux.Unlock()
+
+	if !refused {
+		l := k.factory(key)
+		var km *keyMultiplier
+		if k.multiplier != nil {
+			km = newKeyMultiplier(l)
+		}
+		e = &keyEntry{limiter: l, inUse: 1}
+		k.keysMux.Lock()
+		k.keys[key] = e
+		if km != nil {
+			k.multiplied[key] = km
+		}
+		k.keysMux.Unlock()
+	}
+	if !k.limiters.Set(key, e.limiter, LimiterCost(e.limiter)) && !refused {
+		k.keysMux.Lock()
+		k.cacheEvicted = append(k.cacheEvicted, keyEviction{key: key, limiter: e.limiter, reason: EvictRefused})
+		k.keysMux.Unlock()
+	}
+	return e
+}
+
+// Start evicts the keys unused for the time set with WithIdleTimeout in the background, once per timeout, until ctx is
+// done, so they're dropped even if the keyed limiter isn't used anymore. It does nothing without WithIdleTimeout.
+func (k *KeyedLimiter) Start(ctx context.Context) {
+	if k.idleTimeout <= 0 {
+		return
+	}
+
+	go func() {
+		timer := k.clock.NewTimer(k.idleTimeout)
+		defer timer.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C():
+			}
+
+			k.mux.Lock()
+			idle := k.sweepLocked(k.clock.Now())
+			k.mux.Unlock()
+			k.evictedIdle(idle)
+			timer.Reset(k.idleTimeout)
+		}
+	}()
+}
+
+// sweepLocked drops the limiters unused for the idle timeout that aren't busy, and returns them by key.
+func (k *KeyedLimiter) sweepLocked(now time.Time) map[string]Limiter {
+	// This must be called with the mutex already locked
+	k.nextSweep = now.Add(k.idleTimeout)
+	idle := make(map[string]Limiter)
+	k.keysMux.Lock()
+	for key, e := range k.keys {
+		if e.inUse == 0 && now.Sub(e.lastUsed) >= k.idleTimeout {
+			idle[key] = e.limiter
+		}
+	}
+	k.keysMux.Unlock()
+
+	// The limiters are asked whether they're busy without keysMux locked, then the keys are checked again
+	maps.DeleteFunc(idle, func(_ string, l Limiter) bool {
+		return busy(l)
+	})
+	k.keysMux.Lock()
+	for key, l := range idle {
+		if e, ok := k.keys[key]; !ok || e.limiter != l || e.inUse > 0 || now.Sub(e.lastUsed) < k.idleTimeout {
+			delete(idle, key)
+			continue
+		}
+		delete(k.keys, key)
+		delete(k.multiplied, key)
+		k.evictions++
+	}
+	k.keysMux.Unlock()
+
+	// The cache is called without keysMux locked, it may be vetoing an eviction
+	for key := range idle {
+		k.limiters.Delete(key)
+	}
+	return idle
+}
+
+// evictedIdle reports the limiters sweepLocked dropped. It must be called without the mutex locked.
+func (k *KeyedLimiter) evictedIdle(idle map[string]Limiter) {
+	for key, l := range idle {
+		k.evicted(key, l.Stats(), EvictIdle)
+	}
+}
+
+// takeCacheEvicted returns the keys the cache evicted or refused since it was last called.
+func (k *KeyedLimiter) takeCacheEvicted() []keyEviction {
+	k.keysMux.Lock()
+	defer k.keysMux.Unlock()
+	evicted := k.cacheEvicted
+	k.cacheEvicted = nil
+	return evicted
+}
+
+// evictedCache reports the keys the cache evicted or refused. It must be called without the mutex locked.
+func (k *KeyedLimiter) evictedCache(evicted []keyEviction) {
+	for _, e := range evicted {
+		k.evicted(e.key, e.limiter.Stats(), e.reason)
+	}
+}
+
+// Allowed reports whether the limiter of key allows the operation right now, creating it if it's the first time key
+// is used.
+func (k *KeyedLimiter) Allowed(key string) bool {
+	return k.Get(key).Allowed()
+}
+
+// WaitContext blocks until the limiter of key allows the operation or the context is done, creating it if it's the
+// first time key is used.
+func (k *KeyedLimiter) WaitContext(ctx context.Context, key string) error {
+	limiters, release := k.getMany([]string{key}, true)
+	defer release()
+	return limiters[0].WaitContext(ctx)
+}
+
+// Keys returns the keys in use, sorted.
+func (k *KeyedLimiter) Keys() []string {
+	k.keysMux.Lock()
+	defer k.keysMux.Unlock()
+	return slices.Sorted(maps.Keys(k.keys))
+}
+
+// Stats returns how many keys are in use, how many were evicted and the stats of their limiters added up. Use
+// KeyStats for the stats of a single key.
+func (k *KeyedLimiter) Stats() KeyedStats {
+	k.keysMux.Lock()
+	stats := KeyedStats{Keys: len(k.keys), Evictions: k.evictions}
+	limiters := make([]Limiter, 0, len(k.keys))
+	for _, e := range k.keys {
+		limiters = append(limiters, e.limiter)
+	}
+	k.keysMux.Unlock()
+
+	for _, l := range limiters {
+		addStats(&stats.Total, l.Stats())
+	}
+	return stats
+}
+
+// Delete drops the limiter of key, like Remove without returning its final stats.
+func (k *KeyedLimiter) Delete(key string) {
+	k.Remove(key)
+}
+
+// Remove drops the limiter of key and returns its final stats, or false if key wasn't in use. Callers still holding
+// the limiter may use it after its stats were taken, that use isn't reported.
+func (k *KeyedLimiter) Remove(key string) (Stats, bool) {
+	k.mux.Lock()
+	l, ok := k.limiters.Get(key)
+	k.limiters.Delete(key)
+	k.keysMux.Lock()
+	// The keys the cache refused are only tracked in the keys
+	if e, tracked := k.keys[key]; tracked {
+		l, ok = e.limiter, true
+	}
+	delete(k.keys, key)
+	delete(k.multiplied, key)
+	k.keysMux.Unlock()
+	k.mux.Unlock()
+
+	if !ok {
+		return Stats{}, false
+	}
+	stats := l.Stats()
+	k.evicted(key, stats, EvictRemoved)
+	return stats, true
+}
+
+// evicted calls the eviction hook, if any. It must be called without the mutex locked.
+func (k *KeyedLimiter) evicted(key string, stats Stats, reason EvictReason) {
+	if k.evictionHook != nil {
+		k.evictionHook(key, stats, reason)
+	}
+}
+
+// AllowedAll reports whether every key allows the operation right now, consuming a permit of each if so and none
+// otherwise. Keys are reserved in sorted order, so callers passing them in any order can't starve each other, and
+// only the key that turned the operation down counts it as denied. A key repeated in keys takes a permit each time.
+// A leaky bucket holds an event it let leak right away instead of a reservation, as in NewMultiLimiter.
+func (k *KeyedLimiter) AllowedAll(keys ...string) bool {
+	sorted := slices.Sorted(slices.Values(keys))
+	limiters := make([]Limiter, 0, len(sorted))
+	for _, key := range sorted {
+		limiters = append(limiters, k.Get(key))
+	}
+	return allowAll(limiters, 1)
+}
+
+// AllowedMany reports whether each key allows an operation right now, consuming a permit of each key that does, e.g.
+// to check the recipients of a notification fanout. The results are in the order of keys, and a key repeated in keys
+// takes a permit each time. Unlike AllowedAll each key is decided on its own. The limiters of all the keys are looked
+// up under a single lock.
+func (k *KeyedLimiter) AllowedMany(keys []string) []bool {
+	return k.AllowedManyN(keys, nil)
+}
+
+// AllowedManyN is AllowedMany charging costs[i] permits for keys[i], as CostMap does. A nil costs charges one permit per
+// key. It panics if costs is not nil and has a different length than keys.
+func (k *KeyedLimiter) AllowedManyN(keys []string, costs []int) []bool {
+	if costs != nil && len(costs) != len(keys) {
+		panic(fmt.Sprintf("limit: AllowedManyN with %d keys and %d costs", len(keys), len(costs)))
+	}
+
+	allowed := make([]bool, len(keys))
+	limiters, _ := k.getMany(keys, false)
+	for i, l := range limiters {
+		cost := 1
+		if costs != nil {
+			cost = costs[i]
+		}
+		allowed[i] = allowCost(l, cost)
+	}
+	return allowed
+}
+
+// WaitAll blocks until every key allows the operation or the context is done, taking a permit of each key only if it
+// got all of them. Keys are reserved in sorted order and the reservations already taken are held while waiting for
+// the next key, then given back if it fails. The error is a *KeyError naming the key that failed, which also counts
+// the denial.
+func (k *KeyedLimiter) WaitAll(ctx context.Context, keys ...string) error {
+	sorted := slices.Sorted(slices.Values(keys))
+	limiters, release := k.getMany(sorted, true)
+	defer release()
+	reservations := make([]Reservation, 0, len(sorted))
+	for i, key := range sorted {
+		reservation, err := limiters[i].ReserveContext(ctx, nil)
+		if err != nil {
+			cancelAll(reservations)
+			return &KeyError{Key: key, Err: err}
+		}
+		reservations = append(reservations, reservation)
+	}
+
+	if i, err := consumeAll(reservations); err != nil {
+		return &KeyError{Key: sorted[i], Err: err}
+	}
+	return nil
+}
+
+// cancelAll gives back the reservations.
+func cancelAll(reservations []Reservation) {
+	for _, reservation := range reservations {
+		reservation.Cancel()
+	}
+}
+
+// consumeAll consumes the reservations in order, after checking they're all pending so that none is consumed if one
+// expired or was canceled, e.g. while waiting for the others. If one still fails, because Clear canceled it or it
+// expired while the ones before it were consumed, the ones after it are given back and its index is returned with the
+// error, but the ones before it stay consumed.
+func consumeAll(reservations []Reservation) (int, error) {
+	if i, err := pendingAll(reservations); err != nil {
+		return i, err
+	}
+	for i, reservation := range reservations {
+		if err := reservation.Consume(); err != nil {
+			cancelAll(reservations[i+1:])
+			return i, err
+		}
+	}
+	return 0, nil
+}
+
+// pendingAll gives back every reservation if one of them isn't pending anymore, and returns its index with why.
+func pendingAll(reservations []Reservation) (int, error) {
+	for i, reservation := range reservations {
+		if err := stateErr(reservation.State()); err != nil {
+			cancelAll(reservations)
+			return i, err
+		}
+	}
+	return 0, nil
+}
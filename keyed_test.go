@@ -0,0 +1,297 @@
+package limit_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limittest"
+	"github.com/stretchr/testify/assert"
+)
+
+// userAndOrg gives users two requests per hour and organizations one.
+func userAndOrg(key string) limit.Limiter {
+	if key == "org" {
+		return limit.NewTokenBucket(1, 1*time.Hour)
+	}
+	return limit.NewRollingWindow(2, 1*time.Hour)
+}
+
+func TestKeyedLimiter_Get(t *testing.T) {
+	t.Parallel()
+
+	var created atomic.Int64
+	keyed := limit.NewKeyedLimiter(func(string) limit.Limiter {
+		created.Add(1)
+		return limit.NewTokenBucket(1, 1*time.Hour)
+	})
+
+	var wg sync.WaitGroup
+	limiters := make([]limit.Limiter, 10)
+	for i := range limiters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			limiters[i] = keyed.Get("user")
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(1), created.Load())
+	for _, l := range limiters {
+		assert.Same(t, limiters[0], l)
+	}
+}
+
+func TestKeyedLimiter_Stats(t *testing.T) {
+	t.Parallel()
+
+	keyed := limit.NewKeyedLimiter(userAndOrg)
+	assert.True(t, keyed.Allowed("user"))
+	assert.True(t, keyed.Allowed("user"))
+	assert.False(t, keyed.Allowed("user"))
+	assert.NoError(t, keyed.WaitContext(context.Background(), "org"))
+	assert.Equal(t, []string{"org", "user"}, keyed.Keys())
+
+	stats := keyed.Stats()
+	assert.Equal(t, 2, stats.Keys)
+	assert.Equal(t, 3, stats.Total.AllowedRequests)
+	assert.Equal(t, 1, stats.Total.DeniedRequests)
+	assert.Equal(t, 2, keyed.KeyStats("user").AllowedRequests)
+
+	keyed.Delete("user")
+	assert.Equal(t, []string{"org"}, keyed.Keys())
+	assert.Equal(t, limit.KeyedStats{Keys: 1, Total: limit.Stats{AllowedRequests: 1}}, keyed.Stats())
+}
+
+func TestKeyedLimiter_IdleTimeout(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	var mux sync.Mutex
+	var evicted []string
+	keyed := limit.NewKeyedLimiter(func(string) limit.Limiter {
+		return limit.NewTokenBucket(1, 1*time.Hour, limit.WithClock(clock))
+	}, limit.WithClock(clock), limit.WithIdleTimeout(1*time.Minute), limit.WithEvictionHook(
+		func(key string, _ limit.Stats, reason limit.EvictReason) {
+			mux.Lock()
+			defer mux.Unlock()
+			assert.Equal(t, limit.EvictIdle, reason)
+			evicted = append(evicted, key)
+		}))
+
+	assert.True(t, keyed.Allowed("idle"))
+	assert.True(t, keyed.Allowed("active"))
+	// A pending reservation keeps its key
+	reservation, ok := keyed.Get("reserved").TryReserve(nil)
+	assert.True(t, ok)
+
+	clock.Advance(30 * time.Second)
+	assert.False(t, keyed.Allowed("active"))
+	clock.Advance(30 * time.Second)
+	assert.False(t, keyed.Allowed("active"))
+	assert.Equal(t, []string{"active", "reserved"}, keyed.Keys())
+	assert.Equal(t, []string{"idle"}, evicted)
+	assert.Equal(t, 1, keyed.Stats().Evictions)
+
+	// Once the reservation is consumed the sweeper evicts the key
+	assert.NoError(t, reservation.Consume())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	keyed.Start(ctx)
+	clock.BlockUntil(1)
+	clock.Advance(1 * time.Minute)
+	assert.Eventually(t, func() bool {
+		return len(keyed.Keys()) == 0
+	}, 1*time.Second, 1*time.Millisecond)
+
+	stats := keyed.Stats()
+	assert.Equal(t, 0, stats.Keys)
+	assert.Equal(t, 3, stats.Evictions)
+}
+
+func TestKeyedLimiter_AllowedAll(t *testing.T) {
+	t.Parallel()
+
+	keyed := limit.NewKeyedLimiter(userAndOrg)
+	assert.True(t, keyed.AllowedAll("user", "org"))
+
+	// The org is exhausted, so the user keeps its second permit
+	assert.False(t, keyed.AllowedAll("user", "org"))
+	assert.Equal(t, 1, keyed.Get("user").Stats().AllowedRequests)
+	assert.Equal(t, 0, keyed.Get("user").Stats().DeniedRequests)
+	assert.Equal(t, 1, keyed.Get("org").Stats().DeniedRequests)
+	assert.Equal(t, 1, keyed.Get("org").Stats().DeniedByReason[limit.ReasonLimited])
+	assert.True(t, keyed.Get("user").Allowed())
+}
+
+func TestKeyedLimiter_WaitAll(t *testing.T) {
+	t.Parallel()
+
+	keyed := limit.NewKeyedLimiter(userAndOrg)
+	assert.NoError(t, keyed.WaitAll(context.Background(), "org", "user"))
+	assert.Equal(t, 1, keyed.Get("user").Stats().AllowedRequests)
+	assert.Equal(t, 1, keyed.Get("org").Stats().AllowedRequests)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := keyed.WaitAll(ctx, "user", "org")
+
+	var keyErr *limit.KeyError
+	if assert.ErrorAs(t, err, &keyErr) {
+		assert.Equal(t, "org", keyErr.Key)
+	}
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 1, keyed.Get("org").Stats().DeniedByReason[limit.ReasonContext])
+
+	// The user's reservation was given back
+	assert.Equal(t, 0, keyed.Get("user").Stats().DeniedRequests)
+	assert.True(t, keyed.Get("user").Allowed())
+}
+
+func TestKeyedLimiter_WaitAll_OppositeOrders(t *testing.T) {
+	t.Parallel()
+
+	keyed := limit.NewKeyedLimiter(func(string) limit.Limiter {
+		return limit.NewTokenBucket(1, 1*time.Millisecond)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		keys := []string{"a", "b"}
+		if i%2 == 1 {
+			keys = []string{"b", "a"}
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- keyed.WaitAll(ctx, keys...)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 20, keyed.Get("a").Stats().AllowedRequests)
+	assert.Equal(t, 20, keyed.Get("b").Stats().AllowedRequests)
+}
+
+func TestKeyError(t *testing.T) {
+	t.Parallel()
+
+	err := error(&limit.KeyError{Key: "org", Err: limit.ErrWaitCanceled})
+	assert.EqualError(t, err, `key "org": wait canceled`)
+	assert.True(t, errors.Is(err, limit.ErrWaitCanceled))
+}
+
+func TestKeyedLimiter_Remove(t *testing.T) {
+	t.Parallel()
+
+	type eviction struct {
+		key    string
+		stats  limit.Stats
+		reason limit.EvictReason
+	}
+	var keyed *limit.KeyedLimiter
+	var evictions []eviction
+	keyed = limit.NewKeyedLimiter(userAndOrg, limit.WithEvictionHook(func(key string, finalStats limit.Stats, reason limit.EvictReason) {
+		evictions = append(evictions, eviction{key, finalStats, reason})
+		// The hook may use the keyed limiter without deadlocking it
+		_, _ = keyed.Remove(key)
+	}))
+
+	assert.True(t, keyed.Get("user").Allowed())
+	stats, ok := keyed.Remove("user")
+	assert.True(t, ok)
+	assert.Equal(t, 1, stats.AllowedRequests)
+
+	_, ok = keyed.Remove("user")
+	assert.False(t, ok)
+	if assert.Len(t, evictions, 1) {
+		assert.Equal(t, eviction{"user", stats, limit.EvictRemoved}, evictions[0])
+	}
+
+	// Using the key again starts afresh
+	assert.Equal(t, 0, keyed.Get("user").Stats().AllowedRequests)
+}
+
+func TestKeyedLimiter_Remove_Concurrent(t *testing.T) {
+	t.Parallel()
+
+	var evictions atomic.Int64
+	keyed := limit.NewKeyedLimiter(userAndOrg, limit.WithEvictionHook(func(string, limit.Stats, limit.EvictReason) {
+		evictions.Add(1)
+	}))
+	keyed.Get("user")
+
+	var wg sync.WaitGroup
+	var removed atomic.Int64
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok := keyed.Remove("user"); ok {
+				removed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(1), removed.Load())
+	assert.Equal(t, int64(1), evictions.Load())
+}
+
+func TestKeyedLimiter_AllowedMany(t *testing.T) {
+	t.Parallel()
+
+	keyed := limit.NewKeyedLimiter(userAndOrg)
+
+	// Results follow the keys, and repeated keys take a permit each
+	assert.Equal(t, []bool{true, true, true, false, false}, keyed.AllowedMany([]string{"user", "org", "user", "user", "org"}))
+	assert.Equal(t, 2, keyed.Get("user").Stats().AllowedRequests)
+	assert.Equal(t, 1, keyed.Get("user").Stats().DeniedRequests)
+	assert.Empty(t, keyed.AllowedMany(nil))
+
+	// Costs are charged per key
+	assert.Equal(t, []bool{false, true, true}, keyed.AllowedManyN([]string{"other", "other", "free"}, []int{3, 2, 0}))
+	assert.Panics(t, func() { keyed.AllowedManyN([]string{"user"}, []int{1, 2}) })
+}
+
+func BenchmarkKeyedLimiter_AllowedMany(b *testing.B) {
+	keys := make([]string, 500)
+	for i := range keys {
+		keys[i] = fmt.Sprint("recipient-", i)
+	}
+	newKeyed := func() *limit.KeyedLimiter {
+		return limit.NewKeyedLimiter(func(string) limit.Limiter {
+			return limit.NewTokenBucket(1_000_000_000, 1*time.Second)
+		})
+	}
+
+	b.Run("Loop", func(b *testing.B) {
+		keyed := newKeyed()
+		for range b.N {
+			for _, key := range keys {
+				keyed.Get(key).Allowed()
+			}
+		}
+	})
+	b.Run("Batch", func(b *testing.B) {
+		keyed := newKeyed()
+		for range b.N {
+			keyed.AllowedMany(keys)
+		}
+	})
+}
@@ -4,73 +4,207 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"sync"
 	"time"
 )
 
+var _ Limiter = (*leakyBucket)(nil)
+
 type leakyBucket struct {
-	// Mutex
-	mux sync.Mutex
+	base
 
 	// Config
 	maxCapacity     int
 	currentCapacity int // Queued events
 	leakRate        time.Duration
+	rate            Rate
+	overflowPolicy  OverflowPolicy
+	adaptive        *adaptiveQueue // Set by WithAdaptiveQueue
 
 	// State
-	allowedEvents int
-	deniedEvents  int
-
-	lastLeak time.Time
+	lastLeak         time.Time
+	nextTune         time.Time // When the queue size is adjusted next with WithAdaptiveQueue
+	queueAdjustments int
 
 	// Reservation tracking
 	pendingReservations map[*leakyBucketReservation]struct{}
 }
 
-func NewLeakyBucket(count int, duration time.Duration, maxQueue int) Limiter {
+// NewLeakyBucket creates a leaky bucket letting count events per duration through, one every duration divided by count,
+// and queuing up to maxQueue of them. It panics if count or duration isn't positive, if the leak interval truncates to
+// zero, if maxQueue is negative or if an option doesn't apply to a leaky bucket, see NewLeakyBucketE.
+func NewLeakyBucket(count int, duration time.Duration, maxQueue int, opts ...Option) Limiter {
+	o := newOptions(opts)
+	err := validateLeakyBucket(count, duration, maxQueue)
+	if err == nil {
+		err = o.check(kindLeakyBucket)
+	}
+	if err != nil {
+		panic(fmt.Sprintf("limit: NewLeakyBucket: %v", err))
+	}
+
 	leakRate := duration / time.Duration(count)
-	return &leakyBucket{
-		mux:                 sync.Mutex{},
+	l := &leakyBucket{
 		maxCapacity:         maxQueue,
 		currentCapacity:     0,
 		leakRate:            leakRate,
-		lastLeak:            time.Now().Add(-leakRate),
+		rate:                Rate{Count: count, Per: duration},
+		overflowPolicy:      o.overflowPolicy,
+		adaptive:            o.adaptiveQueue,
+		lastLeak:            o.clock.Now().Add(-leakRate),
 		pendingReservations: make(map[*leakyBucketReservation]struct{}),
 	}
+	l.init(o)
+	if l.adaptive != nil {
+		// Start at the size the rate calls for
+		l.maxCapacity = l.adaptive.size(leakRate)
+		l.nextTune = o.clock.Now().Add(l.adaptive.targetMaxWait)
+	}
+	l.remaining = func() int { return l.maxCapacity - l.currentCapacity - reservedUnits(l.pendingReservations) }
+	l.nextAllowed = func() time.Time { return l.nextAllowedTime() }
+	return l
+}
+
+// NewLeakyBucketE is NewLeakyBucket returning an error instead of panicking on an invalid rate, queue size or option.
+func NewLeakyBucketE(count int, duration time.Duration, maxQueue int, opts ...Option) (Limiter, error) {
+	if err := validateLeakyBucket(count, duration, maxQueue); err != nil {
+		return nil, err
+	}
+	if err := newOptions(opts).check(kindLeakyBucket); err != nil {
+		return nil, err
+	}
+	return NewLeakyBucket(count, duration, maxQueue, opts...), nil
+}
+
+// validateLeakyBucket returns why a leaky bucket can't be created with the arguments of NewLeakyBucket, nil if it can.
+func validateLeakyBucket(count int, duration time.Duration, maxQueue int) error {
+	if maxQueue < 0 {
+		return fmt.Errorf("max queue %d is negative", maxQueue)
+	}
+	return validateRate(count, duration, true)
+}
+
+// NewLeakyBucketFromString creates a leaky bucket leaking at rate, a string like "100/s" as ParseRate parses it, with
+// a queue of maxQueue.
+func NewLeakyBucketFromString(rate string, maxQueue int, opts ...Option) (Limiter, error) {
+	count, per, err := ParseRate(rate)
+	if err != nil {
+		return nil, err
+	}
+	return NewLeakyBucketE(count, per, maxQueue, opts...)
 }
 
 func (l *leakyBucket) WaitContext(ctx context.Context) error {
-	if l.currentCapacity+len(l.pendingReservations) >= l.maxCapacity {
-		l.deniedEvents++
-		return errors.New("max allowed queue reached")
+	return l.WaitNContext(ctx, 1)
+}
+
+func (l *leakyBucket) WaitN(n int) error {
+	return l.WaitNContext(context.Background(), n)
+}
+
+func (l *leakyBucket) WaitNTimeout(timeout time.Duration, n int) error {
+	ctx, cancel := withTimeout(l.clock, timeout)
+	defer cancel()
+	return l.WaitNContext(ctx, n)
+}
+
+// WaitNContext queues an event of size n, which takes n slots in the queue and leaks once the bucket has been idle for n
+// leak intervals.
+func (l *leakyBucket) WaitNContext(ctx context.Context, n int) error {
+	if n <= 0 {
+		return nil
 	}
 
-	l.currentCapacity++ // Queue the event
-	for {
-		l.mux.Lock()
-		l.cleanupExpiredReservations()
+	return l.queueAndWait(ctx, n, false)
+}
+
+// queueAndWait queues an event of size n and waits for it to leak. If the queue is full it applies the overflow
+// policy, or waits for room with waitForRoom.
+func (l *leakyBucket) queueAndWait(ctx context.Context, n int, waitForRoom bool) error {
+	w := l.newWaiter(ctx)
+	return l.awaitAs(ctx, w, func() (bool, time.Duration, error) {
+		if w.queued == 0 {
+			l.tuneQueue()
+			if err := l.costErrLocked(n); err != nil {
+				return false, 0, err
+			}
+
+			l.cleanupExpiredReservations()
+			if l.queueFullLocked(n) && waitForRoom {
+				// Check again once the next event leaks or a reservation is canceled
+				return false, l.leakRate, nil
+			}
+			if l.queueFullLocked(n) && !l.makeRoomLocked(n) {
+				return false, 0, l.overflowLocked()
+			}
+			l.currentCapacity += n // Queue the event
+			w.queued = n
+		}
 
-		if l.canLeak() {
-			l.leak()
-			l.allowedEvents++
-			l.mux.Unlock()
-			return nil
+		ok, retryIn := l.tryLeakLocked(n)
+		if ok {
+			w.queued = 0
 		}
+		return ok, retryIn, nil
+	}, func() {
+		l.currentCapacity -= w.queued // Unqueue the event
+		w.queued = 0
+	})
+}
+
+// costErrLocked denies an event of size n and returns an error if it exceeds the max queue, nil otherwise.
+func (l *leakyBucket) costErrLocked(n int) error {
+	// This must be called with the mutex already locked
+	if n > l.maxCapacity {
+		l.deny(ReasonQueueFull)
+		return fmt.Errorf("%w: cost %d exceeds the max queue of %d", ErrQueueFull, n, l.maxCapacity)
+	}
+	return nil
+}
 
-		l.mux.Unlock()
-
-		select {
-		case <-ctx.Done():
-			l.mux.Lock()
-			l.deniedEvents++
-			// Unqueue the event
-			l.currentCapacity--
-			l.mux.Unlock()
-			return ctx.Err()
-		case <-time.After(l.lastLeak.Add(l.leakRate).Sub(time.Now())):
-			// Wait until the next event is allowed
+// makeRoomLocked evicts the longest queued callers until there is room for n more events, if the overflow policy is
+// DropOldest and evicting them is enough. It reports whether there is room.
+func (l *leakyBucket) makeRoomLocked(n int) bool {
+	// This must be called with the mutex already locked
+	if l.overflowPolicy != DropOldest {
+		return false
+	}
+
+	var victims []*waiter
+	freed := 0
+	reserved := reservedUnits(l.pendingReservations)
+	for _, w := range l.waiters.waiters {
+		if l.currentCapacity-freed+reserved+n <= l.maxCapacity {
+			break
+		}
+		if w.queued > 0 {
+			victims = append(victims, w)
+			freed += w.queued
 		}
 	}
+	if l.currentCapacity-freed+reserved+n > l.maxCapacity {
+		// Pending reservations hold too much of the queue
+		return false
+	}
+
+	for _, w := range victims {
+		l.currentCapacity -= w.queued // Unqueue the evicted event
+		w.queued = 0
+		l.deny(ReasonEvicted)
+		l.eject(w, ErrEvicted)
+	}
+	return true
+}
+
+// overflowLocked counts a request turned away by a full queue and returns its error.
+func (l *leakyBucket) overflowLocked() error {
+	// This must be called with the mutex already locked
+	if l.overflowPolicy == DropNewest {
+		l.deny(ReasonDropped)
+		return ErrDropped
+	}
+
+	l.deny(ReasonQueueFull)
+	return l.queueFullErr()
 }
 
 func (l *leakyBucket) Wait() {
@@ -78,110 +212,351 @@ func (l *leakyBucket) Wait() {
 }
 
 func (l *leakyBucket) WaitTimeout(timeout time.Duration) error {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	ctx, cancel := withTimeout(l.clock, timeout)
 	defer cancel()
 	return l.WaitContext(ctx)
 }
 
-// Allow does not increase capacity as it does not wait.
+// Allowed does not queue the event as it does not wait, it's only allowed if the queue is empty.
 func (l *leakyBucket) Allowed() bool {
+	return l.AllowN(1)
+}
+
+// AllowedReport is Allowed, reporting the room left in the queue as Remaining.
+func (l *leakyBucket) AllowedReport() (AdmitReport, bool) {
+	return l.allowReport(func() bool { return l.allowLocked(1) })
+}
+
+// WaitContextReport is WaitContext, reporting the room left in the queue as Remaining.
+func (l *leakyBucket) WaitContextReport(ctx context.Context) (AdmitReport, error) {
+	return waitReport(ctx, l.WaitContext)
+}
+
+func (l *leakyBucket) AllowN(n int) bool {
+	if n <= 0 {
+		return true
+	}
+
 	l.mux.Lock()
 	defer l.mux.Unlock()
+	return l.allowLocked(n)
+}
 
-	if l.currentCapacity == 0 && l.canLeak() {
+func (l *leakyBucket) allowLocked(n int) bool {
+	// This must be called with the mutex already locked
+	if l.denyHalted() {
+		return false
+	}
+	if l.leakableNowLocked(n) {
 		l.leak()
-		l.allowedEvents++
+		l.countAllowed()
 		return true
 	}
 
-	l.deniedEvents++
+	l.deny(l.limitedReason())
 	return false
 }
 
-func (l *leakyBucket) canLeak() bool {
-	return time.Since(l.lastLeak) >= l.leakRate
+// leakableNowLocked reports whether an event of size n can leak right away, with nothing queued ahead of it.
+func (l *leakyBucket) leakableNowLocked(n int) bool {
+	// This must be called with the mutex already locked
+	return l.currentCapacity == 0 && !l.delayedAhead() && l.canLeak(n) && l.closedUntil().IsZero()
 }
 
-func (l *leakyBucket) leak() {
-	l.currentCapacity--
-	if l.currentCapacity < 0 {
-		l.currentCapacity = 0
+// reserveNow lets an event of size n leak if AllowN would, holding it in a reservation that counts it as allowed once
+// consumed. Its reservations from TryReserve would only hold room in the queue, and consuming them waits for the leak.
+func (l *leakyBucket) reserveNow(n int) (Reservation, bool) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	if l.denyHalted() {
+		return nil, false
+	}
+	if l.leakableNowLocked(n) {
+		reservation := &leakedReservation{limiter: l, previous: l.lastLeak}
+		l.leak()
+		reservation.leakedAt = l.lastLeak
+		return reservation, true
+	}
+
+	l.deny(l.limitedReason())
+	return nil, false
+}
+
+// tryLeakLocked lets a queued event of size n leak and unqueues it, otherwise it returns how long until it can leak.
+func (l *leakyBucket) tryLeakLocked(n int) (bool, time.Duration) {
+	// This must be called with the mutex already locked
+	if end := l.closedUntil(); !end.IsZero() {
+		return false, l.retryIn(end, l.leakRate)
+	}
+	if !l.canLeak(n) {
+		return false, l.nextLeak(n)
+	}
+
+	l.leak()
+	l.currentCapacity -= n // Unqueue the event
+	l.countAllowed()
+	return true, 0
+}
+
+// queueFullLocked reports whether there is no room for n more events in the queue.
+func (l *leakyBucket) queueFullLocked(n int) bool {
+	// This must be called with the mutex already locked
+	return l.currentCapacity+reservedUnits(l.pendingReservations)+n > l.maxCapacity
+}
+
+func (l *leakyBucket) canLeak(n int) bool {
+	// This must be called with the mutex already locked
+	return l.sinceLastLeak() >= time.Duration(n)*l.leakRate
+}
+
+func (l *leakyBucket) nextLeak(n int) time.Duration {
+	// This must be called with the mutex already locked
+	return time.Duration(n)*l.leakRate - l.sinceLastLeak()
+}
+
+func (l *leakyBucket) sinceLastLeak() time.Duration {
+	// This must be called with the mutex already locked
+	now := l.clock.Now()
+	if now.Before(l.lastLeak) {
+		// The wall clock stepped backwards, count the last leak from now instead of waiting for it to catch up
+		l.clockStepped(l.lastLeak.Sub(now))
+		l.lastLeak = now
 	}
-	l.lastLeak = time.Now()
+	return now.Sub(l.lastLeak)
 }
 
+// leak lets one event through. Queued events unqueue themselves, so every increment of currentCapacity is paired
+// with exactly one decrement by the same caller.
+func (l *leakyBucket) leak() {
+	// This must be called with the mutex already locked
+	l.lastLeak = l.clock.Now()
+}
+
+// Clear cancels pending reservations and resets the leak timer.
+// Events already queued by blocked callers stay queued until they leak or their callers give up.
 func (l *leakyBucket) Clear() {
 	l.mux.Lock()
 	defer l.mux.Unlock()
 
-	// Mark all reservations as canceled
+	l.cancelReservationsLocked()
+
+	l.lastLeak = l.clock.Now().Add(-l.leakRate)
+	l.waiters.notify()
+}
+
+func (l *leakyBucket) Close() error {
+	return l.close(l.cancelReservationsLocked)
+}
+
+// cancelReservationsLocked cancels the pending reservations.
+func (l *leakyBucket) cancelReservationsLocked() {
+	// This must be called with the mutex already locked
 	for res := range l.pendingReservations {
 		res.canceled = true
 	}
 
-	// Clear the pending reservations map
 	l.pendingReservations = make(map[*leakyBucketReservation]struct{})
-
-	l.currentCapacity = 0
-	l.lastLeak = time.Now().Add(-l.leakRate)
 }
 
 func (l *leakyBucket) Stats() Stats {
 	l.mux.Lock()
 	defer l.mux.Unlock()
+	l.tuneQueue()
+	l.cleanupExpiredReservations()
+
+	stats := l.stats()
+	stats.PendingReservations = len(l.pendingReservations)
+	stats.QueueDepth = l.currentCapacity // Queued events, rather than blocked callers
+	stats.Queue = l.queueStats()
+	return stats
+}
+
+// Info reports the queue size as the limit and the room left in it as remaining, with Reset being when the queued
+// events will have leaked and Window how long a full queue takes to leak.
+func (l *leakyBucket) Info() LimitInfo {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.tuneQueue()
+	l.cleanupExpiredReservations()
+
+	l.sinceLastLeak() // Bring a last leak from the future back to now
+	reset := l.lastLeak.Add(time.Duration(l.currentCapacity) * l.leakRate)
+	if now := l.clock.Now(); reset.Before(now) {
+		reset = now
+	}
+	window := time.Duration(l.maxCapacity) * l.leakRate
+	return l.info(l.maxCapacity, l.maxCapacity-l.currentCapacity-reservedUnits(l.pendingReservations), reset, window)
+}
+
+// Available returns the room left in the queue net of pending reservations, without queuing anything.
+func (l *leakyBucket) Available() int {
+	return l.Info().Remaining
+}
+
+// EstimatedWait returns how long until the queued events leak and the next one can too. Pending reservations
+// don't hold it back since they only queue once consumed.
+func (l *leakyBucket) EstimatedWait() time.Duration {
+	return l.waitUntil(l.Stats().NextAllowedTime)
+}
+
+// Config reports the rate the bucket was created with, or set since, rather than the leak interval derived from it,
+// and the current queue size with WithAdaptiveQueue.
+func (l *leakyBucket) Config() Config {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.tuneQueue()
+	return Config{Algorithm: AlgorithmLeakyBucket, Count: l.rate.Count, Per: l.rate.Per, MaxQueue: l.maxCapacity}
+}
+
+// Limit returns the rate events leak at.
+func (l *leakyBucket) Limit() Rate {
+	return l.rate
+}
+
+// Burst is always 1, events leak one at a time.
+func (l *leakyBucket) Burst() int {
+	return 1
+}
+
+// MaxQueue returns the number of events the bucket queues, which changes over time with WithAdaptiveQueue.
+func (l *leakyBucket) MaxQueue() int {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.tuneQueue()
+	return l.maxCapacity
+}
 
-	nextAllowedTime := time.Now()
-	if l.currentCapacity > 0 {
-		nextAllowedTime = l.lastLeak.Add(l.leakRate)
+func (l *leakyBucket) PendingReservationAges(n int) []time.Duration {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.cleanupExpiredReservations()
+
+	reservedAt := make([]time.Time, 0, len(l.pendingReservations))
+	for res := range l.pendingReservations {
+		reservedAt = append(reservedAt, res.reservedAt)
 	}
+	return l.reservationAges(reservedAt, n)
+}
 
-	return Stats{
-		AllowedRequests: l.allowedEvents,
-		DeniedRequests:  l.deniedEvents,
-		NextAllowedTime: nextAllowedTime,
+// nextAllowedTime returns when the queued events will have leaked and the one after them can leak too.
+// Pending reservations don't hold it back since they only queue once consumed.
+func (l *leakyBucket) nextAllowedTime() time.Time {
+	// This must be called with the mutex already locked
+	l.sinceLastLeak() // Bring a last leak from the future back to now
+	next := l.lastLeak.Add(time.Duration(l.currentCapacity+1) * l.leakRate)
+	if now := l.clock.Now(); next.Before(now) {
+		return now
 	}
+	return next
+}
+
+// Permits waits for room in the queue instead of failing when it's full, and queues again if evicted. A leak can't be
+// given back, so a permit taken when the context is done is lost.
+func (l *leakyBucket) Permits(ctx context.Context) <-chan struct{} {
+	return deliverPermits(ctx, func(ctx context.Context) (permit, error) {
+		err := l.queueAndWait(ctx, 1, true)
+		for errors.Is(err, ErrEvicted) {
+			err = l.queueAndWait(ctx, 1, true)
+		}
+		if err != nil {
+			return permit{}, err
+		}
+		return permit{use: func() {}, giveBack: func() {}}, nil
+	})
 }
 
+// Reserve blocks until there is room in the queue for the reservation.
 func (l *leakyBucket) Reserve(reservationTTL *time.Duration) Reservation {
-	reservation, _ := l.ReserveContext(context.Background(), reservationTTL)
+	var reservation *leakyBucketReservation
+	err := l.awaitReservation(context.Background(), func() (bool, time.Duration, error) {
+		// The queue is full, check again once the next event leaks or a reservation is canceled
+		reservation = l.tryReserveLocked(context.Background(), 1, reservationTTL)
+		return reservation != nil, l.leakRate, nil
+	})
+	if err != nil {
+		return failedReservation{err: err}
+	}
 	return reservation
 }
 
 func (l *leakyBucket) ReserveTimeout(timeout time.Duration, reservationTTL *time.Duration) (Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	ctx, cancel := withTimeout(l.clock, timeout)
 	defer cancel()
 	return l.ReserveContext(ctx, reservationTTL)
 }
 
+// TryReserve reserves room in the queue like ReserveContext, which doesn't wait for it either.
+func (l *leakyBucket) TryReserve(reservationTTL *time.Duration) (Reservation, bool) {
+	reservation, err := l.ReserveContext(context.Background(), reservationTTL)
+	return reservation, err == nil
+}
+
+// ReserveContext doesn't wait for room in the queue, it fails right away if the queue is full.
 func (l *leakyBucket) ReserveContext(ctx context.Context, reservationTTL *time.Duration) (Reservation, error) {
+	return l.ReserveN(ctx, 1, reservationTTL)
+}
+
+// ReserveN reserves room for an event of size n in the queue, which leaks once the bucket has been idle for n leak
+// intervals after it's consumed. Like ReserveContext, it fails right away if the queue is full.
+func (l *leakyBucket) ReserveN(ctx context.Context, n int, reservationTTL *time.Duration) (Reservation, error) {
+	if err := reserveCostErr(n); err != nil {
+		return nil, err
+	}
+
 	l.mux.Lock()
-	l.cleanupExpiredReservations()
+	defer l.mux.Unlock()
 
-	if l.currentCapacity+len(l.pendingReservations) >= l.maxCapacity {
-		l.deniedEvents++
-		l.mux.Unlock()
-		return nil, errors.New("max allowed queue reached")
+	if l.stopped {
+		l.deny(ReasonClosed)
+		return nil, ErrLimiterClosed
+	}
+	if err := l.pausedReservationErr(); err != nil {
+		return nil, err
+	}
+	if ctx.Err() != nil {
+		l.deny(ReasonContext)
+		return nil, contextError(ctx, l.name, 0, l.retryAfter())
+	}
+
+	l.tuneQueue()
+	if err := l.costErrLocked(n); err != nil {
+		return nil, err
+	}
+	reservation := l.tryReserveLocked(ctx, n, reservationTTL)
+	if reservation == nil {
+		l.deny(ReasonQueueFull)
+		return nil, l.queueFullErr()
 	}
+	l.link(ctx, reservation)
+	return reservation, nil
+}
 
-	var expiresAt *time.Time
-	if reservationTTL != nil {
-		expiresAt = new(time.Time)
-		*expiresAt = time.Now().Add(*reservationTTL)
+// tryReserveLocked reserves room for n events in the queue, it returns nil if the queue is full.
+func (l *leakyBucket) tryReserveLocked(ctx context.Context, n int, reservationTTL *time.Duration) *leakyBucketReservation {
+	// This must be called with the mutex already locked
+	l.tuneQueue()
+	l.cleanupExpiredReservations()
+	if l.queueFullLocked(n) {
+		return nil
 	}
 
 	reservation := &leakyBucketReservation{
-		limiter:   l,
-		expiresAt: expiresAt,
+		limiter:    l,
+		n:          n,
+		reservedAt: l.clock.Now(),
+		expiresAt:  l.expiryFor(ctx, reservationTTL),
 	}
 	l.pendingReservations[reservation] = struct{}{}
-	l.mux.Unlock()
-
-	return reservation, nil
+	l.watchAbandoned(reservation.reservedAt, func() bool {
+		return pendingAt(l.clock.Now(), reservation.consumed, reservation.canceled, reservation.expiresAt)
+	})
+	return reservation
 }
 
 func (l *leakyBucket) cleanupExpiredReservations() {
 	// This must be called with the mutex already locked
-	now := time.Now()
+	now := l.clock.Now()
 	for res := range l.pendingReservations {
 		if res.expiresAt != nil && now.After(*res.expiresAt) {
 			delete(l.pendingReservations, res)
@@ -189,99 +564,222 @@ func (l *leakyBucket) cleanupExpiredReservations() {
 	}
 }
 
+// leakedReservation holds an event a leaky bucket let leak for allowAll, until it's consumed along with the
+// reservations of the other limiters or given back because one of them turned the request down.
+type leakedReservation struct {
+	limiter  *leakyBucket
+	previous time.Time // The last leak before the event's
+	leakedAt time.Time
+	consumed bool
+	canceled bool
+}
+
+func (r *leakedReservation) Consume() error {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	if err := reservationErr(r.limiter.clock.Now(), r.consumed, r.canceled, nil); err != nil {
+		return err
+	}
+	r.consumed = true
+	r.limiter.countAllowed()
+	return nil
+}
+
+// ConsumeContext is Consume, the event already leaked.
+func (r *leakedReservation) ConsumeContext(context.Context) error {
+	return r.Consume()
+}
+
+// Cancel gives the leak back, unless another event leaked since or Clear reset the leak timer.
+func (r *leakedReservation) Cancel() {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	if r.consumed || r.canceled {
+		return
+	}
+	r.canceled = true
+	if r.limiter.lastLeak.Equal(r.leakedAt) {
+		r.limiter.lastLeak = r.previous
+		r.limiter.waiters.notify()
+	}
+}
+
+// Detach fails, the reservation is only ever held by allowAll along with the ones of other limiters.
+func (r *leakedReservation) Detach() (ReservationHandle, error) {
+	return ReservationHandle{}, ErrCombinedDetach
+}
+
+func (r *leakedReservation) ReadyAt() time.Time {
+	return r.limiter.clock.Now()
+}
+
+func (r *leakedReservation) Delay() time.Duration {
+	return 0
+}
+
+func (r *leakedReservation) ExpiresAt() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (r *leakedReservation) State() ReservationState {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	return reservationState(r.limiter.clock.Now(), r.consumed, r.canceled, nil)
+}
+
 // leakyBucketReservation implements the Reservation interface
 type leakyBucketReservation struct {
-	limiter   *leakyBucket
-	expiresAt *time.Time
-	consumed  bool
-	canceled  bool
+	limiter    *leakyBucket
+	n          int // Size of the event
+	reservedAt time.Time
+	expiresAt  *time.Time
+	consumed   bool
+	canceled   bool
+	// When a reservation taken with ReserveDelayed is ready, zero for the others
+	readyAt time.Time
 }
 
 func (r *leakyBucketReservation) Consume() error {
-	r.limiter.mux.Lock()
+	return r.ConsumeContext(context.Background())
+}
+
+// ConsumeContext queues the event and blocks until it leaks, the reservation expires or ctx is done. A consume that
+// doesn't complete takes the event back out of the queue, leaving the reservation expired or canceled. A reservation
+// taken with ReserveDelayed only queues once it's ready.
+func (r *leakyBucketReservation) ConsumeContext(ctx context.Context) error {
+	if err := r.limiter.sleepUntil(ctx, r.readyAt, r.Cancel); err != nil {
+		return err
+	}
+
+	queued := false
+	start := r.limiter.clock.Now()
+	return r.limiter.await(ctx, func() (bool, time.Duration, error) {
+		if !queued {
+			if err := r.queueLocked(); err != nil {
+				return false, 0, err
+			}
+			queued = true
+		}
+
+		// Wait for the event to be leaked, without waiting past the reservation expiry
+		ok, retryIn := r.limiter.tryLeakLocked(r.n)
+		if ok || r.expiresAt == nil {
+			return ok, retryIn, nil
+		}
+
+		timeToDeadline := r.expiresAt.Sub(r.limiter.clock.Now())
+		if timeToDeadline <= 0 {
+			r.limiter.deny(ReasonExpired)
+			r.limiter.currentCapacity -= r.n // Unqueue the event
+			r.consumed = false
+			return false, 0, &LimitError{
+				Limiter:    r.limiter.name,
+				Reason:     ReasonExpired,
+				Waited:     r.limiter.clock.Now().Sub(start),
+				RetryAfter: r.limiter.retryAfter(),
+				Err:        fmt.Errorf("%w while waiting to leak", ErrReservationExpired),
+			}
+		}
+		return false, min(retryIn, timeToDeadline), nil
+	}, func() {
+		if queued {
+			r.limiter.currentCapacity -= r.n // Unqueue the event
+			r.consumed, r.canceled = false, true
+		}
+	})
+}
 
+// queueLocked turns the reservation into a queued event.
+func (r *leakyBucketReservation) queueLocked() error {
+	// This must be called with the mutex already locked
 	if r.consumed {
-		r.limiter.mux.Unlock()
-		return fmt.Errorf("reservation already consumed")
+		return ErrReservationConsumed
 	}
 
 	if r.canceled {
-		r.limiter.mux.Unlock()
-		return fmt.Errorf("reservation was canceled")
+		return ErrReservationCanceled
 	}
 
-	if r.expiresAt != nil && time.Now().After(*r.expiresAt) {
+	if r.expiresAt != nil && r.limiter.clock.Now().After(*r.expiresAt) {
 		delete(r.limiter.pendingReservations, r)
-		r.limiter.mux.Unlock()
-		return fmt.Errorf("reservation expired")
+		return ErrReservationExpired
 	}
 
 	r.consumed = true
 	delete(r.limiter.pendingReservations, r)
 
 	// In leaky bucket, consuming means adding to the current capacity queue
-	r.limiter.currentCapacity++
+	r.limiter.currentCapacity += r.n
+	return nil
+}
 
-	// Try to leak immediately
-	if r.limiter.canLeak() {
-		r.limiter.leak()
-		r.limiter.allowedEvents++
-		r.limiter.mux.Unlock()
-		return nil
+func (r *leakyBucketReservation) units() int {
+	return r.n
+}
+
+func (r *leakyBucketReservation) Cancel() {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	if !r.consumed {
+		r.canceled = true
+		delete(r.limiter.pendingReservations, r)
+		// The reserved room in the queue is free again
+		r.limiter.waiters.notify()
 	}
+}
 
-	// We need to wait for leaking
-	var deadline time.Time
-	hasDeadline := false
-	if r.expiresAt != nil {
-		deadline = *r.expiresAt
-		hasDeadline = true
+func (r *leakyBucketReservation) Detach() (ReservationHandle, error) {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	state := func() error {
+		return reservationErr(r.limiter.clock.Now(), r.consumed, r.canceled, r.expiresAt)
+	}
+	if err := state(); err != nil {
+		return ReservationHandle{}, err
 	}
+	return r.limiter.detach(r, r.expiresAt, state), nil
+}
 
-	r.limiter.mux.Unlock()
+// ReadyAt returns when the event would leak if the reservation were consumed now, after the events already queued, or
+// now if Consume would fail. It doesn't account for a pause.
+func (r *leakyBucketReservation) ReadyAt() time.Time {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
 
-	// Wait for the event to be leaked
-	for {
-		// Calculate time to wait until next leak opportunity
-		waitTime := r.limiter.lastLeak.Add(r.limiter.leakRate).Sub(time.Now())
+	l := r.limiter
+	now := l.clock.Now()
+	if reservationErr(now, r.consumed, r.canceled, r.expiresAt) != nil {
+		return now
+	}
 
-		// If we have a deadline, ensure we don't wait past it
-		if hasDeadline {
-			timeToDeadline := deadline.Sub(time.Now())
-			if timeToDeadline <= 0 {
-				r.limiter.mux.Lock()
-				// Don't decrement capacity as the event is still in queue
-				r.limiter.mux.Unlock()
-				return fmt.Errorf("reservation expired while waiting to leak")
-			}
+	l.sinceLastLeak() // Bring a last leak from the future back to now
+	ready := l.lastLeak.Add(time.Duration(l.currentCapacity+r.n) * l.leakRate)
+	if open := l.afterClosed(ready); !open.IsZero() {
+		ready = open
+	}
+	return latest(latest(ready, r.readyAt), now)
+}
 
-			// Use the shorter of the two wait times
-			if timeToDeadline < waitTime {
-				waitTime = timeToDeadline
-			}
-		}
+func (r *leakyBucketReservation) Delay() time.Duration {
+	return max(r.ReadyAt().Sub(r.limiter.clock.Now()), 0)
+}
 
-		// Wait for the calculated time
-		time.Sleep(waitTime)
+func (r *leakyBucketReservation) ExpiresAt() (time.Time, bool) {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
 
-		// Check if we can leak now
-		r.limiter.mux.Lock()
-		if r.limiter.canLeak() {
-			r.limiter.leak()
-			r.limiter.allowedEvents++
-			r.limiter.mux.Unlock()
-			return nil
-		}
-		r.limiter.mux.Unlock()
-	}
+	return expiry(r.expiresAt)
 }
 
-func (r *leakyBucketReservation) Cancel() {
+// State reports a reservation queued by Consume as consumed, even while its event waits to leak.
+func (r *leakyBucketReservation) State() ReservationState {
 	r.limiter.mux.Lock()
 	defer r.limiter.mux.Unlock()
 
-	if !r.consumed {
-		r.canceled = true
-		delete(r.limiter.pendingReservations, r)
-	}
+	return reservationState(r.limiter.clock.Now(), r.consumed, r.canceled, r.expiresAt)
 }
@@ -2,35 +2,43 @@ package limit_test
 
 import (
 	"context"
-	"log"
+	"math/rand"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limittest"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestLeakyBucket_Wait(t *testing.T) {
 	t.Parallel()
 
-	start := time.Now()
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	start := clock.Now()
 
 	// 5 requests per second
-	limiter := limit.NewLeakyBucket(5, 1*time.Second, 100)
+	limiter := limit.NewLeakyBucket(5, 1*time.Second, 100, limit.WithClock(clock))
 
 	limiter.Wait()
-	log.Default().Print(time.Since(start))
 
-	// First request should have been pseudo instant
-	assert.True(t, time.Since(start) < time.Millisecond)
+	// First request should have been instant
+	assert.Equal(t, start, clock.Now())
 
+	// The next 3 requests should be spaced by 200ms each
 	for i := 0; i < 3; i++ {
-		limiter.Wait()
-		log.Default().Print(time.Since(start))
+		waited := make(chan struct{})
+		go func() {
+			limiter.Wait()
+			close(waited)
+		}()
+		clock.BlockUntil(1)
+		clock.Advance(200 * time.Millisecond)
+		<-waited
 	}
-
-	// The next 3 requests should have been spaced by 200ms each
-	assert.True(t, time.Since(start) >= 600*time.Millisecond)
+	assert.Equal(t, start.Add(600*time.Millisecond), clock.Now())
+	assert.Equal(t, 4, limiter.Stats().AllowedRequests)
 }
 
 func TestLeakyBucket_Allow(t *testing.T) {
@@ -83,3 +91,384 @@ func TestLeakyBucket_Reserve_BlocksAfterCapacityReached(t *testing.T) {
 		assert.NoError(t, res.Consume())
 	}
 }
+
+func TestLeakyBucket_CanceledWaitAfterClear_DoesNotDrift(t *testing.T) {
+	t.Parallel()
+
+	// 1 request per second, max queue of 2
+	limiter := limit.NewLeakyBucket(1, 1*time.Second, 2)
+	assert.True(t, limiter.Allowed())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- limiter.WaitContext(ctx)
+	}()
+
+	// The waiter stays queued across Clear until it gives up
+	time.Sleep(50 * time.Millisecond)
+	limiter.Clear()
+	assert.Equal(t, 1, limit.QueueDepth(limiter))
+
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+	assert.Equal(t, 0, limit.QueueDepth(limiter))
+}
+
+func TestLeakyBucket_ReservationExpiredWhileWaiting_Unqueues(t *testing.T) {
+	t.Parallel()
+
+	// 1 request per second, max queue of 2
+	limiter := limit.NewLeakyBucket(1, 1*time.Second, 2)
+	assert.True(t, limiter.Allowed())
+
+	ttl := 100 * time.Millisecond
+	res := limiter.Reserve(&ttl)
+
+	// The next leak is a second away, so the reservation expires while queued
+	err := res.Consume()
+	assert.ErrorIs(t, err, limit.ErrReservationExpired)
+	var limitErr *limit.LimitError
+	if assert.ErrorAs(t, err, &limitErr) {
+		assert.Equal(t, limit.ReasonExpired, limitErr.Reason)
+	}
+	assert.Equal(t, 0, limit.QueueDepth(limiter))
+	assert.Equal(t, 1, limiter.Stats().DeniedByReason[limit.ReasonExpired])
+	assert.Equal(t, limit.ReservationExpired, res.State())
+}
+
+func TestLeakyBucket_ReservationTTL(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	limiter := limit.NewLeakyBucket(1, 1*time.Second, 1, limit.WithClock(clock))
+	ttl := 1 * time.Second
+	res := limiter.Reserve(&ttl)
+	expiresAt, ok := res.ExpiresAt()
+	assert.True(t, ok)
+	assert.Equal(t, clock.Now().Add(ttl), expiresAt)
+
+	// The expired reservation gives its room in the queue back
+	clock.Advance(2 * time.Second)
+	assert.Equal(t, limit.ReservationExpired, res.State())
+	assert.ErrorIs(t, res.Consume(), limit.ErrReservationExpired)
+	_, err := limiter.ReserveContext(context.Background(), nil)
+	assert.NoError(t, err)
+}
+
+func TestLeakyBucket_ReservationTTLFromContext(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Now()) // Context deadlines follow the wall clock
+	limiter := limit.NewLeakyBucket(1, 1*time.Second, 3, limit.WithClock(clock), limit.WithReservationTTLFromContext())
+	ctx, cancel := context.WithDeadline(context.Background(), clock.Now().Add(5*time.Second))
+	defer cancel()
+
+	// The deadline caps a longer TTL and applies without one
+	ttl := 1 * time.Minute
+	res, err := limiter.ReserveContext(ctx, &ttl)
+	assert.NoError(t, err)
+	expiresAt, _ := res.ExpiresAt()
+	assert.Equal(t, clock.Now().Add(5*time.Second), expiresAt)
+
+	res, err = limiter.ReserveContext(ctx, nil)
+	assert.NoError(t, err)
+	expiresAt, ok := res.ExpiresAt()
+	assert.True(t, ok)
+	assert.Equal(t, clock.Now().Add(5*time.Second), expiresAt)
+
+	// A shorter TTL wins
+	ttl = 1 * time.Second
+	res = limiter.Reserve(&ttl)
+	expiresAt, _ = res.ExpiresAt()
+	assert.Equal(t, clock.Now().Add(ttl), expiresAt)
+}
+
+func TestLeakyBucket_ConsumeContext_Canceled(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	limiter := limit.NewLeakyBucket(1, 1*time.Second, 2, limit.WithClock(clock))
+	assert.True(t, limiter.Allowed())
+	res := limiter.Reserve(nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	consumed := make(chan error)
+	go func() {
+		consumed <- res.ConsumeContext(ctx)
+	}()
+	assert.Eventually(t, func() bool { return limit.QueueDepth(limiter) == 1 }, time.Second, time.Millisecond)
+
+	// Giving up takes the event back out of the queue
+	cancel()
+	err := <-consumed
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 0, limit.QueueDepth(limiter))
+	assert.Equal(t, 1, limiter.Stats().DeniedByReason[limit.ReasonContext])
+	assert.Equal(t, limit.ReservationCanceled, res.State())
+}
+
+func TestLeakyBucket_QueueAccounting_NeverDrifts(t *testing.T) {
+	t.Parallel()
+
+	const maxQueue = 5
+
+	// 50 requests per second
+	limiter := limit.NewLeakyBucket(50, 1*time.Second, maxQueue)
+
+	stop := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			depth := limit.QueueDepth(limiter)
+			assert.GreaterOrEqual(t, depth, 0)
+			assert.LessOrEqual(t, depth, maxQueue)
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed))
+			for j := 0; j < 10; j++ {
+				timeout := time.Duration(rng.Intn(60)) * time.Millisecond
+				switch rng.Intn(5) {
+				case 0:
+					_ = limiter.WaitTimeout(timeout)
+				case 1:
+					limiter.Allowed()
+				case 2:
+					ttl := timeout
+					if res, err := limiter.ReserveTimeout(timeout, &ttl); err == nil {
+						_ = res.Consume()
+					}
+				case 3:
+					if res, err := limiter.ReserveTimeout(timeout, nil); err == nil {
+						res.Cancel()
+					}
+				case 4:
+					limiter.Clear()
+				}
+			}
+		}(int64(i))
+	}
+
+	wg.Wait()
+	close(stop)
+	<-sampled
+
+	// Nobody is waiting anymore, so nothing can be queued
+	assert.Equal(t, 0, limit.QueueDepth(limiter))
+}
+
+func TestLeakyBucket_Stats_NextAllowedTime(t *testing.T) {
+	t.Parallel()
+
+	// 5 requests per second, a leak every 200ms
+	limiter := limit.NewLeakyBucket(5, 1*time.Second, 10)
+	assert.WithinDuration(t, time.Now(), limiter.Stats().NextAllowedTime, 10*time.Millisecond)
+
+	// The next leak is 200ms away even with nothing queued
+	assert.True(t, limiter.Allowed())
+	next := limiter.Stats().NextAllowedTime
+	assert.WithinDuration(t, time.Now().Add(200*time.Millisecond), next, 20*time.Millisecond)
+	assert.False(t, limiter.Allowed())
+
+	// A queued event pushes it back by another leak
+	go limiter.Wait()
+	time.Sleep(50 * time.Millisecond)
+	assert.WithinDuration(t, next.Add(200*time.Millisecond), limiter.Stats().NextAllowedTime, 20*time.Millisecond)
+
+	time.Sleep(time.Until(limiter.Stats().NextAllowedTime) + 10*time.Millisecond)
+	assert.True(t, limiter.Allowed())
+}
+
+func TestLeakyBucket_ClockSteps(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Now())
+
+	// 5 requests per second, a leak every 200ms
+	limiter := limit.NewLeakyBucket(5, 1*time.Second, 10, limit.WithClock(clock))
+	assert.True(t, limiter.Allowed())
+	assert.False(t, limiter.Allowed())
+
+	// Stepping the clock backwards doesn't stop leaks until the clock catches up
+	clock.Set(clock.Now().Add(-5 * time.Second))
+	assert.False(t, limiter.Allowed())
+	clock.Advance(200 * time.Millisecond)
+	assert.True(t, limiter.Allowed())
+
+	// Stepping it forwards lets the next event leak, but only one
+	clock.Set(clock.Now().Add(1 * time.Hour))
+	assert.True(t, limiter.Allowed())
+	assert.False(t, limiter.Allowed())
+}
+
+func TestLeakyBucket_OverflowPolicy(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name       string
+		policy     limit.OverflowPolicy
+		wantReason limit.Reason
+	}{
+		{name: "RejectNew", policy: limit.RejectNew, wantReason: limit.ReasonQueueFull},
+		{name: "DropOldest", policy: limit.DropOldest, wantReason: limit.ReasonEvicted},
+		{name: "DropNewest", policy: limit.DropNewest, wantReason: limit.ReasonDropped},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := limittest.NewFakeClock(time.Now())
+			limiter := limit.NewLeakyBucket(1, 1*time.Hour, 2, limit.WithOverflowPolicy(tt.policy), limit.WithClock(clock))
+			assert.True(t, limiter.Allowed())
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			// Fill the queue with an older and a newer caller
+			older := make(chan error, 1)
+			go func() { older <- limiter.WaitContext(ctx) }()
+			assert.Eventually(t, func() bool { return limit.QueueDepth(limiter) == 1 }, 1*time.Second, 1*time.Millisecond)
+			newer := make(chan error, 1)
+			go func() { newer <- limiter.WaitContext(ctx) }()
+			assert.Eventually(t, func() bool { return limit.QueueDepth(limiter) == 2 }, 1*time.Second, 1*time.Millisecond)
+
+			newest := make(chan error, 1)
+			go func() { newest <- limiter.WaitContext(ctx) }()
+
+			switch tt.policy {
+			case limit.RejectNew:
+				err := <-newest
+				assert.ErrorIs(t, err, limit.ErrQueueFull)
+				assert.NotErrorIs(t, err, limit.ErrDropped)
+				// The queued callers leak first
+				var limitErr *limit.LimitError
+				if assert.ErrorAs(t, err, &limitErr) {
+					assert.Equal(t, limit.ReasonQueueFull, limitErr.Reason)
+					assert.Equal(t, 3*time.Hour, limitErr.RetryAfter)
+				}
+			case limit.DropOldest:
+				assert.ErrorIs(t, <-older, limit.ErrEvicted)
+			case limit.DropNewest:
+				assert.ErrorIs(t, <-newest, limit.ErrDropped)
+			}
+
+			assert.Equal(t, 2, limit.QueueDepth(limiter))
+			assert.Equal(t, map[limit.Reason]int{tt.wantReason: 1}, limiter.Stats().DeniedByReason)
+
+			// The callers left in the queue still leak in order of the leak interval
+			clock.BlockUntil(2)
+			clock.Advance(1 * time.Hour)
+			clock.BlockUntil(1)
+			clock.Advance(1 * time.Hour)
+			assert.Eventually(t, func() bool { return limiter.Stats().AllowedRequests == 3 }, 1*time.Second, 1*time.Millisecond)
+			assert.Equal(t, 0, limit.QueueDepth(limiter))
+		})
+	}
+}
+
+func TestLeakyBucket_AdaptiveQueue(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	limiter := limit.NewLeakyBucket(10, 1*time.Second, 100, limit.WithClock(clock), limit.WithAdaptiveQueue(1*time.Second, 2, 50))
+	queue := func() limit.QueueStats {
+		return *limiter.Stats().Queue
+	}
+
+	// A second of events at the leak rate
+	assert.Equal(t, limit.QueueStats{MaxQueue: 10}, queue())
+	for i := 0; i < 10; i++ {
+		_, err := limiter.ReserveContext(context.Background(), nil)
+		assert.NoError(t, err)
+	}
+	_, err := limiter.ReserveContext(context.Background(), nil)
+	assert.Error(t, err)
+
+	// Growing is immediate
+	limit.SetLeakRate(limiter, limit.Rate{Count: 20, Per: 1 * time.Second})
+	assert.Equal(t, limit.QueueStats{MaxQueue: 20, Adjustments: 1}, queue())
+
+	// Shrinking goes half the way every target wait, keeping what is queued
+	limit.SetLeakRate(limiter, limit.Rate{Count: 4, Per: 1 * time.Second})
+	for _, want := range []int{12, 8, 6, 5, 4, 4} {
+		assert.Equal(t, want, queue().MaxQueue)
+		clock.Advance(1 * time.Second)
+	}
+	assert.Equal(t, 6, queue().Adjustments)
+	assert.Len(t, limiter.PendingReservationAges(100), 10)
+	_, err = limiter.ReserveContext(context.Background(), nil)
+	assert.Error(t, err)
+
+	// The other limiters have no queue stats
+	assert.Nil(t, limit.NewLeakyBucket(10, 1*time.Second, 5).Stats().Queue)
+}
+
+func TestLeakyBucket_SetLeakRate(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	limiter := limit.NewLeakyBucket(1, 1*time.Minute, 5, limit.WithClock(clock))
+	assert.True(t, limiter.Allowed())
+
+	// The waiter sleeping until the next leak a minute away is woken up by a faster rate
+	waited := make(chan error)
+	go func() {
+		waited <- limiter.WaitContext(context.Background())
+	}()
+	clock.BlockUntil(1)
+	start := clock.Now()
+	limiter.(limit.QueueTuner).SetLeakRate(10, 1*time.Second)
+	for done := false; !done; {
+		clock.Advance(100 * time.Millisecond)
+		select {
+		case err := <-waited:
+			assert.NoError(t, err)
+			done = true
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	assert.Less(t, clock.Now().Sub(start), 1*time.Second)
+	assert.Equal(t, limit.Config{Algorithm: limit.AlgorithmLeakyBucket, Count: 10, Per: 1 * time.Second, MaxQueue: 5}, limiter.Config())
+
+	assert.Panics(t, func() { limiter.(limit.QueueTuner).SetLeakRate(1_000_000_000, time.Nanosecond) })
+}
+
+func TestLeakyBucket_SetMaxQueue(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	limiter := limit.NewLeakyBucket(1, 1*time.Second, 3, limit.WithClock(clock))
+	for range 3 {
+		_, err := limiter.ReserveContext(context.Background(), nil)
+		assert.NoError(t, err)
+	}
+
+	// A shorter queue keeps what it holds and turns new events away
+	limiter.(limit.QueueTuner).SetMaxQueue(1)
+	assert.Equal(t, 1, limiter.(limit.QueueConfigurer).MaxQueue())
+	assert.Equal(t, 3, limiter.Stats().PendingReservations)
+	_, err := limiter.ReserveContext(context.Background(), nil)
+	assert.ErrorIs(t, err, limit.ErrQueueFull)
+
+	// A longer one lets the callers waiting for room in
+	reserved := make(chan limit.Reservation)
+	go func() {
+		reserved <- limiter.Reserve(nil)
+	}()
+	assert.Eventually(t, func() bool { return len(limiter.Waiters()) == 1 }, time.Second, time.Millisecond)
+	limiter.(limit.QueueTuner).SetMaxQueue(4)
+	assert.Equal(t, limit.ReservationPending, (<-reserved).State())
+	assert.Equal(t, 4, limiter.Stats().PendingReservations)
+}
@@ -0,0 +1,198 @@
+package limit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrWorkerClosed is returned when enqueueing to a closed LeakyWorker.
+var ErrWorkerClosed = errors.New("worker closed")
+
+// LeakyWorker is a work queue serviced at a constant rate: a single goroutine hands each queued item to the handler
+// once per leak interval, in order.
+type LeakyWorker[T any] struct {
+	// Mutex
+	mux sync.Mutex
+
+	// Config
+	bucket   Limiter // Paces the handler
+	handler  func(item T)
+	maxQueue int
+	discard  bool
+	overflow OverflowPolicy
+
+	// State
+	items   []T
+	closed  bool
+	panics  int
+	dropped int
+	wake    chan struct{} // Signaled when items are queued or the worker is closed
+	cancel  context.CancelFunc
+	done    chan struct{} // Closed once the drain goroutine exits
+}
+
+// NewLeakyWorker starts a worker calling handler with count items per duration, queueing up to maxQueue items.
+// It accepts the leaky bucket options, plus WithDiscardOnClose. With the DropOldest overflow policy a full queue drops
+// its oldest item to make room, and with DropNewest the new item is dropped with ErrDropped.
+func NewLeakyWorker[T any](count int, duration time.Duration, maxQueue int, handler func(item T), opts ...Option) *LeakyWorker[T] {
+	o := newOptions(opts)
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &LeakyWorker[T]{
+		bucket:   NewLeakyBucket(count, duration, 1, acceptOptions(opts, kindOther)...),
+		handler:  handler,
+		maxQueue: maxQueue,
+		discard:  o.discardOnClose,
+		overflow: o.overflowPolicy,
+		wake:     make(chan struct{}, 1),
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	go w.drain(ctx)
+	return w
+}
+
+// Enqueue queues item for the handler. It fails if the worker is closed or ctx is done, and applies the overflow
+// policy if the queue is full.
+func (w *LeakyWorker[T]) Enqueue(ctx context.Context, item T) error {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if w.closed {
+		return ErrWorkerClosed
+	}
+	if len(w.items) >= w.maxQueue {
+		switch {
+		case w.overflow == DropOldest && len(w.items) > 0:
+			w.items = w.items[1:]
+			w.dropped++
+		case w.overflow == DropNewest:
+			w.dropped++
+			return ErrDropped
+		default:
+			return ErrQueueFull
+		}
+	}
+
+	w.items = append(w.items, item)
+	w.signal()
+	return nil
+}
+
+// Len returns the number of queued items, not counting the one being handled.
+func (w *LeakyWorker[T]) Len() int {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	return len(w.items)
+}
+
+// Dropped returns the number of items dropped by the overflow policy.
+func (w *LeakyWorker[T]) Dropped() int {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	return w.dropped
+}
+
+// Panics returns the number of times the handler panicked. Panics are recovered and the worker moves on to the next
+// item.
+func (w *LeakyWorker[T]) Panics() int {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	return w.panics
+}
+
+// Close stops accepting items and waits for the drain goroutine to exit, after handling the queued items at the usual
+// rate or, with WithDiscardOnClose, dropping them. It's safe to call more than once.
+func (w *LeakyWorker[T]) Close() {
+	w.close()
+	<-w.done
+}
+
+func (w *LeakyWorker[T]) close() {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	if w.closed {
+		return
+	}
+	w.closed = true
+	if w.discard {
+		w.items = nil
+		w.cancel()
+	}
+	w.signal()
+}
+
+func (w *LeakyWorker[T]) signal() {
+	// This must be called with the mutex already locked
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (w *LeakyWorker[T]) drain(ctx context.Context) {
+	defer close(w.done)
+	defer w.cancel()
+
+	for w.waitForItem() {
+		if w.bucket.WaitContext(ctx) != nil {
+			// Closed discarding the queue
+			return
+		}
+
+		item, ok := w.pop()
+		if !ok {
+			return
+		}
+		w.handle(item)
+	}
+}
+
+// waitForItem blocks until there is an item to handle, it returns false once the worker is closed and nothing is left.
+func (w *LeakyWorker[T]) waitForItem() bool {
+	for {
+		pending, closed := w.state()
+		if pending {
+			return true
+		}
+		if closed {
+			return false
+		}
+		<-w.wake
+	}
+}
+
+func (w *LeakyWorker[T]) state() (pending bool, closed bool) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	return len(w.items) > 0, w.closed
+}
+
+func (w *LeakyWorker[T]) pop() (T, bool) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	var item T
+	if len(w.items) == 0 {
+		return item, false
+	}
+	item = w.items[0]
+	w.items = w.items[1:]
+	return item, true
+}
+
+func (w *LeakyWorker[T]) handle(item T) {
+	defer func() {
+		if recover() != nil {
+			w.mux.Lock()
+			defer w.mux.Unlock()
+			w.panics++
+		}
+	}()
+	w.handler(item)
+}
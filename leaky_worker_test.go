@@ -0,0 +1,153 @@
+package limit_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limittest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeakyWorker_HandlesAtLeakRate(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Now())
+	handled := make(chan string)
+	worker := limit.NewLeakyWorker(1, 1*time.Second, 2, func(item string) {
+		handled <- item
+	}, limit.WithClock(clock))
+	defer worker.Close()
+
+	ctx := context.Background()
+	assert.NoError(t, worker.Enqueue(ctx, "a"))
+
+	// The first item is handled right away, and blocks the handler until received
+	assert.Equal(t, "a", <-handled)
+
+	assert.NoError(t, worker.Enqueue(ctx, "b"))
+	assert.NoError(t, worker.Enqueue(ctx, "c"))
+	assert.Error(t, worker.Enqueue(ctx, "d"))
+	assert.Equal(t, 2, worker.Len())
+
+	// The next ones wait for the leak interval
+	clock.BlockUntil(1)
+	clock.Advance(1 * time.Second)
+	assert.Equal(t, "b", <-handled)
+	clock.BlockUntil(1)
+	clock.Advance(1 * time.Second)
+	assert.Equal(t, "c", <-handled)
+	assert.Equal(t, 0, worker.Len())
+}
+
+func TestLeakyWorker_RecoversPanics(t *testing.T) {
+	t.Parallel()
+
+	var handled atomic.Int64
+	worker := limit.NewLeakyWorker(1000, 1*time.Second, 10, func(item int) {
+		if item == 0 {
+			panic("boom")
+		}
+		handled.Add(1)
+	})
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, worker.Enqueue(context.Background(), i))
+	}
+	worker.Close()
+
+	assert.Equal(t, int64(2), handled.Load())
+	assert.Equal(t, 1, worker.Panics())
+}
+
+func TestLeakyWorker_CloseDrains(t *testing.T) {
+	t.Parallel()
+
+	var handled atomic.Int64
+	worker := limit.NewLeakyWorker(1000, 1*time.Second, 10, func(int) {
+		handled.Add(1)
+	})
+
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, worker.Enqueue(context.Background(), i))
+	}
+	worker.Close()
+	worker.Close()
+
+	assert.Equal(t, int64(10), handled.Load())
+	assert.ErrorIs(t, worker.Enqueue(context.Background(), 10), limit.ErrWorkerClosed)
+}
+
+func TestLeakyWorker_CloseDiscards(t *testing.T) {
+	t.Parallel()
+
+	var handled atomic.Int64
+	worker := limit.NewLeakyWorker(1, 1*time.Hour, 10, func(int) {
+		handled.Add(1)
+	}, limit.WithDiscardOnClose())
+
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, worker.Enqueue(context.Background(), i))
+	}
+	assert.Eventually(t, func() bool { return handled.Load() == 1 }, 1*time.Second, 1*time.Millisecond)
+
+	// Close doesn't wait an hour for the next leak
+	worker.Close()
+	assert.Equal(t, int64(1), handled.Load())
+	assert.Equal(t, 0, worker.Len())
+}
+
+func TestLeakyWorker_OverflowPolicy(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name        string
+		policy      limit.OverflowPolicy
+		wantErr     bool
+		wantHandled string
+	}{
+		{name: "RejectNew", policy: limit.RejectNew, wantErr: true, wantHandled: "b"},
+		{name: "DropOldest", policy: limit.DropOldest, wantHandled: "c"},
+		{name: "DropNewest", policy: limit.DropNewest, wantErr: true, wantHandled: "b"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := limittest.NewFakeClock(time.Now())
+			handled := make(chan string, 10)
+			worker := limit.NewLeakyWorker(1, 1*time.Hour, 2, func(item string) {
+				handled <- item
+			}, limit.WithClock(clock), limit.WithOverflowPolicy(tt.policy), limit.WithDiscardOnClose())
+			defer worker.Close()
+
+			ctx := context.Background()
+			assert.NoError(t, worker.Enqueue(ctx, "a"))
+			assert.Equal(t, "a", <-handled)
+			assert.NoError(t, worker.Enqueue(ctx, "b"))
+			assert.NoError(t, worker.Enqueue(ctx, "c"))
+
+			err := worker.Enqueue(ctx, "d")
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			if tt.policy == limit.DropNewest {
+				assert.ErrorIs(t, err, limit.ErrDropped)
+			}
+			assert.Equal(t, 2, worker.Len())
+
+			clock.BlockUntil(1)
+			clock.Advance(1 * time.Hour)
+			assert.Equal(t, tt.wantHandled, <-handled)
+
+			if tt.policy == limit.RejectNew {
+				assert.Equal(t, 0, worker.Dropped())
+			} else {
+				assert.Equal(t, 1, worker.Dropped())
+			}
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package limit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrLeaseEnded is returned by a Lease once it was released or expired.
+var ErrLeaseEnded = errors.New("lease ended")
+
+// Lease is a Limiter enforcing a share of its parent's rate. The parent's rate is reduced by the leased rate until the
+// lease is released or expires, after which the lease denies every request.
+type Lease interface {
+	Limiter
+	// Release returns the leased rate to the parent. It's safe to call more than once.
+	Release()
+	// ExpiresAt returns when the lease returns to the parent on its own.
+	ExpiresAt() time.Time
+}
+
+// Leaser is implemented by the limiters that can lease part of their rate, the token bucket and the rolling window.
+type Leaser interface {
+	// AcquireLease carves rate out of the limiter for ttl. It fails if the active leases would take the whole rate.
+	AcquireLease(rate Rate, ttl time.Duration) (Lease, error)
+}
+
+// LeaseStats describes an active lease in the parent's Stats.
+type LeaseStats struct {
+	Rate      Rate      `json:"rate"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// leaseSet tracks the active leases of a limiter. It's guarded by the limiter's mutex.
+type leaseSet struct {
+	active map[*lease]struct{}
+}
+
+// leasedIn returns the number of events the active leases take from every d.
+func (s *leaseSet) leasedIn(d time.Duration) float64 {
+	var leased float64
+	for l := range s.active {
+		leased += l.rate.in(d)
+	}
+	return leased
+}
+
+// expire drops the leases that expired by now and reports whether any did.
+func (s *leaseSet) expire(now time.Time) bool {
+	expired := false
+	for l := range s.active {
+		if !now.Before(l.expiresAt) {
+			delete(s.active, l)
+			expired = true
+		}
+	}
+	return expired
+}
+
+func (s *leaseSet) stats() []LeaseStats {
+	if len(s.active) == 0 {
+		return nil
+	}
+
+	stats := make([]LeaseStats, 0, len(s.active))
+	for l := range s.active {
+		stats = append(stats, LeaseStats{Rate: l.rate, ExpiresAt: l.expiresAt})
+	}
+	slices.SortFunc(stats, func(a, b LeaseStats) int {
+		return a.ExpiresAt.Compare(b.ExpiresAt)
+	})
+	return stats
+}
+
+// acquireLease adds a lease of rate out of count events per duration enforced by the limiter newLimiter returns,
+// running apply once the lease set changed. It must be called with the mutex already locked.
+func (b *base) acquireLease(
+	rate Rate,
+	ttl time.Duration,
+	count int,
+	duration time.Duration,
+	newLimiter func() Limiter,
+	apply func(),
+) (Lease, error) {
+	if rate.Count <= 0 || rate.Per <= 0 || ttl <= 0 {
+		return nil, fmt.Errorf("invalid lease of %d/%s for %s", rate.Count, rate.Per, ttl)
+	}
+
+	now := b.clock.Now()
+	b.leases.expire(now)
+	if math.Ceil(b.leases.leasedIn(duration)+rate.in(duration)) >= float64(count) {
+		return nil, fmt.Errorf("lease of %d/%s would take all of the %d/%s rate", rate.Count, rate.Per, count, duration)
+	}
+
+	l := &lease{
+		Limiter:   newLimiter(),
+		rate:      rate,
+		expiresAt: now.Add(ttl),
+		clock:     b.clock,
+	}
+	l.release = func() {
+		b.mux.Lock()
+		defer b.mux.Unlock()
+		delete(b.leases.active, l)
+		apply()
+		b.waiters.notify()
+	}
+
+	if b.leases.active == nil {
+		b.leases.active = make(map[*lease]struct{})
+	}
+	b.leases.active[l] = struct{}{}
+	apply()
+	return l, nil
+}
+
+// expireLeases drops expired leases, running apply and waking the waiters if any did.
+func (b *base) expireLeases(apply func()) {
+	// This must be called with the mutex already locked
+	if b.leases.expire(b.clock.Now()) {
+		apply()
+		b.waiters.notify()
+	}
+}
+
+// lease implements the Lease interface on top of its own limiter enforcing the leased rate.
+type lease struct {
+	Limiter
+
+	// Mutex
+	mux sync.Mutex
+
+	// Config
+	rate      Rate
+	expiresAt time.Time
+	clock     Clock
+	release   func()
+
+	// State
+	released bool
+}
+
+func (l *lease) Release() {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	if l.released {
+		return
+	}
+	l.released = true
+	l.release()
+}
+
+// Close releases the lease and closes the limiter enforcing it.
+func (l *lease) Close() error {
+	l.Release()
+	return l.Limiter.Close()
+}
+
+func (l *lease) ExpiresAt() time.Time {
+	return l.expiresAt
+}
+
+func (l *lease) active() bool {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return !l.released && l.clock.Now().Before(l.expiresAt)
+}
+
+func (l *lease) Wait() {
+	_ = l.WaitContext(context.Background())
+}
+
+func (l *lease) WaitTimeout(timeout time.Duration) error {
+	ctx, cancel := withTimeout(l.clock, timeout)
+	defer cancel()
+	return l.WaitContext(ctx)
+}
+
+func (l *lease) WaitContext(ctx context.Context) error {
+	return l.WaitNContext(ctx, 1)
+}
+
+func (l *lease) WaitN(n int) error {
+	return l.WaitNContext(context.Background(), n)
+}
+
+func (l *lease) WaitNTimeout(timeout time.Duration, n int) error {
+	ctx, cancel := withTimeout(l.clock, timeout)
+	defer cancel()
+	return l.WaitNContext(ctx, n)
+}
+
+func (l *lease) WaitNContext(ctx context.Context, n int) error {
+	if !l.active() {
+		return ErrLeaseEnded
+	}
+	if err := l.Limiter.WaitNContext(ctx, n); err != nil {
+		return err
+	}
+	if !l.active() {
+		// The lease ended while waiting
+		return ErrLeaseEnded
+	}
+	return nil
+}
+
+func (l *lease) Allowed() bool {
+	return l.active() && l.Limiter.Allowed()
+}
+
+func (l *lease) AllowN(n int) bool {
+	return l.active() && l.Limiter.AllowN(n)
+}
+
+func (l *lease) TryReserve(reservationTTL *time.Duration) (Reservation, bool) {
+	if !l.active() {
+		return nil, false
+	}
+	return l.Limiter.TryReserve(reservationTTL)
+}
+
+// Reserve returns a reservation that can't be consumed once the lease ended.
+func (l *lease) Reserve(reservationTTL *time.Duration) Reservation {
+	if !l.active() {
+		return failedReservation{err: ErrLeaseEnded}
+	}
+	return l.Limiter.Reserve(reservationTTL)
+}
+
+func (l *lease) ReserveTimeout(timeout time.Duration, reservationTTL *time.Duration) (Reservation, error) {
+	ctx, cancel := withTimeout(l.clock, timeout)
+	defer cancel()
+	return l.ReserveContext(ctx, reservationTTL)
+}
+
+func (l *lease) ReserveContext(ctx context.Context, reservationTTL *time.Duration) (Reservation, error) {
+	return l.ReserveN(ctx, 1, reservationTTL)
+}
+
+func (l *lease) ReserveN(ctx context.Context, n int, reservationTTL *time.Duration) (Reservation, error) {
+	if !l.active() {
+		return nil, ErrLeaseEnded
+	}
+	return l.Limiter.ReserveN(ctx, n, reservationTTL)
+}
+
+// Info reports nothing remaining once the lease ended.
+func (l *lease) Info() LimitInfo {
+	info := l.Limiter.Info()
+	if !l.active() {
+		info.Remaining = 0
+	}
+	return info
+}
+
+// Available returns nothing once the lease ended.
+func (l *lease) Available() int {
+	return l.Info().Remaining
+}
+
+// Limit returns the leased rate.
+func (l *lease) Limit() Rate {
+	return l.Limiter.(Configurer).Limit()
+}
+
+func (l *lease) Burst() int {
+	return l.Limiter.(Configurer).Burst()
+}
+
+func (l *lease) Permits(ctx context.Context) <-chan struct{} {
+	return deliverPermits(ctx, func(ctx context.Context) (permit, error) {
+		return reservePermit(ctx, l)
+	})
+}
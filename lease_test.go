@@ -0,0 +1,137 @@
+package limit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limittest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var leaserConstructors = map[string]func(count int, duration time.Duration, opts ...limit.Option) limit.Limiter{
+	"RollingWindow": limit.NewRollingWindow,
+	"TokenBucket":   limit.NewTokenBucket,
+}
+
+func TestLease_CarvesRateOutOfParent(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range leaserConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := limittest.NewFakeClock(time.Now())
+			parent := newLimiter(10, 1*time.Second, limit.WithClock(clock))
+
+			lease, err := parent.(limit.Leaser).AcquireLease(limit.Rate{Count: 4, Per: 1 * time.Second}, 1*time.Minute)
+			require.NoError(t, err)
+
+			stats := parent.Stats()
+			assert.Equal(t, []limit.LeaseStats{
+				{Rate: limit.Rate{Count: 4, Per: 1 * time.Second}, ExpiresAt: clock.Now().Add(1 * time.Minute)},
+			}, stats.Leases)
+
+			for i := 0; i < 6; i++ {
+				assert.True(t, parent.Allowed())
+			}
+			assert.False(t, parent.Allowed())
+
+			for i := 0; i < 4; i++ {
+				assert.True(t, lease.Allowed())
+			}
+			assert.False(t, lease.Allowed())
+		})
+	}
+}
+
+func TestLease_DoesNotOversubscribe(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range leaserConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			parent := newLimiter(10, 1*time.Second).(limit.Leaser)
+
+			first, err := parent.AcquireLease(limit.Rate{Count: 4, Per: 1 * time.Second}, 1*time.Minute)
+			require.NoError(t, err)
+
+			// 4 + 6 would leave nothing to the parent
+			_, err = parent.AcquireLease(limit.Rate{Count: 6, Per: 1 * time.Second}, 1*time.Minute)
+			assert.Error(t, err)
+
+			// Rates are compared per the parent's duration
+			_, err = parent.AcquireLease(limit.Rate{Count: 600, Per: 1 * time.Minute}, 1*time.Minute)
+			assert.Error(t, err)
+
+			_, err = parent.AcquireLease(limit.Rate{Count: 5, Per: 1 * time.Second}, 1*time.Minute)
+			assert.NoError(t, err)
+
+			// Releasing a lease makes room for another one
+			first.Release()
+			first.Release()
+			_, err = parent.AcquireLease(limit.Rate{Count: 4, Per: 1 * time.Second}, 1*time.Minute)
+			assert.NoError(t, err)
+
+			_, err = parent.AcquireLease(limit.Rate{Count: 0, Per: 1 * time.Second}, 1*time.Minute)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLease_EndsOnReleaseAndExpiry(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range leaserConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := limittest.NewFakeClock(time.Now())
+			parent := newLimiter(10, 1*time.Second, limit.WithClock(clock))
+			leaser := parent.(limit.Leaser)
+
+			released, err := leaser.AcquireLease(limit.Rate{Count: 4, Per: 1 * time.Second}, 1*time.Minute)
+			require.NoError(t, err)
+			expiring, err := leaser.AcquireLease(limit.Rate{Count: 4, Per: 1 * time.Second}, 10*time.Second)
+			require.NoError(t, err)
+			assert.Len(t, parent.Stats().Leases, 2)
+
+			released.Release()
+			assert.False(t, released.Allowed())
+			assert.ErrorIs(t, released.WaitContext(context.Background()), limit.ErrLeaseEnded)
+			assert.ErrorIs(t, released.Reserve(nil).Consume(), limit.ErrLeaseEnded)
+			assert.Len(t, parent.Stats().Leases, 1)
+
+			clock.Advance(10 * time.Second)
+			assert.False(t, expiring.Allowed())
+			_, err = expiring.ReserveContext(context.Background(), nil)
+			assert.ErrorIs(t, err, limit.ErrLeaseEnded)
+			assert.Empty(t, parent.Stats().Leases)
+
+			// The whole rate is back once the parent had time to refill
+			clock.Advance(1 * time.Second)
+			for i := 0; i < 10; i++ {
+				assert.True(t, parent.Allowed())
+			}
+			assert.False(t, parent.Allowed())
+		})
+	}
+}
+
+func TestLease_InheritsLabels(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range leaserConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			parent := newLimiter(100, 1*time.Second, limit.WithLabels(map[string]string{"team": "payments"}))
+			lease, err := parent.(limit.Leaser).AcquireLease(limit.Rate{Count: 10, Per: 1 * time.Second}, 1*time.Minute)
+			assert.NoError(t, err)
+			assert.Equal(t, map[string]string{"team": "payments"}, lease.Labels())
+		})
+	}
+}
@@ -0,0 +1,220 @@
+// Package limitconnect provides a connect-go interceptor backed by go-limit limiters.
+package limitconnect
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/agustinbanchio/go-limit"
+)
+
+// RetryAfterHeader is the metadata key set on rejected calls with the number of seconds to wait before retrying.
+const RetryAfterHeader = "Retry-After"
+
+// ErrRateLimited is the error wrapped by the connect errors returned on rejected calls.
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// KeyFunc extracts the rate limiting key for a call. An empty key falls back to the default limiter.
+type KeyFunc func(ctx context.Context, spec connect.Spec, header http.Header) string
+
+// Option configures the interceptor.
+type Option func(*interceptor)
+
+// WithKeyFunc limits each key returned by fn with its own limiter, created on first use by factory and held in a
+// limit.KeyedLimiter built with opts. Without limit.WithIdleTimeout, limit.WithMaxKeys or limit.WithKeyCache every key
+// is kept for the life of the interceptor.
+func WithKeyFunc(fn KeyFunc, factory func(key string) limit.Limiter, opts ...limit.Option) Option {
+	return func(i *interceptor) {
+		i.keyFunc = fn
+		i.keyed = limit.NewKeyedLimiter(factory, opts...)
+	}
+}
+
+// WithAdmissionHook makes the interceptor call hook after deciding on each call it limits, with how long clients
+// waited, e.g. to annotate the call's span. The admission's key is the call's key, or its procedure if it has none.
+func WithAdmissionHook(hook limit.AdmissionHook) Option {
+	return func(i *interceptor) {
+		i.admissionHook = hook
+	}
+}
+
+// WithProcedureLimiter limits calls to the given procedure (e.g. "/acme.v1.FooService/Bar") with l instead of the
+// default or keyed limiters.
+func WithProcedureLimiter(procedure string, l limit.Limiter) Option {
+	return func(i *interceptor) {
+		i.procedures[procedure] = l
+	}
+}
+
+type interceptor struct {
+	// Config
+	limiter       limit.Limiter
+	procedures    map[string]limit.Limiter
+	keyFunc       KeyFunc
+	keyed         *limit.KeyedLimiter
+	admissionHook limit.AdmissionHook
+}
+
+// NewInterceptor returns a connect.Interceptor limiting calls with l.
+// Handlers reject calls that are not allowed with connect.CodeResourceExhausted and a Retry-After header, while
+// clients block until the limiter allows the outbound call or its context is done.
+// A nil l leaves calls that don't match a procedure or key limiter unlimited.
+func NewInterceptor(l limit.Limiter, opts ...Option) connect.Interceptor {
+	i := &interceptor{
+		limiter:    l,
+		procedures: make(map[string]limit.Limiter),
+	}
+	for _, opt := range opts {
+		opt(i)
+	}
+	return i
+}
+
+func (i *interceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		if err := i.admit(ctx, req.Spec(), req.Header()); err != nil {
+			return nil, err
+		}
+		return next(ctx, req)
+	}
+}
+
+// WrapStreamingClient admits streams before opening them, so a rejected stream has no connection to close. The key
+// function gets an empty header for them, connect only adds the request's headers once the stream is open.
+func (i *interceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return func(ctx context.Context, spec connect.Spec) connect.StreamingClientConn {
+		if err := i.admit(ctx, spec, make(http.Header)); err != nil {
+			return &rejectedClientConn{spec: spec, err: err}
+		}
+		return next(ctx, spec)
+	}
+}
+
+func (i *interceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		if err := i.admit(ctx, conn.Spec(), conn.RequestHeader()); err != nil {
+			return err
+		}
+		return next(ctx, conn)
+	}
+}
+
+// admit waits for the limiter on the client side and checks it without blocking on the handler side.
+func (i *interceptor) admit(ctx context.Context, spec connect.Spec, header http.Header) error {
+	key, l := i.resolve(ctx, spec, header)
+	if l == nil {
+		return nil
+	}
+
+	if spec.IsClient {
+		start := time.Now()
+		err := l.WaitContext(ctx)
+		i.admitted(ctx, limit.Admission{Key: key, Limiter: l, Limited: err != nil, Wait: time.Since(start)})
+		if err != nil {
+			return connect.NewError(contextCode(err), err)
+		}
+		return nil
+	}
+
+	allowed := l.Allowed()
+	i.admitted(ctx, limit.Admission{Key: key, Limiter: l, Limited: !allowed})
+	if allowed {
+		return nil
+	}
+
+	connectErr := connect.NewError(connect.CodeResourceExhausted, ErrRateLimited)
+	connectErr.Meta().Set(RetryAfterHeader, retryAfter(l.Stats().NextAllowedTime))
+	return connectErr
+}
+
+// admitted calls the admission hook, if any.
+func (i *interceptor) admitted(ctx context.Context, admission limit.Admission) {
+	if i.admissionHook != nil {
+		i.admissionHook(ctx, admission)
+	}
+}
+
+// resolve returns the limiter of a call and the key it was picked by, the procedure if the call has no key.
+func (i *interceptor) resolve(ctx context.Context, spec connect.Spec, header http.Header) (string, limit.Limiter) {
+	if l, ok := i.procedures[spec.Procedure]; ok {
+		return spec.Procedure, l
+	}
+
+	if i.keyFunc == nil {
+		return spec.Procedure, i.limiter
+	}
+
+	key := i.keyFunc(ctx, spec, header)
+	if key == "" {
+		return spec.Procedure, i.limiter
+	}
+
+	return key, i.keyed.Get(key)
+}
+
+// retryAfter formats the whole seconds until next, rounded up and never less than one.
+func retryAfter(next time.Time) string {
+	seconds := int(math.Ceil(time.Until(next).Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.Itoa(seconds)
+}
+
+func contextCode(err error) connect.Code {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return connect.CodeDeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		return connect.CodeCanceled
+	default:
+		return connect.CodeResourceExhausted
+	}
+}
+
+// rejectedClientConn fails every send and receive of a stream the limiter didn't admit, in place of the stream.
+type rejectedClientConn struct {
+	spec connect.Spec
+	err  error
+}
+
+func (c *rejectedClientConn) Spec() connect.Spec {
+	return c.spec
+}
+
+func (c *rejectedClientConn) Peer() connect.Peer {
+	return connect.Peer{}
+}
+
+func (c *rejectedClientConn) RequestHeader() http.Header {
+	return make(http.Header)
+}
+
+func (c *rejectedClientConn) ResponseHeader() http.Header {
+	return make(http.Header)
+}
+
+func (c *rejectedClientConn) ResponseTrailer() http.Header {
+	return make(http.Header)
+}
+
+func (c *rejectedClientConn) Send(any) error {
+	return c.err
+}
+
+func (c *rejectedClientConn) Receive(any) error {
+	return c.err
+}
+
+func (c *rejectedClientConn) CloseRequest() error {
+	return c.err
+}
+
+func (c *rejectedClientConn) CloseResponse() error {
+	return nil
+}
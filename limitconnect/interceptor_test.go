@@ -0,0 +1,351 @@
+package limitconnect_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limitconnect"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+const (
+	pingProcedure  = "/limit.test.v1.TestService/Ping"
+	countProcedure = "/limit.test.v1.TestService/Count"
+)
+
+// memListener is an in-memory net.Listener so tests don't touch the network.
+type memListener struct {
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newMemListener() *memListener {
+	return &memListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+func (l *memListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *memListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *memListener) Addr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+}
+
+func (l *memListener) dial(ctx context.Context, _, _ string) (net.Conn, error) {
+	server, client := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// startServer serves a unary Ping and a server streaming Count procedure with the given handler options and
+// returns an HTTP client connected to it in memory.
+func startServer(t *testing.T, opts ...connect.HandlerOption) *http.Client {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.Handle(pingProcedure, connect.NewUnaryHandler(
+		pingProcedure,
+		func(context.Context, *connect.Request[emptypb.Empty]) (*connect.Response[emptypb.Empty], error) {
+			return connect.NewResponse(&emptypb.Empty{}), nil
+		},
+		opts...,
+	))
+	mux.Handle(countProcedure, connect.NewServerStreamHandler(
+		countProcedure,
+		func(_ context.Context, _ *connect.Request[emptypb.Empty], stream *connect.ServerStream[emptypb.Empty]) error {
+			for i := 0; i < 3; i++ {
+				if err := stream.Send(&emptypb.Empty{}); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		opts...,
+	))
+
+	listener := newMemListener()
+	server := &http.Server{Handler: mux}
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(func() { _ = server.Close() })
+
+	return &http.Client{Transport: &http.Transport{DialContext: listener.dial}}
+}
+
+func ping(ctx context.Context, client *http.Client, opts ...connect.ClientOption) error {
+	c := connect.NewClient[emptypb.Empty, emptypb.Empty](client, "http://memory"+pingProcedure, opts...)
+	_, err := c.CallUnary(ctx, connect.NewRequest(&emptypb.Empty{}))
+	return err
+}
+
+func TestInterceptor_UnaryRejectsWithRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	limiter := limit.NewRollingWindow(2, 1*time.Second)
+	client := startServer(t, connect.WithInterceptors(limitconnect.NewInterceptor(limiter)))
+
+	assert.NoError(t, ping(context.Background(), client))
+	assert.NoError(t, ping(context.Background(), client))
+
+	err := ping(context.Background(), client)
+	require.Error(t, err)
+	assert.Equal(t, connect.CodeResourceExhausted, connect.CodeOf(err))
+
+	var connectErr *connect.Error
+	require.True(t, errors.As(err, &connectErr))
+	assert.Equal(t, "1", connectErr.Meta().Get(limitconnect.RetryAfterHeader))
+}
+
+func TestInterceptor_StreamingHandler(t *testing.T) {
+	t.Parallel()
+
+	limiter := limit.NewRollingWindow(1, 1*time.Second)
+	client := startServer(t, connect.WithInterceptors(limitconnect.NewInterceptor(limiter)))
+	c := connect.NewClient[emptypb.Empty, emptypb.Empty](client, "http://memory"+countProcedure)
+
+	// The first stream is admitted and receives every message
+	stream, err := c.CallServerStream(context.Background(), connect.NewRequest(&emptypb.Empty{}))
+	require.NoError(t, err)
+	received := 0
+	for stream.Receive() {
+		received++
+	}
+	assert.NoError(t, stream.Err())
+	assert.Equal(t, 3, received)
+	assert.NoError(t, stream.Close())
+
+	// The second stream is rejected
+	stream, err = c.CallServerStream(context.Background(), connect.NewRequest(&emptypb.Empty{}))
+	require.NoError(t, err)
+	assert.False(t, stream.Receive())
+	assert.Equal(t, connect.CodeResourceExhausted, connect.CodeOf(stream.Err()))
+	assert.NoError(t, stream.Close())
+}
+
+func TestInterceptor_ProcedureLimiterOverridesDefault(t *testing.T) {
+	t.Parallel()
+
+	pingLimiter := limit.NewRollingWindow(1, 1*time.Second)
+	interceptor := limitconnect.NewInterceptor(
+		limit.NewRollingWindow(100, 1*time.Second),
+		limitconnect.WithProcedureLimiter(pingProcedure, pingLimiter),
+	)
+	client := startServer(t, connect.WithInterceptors(interceptor))
+
+	assert.NoError(t, ping(context.Background(), client))
+	assert.Equal(t, connect.CodeResourceExhausted, connect.CodeOf(ping(context.Background(), client)))
+	assert.Equal(t, 1, pingLimiter.Stats().AllowedRequests)
+	assert.Equal(t, 1, pingLimiter.Stats().DeniedRequests)
+}
+
+func TestInterceptor_KeyFuncLimitsEachKey(t *testing.T) {
+	t.Parallel()
+
+	interceptor := limitconnect.NewInterceptor(
+		nil,
+		limitconnect.WithKeyFunc(
+			func(_ context.Context, _ connect.Spec, header http.Header) string {
+				return header.Get("X-Tenant")
+			},
+			func(string) limit.Limiter {
+				return limit.NewRollingWindow(1, 1*time.Second)
+			},
+		),
+	)
+	client := startServer(t, connect.WithInterceptors(interceptor))
+
+	pingAs := func(tenant string) error {
+		c := connect.NewClient[emptypb.Empty, emptypb.Empty](client, "http://memory"+pingProcedure)
+		req := connect.NewRequest(&emptypb.Empty{})
+		if tenant != "" {
+			req.Header().Set("X-Tenant", tenant)
+		}
+		_, err := c.CallUnary(context.Background(), req)
+		return err
+	}
+
+	assert.NoError(t, pingAs("a"))
+	assert.NoError(t, pingAs("b"))
+	assert.Equal(t, connect.CodeResourceExhausted, connect.CodeOf(pingAs("a")))
+	assert.Equal(t, connect.CodeResourceExhausted, connect.CodeOf(pingAs("b")))
+
+	// Calls without a key use the nil default limiter and are not limited
+	assert.NoError(t, pingAs(""))
+	assert.NoError(t, pingAs(""))
+}
+
+func TestInterceptor_KeyFuncBoundsKeys(t *testing.T) {
+	t.Parallel()
+
+	var mux sync.Mutex
+	var evicted []string
+	interceptor := limitconnect.NewInterceptor(
+		nil,
+		limitconnect.WithKeyFunc(
+			func(_ context.Context, _ connect.Spec, header http.Header) string {
+				return header.Get("X-Tenant")
+			},
+			func(string) limit.Limiter {
+				return limit.NewRollingWindow(1, 1*time.Hour)
+			},
+			limit.WithMaxKeys(1),
+			limit.WithEvictionHook(func(key string, _ limit.Stats, _ limit.EvictReason) {
+				mux.Lock()
+				defer mux.Unlock()
+				evicted = append(evicted, key)
+			}),
+		),
+	)
+	client := startServer(t, connect.WithInterceptors(interceptor))
+
+	pingAs := func(tenant string) error {
+		c := connect.NewClient[emptypb.Empty, emptypb.Empty](client, "http://memory"+pingProcedure)
+		req := connect.NewRequest(&emptypb.Empty{})
+		req.Header().Set("X-Tenant", tenant)
+		_, err := c.CallUnary(context.Background(), req)
+		return err
+	}
+
+	// The limiter of a is evicted to make room for b, so a starts over with a new one
+	assert.NoError(t, pingAs("a"))
+	assert.NoError(t, pingAs("b"))
+	assert.Equal(t, connect.CodeResourceExhausted, connect.CodeOf(pingAs("b")))
+	assert.NoError(t, pingAs("a"))
+	assert.Eventually(t, func() bool {
+		mux.Lock()
+		defer mux.Unlock()
+		return len(evicted) == 2
+	}, 1*time.Second, 10*time.Millisecond)
+}
+
+func TestInterceptor_ClientWaits(t *testing.T) {
+	t.Parallel()
+
+	client := startServer(t)
+	limiter := limit.NewRollingWindow(1, 200*time.Millisecond)
+	interceptor := connect.WithInterceptors(limitconnect.NewInterceptor(limiter))
+
+	start := time.Now()
+	assert.NoError(t, ping(context.Background(), client, interceptor))
+	assert.NoError(t, ping(context.Background(), client, interceptor))
+
+	// The second call should have waited for the window
+	assert.True(t, time.Since(start) >= 200*time.Millisecond)
+
+	// An outbound call that can't be admitted before its deadline never reaches the server
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, connect.CodeDeadlineExceeded, connect.CodeOf(ping(ctx, client, interceptor)))
+}
+
+// openCounter counts the client streams opened by the interceptors it's given after.
+type openCounter struct {
+	mux    sync.Mutex
+	opened int
+}
+
+func (c *openCounter) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return next
+}
+
+func (c *openCounter) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return func(ctx context.Context, spec connect.Spec) connect.StreamingClientConn {
+		c.mux.Lock()
+		c.opened++
+		c.mux.Unlock()
+		return next(ctx, spec)
+	}
+}
+
+func (c *openCounter) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return next
+}
+
+func (c *openCounter) count() int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.opened
+}
+
+func TestInterceptor_ClientStreamRejected(t *testing.T) {
+	t.Parallel()
+
+	client := startServer(t)
+	limiter := limit.NewRollingWindow(1, 1*time.Second)
+	opened := &openCounter{}
+	c := connect.NewClient[emptypb.Empty, emptypb.Empty](
+		client,
+		"http://memory"+countProcedure,
+		connect.WithInterceptors(limitconnect.NewInterceptor(limiter), opened),
+	)
+
+	stream, err := c.CallServerStream(context.Background(), connect.NewRequest(&emptypb.Empty{}))
+	require.NoError(t, err)
+	for stream.Receive() {
+	}
+	assert.NoError(t, stream.Err())
+	assert.NoError(t, stream.Close())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = c.CallServerStream(ctx, connect.NewRequest(&emptypb.Empty{}))
+	assert.Equal(t, connect.CodeDeadlineExceeded, connect.CodeOf(err))
+
+	// The rejected stream was never opened, so there's nothing left to close
+	assert.Equal(t, 1, opened.count())
+}
+
+func TestInterceptor_AdmissionHook(t *testing.T) {
+	t.Parallel()
+
+	var mux sync.Mutex
+	var admissions []limit.Admission
+	hook := limitconnect.WithAdmissionHook(func(_ context.Context, admission limit.Admission) {
+		mux.Lock()
+		defer mux.Unlock()
+		admissions = append(admissions, admission)
+	})
+
+	handlerLimiter := limit.NewRollingWindow(1, 1*time.Hour)
+	client := startServer(t, connect.WithInterceptors(limitconnect.NewInterceptor(handlerLimiter, hook)))
+	clientLimiter := limit.NewRollingWindow(1, 100*time.Millisecond)
+	interceptor := connect.WithInterceptors(limitconnect.NewInterceptor(clientLimiter, hook))
+
+	assert.NoError(t, ping(context.Background(), client, interceptor))
+	assert.Error(t, ping(context.Background(), client, interceptor))
+
+	mux.Lock()
+	defer mux.Unlock()
+	require.Len(t, admissions, 4)
+	assert.Equal(t, limit.Admission{Key: pingProcedure, Limiter: handlerLimiter, Limited: true}, admissions[3])
+
+	// The second outbound call waited for the client limiter
+	assert.Equal(t, pingProcedure, admissions[2].Key)
+	assert.False(t, admissions[2].Limited)
+	assert.Greater(t, admissions[2].Wait, 50*time.Millisecond)
+}
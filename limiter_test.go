@@ -0,0 +1,1571 @@
+package limit_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limittest"
+	"github.com/stretchr/testify/assert"
+)
+
+// limiterConstructors builds every implementation with the same rate, the leaky bucket using count as its max queue.
+var limiterConstructors = map[string]func(count int, duration time.Duration, opts ...limit.Option) limit.Limiter{
+	"RollingWindow": limit.NewRollingWindow,
+	"TokenBucket":   limit.NewTokenBucket,
+	"LeakyBucket": func(count int, duration time.Duration, opts ...limit.Option) limit.Limiter {
+		return limit.NewLeakyBucket(count, duration, count, opts...)
+	},
+}
+
+func TestLimiter_Reserve_NeverReturnsNil(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range limiterConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			limiter := newLimiter(2, 1*time.Second)
+
+			first := limiter.Reserve(nil)
+			second := limiter.Reserve(nil)
+			assert.NotNil(t, first)
+			assert.NotNil(t, second)
+
+			// Capacity is exhausted, so the next Reserve blocks until a reservation is canceled
+			go func() {
+				time.Sleep(100 * time.Millisecond)
+				first.Cancel()
+			}()
+
+			third := limiter.Reserve(nil)
+			assert.NotNil(t, third)
+		})
+	}
+}
+
+func TestLimiter_Allowed(t *testing.T) {
+	t.Parallel()
+
+	// How many requests in a row each limiter allows at 10 per second, the leaky bucket lets one through per interval
+	bursts := map[string]int{
+		"RollingWindow": 10,
+		"TokenBucket":   10,
+		"LeakyBucket":   1,
+	}
+	for name, newLimiter := range limiterConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := limittest.NewFakeClock(time.Unix(0, 0))
+			limiter := newLimiter(10, 1*time.Second, limit.WithClock(clock))
+			for range bursts[name] {
+				assert.True(t, limiter.Allowed())
+			}
+			assert.False(t, limiter.Allowed())
+			assert.Equal(t, bursts[name], limiter.Stats().AllowedRequests)
+			assert.Equal(t, 1, limiter.Stats().DeniedByReason[limit.ReasonLimited])
+
+			clock.Advance(1*time.Second + time.Nanosecond)
+			assert.True(t, limiter.Allowed())
+		})
+	}
+}
+
+func TestLimiter_AllowN(t *testing.T) {
+	t.Parallel()
+
+	for _, name := range []string{"RollingWindow", "TokenBucket"} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := limittest.NewFakeClock(time.Unix(0, 0))
+			limiter := limiterConstructors[name](10, 1*time.Second, limit.WithClock(clock))
+
+			// Zero units are a no-op
+			assert.True(t, limiter.AllowN(0))
+			assert.Equal(t, 0, limiter.Stats().AllowedRequests)
+
+			// All the units or none are taken
+			assert.False(t, limiter.AllowN(11))
+			assert.Equal(t, 10, limiter.Info().Remaining)
+			assert.True(t, limiter.AllowN(4))
+			assert.False(t, limiter.AllowN(7))
+			assert.True(t, limiter.AllowN(6))
+			assert.False(t, limiter.Allowed())
+			assert.Equal(t, 2, limiter.Stats().AllowedRequests)
+			assert.Equal(t, 3, limiter.Stats().DeniedRequests)
+		})
+	}
+
+	t.Run("LeakyBucket", func(t *testing.T) {
+		t.Parallel()
+
+		clock := limittest.NewFakeClock(time.Unix(0, 0))
+		limiter := limiterConstructors["LeakyBucket"](10, 1*time.Second, limit.WithClock(clock))
+
+		// n units leak at once when the bucket has been idle for n leak intervals
+		assert.True(t, limiter.AllowN(0))
+		assert.False(t, limiter.AllowN(2))
+		assert.True(t, limiter.AllowN(1))
+		clock.Advance(300 * time.Millisecond)
+		assert.False(t, limiter.AllowN(4))
+		assert.True(t, limiter.AllowN(3))
+		assert.False(t, limiter.Allowed())
+		assert.Equal(t, 2, limiter.Stats().AllowedRequests)
+	})
+}
+
+func TestLimiter_WaitN(t *testing.T) {
+	t.Parallel()
+
+	// How long 5 units wait for once the limiter is drained, the window frees its events just after a full window
+	waits := map[string]time.Duration{
+		"RollingWindow": 1*time.Second + time.Nanosecond,
+		"TokenBucket":   500 * time.Millisecond,
+		"LeakyBucket":   500 * time.Millisecond,
+	}
+	for name, newLimiter := range limiterConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := limittest.NewFakeClock(time.Unix(0, 0))
+			limiter := newLimiter(10, 1*time.Second, limit.WithClock(clock))
+
+			// More units than the limiter ever allows at once fail right away
+			err := limiter.WaitN(11)
+			assert.ErrorContains(t, err, "exceeds")
+			assert.NoError(t, limiter.WaitN(0))
+
+			drained := 0
+			for limiter.Allowed() {
+				drained++
+			}
+			done := make(chan error, 1)
+			go func() {
+				done <- limiter.WaitNContext(context.Background(), 5)
+			}()
+
+			clock.BlockUntil(1)
+			clock.Advance(waits[name] - 100*time.Millisecond)
+			select {
+			case <-done:
+				t.Fatal("WaitN returned before the 5 units were available")
+			default:
+			}
+			clock.BlockUntil(1)
+			clock.Advance(100 * time.Millisecond)
+			assert.NoError(t, <-done)
+			assert.Equal(t, drained+1, limiter.Stats().AllowedRequests)
+		})
+	}
+}
+
+func TestLimiter_ReserveN(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range limiterConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			clock := limittest.NewFakeClock(time.Unix(0, 0))
+			limiter := newLimiter(10, 1*time.Second, limit.WithClock(clock))
+
+			_, err := limiter.ReserveN(ctx, 0, nil)
+			assert.Error(t, err)
+			_, err = limiter.ReserveN(ctx, 11, nil)
+			assert.ErrorContains(t, err, "exceeds")
+
+			// Pending reservations hold all their units
+			reservation, err := limiter.ReserveN(ctx, 6, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, 4, limiter.Info().Remaining)
+			if name != "LeakyBucket" {
+				assert.False(t, limiter.AllowN(5))
+			}
+			reservation.Cancel()
+			assert.Equal(t, 10, limiter.Info().Remaining)
+
+			// Expiring releases all of them too
+			ttl := 1 * time.Second
+			reservation, err = limiter.ReserveN(ctx, 6, &ttl)
+			assert.NoError(t, err)
+			clock.Advance(2 * time.Second)
+			assert.Equal(t, 10, limiter.Info().Remaining)
+			assert.Error(t, reservation.Consume())
+
+			// Consuming uses them at once, as one request
+			reservation, err = limiter.ReserveN(ctx, 3, nil)
+			assert.NoError(t, err)
+			assert.NoError(t, reservation.Consume())
+			assert.Equal(t, 1, limiter.Stats().AllowedRequests)
+			if name != "LeakyBucket" {
+				assert.Equal(t, 7, limiter.Info().Remaining)
+			}
+		})
+	}
+}
+
+func TestLimiter_Available(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range limiterConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := limittest.NewFakeClock(time.Unix(0, 0))
+			limiter := newLimiter(10, 1*time.Second, limit.WithClock(clock))
+			assert.Equal(t, 10, limiter.Available())
+
+			// Pending reservations count, and asking takes nothing
+			_, err := limiter.ReserveN(context.Background(), 3, nil)
+			assert.NoError(t, err)
+			for range 3 {
+				assert.Equal(t, 7, limiter.Available())
+			}
+			assert.Equal(t, 0, limiter.Stats().AllowedRequests)
+			assert.Equal(t, 0, limiter.Stats().DeniedRequests)
+
+			if name != "LeakyBucket" {
+				// The leaky bucket reports room in the queue, which allowing without queuing doesn't take
+				assert.True(t, limiter.AllowN(2))
+				assert.Equal(t, 5, limiter.Available())
+				clock.Advance(2 * time.Second)
+				assert.Equal(t, 7, limiter.Available())
+			}
+		})
+	}
+}
+
+func TestLimiter_EstimatedWait(t *testing.T) {
+	t.Parallel()
+
+	for _, name := range []string{"RollingWindow", "TokenBucket"} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := limittest.NewFakeClock(time.Unix(0, 0))
+			limiter := limiterConstructors[name](10, 1*time.Second, limit.WithClock(clock))
+			assert.Equal(t, time.Duration(0), limiter.EstimatedWait())
+
+			// Pending reservations count, and asking takes nothing
+			assert.True(t, limiter.AllowN(5))
+			_, err := limiter.ReserveN(context.Background(), 5, nil)
+			assert.NoError(t, err)
+			wait := limiter.EstimatedWait()
+			assert.Greater(t, wait, time.Duration(0))
+			assert.LessOrEqual(t, wait, 1*time.Second)
+			assert.Equal(t, wait, limiter.EstimatedWait())
+			assert.Equal(t, 1, limiter.Stats().AllowedRequests)
+			assert.Equal(t, 0, limiter.Stats().DeniedRequests)
+
+			clock.Advance(wait + time.Nanosecond)
+			assert.Equal(t, time.Duration(0), limiter.EstimatedWait())
+			assert.True(t, limiter.Allowed())
+		})
+	}
+
+	t.Run("LeakyBucket", func(t *testing.T) {
+		t.Parallel()
+
+		clock := limittest.NewFakeClock(time.Unix(0, 0))
+		limiter := limit.NewLeakyBucket(10, 1*time.Second, 10, limit.WithClock(clock))
+		assert.Equal(t, time.Duration(0), limiter.EstimatedWait())
+
+		// The next event leaks one interval after the last
+		assert.True(t, limiter.Allowed())
+		assert.Equal(t, 100*time.Millisecond, limiter.EstimatedWait())
+	})
+}
+
+func TestLimiter_Config(t *testing.T) {
+	t.Parallel()
+
+	algorithms := map[string]string{
+		"RollingWindow": limit.AlgorithmRollingWindow,
+		"TokenBucket":   limit.AlgorithmTokenBucket,
+		"LeakyBucket":   limit.AlgorithmLeakyBucket,
+	}
+	for name, newLimiter := range limiterConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			config := limit.Config{Algorithm: algorithms[name], Count: 10, Per: 1 * time.Second}
+			if name == "LeakyBucket" {
+				config.MaxQueue = 10
+			}
+			assert.Equal(t, config, newLimiter(10, 1*time.Second).Config())
+
+			// A config applied to the live limiter is reported back
+			registry := limit.NewRegistry()
+			assert.NoError(t, registry.Apply(map[string]limit.Config{"api": config}))
+			config.Count = 20
+			assert.NoError(t, registry.Apply(map[string]limit.Config{"api": config}))
+			limiter, _ := registry.Get("api")
+			assert.Equal(t, config, limiter.Config())
+		})
+	}
+}
+
+func TestLimiter_ReservationDelay(t *testing.T) {
+	t.Parallel()
+
+	for _, name := range []string{"RollingWindow", "TokenBucket"} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := limittest.NewFakeClock(time.Unix(0, 0))
+			limiter := limiterConstructors[name](10, 1*time.Second, limit.WithClock(clock))
+			reservation, err := limiter.ReserveN(context.Background(), 5, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, time.Duration(0), reservation.Delay())
+			assert.Equal(t, clock.Now(), reservation.ReadyAt())
+		})
+	}
+
+	t.Run("LeakyBucket", func(t *testing.T) {
+		t.Parallel()
+
+		clock := limittest.NewFakeClock(time.Unix(0, 0))
+		limiter := limit.NewLeakyBucket(10, 1*time.Second, 10, limit.WithClock(clock))
+		assert.True(t, limiter.Allowed())
+		reservation := limiter.Reserve(nil)
+
+		// The event leaks one interval after the last
+		assert.Equal(t, 100*time.Millisecond, reservation.Delay())
+		assert.Equal(t, clock.Now().Add(100*time.Millisecond), reservation.ReadyAt())
+
+		clock.Advance(100 * time.Millisecond)
+		assert.Equal(t, time.Duration(0), reservation.Delay())
+		assert.NoError(t, reservation.Consume())
+		assert.Equal(t, time.Duration(0), reservation.Delay())
+	})
+}
+
+func TestLimiter_ReservationExpiresAt(t *testing.T) {
+	t.Parallel()
+
+	for name, constructor := range limiterConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := limittest.NewFakeClock(time.Unix(0, 0))
+			limiter := constructor(10, 1*time.Second, limit.WithClock(clock))
+			_, ok := limiter.Reserve(nil).ExpiresAt()
+			assert.False(t, ok)
+
+			ttl := 5 * time.Second
+			expiresAt, ok := limiter.Reserve(&ttl).ExpiresAt()
+			assert.True(t, ok)
+			assert.Equal(t, clock.Now().Add(ttl), expiresAt)
+		})
+	}
+}
+
+func TestLimiter_TryReserve(t *testing.T) {
+	t.Parallel()
+
+	for name, constructor := range limiterConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := limittest.NewFakeClock(time.Unix(0, 0))
+			limiter := constructor(2, 1*time.Second, limit.WithClock(clock))
+
+			ttl := 100 * time.Millisecond
+			expiring, ok := limiter.TryReserve(&ttl)
+			assert.True(t, ok)
+			assert.NotNil(t, expiring)
+			held, ok := limiter.TryReserve(nil)
+			assert.True(t, ok)
+
+			// Capacity is held by the reservations, so it fails right away and counts a denial
+			reservation, ok := limiter.TryReserve(nil)
+			assert.False(t, ok)
+			assert.Nil(t, reservation)
+			assert.Equal(t, 1, limiter.Stats().DeniedRequests)
+
+			// An expired reservation frees its capacity
+			clock.Advance(ttl + time.Millisecond)
+			reservation, ok = limiter.TryReserve(nil)
+			assert.True(t, ok)
+			assert.Equal(t, limit.ReservationPending, reservation.State())
+
+			held.Cancel()
+			_, ok = limiter.TryReserve(nil)
+			assert.True(t, ok)
+		})
+	}
+}
+
+func TestLimiter_ReserveDelayed(t *testing.T) {
+	t.Parallel()
+
+	// 2 requests per second, the third is ready once a token refills, the first events leave the window or the first
+	// two events leaked
+	delays := map[string][]time.Duration{
+		"TokenBucket":   {0, 0, 500 * time.Millisecond},
+		"RollingWindow": {0, 0, 1*time.Second + time.Nanosecond},
+		"LeakyBucket":   {0, 500 * time.Millisecond, 1 * time.Second},
+	}
+	for name, constructor := range limiterConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := limittest.NewFakeClock(time.Unix(0, 0))
+			limiter := constructor(2, 1*time.Second, limit.WithClock(clock))
+			reserver := limiter.(limit.DelayedReserver)
+			for _, want := range delays[name][:2] {
+				_, delay := reserver.ReserveDelayed(nil)
+				assert.Equal(t, want, delay)
+			}
+
+			// The limiter is exhausted, the reservation is booked anyway
+			third, delay := reserver.ReserveDelayed(nil)
+			assert.Equal(t, delays[name][2], delay)
+			assert.Equal(t, delay, third.Delay())
+			assert.Equal(t, limit.ReservationPending, third.State())
+			assert.False(t, limiter.Allowed())
+
+			// Canceling a later reservation gives its place back
+			fourth, fourthDelay := reserver.ReserveDelayed(nil)
+			assert.GreaterOrEqual(t, fourthDelay, delay)
+			fourth.Cancel()
+			_, again := reserver.ReserveDelayed(nil)
+			assert.Equal(t, fourthDelay, again)
+
+			// Consume waits out the delay
+			consumed := make(chan error)
+			go func() {
+				consumed <- third.Consume()
+			}()
+			clock.BlockUntil(1)
+			clock.Advance(delay)
+			assert.NoError(t, <-consumed)
+
+			// Giving up on the delay cancels the reservation
+			late, _ := reserver.ReserveDelayed(nil)
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			assert.ErrorIs(t, late.ConsumeContext(ctx), context.Canceled)
+			assert.Equal(t, limit.ReservationCanceled, late.State())
+
+			assert.NoError(t, limiter.Close())
+			closed, _ := reserver.ReserveDelayed(nil)
+			assert.ErrorIs(t, closed.Consume(), limit.ErrLimiterClosed)
+		})
+	}
+}
+
+func TestLimiter_ReservationState(t *testing.T) {
+	t.Parallel()
+
+	for name, constructor := range limiterConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := limittest.NewFakeClock(time.Unix(0, 0))
+			limiter := constructor(10, 1*time.Second, limit.WithClock(clock))
+
+			consumed := limiter.Reserve(nil)
+			assert.Equal(t, limit.ReservationPending, consumed.State())
+			assert.NoError(t, consumed.Consume())
+			assert.Equal(t, limit.ReservationConsumed, consumed.State())
+
+			canceled := limiter.Reserve(nil)
+			canceled.Cancel()
+			assert.Equal(t, limit.ReservationCanceled, canceled.State())
+
+			// Expiry is seen before the limiter cleans the reservation up
+			ttl := 1 * time.Second
+			expired := limiter.Reserve(&ttl)
+			clock.Advance(2 * time.Second)
+			assert.Equal(t, limit.ReservationExpired, expired.State())
+			assert.Equal(t, "expired", expired.State().String())
+		})
+	}
+}
+
+func TestLimiter_Close(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range limiterConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := limittest.NewFakeClock(time.Unix(0, 0))
+			limiter := newLimiter(10, 1*time.Second, limit.WithClock(clock))
+			reservation, err := limiter.ReserveContext(context.Background(), nil)
+			assert.NoError(t, err)
+			if name == "LeakyBucket" {
+				assert.True(t, limiter.Allowed())
+			} else {
+				assert.True(t, limiter.AllowN(9))
+			}
+
+			waited := make(chan error)
+			go func() {
+				waited <- limiter.WaitContext(context.Background())
+			}()
+			assert.Eventually(t, func() bool { return len(limiter.Waiters()) == 1 }, time.Second, time.Millisecond)
+
+			// Blocked callers are woken and pending reservations canceled
+			assert.NoError(t, limiter.Close())
+			assert.ErrorIs(t, <-waited, limit.ErrLimiterClosed)
+			err = reservation.Consume()
+			assert.True(t, errors.Is(err, limit.ErrReservationCanceled) || errors.Is(err, limit.ErrLimiterClosed), err)
+
+			// Later calls fail, even once there is capacity again
+			clock.Advance(2 * time.Second)
+			assert.False(t, limiter.Allowed())
+			assert.Equal(t, 0, limiter.Available())
+			assert.ErrorIs(t, limiter.WaitContext(context.Background()), limit.ErrLimiterClosed)
+			assert.ErrorIs(t, limiter.Reserve(nil).Consume(), limit.ErrLimiterClosed)
+			_, err = limiter.ReserveContext(context.Background(), nil)
+			assert.ErrorIs(t, err, limit.ErrLimiterClosed)
+			assert.Positive(t, limiter.Stats().DeniedByReason[limit.ReasonClosed])
+
+			assert.NoError(t, limiter.Close())
+		})
+	}
+}
+
+func TestLimiter_StatsQueueDepthAndPendingReservations(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range limiterConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := limittest.NewFakeClock(time.Unix(0, 0))
+			limiter := newLimiter(10, 1*time.Second, limit.WithClock(clock))
+			for range 2 {
+				_, err := limiter.ReserveContext(context.Background(), nil)
+				assert.NoError(t, err)
+			}
+			if name == "LeakyBucket" {
+				assert.True(t, limiter.Allowed())
+			} else {
+				assert.True(t, limiter.AllowN(8))
+			}
+			assert.Equal(t, 2, limiter.Stats().PendingReservations)
+			assert.Equal(t, 0, limiter.Stats().QueueDepth)
+
+			// A blocked caller counts until it gives up
+			ctx, cancel := context.WithCancel(context.Background())
+			waited := make(chan error)
+			go func() {
+				waited <- limiter.WaitContext(ctx)
+			}()
+			assert.Eventually(t, func() bool { return limiter.Stats().QueueDepth == 1 }, time.Second, time.Millisecond)
+			cancel()
+			assert.ErrorIs(t, <-waited, context.Canceled)
+			assert.Equal(t, 0, limiter.Stats().QueueDepth)
+		})
+	}
+}
+
+func TestLimiter_StatsRemaining(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range limiterConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := limittest.NewFakeClock(time.Unix(0, 0))
+			limiter := newLimiter(10, 1*time.Second, limit.WithClock(clock))
+			assert.Equal(t, 10, limiter.Stats().Remaining)
+
+			ttl := 500 * time.Millisecond
+			_, err := limiter.ReserveN(context.Background(), 3, &ttl)
+			assert.NoError(t, err)
+			assert.Equal(t, 7, limiter.Stats().Remaining)
+			assert.Equal(t, limiter.Available(), limiter.Stats().Remaining)
+
+			// Expired reservations and events stop counting without anything else being called
+			if name != "LeakyBucket" {
+				assert.True(t, limiter.AllowN(7))
+				assert.Equal(t, 0, limiter.Stats().Remaining)
+			}
+			clock.Advance(2 * time.Second)
+			assert.Equal(t, 10, limiter.Stats().Remaining)
+		})
+	}
+}
+
+func TestLimiter_SentinelErrors(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range limiterConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := limittest.NewFakeClock(time.Unix(0, 0))
+			limiter := newLimiter(10, 1*time.Second, limit.WithClock(clock))
+
+			reservation := limiter.Reserve(nil)
+			assert.NoError(t, reservation.Consume())
+			assert.ErrorIs(t, reservation.Consume(), limit.ErrReservationConsumed)
+
+			reservation = limiter.Reserve(nil)
+			reservation.Cancel()
+			assert.ErrorIs(t, reservation.Consume(), limit.ErrReservationCanceled)
+
+			ttl := 1 * time.Second
+			reservation = limiter.Reserve(&ttl)
+			clock.Advance(2 * time.Second)
+			assert.ErrorIs(t, reservation.Consume(), limit.ErrReservationExpired)
+
+			if name == "LeakyBucket" {
+				_, err := limiter.ReserveN(context.Background(), 10, nil)
+				assert.NoError(t, err)
+				_, err = limiter.ReserveContext(context.Background(), nil)
+				assert.ErrorIs(t, err, limit.ErrQueueFull)
+				assert.ErrorIs(t, limiter.WaitN(11), limit.ErrQueueFull)
+			}
+
+			assert.NoError(t, limiter.Close())
+			assert.ErrorIs(t, limiter.Reserve(nil).Consume(), limit.ErrLimiterClosed)
+		})
+	}
+}
+
+func TestLimiter_ReserveContext_ReturnsReservationOrError(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range limiterConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			limiter := newLimiter(1, 1*time.Second)
+
+			res, err := limiter.ReserveContext(context.Background(), nil)
+			assert.NoError(t, err)
+			assert.NotNil(t, res)
+
+			res, err = limiter.ReserveTimeout(50*time.Millisecond, nil)
+			assert.Error(t, err)
+			assert.Nil(t, res)
+		})
+	}
+}
+
+func TestLimiter_PreCanceledContext_DoesNotConsume(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range limiterConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			limiter := newLimiter(1, 1*time.Second)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			assert.ErrorIs(t, limiter.WaitContext(ctx), context.Canceled)
+			assert.ErrorIs(t, limiter.WaitTimeout(0), context.DeadlineExceeded)
+
+			res, err := limiter.ReserveContext(ctx, nil)
+			assert.ErrorIs(t, err, context.Canceled)
+			assert.Nil(t, res)
+
+			res, err = limiter.ReserveTimeout(0, nil)
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+			assert.Nil(t, res)
+
+			stats := limiter.Stats()
+			assert.Equal(t, 0, stats.AllowedRequests)
+			assert.Equal(t, 4, stats.DeniedRequests)
+			assert.Equal(t, 4, stats.DeniedByReason[limit.ReasonContext])
+
+			// No capacity was consumed
+			assert.True(t, limiter.Allowed())
+		})
+	}
+}
+
+func TestLimiter_ContextErrors_AreWrapped(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range limiterConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			limiter := newLimiter(1, 1*time.Second, limit.WithName("upstream"))
+			assert.True(t, limiter.Allowed())
+
+			err := limiter.WaitTimeout(50 * time.Millisecond)
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+			var limitErr *limit.LimitError
+			if assert.ErrorAs(t, err, &limitErr) {
+				assert.Equal(t, "upstream", limitErr.Limiter)
+				assert.GreaterOrEqual(t, limitErr.Waited, 50*time.Millisecond)
+			}
+			assert.Contains(t, err.Error(), `"upstream"`)
+		})
+	}
+}
+
+func TestLimiter_ContextErrors_UseCause(t *testing.T) {
+	t.Parallel()
+
+	cause := errors.New("client went away")
+
+	for name, newLimiter := range limiterConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			limiter := newLimiter(1, 1*time.Second)
+
+			ctx, cancel := context.WithCancelCause(context.Background())
+			cancel(cause)
+
+			err := limiter.WaitContext(ctx)
+			assert.ErrorIs(t, err, cause)
+			assert.ErrorIs(t, err, context.Canceled)
+
+			_, err = limiter.ReserveContext(ctx, nil)
+			assert.ErrorIs(t, err, cause)
+			assert.ErrorIs(t, err, context.Canceled)
+		})
+	}
+}
+
+func TestLimiter_WaitTimeout_FakeClock(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range limiterConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := limittest.NewFakeClock(time.Now())
+			limiter := newLimiter(1, 10*time.Second, limit.WithClock(clock))
+			assert.True(t, limiter.Allowed())
+
+			done := make(chan error)
+			go func() {
+				done <- limiter.WaitTimeout(1 * time.Second)
+			}()
+
+			// Both the timeout and the limiter are waiting on the clock
+			clock.BlockUntil(2)
+			clock.Advance(1 * time.Second)
+
+			err := <-done
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+			var limitErr *limit.LimitError
+			if assert.ErrorAs(t, err, &limitErr) {
+				assert.Equal(t, 1*time.Second, limitErr.Waited)
+				assert.Equal(t, limit.ReasonContext, limitErr.Reason)
+				// The next request is allowed 10s after the first one
+				assert.InDelta(t, 9*time.Second, limitErr.RetryAfter, float64(time.Millisecond))
+			}
+		})
+	}
+}
+
+func TestLimiter_ReservationExpiry(t *testing.T) {
+	t.Parallel()
+
+	ttl := 1 * time.Second
+	short := 100 * time.Millisecond
+
+	for _, tt := range []struct {
+		name        string
+		opts        []limit.Option
+		ttl         *time.Duration
+		ctxTimeout  time.Duration
+		wantExpired bool
+	}{
+		{name: "TTLIgnoresContextDeadline", ttl: &ttl, ctxTimeout: short, wantExpired: false},
+		{name: "NilTTLNeverExpires", ttl: nil, ctxTimeout: short, wantExpired: false},
+		{name: "ShortTTLExpires", ttl: &short, ctxTimeout: 1 * time.Second, wantExpired: true},
+		{
+			name:        "ContextDeadlineCapsTTL",
+			opts:        []limit.Option{limit.WithReservationTTLFromContext()},
+			ttl:         &ttl,
+			ctxTimeout:  short,
+			wantExpired: true,
+		},
+		{
+			name:        "ContextDeadlineCapsNilTTL",
+			opts:        []limit.Option{limit.WithReservationTTLFromContext()},
+			ttl:         nil,
+			ctxTimeout:  short,
+			wantExpired: true,
+		},
+	} {
+		for name, newLimiter := range limiterConstructors {
+			t.Run(tt.name+"/"+name, func(t *testing.T) {
+				t.Parallel()
+
+				limiter := newLimiter(5, 1*time.Second, tt.opts...)
+
+				ctx, cancel := context.WithTimeout(context.Background(), tt.ctxTimeout)
+				defer cancel()
+				res, err := limiter.ReserveContext(ctx, tt.ttl)
+				assert.NoError(t, err)
+
+				time.Sleep(short + 50*time.Millisecond)
+				if tt.wantExpired {
+					assert.Error(t, res.Consume())
+				} else {
+					assert.NoError(t, res.Consume())
+				}
+			})
+		}
+	}
+}
+
+func TestLimiter_Cancel_WakesBlockedCallers(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range limiterConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			// Nothing frees up on its own for 10 seconds
+			limiter := newLimiter(1, 10*time.Second)
+			held := limiter.Reserve(nil)
+
+			done := make(chan limit.Reservation)
+			go func() {
+				done <- limiter.Reserve(nil)
+			}()
+
+			time.Sleep(50 * time.Millisecond)
+			held.Cancel()
+
+			select {
+			case res := <-done:
+				assert.NotNil(t, res)
+			case <-time.After(1 * time.Second):
+				t.Fatal("blocked Reserve was not woken by Cancel")
+			}
+		})
+	}
+}
+
+func TestLimiter_Stress(t *testing.T) {
+	t.Parallel()
+
+	const (
+		goroutines = 32
+		operations = 50
+	)
+
+	for name, newLimiter := range limiterConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			limiter := newLimiter(20, 10*time.Millisecond)
+			ttl := 2 * time.Millisecond
+
+			var allowed atomic.Int64
+			var wg sync.WaitGroup
+			for g := range goroutines {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					rng := rand.New(rand.NewPCG(uint64(g), 0))
+					for range operations {
+						switch rng.IntN(10) {
+						case 0:
+							limiter.Clear()
+						case 1:
+							_ = limiter.Stats()
+						case 2, 3:
+							if limiter.Allowed() {
+								allowed.Add(1)
+							}
+						case 4, 5:
+							if limiter.WaitTimeout(time.Duration(rng.IntN(3))*time.Millisecond) == nil {
+								allowed.Add(1)
+							}
+						default:
+							var reservationTTL *time.Duration
+							if rng.IntN(2) == 0 {
+								reservationTTL = &ttl
+							}
+							res, err := limiter.ReserveTimeout(time.Duration(rng.IntN(3))*time.Millisecond, reservationTTL)
+							if err != nil {
+								continue
+							}
+							if rng.IntN(3) == 0 {
+								res.Cancel()
+							} else if res.Consume() == nil {
+								allowed.Add(1)
+							}
+						}
+					}
+				}()
+			}
+			wg.Wait()
+
+			assert.Equal(t, int(allowed.Load()), limiter.Stats().AllowedRequests)
+			if name == "LeakyBucket" {
+				assert.Equal(t, 0, limit.QueueDepth(limiter))
+			}
+		})
+	}
+}
+
+func TestLimiter_Labels(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range limiterConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			labels := map[string]string{"team": "payments", "tier": "gold"}
+			limiter := newLimiter(1, 1*time.Second, limit.WithLabels(labels))
+
+			// Neither the map passed in nor the one returned change the limiter's labels
+			labels["team"] = "search"
+			got := limiter.Labels()
+			assert.Equal(t, map[string]string{"team": "payments", "tier": "gold"}, got)
+			got["tier"] = "free"
+			assert.Equal(t, map[string]string{"team": "payments", "tier": "gold"}, limiter.Labels())
+
+			assert.Empty(t, newLimiter(1, 1*time.Second).Labels())
+		})
+	}
+}
+
+func TestLimiter_PendingReservationAges(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range limiterConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := limittest.NewFakeClock(time.Now())
+			limiter := newLimiter(10, 1*time.Second, limit.WithClock(clock))
+			assert.Empty(t, limiter.PendingReservationAges(10))
+
+			first := limiter.Reserve(nil)
+			clock.Advance(5 * time.Second)
+			second := limiter.Reserve(nil)
+			clock.Advance(2 * time.Second)
+			assert.Equal(t, []time.Duration{7 * time.Second, 2 * time.Second}, limiter.PendingReservationAges(10))
+			assert.Equal(t, []time.Duration{7 * time.Second}, limiter.PendingReservationAges(1))
+
+			first.Cancel()
+			assert.Equal(t, []time.Duration{2 * time.Second}, limiter.PendingReservationAges(10))
+			second.Cancel()
+			assert.Empty(t, limiter.PendingReservationAges(10))
+		})
+	}
+}
+
+func TestLimiter_Waiters(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range limiterConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := limittest.NewFakeClock(time.Now())
+			limiter := newLimiter(3, 1*time.Hour, limit.WithClock(clock))
+			for i := 0; i < 3; i++ {
+				limiter.Allowed()
+			}
+			assert.Empty(t, limiter.Waiters())
+
+			ctx, cancel := context.WithCancel(context.Background())
+			deadline := time.Now().Add(1 * time.Hour)
+			exportCtx, cancelExport := context.WithDeadline(limit.WithTag(ctx, "report-export"), deadline)
+			defer cancelExport()
+
+			var wg sync.WaitGroup
+			for i, waitCtx := range []context.Context{exportCtx, limit.WithTag(ctx, "sync"), ctx} {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_ = limiter.WaitContext(waitCtx)
+				}()
+				assert.Eventually(t, func() bool { return len(limiter.Waiters()) == i+1 }, 1*time.Second, 1*time.Millisecond)
+				clock.Advance(1 * time.Second)
+			}
+
+			assert.Equal(t, []limit.WaiterInfo{
+				{Waiting: 3 * time.Second, Deadline: deadline, Tag: "report-export"},
+				{Waiting: 2 * time.Second, Tag: "sync"},
+				{Waiting: 1 * time.Second},
+			}, limiter.Waiters())
+
+			cancel()
+			wg.Wait()
+			assert.Empty(t, limiter.Waiters())
+		})
+	}
+}
+
+func TestLimiter_CancelWaiters(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range limiterConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := limittest.NewFakeClock(time.Now())
+			limiter := newLimiter(3, 1*time.Hour, limit.WithClock(clock))
+			for i := 0; i < 3; i++ {
+				limiter.Allowed()
+			}
+			assert.Equal(t, 0, limiter.CancelWaiters(nil))
+
+			shedding := errors.New("shedding load")
+			errs := make(chan error, 3)
+			for i := 0; i < 3; i++ {
+				go func() { errs <- limiter.WaitContext(context.Background()) }()
+			}
+			assert.Eventually(t, func() bool { return len(limiter.Waiters()) == 3 }, 1*time.Second, 1*time.Millisecond)
+
+			assert.Equal(t, 3, limiter.CancelWaiters(shedding))
+			for i := 0; i < 3; i++ {
+				assert.ErrorIs(t, <-errs, shedding)
+			}
+			assert.Equal(t, 3, limiter.Stats().DeniedByReason[limit.ReasonCanceled])
+			if name == "LeakyBucket" {
+				assert.Equal(t, 0, limit.QueueDepth(limiter))
+			}
+
+			// Unlike Clear the limiter's state is left as it was, and it keeps working for new callers
+			assert.False(t, limiter.Allowed())
+			go func() { errs <- limiter.WaitContext(context.Background()) }()
+			assert.Eventually(t, func() bool { return len(limiter.Waiters()) == 1 }, 1*time.Second, 1*time.Millisecond)
+			clock.Advance(1*time.Hour + 1*time.Millisecond)
+			assert.NoError(t, <-errs)
+
+			drained := 0
+			for limiter.Allowed() {
+				drained++
+			}
+			go func() { errs <- limiter.WaitContext(context.Background()) }()
+			assert.Eventually(t, func() bool { return len(limiter.Waiters()) == 1 }, 1*time.Second, 1*time.Millisecond)
+			assert.Equal(t, 1, limiter.CancelWaiters(nil))
+			assert.ErrorIs(t, <-errs, limit.ErrWaitCanceled)
+		})
+	}
+}
+
+func TestLimiter_StartTime(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range limiterConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			created := time.Date(2026, time.June, 1, 0, 0, 0, 0, time.UTC)
+			start := created.Add(24 * time.Hour)
+			clock := limittest.NewFakeClock(created)
+			limiter := newLimiter(3, 1*time.Second, limit.WithClock(clock), limit.WithStartTime(start))
+
+			assert.False(t, limiter.Allowed())
+			stats := limiter.Stats()
+			assert.Equal(t, map[limit.Reason]int{limit.ReasonNotStarted: 1}, stats.DeniedByReason)
+			assert.Equal(t, start, stats.NextAllowedTime)
+
+			done := make(chan error, 1)
+			go func() { done <- limiter.WaitContext(context.Background()) }()
+			clock.BlockUntil(1)
+			clock.Advance(24*time.Hour - 1*time.Millisecond)
+			assert.False(t, limiter.Allowed())
+
+			clock.Advance(1 * time.Millisecond)
+			assert.NoError(t, <-done)
+
+			// A day of waiting doesn't build up more than the limiter allows at once
+			admitted := 0
+			for limiter.Allowed() {
+				admitted++
+			}
+			assert.LessOrEqual(t, admitted, 2)
+		})
+	}
+}
+
+func TestLimiter_MaxWait(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range limiterConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := limittest.NewFakeClock(time.Now())
+			limiter := newLimiter(1, 10*time.Second, limit.WithClock(clock), limit.WithMaxWait(2*time.Second), limit.WithName("checkout"))
+			assert.True(t, limiter.Allowed())
+
+			// The next slot is 10s away, more than the limiter lets callers wait
+			err := limiter.WaitContext(context.Background())
+			assert.ErrorIs(t, err, limit.ErrWaitTooLong)
+			var tooLong *limit.WaitTooLongError
+			if assert.ErrorAs(t, err, &tooLong) {
+				assert.Equal(t, limit.WaitTooLongError{Limiter: "checkout", Estimate: 10 * time.Second, MaxWait: 2 * time.Second}, *tooLong)
+			}
+			assert.ErrorIs(t, limiter.Reserve(nil).Consume(), limit.ErrWaitTooLong)
+			assert.Equal(t, 2, limiter.Stats().DeniedByReason[limit.ReasonWaitTooLong])
+			assert.Empty(t, limiter.Waiters())
+
+			// Closer to the next slot the wait is short enough, and the callers turned away didn't use it up
+			clock.Advance(8 * time.Second)
+			done := make(chan error, 1)
+			go func() { done <- limiter.WaitContext(context.Background()) }()
+			clock.BlockUntil(1)
+			clock.Advance(2*time.Second + 1*time.Millisecond)
+			assert.NoError(t, <-done)
+		})
+	}
+}
+
+func TestLimiter_MaxWait_ShrinksAfterCancel(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range leaserConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			limiter := newLimiter(1, 1*time.Hour, limit.WithMaxWait(2*time.Second))
+			reservation := limiter.Reserve(nil)
+			assert.ErrorIs(t, limiter.WaitContext(context.Background()), limit.ErrWaitTooLong)
+
+			// Canceling the reservation frees its capacity, so the next caller doesn't wait at all
+			reservation.Cancel()
+			assert.NoError(t, limiter.WaitContext(context.Background()))
+		})
+	}
+}
+
+func TestLimiter_MaxWaiters(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range limiterConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := limittest.NewFakeClock(time.Unix(0, 0))
+			limiter := newLimiter(1, 1*time.Hour, limit.WithClock(clock), limit.WithMaxWaiters(1))
+			assert.True(t, limiter.Allowed())
+
+			waited := make(chan error)
+			go func() {
+				waited <- limiter.WaitContext(context.Background())
+			}()
+			clock.BlockUntil(1)
+
+			// There's no room for a second waiter
+			err := limiter.WaitContext(context.Background())
+			assert.ErrorIs(t, err, limit.ErrQueueFull)
+			assert.Equal(t, 1, limiter.Stats().DeniedByReason[limit.ReasonQueueFull])
+
+			clock.Advance(1*time.Hour + time.Nanosecond)
+			assert.NoError(t, <-waited)
+		})
+	}
+}
+
+func TestLimiter_DefaultReservationTTL(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range limiterConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := limittest.NewFakeClock(time.Unix(0, 0))
+			limiter := newLimiter(10, 1*time.Second, limit.WithClock(clock), limit.WithDefaultReservationTTL(5*time.Second))
+			expiresAt, ok := limiter.Reserve(nil).ExpiresAt()
+			assert.True(t, ok)
+			assert.Equal(t, clock.Now().Add(5*time.Second), expiresAt)
+
+			// An explicit TTL wins
+			ttl := 1 * time.Second
+			expiresAt, _ = limiter.Reserve(&ttl).ExpiresAt()
+			assert.Equal(t, clock.Now().Add(ttl), expiresAt)
+		})
+	}
+}
+
+func TestLimiter_LinkedReservations(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range limiterConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			limiter := newLimiter(1, 1*time.Hour, limit.WithLinkedReservations())
+			ctx, cancel := context.WithCancel(context.Background())
+			reservation, err := limiter.ReserveContext(ctx, nil)
+			assert.NoError(t, err)
+
+			// The caller goes away without canceling, the reservation cancels itself
+			cancel()
+			assert.Eventually(t, func() bool { return len(limiter.PendingReservationAges(1)) == 0 }, 1*time.Second, 1*time.Millisecond)
+			assert.ErrorIs(t, reservation.Consume(), limit.ErrReservationCanceled)
+			assert.True(t, limiter.Allowed())
+		})
+	}
+}
+
+func TestLimiter_LinkedReservations_ConsumedFirst(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range leaserConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			limiter := newLimiter(1, 1*time.Hour, limit.WithLinkedReservations())
+			ctx, cancel := context.WithCancel(context.Background())
+			reservation, err := limiter.ReserveContext(ctx, nil)
+			assert.NoError(t, err)
+			assert.NoError(t, reservation.Consume())
+
+			// Ending the context after consuming doesn't give the capacity back
+			cancel()
+			time.Sleep(10 * time.Millisecond)
+			assert.False(t, limiter.Allowed())
+			assert.Equal(t, 1, limiter.Stats().AllowedRequests)
+		})
+	}
+}
+
+func TestLimiter_LinkedReservations_ConsumeRacesCancel(t *testing.T) {
+	t.Parallel()
+
+	limiter := limit.NewTokenBucket(1000, 1*time.Hour, limit.WithLinkedReservations())
+	consumed := 0
+	for i := 0; i < 200; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		reservation, err := limiter.ReserveContext(ctx, nil)
+		assert.NoError(t, err)
+
+		go cancel()
+		err = reservation.Consume()
+		if err == nil {
+			consumed++
+		} else {
+			assert.ErrorIs(t, err, limit.ErrReservationCanceled)
+		}
+	}
+
+	// Every reservation is either consumed or canceled, never both
+	assert.Eventually(t, func() bool { return len(limiter.PendingReservationAges(1)) == 0 }, 1*time.Second, 1*time.Millisecond)
+	assert.Equal(t, consumed, limiter.Stats().AllowedRequests)
+	assert.Equal(t, 1000-consumed, limiter.Info().Remaining)
+}
+
+func TestLimiter_UnlinkedReservationsOutliveContext(t *testing.T) {
+	t.Parallel()
+
+	limiter := limit.NewTokenBucket(1, 1*time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	reservation, err := limiter.ReserveContext(ctx, nil)
+	assert.NoError(t, err)
+
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, reservation.Consume())
+}
+
+func TestLimiter_AbandonedReservations(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range limiterConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := limittest.NewFakeClock(time.Now())
+			reports := make(chan limit.ReservationInfo, 4)
+			limiter := newLimiter(3, 1*time.Hour, limit.WithClock(clock), limit.WithName("vendor"), limit.WithReservationStacks(),
+				limit.WithAbandonedReservationDetector(1*time.Minute, func(info limit.ReservationInfo) { reports <- info }))
+
+			reservedAt := clock.Now()
+			_ = limiter.Reserve(nil) // Forgotten
+			assert.NoError(t, limiter.Reserve(nil).Consume())
+			limiter.Reserve(nil).Cancel()
+			ttl := 1 * time.Second
+			_ = limiter.Reserve(&ttl) // Expires on its own
+
+			clock.Advance(1 * time.Minute)
+			select {
+			case info := <-reports:
+				assert.Equal(t, "vendor", info.Limiter)
+				assert.Equal(t, reservedAt, info.ReservedAt)
+				assert.Contains(t, string(info.Stack), "TestLimiter_AbandonedReservations")
+			case <-time.After(1 * time.Second):
+				t.Fatal("the forgotten reservation wasn't reported")
+			}
+			assert.Equal(t, 1, limiter.Stats().AbandonedReservations)
+
+			select {
+			case info := <-reports:
+				t.Fatalf("unexpected report of a reservation taken at %s", info.ReservedAt)
+			case <-time.After(50 * time.Millisecond):
+			}
+		})
+	}
+}
+
+func TestLimiter_ClockAnomalies(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range limiterConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := limittest.NewFakeClock(time.Now())
+			anomalies := make(chan limit.ClockAnomaly, 10)
+			limiter := newLimiter(2, 1*time.Second, limit.WithClock(clock), limit.WithName(name),
+				limit.WithClockAnomalies(1*time.Second, func(anomaly limit.ClockAnomaly) {
+					anomalies <- anomaly
+				}))
+			drained := 0
+			for limiter.Allowed() {
+				drained++
+			}
+
+			// Steps within the tolerance are healed without being reported
+			clock.Set(clock.Now().Add(-500 * time.Millisecond))
+			limiter.Allowed()
+			assert.Equal(t, 0, limiter.Stats().ClockAnomalies)
+
+			// The limiter's timestamps are an hour ahead, as if restored from a host with a fast clock
+			clock.Set(clock.Now().Add(-1 * time.Hour))
+			assert.False(t, limiter.Allowed())
+			assert.Equal(t, 1, limiter.Stats().ClockAnomalies)
+			select {
+			case anomaly := <-anomalies:
+				assert.Equal(t, name, anomaly.Limiter)
+				assert.InDelta(t, float64(1*time.Hour), float64(anomaly.Ahead), float64(1*time.Second))
+				assert.Equal(t, clock.Now(), anomaly.At)
+			case <-time.After(1 * time.Second):
+				assert.Fail(t, "the anomaly wasn't reported")
+			}
+
+			// It admits again within one interval instead of an hour
+			clock.Advance(1*time.Second + time.Millisecond)
+			assert.True(t, limiter.Allowed())
+			assert.Equal(t, 1, limiter.Stats().ClockAnomalies)
+		})
+	}
+}
+
+func TestLimiter_ReservationOversubscription(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range map[string]func(count int, duration time.Duration, opts ...limit.Option) limit.Limiter{
+		"token bucket":   limit.NewTokenBucket,
+		"rolling window": limit.NewRollingWindow,
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := limittest.NewFakeClock(time.Now())
+			limiter := newLimiter(10, 1*time.Hour, limit.WithClock(clock), limit.WithReservationOversubscription(1.3))
+
+			// 13 reservations fit in a capacity of 10
+			var reservations []limit.Reservation
+			for i := 0; i < 13; i++ {
+				reservations = append(reservations, limiter.Reserve(nil))
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error)
+			go func() {
+				_, err := limiter.ReserveContext(ctx, nil)
+				done <- err
+			}()
+			clock.BlockUntil(1)
+			cancel()
+			assert.Error(t, <-done)
+			assert.False(t, limiter.Allowed())
+
+			// Some are canceled, as expected of the workload
+			reservations[0].Cancel()
+			reservations[1].Cancel()
+
+			// Only 10 can be consumed, the last one stays pending and can be canceled
+			for _, r := range reservations[2:12] {
+				assert.NoError(t, r.Consume())
+			}
+			assert.ErrorIs(t, reservations[12].Consume(), limit.ErrOversubscribed)
+			assert.ErrorIs(t, reservations[12].Consume(), limit.ErrOversubscribed)
+			assert.Equal(t, 2, limiter.Stats().Oversubscribed)
+			assert.Equal(t, 10, limiter.Stats().AllowedRequests)
+			assert.Equal(t, []time.Duration{0}, limiter.PendingReservationAges(10))
+			reservations[12].Cancel()
+			assert.Empty(t, limiter.PendingReservationAges(10))
+		})
+	}
+}
+
+func TestLimiter_ReservationOversubscription_RetriedConsume(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Now())
+	limiter := limit.NewTokenBucket(1, 1*time.Second, limit.WithClock(clock), limit.WithReservationOversubscription(2))
+	first, second := limiter.Reserve(nil), limiter.Reserve(nil)
+	assert.NoError(t, first.Consume())
+	assert.ErrorIs(t, second.Consume(), limit.ErrOversubscribed)
+
+	// Once the bucket refills the retry succeeds
+	clock.Advance(1 * time.Second)
+	assert.NoError(t, second.Consume())
+	assert.Equal(t, 1, limiter.Stats().Oversubscribed)
+}
+
+func TestLimiter_DetachedReservations(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range limiterConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := limittest.NewFakeClock(time.Now())
+			limiter := newLimiter(10, 1*time.Second, limit.WithClock(clock))
+			attacher := limiter.(limit.Attacher)
+
+			// The handle survives a round trip through a job payload, and is consumed in another goroutine
+			handle, err := limiter.Reserve(nil).Detach()
+			assert.NoError(t, err)
+			payload, err := json.Marshal(handle)
+			assert.NoError(t, err)
+			var received limit.ReservationHandle
+			assert.NoError(t, json.Unmarshal(payload, &received))
+			assert.Equal(t, handle, received)
+			done := make(chan error)
+			go func() {
+				r, err := attacher.Attach(received)
+				if err == nil {
+					err = r.Consume()
+				}
+				done <- err
+			}()
+			assert.NoError(t, <-done)
+			assert.Equal(t, 1, limiter.Stats().AllowedRequests)
+
+			// A handle attaches once
+			_, err = attacher.Attach(handle)
+			assert.ErrorIs(t, err, limit.ErrUnknownReservation)
+
+			// Detaching again returns the same handle
+			reservation := limiter.Reserve(nil)
+			first, _ := reservation.Detach()
+			second, _ := reservation.Detach()
+			assert.Equal(t, first, second)
+
+			// Consumed, canceled and expired reservations don't attach
+			clock.Advance(1 * time.Second) // The leaky bucket leaks the previous event first
+			assert.NoError(t, reservation.Consume())
+			_, err = attacher.Attach(first)
+			assert.ErrorContains(t, err, "reservation already consumed")
+			reservation = limiter.Reserve(nil)
+			handle, _ = reservation.Detach()
+			reservation.Cancel()
+			_, err = attacher.Attach(handle)
+			assert.ErrorIs(t, err, limit.ErrReservationCanceled)
+			ttl := 1 * time.Second
+			handle, _ = limiter.Reserve(&ttl).Detach()
+			assert.Equal(t, clock.Now().Add(ttl), handle.ExpiresAt)
+			clock.Advance(2 * time.Second)
+			_, err = attacher.Attach(handle)
+			assert.ErrorContains(t, err, "reservation expired")
+			_, err = limiter.Reserve(&ttl).Detach()
+			assert.NoError(t, err)
+
+			// Handles from elsewhere are unknown
+			_, err = attacher.Attach(limit.ReservationHandle{ID: "nope"})
+			assert.ErrorIs(t, err, limit.ErrUnknownReservation)
+		})
+	}
+}
+
+func TestLimiter_ConstructorValidation(t *testing.T) {
+	t.Parallel()
+
+	validating := map[string]func(count int, duration time.Duration, maxQueue int, opts ...limit.Option) (limit.Limiter, error){
+		"TokenBucket": func(count int, duration time.Duration, _ int, opts ...limit.Option) (limit.Limiter, error) {
+			return limit.NewTokenBucketE(count, duration, opts...)
+		},
+		"RollingWindow": func(count int, duration time.Duration, _ int, opts ...limit.Option) (limit.Limiter, error) {
+			return limit.NewRollingWindowE(count, duration, opts...)
+		},
+		"LeakyBucket": limit.NewLeakyBucketE,
+	}
+	panicking := map[string]func(count int, duration time.Duration, maxQueue int, opts ...limit.Option) limit.Limiter{
+		"TokenBucket": func(count int, duration time.Duration, _ int, opts ...limit.Option) limit.Limiter {
+			return limit.NewTokenBucket(count, duration, opts...)
+		},
+		"RollingWindow": func(count int, duration time.Duration, _ int, opts ...limit.Option) limit.Limiter {
+			return limit.NewRollingWindow(count, duration, opts...)
+		},
+		"LeakyBucket": limit.NewLeakyBucket,
+	}
+
+	tests := []struct {
+		name     string
+		count    int
+		duration time.Duration
+		maxQueue int
+		opts     []limit.Option
+		// The constructors rejecting the combination, the others accept it
+		invalid []string
+	}{
+		{name: "valid", count: 10, duration: 1 * time.Second, maxQueue: 10},
+		{name: "empty queue", count: 10, duration: 1 * time.Second},
+		{name: "zero count", duration: 1 * time.Second, invalid: []string{"TokenBucket", "RollingWindow", "LeakyBucket"}},
+		{name: "negative count", count: -1, duration: 1 * time.Second, invalid: []string{"TokenBucket", "RollingWindow", "LeakyBucket"}},
+		{name: "zero duration", count: 10, invalid: []string{"TokenBucket", "RollingWindow", "LeakyBucket"}},
+		{name: "negative duration", count: 10, duration: -1 * time.Second, invalid: []string{"TokenBucket", "RollingWindow", "LeakyBucket"}},
+		{name: "negative queue", count: 10, duration: 1 * time.Second, maxQueue: -1, invalid: []string{"LeakyBucket"}},
+		{name: "interval under a nanosecond", count: 1000000000, duration: 1 * time.Nanosecond, maxQueue: 1, invalid: []string{"TokenBucket", "LeakyBucket"}},
+		{name: "shared option", count: 10, duration: 1 * time.Second, opts: []limit.Option{limit.WithMaxWait(1 * time.Second)}},
+		{name: "token bucket option", count: 10, duration: 1 * time.Second, opts: []limit.Option{limit.WithBurst(20)}, invalid: []string{"RollingWindow", "LeakyBucket"}},
+		{name: "rolling window option", count: 10, duration: 1 * time.Second, opts: []limit.Option{limit.WithSmoothing(100 * time.Millisecond)}, invalid: []string{"TokenBucket", "LeakyBucket"}},
+		{name: "leaky bucket option", count: 10, duration: 1 * time.Second, opts: []limit.Option{limit.WithAdaptiveQueue(1*time.Second, 1, 10)}, invalid: []string{"TokenBucket", "RollingWindow"}},
+		{name: "keyed limiter option", count: 10, duration: 1 * time.Second, opts: []limit.Option{limit.WithMaxKeys(10)}, invalid: []string{"TokenBucket", "RollingWindow", "LeakyBucket"}},
+	}
+	for _, tt := range tests {
+		for name, constructor := range validating {
+			t.Run(tt.name+"/"+name, func(t *testing.T) {
+				t.Parallel()
+
+				limiter, err := constructor(tt.count, tt.duration, tt.maxQueue, tt.opts...)
+				if !slices.Contains(tt.invalid, name) {
+					assert.NoError(t, err)
+					assert.NotNil(t, limiter)
+					assert.NotPanics(t, func() { panicking[name](tt.count, tt.duration, tt.maxQueue, tt.opts...) })
+					return
+				}
+				assert.Error(t, err)
+				assert.Nil(t, limiter)
+				assert.PanicsWithValue(t, "limit: New"+name+": "+err.Error(), func() {
+					panicking[name](tt.count, tt.duration, tt.maxQueue, tt.opts...)
+				})
+			})
+		}
+	}
+}
+
+func TestLimiter_InapplicableOption(t *testing.T) {
+	t.Parallel()
+
+	_, err := limit.NewTokenBucketE(10, 1*time.Second, limit.WithSmoothing(1*time.Second))
+	assert.EqualError(t, err, "option WithSmoothing doesn't apply to a token bucket")
+
+	// Wrappers accept their own options on top of the ones of the limiters they create
+	assert.NotPanics(t, func() { limit.NewAdaptiveLimiter(10, 1, 100, 1*time.Second, limit.WithAdditiveIncrease(2, 5)) })
+	assert.Panics(t, func() { limit.NewAdaptiveLimiter(10, 1, 100, 1*time.Second, limit.WithSmoothing(1*time.Second)) })
+
+	// Configs share their options between algorithms
+	config := limit.Config{Algorithm: limit.AlgorithmRollingWindow, Count: 10, Per: 1 * time.Second}
+	assert.NotPanics(t, func() { config.NewLimiter(limit.WithBurst(20)) })
+}
@@ -0,0 +1,55 @@
+// Package limitotel annotates OpenTelemetry spans with the decisions of go-limit middleware.
+package limitotel
+
+import (
+	"context"
+
+	"github.com/agustinbanchio/go-limit"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The attributes and event added to spans. Their names are stable and won't change in minor releases.
+const (
+	// AttrLimited is true on requests the limiter turned down.
+	AttrLimited = "ratelimit.limited"
+	// AttrWaitMs is how long the request waited for the limiter, in milliseconds.
+	AttrWaitMs = "ratelimit.wait_ms"
+	// AttrKey is the key the request was limited by, see limit.Admission.
+	AttrKey = "ratelimit.key"
+	// AttrLimit is the limit of the limiter, as in limit.LimitInfo.
+	AttrLimit = "ratelimit.limit"
+	// AttrRemaining is what the limiter had left after deciding on the request, as in limit.LimitInfo.
+	AttrRemaining = "ratelimit.remaining"
+	// EventDenied is the event added to the spans of requests the limiter turned down, with the key and limit.
+	EventDenied = "ratelimit.denied"
+)
+
+// SpanAttributes returns a limit.AdmissionHook adding rate limit attributes to the span in the request's context when
+// the limiter turned the request down or made it wait, so traces explain the latency without new spans. Pass it to
+// limit.WithAdmissionHook or limitconnect.WithAdmissionHook. Spans that aren't recording cost a single check, the
+// limiter isn't asked for its info.
+func SpanAttributes() limit.AdmissionHook {
+	return func(ctx context.Context, admission limit.Admission) {
+		if !admission.Limited && admission.Wait <= 0 {
+			return
+		}
+		span := trace.SpanFromContext(ctx)
+		if !span.IsRecording() {
+			return
+		}
+
+		info := admission.Limiter.Info()
+		attrs := []attribute.KeyValue{
+			attribute.Bool(AttrLimited, admission.Limited),
+			attribute.Int64(AttrWaitMs, admission.Wait.Milliseconds()),
+			attribute.String(AttrKey, admission.Key),
+			attribute.Int(AttrLimit, info.Limit),
+			attribute.Int(AttrRemaining, info.Remaining),
+		}
+		span.SetAttributes(attrs...)
+		if admission.Limited {
+			span.AddEvent(EventDenied, trace.WithAttributes(attrs[2], attrs[3]))
+		}
+	}
+}
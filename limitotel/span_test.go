@@ -0,0 +1,98 @@
+package limitotel_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limitotel"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSpanAttributes(t *testing.T) {
+	t.Parallel()
+
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+
+	limiter := limit.NewTokenBucket(1, 1*time.Hour)
+	handler := limit.Middleware(limit.Routes{{Name: "search", Pattern: "/search", Limiter: limiter}},
+		limit.WithAdmissionHook(limitotel.SpanAttributes()))(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	// The server span is started before the middleware, as by otelhttp
+	serve := func() {
+		r := httptest.NewRequest("GET", "/search", nil)
+		ctx, span := tracer.Start(r.Context(), "GET /search")
+		handler.ServeHTTP(httptest.NewRecorder(), r.WithContext(ctx))
+		span.End()
+	}
+	serve()
+	serve()
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+
+	// Requests let through right away are left alone
+	assert.Empty(t, spans[0].Attributes())
+	assert.Empty(t, spans[0].Events())
+
+	assert.ElementsMatch(t, []attribute.KeyValue{
+		attribute.Bool(limitotel.AttrLimited, true),
+		attribute.Int64(limitotel.AttrWaitMs, 0),
+		attribute.String(limitotel.AttrKey, "search"),
+		attribute.Int(limitotel.AttrLimit, 1),
+		attribute.Int(limitotel.AttrRemaining, 0),
+	}, spans[1].Attributes())
+	if assert.Len(t, spans[1].Events(), 1) {
+		event := spans[1].Events()[0]
+		assert.Equal(t, limitotel.EventDenied, event.Name)
+		assert.ElementsMatch(t, []attribute.KeyValue{
+			attribute.String(limitotel.AttrKey, "search"),
+			attribute.Int(limitotel.AttrLimit, 1),
+		}, event.Attributes)
+	}
+}
+
+func TestSpanAttributes_Wait(t *testing.T) {
+	t.Parallel()
+
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+
+	ctx, span := tracer.Start(context.Background(), "call")
+	limitotel.SpanAttributes()(ctx, limit.Admission{Key: "user", Limiter: limit.NewTokenBucket(10, 1*time.Second), Wait: 1500 * time.Millisecond})
+	span.End()
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	assert.Contains(t, spans[0].Attributes(), attribute.Int64(limitotel.AttrWaitMs, 1500))
+	assert.Contains(t, spans[0].Attributes(), attribute.Bool(limitotel.AttrLimited, false))
+	assert.Empty(t, spans[0].Events())
+}
+
+// countingLimiter counts the calls to Info.
+type countingLimiter struct {
+	limit.Limiter
+	infos int
+}
+
+func (l *countingLimiter) Info() limit.LimitInfo {
+	l.infos++
+	return l.Limiter.Info()
+}
+
+func TestSpanAttributes_NonRecording(t *testing.T) {
+	t.Parallel()
+
+	// Without a span in the context the limiter isn't asked for its info
+	limiter := &countingLimiter{Limiter: limit.NewTokenBucket(1, 1*time.Hour)}
+	limitotel.SpanAttributes()(context.Background(), limit.Admission{Key: "user", Limiter: limiter, Limited: true})
+	assert.Equal(t, 0, limiter.infos)
+}
@@ -0,0 +1,82 @@
+// Package limitredis keeps the central quota of go-limit borrowing limiters in Redis.
+package limitredis
+
+import (
+	"context"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+)
+
+// Scripter runs Lua scripts on Redis, returning their integer result. It's the subset of a Redis client this package
+// uses, and a go-redis client can be adapted with:
+//
+//	func (a adapter) Eval(ctx context.Context, script string, keys []string, args ...any) (int64, error) {
+//		return a.client.Eval(ctx, script, keys, args...).Int64()
+//	}
+type Scripter interface {
+	Eval(ctx context.Context, script string, keys []string, args ...any) (int64, error)
+}
+
+// borrowScript takes up to ARGV[1] tokens from the quota at KEYS[1], starting a window of ARGV[2] tokens lasting
+// ARGV[3] milliseconds if there is none.
+const borrowScript = `
+local remaining = redis.call('GET', KEYS[1])
+if not remaining then
+	remaining = ARGV[2]
+	redis.call('SET', KEYS[1], remaining, 'PX', ARGV[3])
+end
+local granted = math.min(tonumber(remaining), tonumber(ARGV[1]))
+if granted > 0 then
+	redis.call('DECRBY', KEYS[1], granted)
+end
+return granted
+`
+
+// returnScript gives ARGV[1] tokens back to the quota at KEYS[1], up to its ARGV[2] tokens, if its window didn't end.
+const returnScript = `
+local remaining = redis.call('GET', KEYS[1])
+if not remaining then
+	return 0
+end
+local returned = math.min(tonumber(ARGV[1]), tonumber(ARGV[2]) - tonumber(remaining))
+if returned > 0 then
+	redis.call('INCRBY', KEYS[1], returned)
+end
+return returned
+`
+
+// QuotaSource is a limit.QuotaSource keeping a quota of tokens per window in a Redis key. The window starts with the
+// first borrow after the previous one expired.
+type QuotaSource struct {
+	client  Scripter
+	key     string
+	quota   limit.Rate
+	timeout time.Duration
+}
+
+// NewQuotaSource creates a QuotaSource handing out quota.Count tokens per quota.Per from key, giving up on Redis after
+// timeout.
+func NewQuotaSource(client Scripter, key string, quota limit.Rate, timeout time.Duration) *QuotaSource {
+	return &QuotaSource{client: client, key: key, quota: quota, timeout: timeout}
+}
+
+func (q *QuotaSource) Borrow(n int) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), q.timeout)
+	defer cancel()
+
+	granted, err := q.client.Eval(ctx, borrowScript, []string{q.key}, n, q.quota.Count, q.quota.Per.Milliseconds())
+	if err != nil {
+		return 0, err
+	}
+	return int(granted), nil
+}
+
+// Return gives n tokens back to the current window. Tokens returned after their window ended, or while Redis fails,
+// are dropped.
+func (q *QuotaSource) Return(n int) {
+	ctx, cancel := context.WithTimeout(context.Background(), q.timeout)
+	defer cancel()
+
+	_, _ = q.client.Eval(ctx, returnScript, []string{q.key}, n, q.quota.Count)
+}
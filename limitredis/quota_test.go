@@ -0,0 +1,86 @@
+package limitredis_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limitredis"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRedis runs the scripts of the package against a map, without expiring keys.
+type fakeRedis struct {
+	mux    sync.Mutex
+	values map[string]int64
+	err    error
+}
+
+func (r *fakeRedis) Eval(_ context.Context, script string, keys []string, args ...any) (int64, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if r.err != nil {
+		return 0, r.err
+	}
+	remaining, ok := r.values[keys[0]]
+	n := int64(args[0].(int))
+	quota := int64(args[1].(int))
+	switch {
+	case strings.Contains(script, "DECRBY"):
+		if !ok {
+			remaining = quota
+		}
+		granted := min(remaining, n)
+		r.values[keys[0]] = remaining - granted
+		return granted, nil
+	case ok:
+		returned := max(min(n, quota-remaining), 0)
+		r.values[keys[0]] = remaining + returned
+		return returned, nil
+	default:
+		return 0, nil
+	}
+}
+
+func TestQuotaSource(t *testing.T) {
+	t.Parallel()
+
+	redis := &fakeRedis{values: make(map[string]int64)}
+	source := limitredis.NewQuotaSource(redis, "quota:search", limit.Rate{Count: 250, Per: 1 * time.Minute}, 1*time.Second)
+
+	granted, err := source.Borrow(100)
+	assert.NoError(t, err)
+	assert.Equal(t, 100, granted)
+	granted, _ = source.Borrow(100)
+	assert.Equal(t, 100, granted)
+	granted, _ = source.Borrow(100)
+	assert.Equal(t, 50, granted)
+	assert.Equal(t, int64(0), redis.values["quota:search"])
+
+	// Tokens given back can be borrowed again, but never past the quota
+	source.Return(30)
+	assert.Equal(t, int64(30), redis.values["quota:search"])
+	source.Return(300)
+	assert.Equal(t, int64(250), redis.values["quota:search"])
+
+	redis.err = errors.New("connection refused")
+	_, err = source.Borrow(100)
+	assert.EqualError(t, err, "connection refused")
+}
+
+func TestQuotaSource_BorrowingLimiter(t *testing.T) {
+	t.Parallel()
+
+	redis := &fakeRedis{values: make(map[string]int64)}
+	source := limitredis.NewQuotaSource(redis, "quota:search", limit.Rate{Count: 250, Per: 1 * time.Minute}, 1*time.Second)
+	limiter := limit.NewBorrowingLimiter(source, 100, 10)
+
+	assert.True(t, limiter.Allowed())
+	assert.Equal(t, 99, limiter.ReturnUnused())
+	assert.Equal(t, int64(249), redis.values["quota:search"])
+}
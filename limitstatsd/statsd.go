@@ -0,0 +1,106 @@
+// Package limitstatsd periodically sends the stats of go-limit limiters to StatsD or DogStatsD.
+package limitstatsd
+
+import (
+	"context"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+)
+
+// StatsdClient is the subset of a StatsD client used to send metrics. It matches the DataDog client, and other clients
+// can be adapted with a few lines.
+type StatsdClient interface {
+	Count(name string, value int64, tags []string, rate float64) error
+	Gauge(name string, value float64, tags []string, rate float64) error
+}
+
+// Attach sends the stats of l to client every flushEvery until ctx is done, flushing one last time then. The returned
+// channel is closed once the last flush is done.
+//
+// Counters are sent as deltas since the previous flush: name.allowed, name.denied tagged with the reason, which
+// includes expired reservations, and name.abandoned_reservations. Gauges are name.remaining, name.waiters and
+// name.pending_reservations. Every metric is tagged with the limiter's labels.
+//
+// The flushes run on their own goroutine and take the limiter's lock only to read its stats, so a slow client doesn't
+// hold up the limiter. Errors from the client are ignored, and a nil client sends nothing.
+func Attach(ctx context.Context, l limit.Limiter, client StatsdClient, name string, flushEvery time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	if client == nil {
+		close(done)
+		return done
+	}
+
+	s := &sink{limiter: l, client: client, name: name, tags: tags(l.Labels()), last: l.Stats()}
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(flushEvery)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				s.flush()
+				return
+			case <-ticker.C:
+				s.flush()
+			}
+		}
+	}()
+	return done
+}
+
+// sink sends the stats of a limiter, remembering the counters it last sent.
+type sink struct {
+	limiter limit.Limiter
+	client  StatsdClient
+	name    string
+	tags    []string
+	last    limit.Stats
+}
+
+func (s *sink) flush() {
+	stats := s.limiter.Stats()
+	info := s.limiter.Info()
+	waiters := len(s.limiter.Waiters())
+	pending := len(s.limiter.PendingReservationAges(math.MaxInt))
+
+	s.count("allowed", stats.AllowedRequests-s.last.AllowedRequests, s.tags)
+	for _, reason := range sortedReasons(stats.DeniedByReason) {
+		delta := stats.DeniedByReason[reason] - s.last.DeniedByReason[reason]
+		s.count("denied", delta, append(slices.Clip(s.tags), "reason:"+string(reason)))
+	}
+	s.count("abandoned_reservations", stats.AbandonedReservations-s.last.AbandonedReservations, s.tags)
+	s.last = stats
+
+	_ = s.client.Gauge(s.name+".remaining", float64(info.Remaining), s.tags, 1)
+	_ = s.client.Gauge(s.name+".waiters", float64(waiters), s.tags, 1)
+	_ = s.client.Gauge(s.name+".pending_reservations", float64(pending), s.tags, 1)
+}
+
+// count sends a counter delta, skipping the ones that didn't move.
+func (s *sink) count(metric string, delta int, tags []string) {
+	if delta > 0 {
+		_ = s.client.Count(s.name+"."+metric, int64(delta), tags, 1)
+	}
+}
+
+// tags turns labels into key:value tags, sorted so they are the same on every flush.
+func tags(labels map[string]string) []string {
+	tags := make([]string, 0, len(labels))
+	for k, v := range labels {
+		tags = append(tags, k+":"+v)
+	}
+	slices.Sort(tags)
+	return tags
+}
+
+func sortedReasons(byReason map[limit.Reason]int) []limit.Reason {
+	reasons := make([]limit.Reason, 0, len(byReason))
+	for reason := range byReason {
+		reasons = append(reasons, reason)
+	}
+	slices.Sort(reasons)
+	return reasons
+}
@@ -0,0 +1,123 @@
+package limitstatsd_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limitstatsd"
+	"github.com/stretchr/testify/assert"
+)
+
+type metric struct {
+	name  string
+	value float64
+	tags  string
+}
+
+// fakeClient records the metrics it's sent.
+type fakeClient struct {
+	mux     sync.Mutex
+	counts  []metric
+	gauges  map[string]float64
+	blocked chan struct{}
+}
+
+func (c *fakeClient) Count(name string, value int64, tags []string, _ float64) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.counts = append(c.counts, metric{name: name, value: float64(value), tags: join(tags)})
+	return nil
+}
+
+func (c *fakeClient) Gauge(name string, value float64, _ []string, _ float64) error {
+	if c.blocked != nil {
+		<-c.blocked
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.gauges[name] = value
+	return nil
+}
+
+// total sums the counts sent for name with tags.
+func (c *fakeClient) total(name, tags string) float64 {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	var total float64
+	for _, m := range c.counts {
+		if m.name == name && m.tags == tags {
+			total += m.value
+		}
+	}
+	return total
+}
+
+func join(tags []string) string {
+	var s string
+	for i, tag := range tags {
+		if i > 0 {
+			s += ","
+		}
+		s += tag
+	}
+	return s
+}
+
+func TestAttach(t *testing.T) {
+	t.Parallel()
+
+	limiter := limit.NewTokenBucket(3, 1*time.Hour, limit.WithLabels(map[string]string{"team": "payments", "dep": "vendor"}))
+	client := &fakeClient{gauges: make(map[string]float64)}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := limitstatsd.Attach(ctx, limiter, client, "vendor_api", 10*time.Millisecond)
+
+	assert.True(t, limiter.Allowed())
+	_ = limiter.Reserve(nil)
+	assert.Eventually(t, func() bool { return client.total("vendor_api.allowed", "dep:vendor,team:payments") == 1 }, 1*time.Second, 1*time.Millisecond)
+
+	for i := 0; i < 4; i++ {
+		limiter.Allowed()
+	}
+	cancel()
+	<-done
+
+	// Counters add up to the stats however the flushes split them
+	assert.Equal(t, float64(2), client.total("vendor_api.allowed", "dep:vendor,team:payments"))
+	assert.Equal(t, float64(3), client.total("vendor_api.denied", "dep:vendor,team:payments,reason:limited"))
+	assert.Equal(t, map[string]float64{
+		"vendor_api.remaining":            0,
+		"vendor_api.waiters":              0,
+		"vendor_api.pending_reservations": 1,
+	}, client.gauges)
+}
+
+func TestAttach_SlowClientDoesNotBlockLimiter(t *testing.T) {
+	t.Parallel()
+
+	limiter := limit.NewTokenBucket(1000, 1*time.Second)
+	client := &fakeClient{gauges: make(map[string]float64), blocked: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := limitstatsd.Attach(ctx, limiter, client, "api", 1*time.Millisecond)
+
+	// The client is stuck in its first flush, the limiter keeps working
+	time.Sleep(10 * time.Millisecond)
+	for i := 0; i < 10; i++ {
+		assert.True(t, limiter.Allowed())
+	}
+
+	cancel()
+	close(client.blocked)
+	<-done
+}
+
+func TestAttach_NilClient(t *testing.T) {
+	t.Parallel()
+
+	done := limitstatsd.Attach(context.Background(), limit.NewTokenBucket(1, 1*time.Second), nil, "api", 1*time.Millisecond)
+	<-done
+}
@@ -0,0 +1,154 @@
+// Package limittest provides helpers for testing code that uses go-limit limiters.
+package limittest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+)
+
+// FakeClock is a limit.Clock whose time only moves when told to.
+// Timers measure elapsed time like Go's monotonic clock, so Set can step the wall clock in either direction without
+// firing or delaying them, while Advance moves both and fires every timer that becomes due.
+type FakeClock struct {
+	mux sync.Mutex
+
+	now     time.Time
+	elapsed time.Duration // Monotonic time since the clock was created
+	timers  []*fakeTimer
+	changed chan struct{} // Closed and replaced whenever timers are added
+}
+
+// NewFakeClock creates a FakeClock starting at the given time.
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{
+		now:     start,
+		changed: make(chan struct{}),
+	}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+func (c *FakeClock) NewTimer(d time.Duration) limit.Timer {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	t := &fakeTimer{clock: c, ch: make(chan time.Time, 1)}
+	c.schedule(t, d)
+	return t
+}
+
+// Advance moves the clock forward by d, firing due timers in order.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	target := c.elapsed + d
+	for len(c.timers) > 0 && c.timers[0].due <= target {
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		c.now = c.now.Add(t.due - c.elapsed)
+		c.elapsed = t.due
+		t.fire(c.now)
+	}
+	c.now = c.now.Add(target - c.elapsed)
+	c.elapsed = target
+}
+
+// Set steps the wall clock to t without firing or delaying any timer, like an NTP adjustment would.
+func (c *FakeClock) Set(t time.Time) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.now = t
+}
+
+// Timers returns the number of timers waiting to fire.
+func (c *FakeClock) Timers() int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return len(c.timers)
+}
+
+// BlockUntil blocks until at least n timers are waiting to fire. It's useful to know goroutines are parked in a
+// limiter before advancing the clock.
+func (c *FakeClock) BlockUntil(n int) {
+	for {
+		c.mux.Lock()
+		if len(c.timers) >= n {
+			c.mux.Unlock()
+			return
+		}
+		changed := c.changed
+		c.mux.Unlock()
+		<-changed
+	}
+}
+
+func (c *FakeClock) schedule(t *fakeTimer, d time.Duration) {
+	// This must be called with the mutex already locked
+	if d <= 0 {
+		t.fire(c.now)
+		return
+	}
+
+	t.due = c.elapsed + d
+	i := sort.Search(len(c.timers), func(i int) bool { return c.timers[i].due > t.due })
+	c.timers = append(c.timers, nil)
+	copy(c.timers[i+1:], c.timers[i:])
+	c.timers[i] = t
+
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func (c *FakeClock) unschedule(t *fakeTimer) bool {
+	// This must be called with the mutex already locked
+	for i, timer := range c.timers {
+		if timer == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTimer struct {
+	clock *FakeClock
+	ch    chan time.Time
+	due   time.Duration
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mux.Lock()
+	defer t.clock.mux.Unlock()
+	return t.clock.unschedule(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mux.Lock()
+	defer t.clock.mux.Unlock()
+	active := t.clock.unschedule(t)
+	t.clock.schedule(t, d)
+	return active
+}
+
+func (t *fakeTimer) fire(now time.Time) {
+	select {
+	case t.ch <- now:
+	default:
+	}
+}
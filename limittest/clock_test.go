@@ -0,0 +1,72 @@
+package limittest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit/limittest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeClock_AdvanceFiresDueTimers(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	clock := limittest.NewFakeClock(start)
+
+	first := clock.NewTimer(100 * time.Millisecond)
+	second := clock.After(300 * time.Millisecond)
+	assert.Equal(t, 2, clock.Timers())
+
+	clock.Advance(200 * time.Millisecond)
+	assert.Equal(t, start.Add(100*time.Millisecond), <-first.C())
+	assert.Empty(t, second)
+	assert.Equal(t, start.Add(200*time.Millisecond), clock.Now())
+
+	clock.Advance(100 * time.Millisecond)
+	assert.Equal(t, start.Add(300*time.Millisecond), <-second)
+	assert.Equal(t, 0, clock.Timers())
+}
+
+func TestFakeClock_SetDoesNotAffectTimers(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	clock := limittest.NewFakeClock(start)
+	timer := clock.NewTimer(100 * time.Millisecond)
+
+	// Steps of the wall clock neither fire nor delay timers
+	clock.Set(start.Add(1 * time.Hour))
+	assert.Empty(t, timer.C())
+	clock.Set(start.Add(-1 * time.Hour))
+	clock.Advance(100 * time.Millisecond)
+	assert.Equal(t, start.Add(-1*time.Hour+100*time.Millisecond), <-timer.C())
+}
+
+func TestFakeClock_StopAndReset(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Now())
+	timer := clock.NewTimer(100 * time.Millisecond)
+
+	assert.True(t, timer.Stop())
+	assert.False(t, timer.Stop())
+	clock.Advance(100 * time.Millisecond)
+	assert.Empty(t, timer.C())
+
+	assert.False(t, timer.Reset(50*time.Millisecond))
+	clock.Advance(50 * time.Millisecond)
+	assert.Len(t, timer.C(), 1)
+}
+
+func TestFakeClock_BlockUntil(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Now())
+	go func() {
+		<-clock.After(1 * time.Second)
+	}()
+
+	clock.BlockUntil(1)
+	assert.Equal(t, 1, clock.Timers())
+}
@@ -0,0 +1,65 @@
+package limittest
+
+import "sync"
+
+// MemoryQuota is an in-memory limit.QuotaSource handing out tokens from a pool, to test borrowing limiters without a
+// central store.
+type MemoryQuota struct {
+	mux sync.Mutex
+
+	available int
+	borrows   int
+	err       error
+}
+
+// NewMemoryQuota creates a MemoryQuota with a pool of tokens.
+func NewMemoryQuota(tokens int) *MemoryQuota {
+	return &MemoryQuota{available: tokens}
+}
+
+// Borrow takes up to n tokens from the pool, or fails with the error set with Fail.
+func (q *MemoryQuota) Borrow(n int) (int, error) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	q.borrows++
+	if q.err != nil {
+		return 0, q.err
+	}
+	granted := min(n, q.available)
+	q.available -= granted
+	return granted, nil
+}
+
+// Return puts n tokens back in the pool.
+func (q *MemoryQuota) Return(n int) {
+	q.Add(n)
+}
+
+// Add adds n tokens to the pool, like a central quota refilling.
+func (q *MemoryQuota) Add(n int) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	q.available += n
+}
+
+// Available returns the tokens left in the pool.
+func (q *MemoryQuota) Available() int {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	return q.available
+}
+
+// Borrows returns how many times Borrow was called.
+func (q *MemoryQuota) Borrows() int {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	return q.borrows
+}
+
+// Fail makes Borrow fail with err, like a central store that is down, until it's called again with nil.
+func (q *MemoryQuota) Fail(err error) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	q.err = err
+}
@@ -0,0 +1,123 @@
+package loadgen
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+)
+
+// Profile is an offered load, the arrival times of the requests relative to the start of a run.
+type Profile interface {
+	// Arrivals returns when requests arrive within d, sorted.
+	Arrivals(d time.Duration) []time.Duration
+}
+
+// Constant offers requests evenly spaced at rate.
+func Constant(rate limit.Rate) Profile {
+	return constant{rate: rate}
+}
+
+type constant struct {
+	rate limit.Rate
+}
+
+func (c constant) Arrivals(d time.Duration) []time.Duration {
+	var arrivals []time.Duration
+	for i := 0; ; i++ {
+		at := time.Duration(int64(i) * int64(c.rate.Per) / int64(c.rate.Count))
+		if at >= d {
+			return arrivals
+		}
+		arrivals = append(arrivals, at)
+	}
+}
+
+// Burst offers size requests at once every interval, starting right away.
+func Burst(size int, every time.Duration) Profile {
+	return burst{size: size, every: every}
+}
+
+type burst struct {
+	size  int
+	every time.Duration
+}
+
+func (b burst) Arrivals(d time.Duration) []time.Duration {
+	var arrivals []time.Duration
+	for at := time.Duration(0); at < d; at += b.every {
+		for range b.size {
+			arrivals = append(arrivals, at)
+		}
+	}
+	return arrivals
+}
+
+// Poisson offers requests at rate on average, with exponentially distributed gaps between them. The same seed gives
+// the same arrivals.
+func Poisson(rate limit.Rate, seed int64) Profile {
+	return poisson{rate: rate, seed: seed}
+}
+
+type poisson struct {
+	rate limit.Rate
+	seed int64
+}
+
+func (p poisson) Arrivals(d time.Duration) []time.Duration {
+	rng := rand.New(rand.NewSource(p.seed))
+	mean := float64(p.rate.Per) / float64(p.rate.Count)
+
+	var arrivals []time.Duration
+	for at := time.Duration(rng.ExpFloat64() * mean); at < d; at += time.Duration(rng.ExpFloat64() * mean) {
+		arrivals = append(arrivals, at)
+	}
+	return arrivals
+}
+
+// Timestamps offers requests at the given offsets from the start.
+type Timestamps []time.Duration
+
+func (t Timestamps) Arrivals(d time.Duration) []time.Duration {
+	arrivals := slices.Sorted(slices.Values(t))
+	return arrivals[:sortedCut(arrivals, d)]
+}
+
+// sortedCut returns how many of the sorted offsets fall before d.
+func sortedCut(offsets []time.Duration, d time.Duration) int {
+	i, _ := slices.BinarySearch(offsets, d)
+	return i
+}
+
+// ReadTimestamps reads offsets from the start, one per line, as durations like 1.5s or as seconds like 1.5. Empty
+// lines and lines starting with # are skipped.
+func ReadTimestamps(r io.Reader) (Timestamps, error) {
+	var timestamps Timestamps
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		offset, err := parseOffset(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		timestamps = append(timestamps, offset)
+	}
+	return timestamps, scanner.Err()
+}
+
+func parseOffset(s string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	return time.ParseDuration(s)
+}
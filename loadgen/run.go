@@ -0,0 +1,132 @@
+// Package loadgen simulates offered load against go-limit limiters on a virtual clock, to answer capacity planning
+// questions like what a leaky bucket of 50/s with a queue of 200 does to latency at 70/s, in milliseconds of real time.
+package loadgen
+
+import (
+	"context"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/agustinbanchio/go-limit/limittest"
+)
+
+// Config describes a run.
+type Config struct {
+	// Limiter creates the limiter under test.
+	Limiter NewLimiter
+	// Profile is the offered load.
+	Profile Profile
+	// Duration is how long the load is offered for. Callers still waiting at the end are given up on.
+	Duration time.Duration
+	// Step is the resolution of the virtual clock, 1ms if zero. Arrivals and admissions are rounded up to it.
+	Step time.Duration
+	// NonBlocking makes requests call Allowed instead of waiting for the limiter.
+	NonBlocking bool
+}
+
+// Report is the outcome of a run.
+type Report struct {
+	Offered  int `json:"offered"`
+	Admitted int `json:"admitted"`
+	Denied   int `json:"denied"`
+	// Unfinished are the callers still waiting when the run ended.
+	Unfinished int `json:"unfinished"`
+	// The waits of the admitted requests.
+	WaitP50 time.Duration `json:"wait_p50"`
+	WaitP90 time.Duration `json:"wait_p90"`
+	WaitP99 time.Duration `json:"wait_p99"`
+	WaitMax time.Duration `json:"wait_max"`
+	// MaxQueueDepth is the largest number of callers waiting at once.
+	MaxQueueDepth int `json:"max_queue_depth"`
+}
+
+// Run offers the load to a new limiter on a virtual clock and reports how it was served. The clock only moves once
+// every caller was either served or is parked waiting for it, so the run is as fast as the limiter allows.
+func Run(cfg Config) Report {
+	step := cfg.Step
+	if step <= 0 {
+		step = 1 * time.Millisecond
+	}
+	clock := limittest.NewFakeClock(time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC))
+	limiter := cfg.Limiter(clock)
+	arrivals := cfg.Profile.Arrivals(cfg.Duration)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		mux      sync.Mutex
+		waits    []time.Duration
+		report   = Report{Offered: len(arrivals)}
+		wg       sync.WaitGroup
+		spawned  int64
+		finished atomic.Int64
+	)
+	// settle returns once every caller was served or is parked on a timer of the clock
+	settle := func() {
+		for finished.Load()+int64(clock.Timers()) < spawned {
+			runtime.Gosched()
+		}
+	}
+
+	next := 0
+	for elapsed := time.Duration(0); elapsed < cfg.Duration; elapsed += step {
+		for ; next < len(arrivals) && arrivals[next] <= elapsed; next++ {
+			spawned++
+			wg.Add(1)
+			go func(arrived time.Time) {
+				defer wg.Done()
+
+				var admitted bool
+				if cfg.NonBlocking {
+					admitted = limiter.Allowed()
+				} else {
+					admitted = limiter.WaitContext(ctx) == nil
+				}
+
+				mux.Lock()
+				switch {
+				case admitted:
+					report.Admitted++
+					waits = append(waits, clock.Now().Sub(arrived))
+				case ctx.Err() == nil:
+					report.Denied++
+				}
+				mux.Unlock()
+
+				// Only counted once recorded, so the clock doesn't move before the wait is measured
+				if ctx.Err() == nil {
+					finished.Add(1)
+				}
+			}(clock.Now())
+		}
+
+		settle()
+		report.MaxQueueDepth = max(report.MaxQueueDepth, int(spawned-finished.Load()))
+		clock.Advance(step)
+	}
+	settle()
+
+	cancel()
+	wg.Wait()
+	report.Unfinished = int(spawned - finished.Load())
+
+	slices.Sort(waits)
+	report.WaitP50 = percentile(waits, 50)
+	report.WaitP90 = percentile(waits, 90)
+	report.WaitP99 = percentile(waits, 99)
+	report.WaitMax = percentile(waits, 100)
+	return report
+}
+
+// percentile returns the p-th percentile of the sorted durations, by the nearest rank.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
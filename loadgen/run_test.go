@@ -0,0 +1,65 @@
+package loadgen_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/loadgen"
+	"github.com/stretchr/testify/assert"
+)
+
+func mustLimiter(t *testing.T, spec string) loadgen.NewLimiter {
+	t.Helper()
+	newLimiter, err := loadgen.ParseLimiter(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newLimiter
+}
+
+func TestRun_LeakyBucketOverloaded(t *testing.T) {
+	t.Parallel()
+
+	// 70/s offered to a bucket leaking 50/s: the queue of 200 fills up in 10s, then a fifth of the load is turned down
+	report := loadgen.Run(loadgen.Config{
+		Limiter:  mustLimiter(t, "leaky 50/1s queue=200"),
+		Profile:  loadgen.Constant(limit.Rate{Count: 70, Per: 1 * time.Second}),
+		Duration: 30 * time.Second,
+	})
+
+	assert.Equal(t, 2100, report.Offered)
+	assert.Equal(t, report.Offered, report.Admitted+report.Denied+report.Unfinished)
+	assert.InDelta(t, 1500, report.Admitted, 5)
+	assert.InDelta(t, 200, report.MaxQueueDepth, 2)
+	// Waiters aren't served in order, so some wait much longer than the 4s it takes to leak a full queue
+	assert.Less(t, report.WaitP50, report.WaitP99)
+	assert.Greater(t, report.WaitMax, 4*time.Second)
+}
+
+func TestRun_UnderCapacity(t *testing.T) {
+	t.Parallel()
+
+	report := loadgen.Run(loadgen.Config{
+		Limiter:  mustLimiter(t, "token 100/1s"),
+		Profile:  loadgen.Poisson(limit.Rate{Count: 20, Per: 1 * time.Second}, 1),
+		Duration: 10 * time.Second,
+	})
+
+	assert.InDelta(t, 200, report.Offered, 40)
+	assert.Equal(t, report.Offered, report.Admitted)
+	assert.Zero(t, report.WaitMax)
+}
+
+func TestRun_NonBlockingBurst(t *testing.T) {
+	t.Parallel()
+
+	report := loadgen.Run(loadgen.Config{
+		Limiter:     mustLimiter(t, "window 10/1s"),
+		Profile:     loadgen.Burst(25, 2*time.Second),
+		Duration:    10 * time.Second,
+		NonBlocking: true,
+	})
+
+	assert.Equal(t, loadgen.Report{Offered: 125, Admitted: 50, Denied: 75}, report)
+}
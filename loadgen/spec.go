@@ -0,0 +1,102 @@
+package loadgen
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+)
+
+// NewLimiter creates the limiter of a run telling the time with clock.
+type NewLimiter func(clock limit.Clock) limit.Limiter
+
+// ParseLimiter parses a limiter spec: the kind, token, window or leaky, and its rate, like "token 100/1s" or
+// "window 600/1m". Leaky buckets take the size of their queue too, like "leaky 50/1s queue=200", which defaults to
+// the rate's count.
+func ParseLimiter(spec string) (NewLimiter, error) {
+	fields := strings.Fields(spec)
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("limiter spec %q: want a kind and a rate", spec)
+	}
+
+	rate, err := ParseRate(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("limiter spec %q: %w", spec, err)
+	}
+
+	queue := rate.Count
+	for _, field := range fields[2:] {
+		value, ok := strings.CutPrefix(field, "queue=")
+		if !ok || fields[0] != "leaky" {
+			return nil, fmt.Errorf("limiter spec %q: unknown setting %q", spec, field)
+		}
+		if queue, err = strconv.Atoi(value); err != nil || queue <= 0 {
+			return nil, fmt.Errorf("limiter spec %q: invalid queue %q", spec, value)
+		}
+	}
+
+	switch fields[0] {
+	case "token":
+		return func(clock limit.Clock) limit.Limiter {
+			return limit.NewTokenBucket(rate.Count, rate.Per, limit.WithClock(clock))
+		}, nil
+	case "window":
+		return func(clock limit.Clock) limit.Limiter {
+			return limit.NewRollingWindow(rate.Count, rate.Per, limit.WithClock(clock))
+		}, nil
+	case "leaky":
+		return func(clock limit.Clock) limit.Limiter {
+			return limit.NewLeakyBucket(rate.Count, rate.Per, queue, limit.WithClock(clock))
+		}, nil
+	default:
+		return nil, fmt.Errorf("limiter spec %q: unknown kind %q, want token, window or leaky", spec, fields[0])
+	}
+}
+
+// ParseProfile parses a load spec: "constant 70/1s", "poisson 70/1s", "burst 100 every 10s" or "file <path>" reading
+// timestamps with ReadTimestamps. Poisson arrivals are drawn with seed.
+func ParseProfile(spec string, seed int64) (Profile, error) {
+	fields := strings.Fields(spec)
+	switch {
+	case len(fields) == 2 && (fields[0] == "constant" || fields[0] == "poisson"):
+		rate, err := ParseRate(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("load spec %q: %w", spec, err)
+		}
+		if fields[0] == "constant" {
+			return Constant(rate), nil
+		}
+		return Poisson(rate, seed), nil
+	case len(fields) == 4 && fields[0] == "burst" && fields[2] == "every":
+		size, err := strconv.Atoi(fields[1])
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("load spec %q: invalid burst size %q", spec, fields[1])
+		}
+		every, err := time.ParseDuration(fields[3])
+		if err != nil || every <= 0 {
+			return nil, fmt.Errorf("load spec %q: invalid interval %q", spec, fields[3])
+		}
+		return Burst(size, every), nil
+	case len(fields) == 2 && fields[0] == "file":
+		f, err := os.Open(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return ReadTimestamps(f)
+	default:
+		return nil, fmt.Errorf("load spec %q: want constant, poisson, burst or file", spec)
+	}
+}
+
+// ParseRate parses a rate like 100/1s, 600/1m or 50/s, as limit.ParseRate does.
+func ParseRate(s string) (limit.Rate, error) {
+	count, per, err := limit.ParseRate(s)
+	if err != nil {
+		return limit.Rate{}, err
+	}
+	return limit.Rate{Count: count, Per: per}, nil
+}
@@ -0,0 +1,65 @@
+package loadgen_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limittest"
+	"github.com/agustinbanchio/go-limit/loadgen"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRate(t *testing.T) {
+	t.Parallel()
+
+	for spec, want := range map[string]limit.Rate{
+		"100/1s": {Count: 100, Per: 1 * time.Second},
+		"600/m":  {Count: 600, Per: 1 * time.Minute},
+		"5/10ms": {Count: 5, Per: 10 * time.Millisecond},
+	} {
+		got, err := loadgen.ParseRate(spec)
+		assert.NoError(t, err, spec)
+		assert.Equal(t, want, got, spec)
+	}
+
+	for _, spec := range []string{"100", "0/1s", "x/1s", "10/", "10/-1s"} {
+		_, err := loadgen.ParseRate(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestParseLimiter(t *testing.T) {
+	t.Parallel()
+
+	newLimiter, err := loadgen.ParseLimiter("leaky 50/1s queue=200")
+	assert.NoError(t, err)
+	limiter := newLimiter(limittest.NewFakeClock(time.Now()))
+	assert.Equal(t, 200, limiter.(limit.QueueConfigurer).MaxQueue())
+	assert.Equal(t, limit.Rate{Count: 50, Per: 1 * time.Second}, limiter.(limit.Configurer).Limit())
+
+	for _, spec := range []string{"", "token", "bucket 1/s", "token 1/s queue=3", "leaky 1/s queue=0"} {
+		_, err := loadgen.ParseLimiter(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestParseProfile(t *testing.T) {
+	t.Parallel()
+
+	profile, err := loadgen.ParseProfile("burst 3 every 1s", 0)
+	assert.NoError(t, err)
+	assert.Len(t, profile.Arrivals(2*time.Second), 6)
+
+	path := filepath.Join(t.TempDir(), "arrivals.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("# replayed\n0.5\n\n100ms\n2s\n"), 0o600))
+	profile, err = loadgen.ParseProfile("file "+path, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []time.Duration{100 * time.Millisecond, 500 * time.Millisecond}, profile.Arrivals(1*time.Second))
+
+	_, err = loadgen.ReadTimestamps(strings.NewReader("1s\nsoon\n"))
+	assert.ErrorContains(t, err, "line 2")
+}
@@ -0,0 +1,144 @@
+package limit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// RegionID names a region sharing a global rate with NewMergedLimiter.
+type RegionID string
+
+// UsageSummary is the usage a region reports to the others: the requests it allowed in the window of the global rate
+// starting at WindowStart. Windows are aligned on the global period, so regions with synchronized clocks agree on them.
+type UsageSummary struct {
+	Region      RegionID
+	WindowStart time.Time
+	Count       int
+}
+
+// regionUsage is the last usage a region reported, and what it reported for the window before.
+type regionUsage struct {
+	windowStart time.Time
+	count       int
+	previous    int
+}
+
+// MergedLimiter enforces a global rate across regions that count their usage locally and exchange it asynchronously,
+// trading exactness for not paying a cross-region round trip per request. Each region allows the global rate minus
+// the usage the others reported and a safety margin, enforced with a rolling window over the global period.
+//
+// The transport is up to the caller: send ExportUsage to the other regions and feed what they send to MergeUsage. The
+// allowance is recomputed on both calls, so exchanging usage every fraction of the period keeps it current.
+type MergedLimiter struct {
+	Limiter
+
+	// Mutex
+	mux sync.Mutex
+
+	// Config
+	global Rate
+	self   RegionID
+	margin float64
+	clock  Clock
+	window rateSetter
+
+	// State
+	windowStart    time.Time
+	allowedAtStart int
+	regions        map[RegionID]*regionUsage
+}
+
+// NewMergedLimiter creates a MergedLimiter for region self, keeping a margin, a fraction of the global count, unused
+// to absorb the usage the other regions haven't reported yet. Until they report, it allows the global rate minus the
+// margin.
+func NewMergedLimiter(global Rate, self RegionID, margin float64, opts ...Option) *MergedLimiter {
+	o := newOptions(opts)
+	window := NewRollingWindow(global.Count, global.Per, opts...)
+	m := &MergedLimiter{
+		Limiter: window,
+		global:  global,
+		self:    self,
+		margin:  margin,
+		clock:   o.clock,
+		window:  window.(rateSetter),
+		regions: make(map[RegionID]*regionUsage),
+	}
+	m.windowStart = m.clock.Now().Truncate(global.Per)
+	m.window.setRate(Rate{Count: m.allowance(), Per: global.Per})
+	return m
+}
+
+// MergeUsage merges the usage region from reported for the window starting at windowStart. Reports may arrive late,
+// repeated or out of order: a report for an older window than the last one is ignored, and a count lower than the one
+// already known for the same window is ignored as the counts only grow.
+func (m *MergedLimiter) MergeUsage(from RegionID, windowStart time.Time, count int) {
+	if from == m.self {
+		return
+	}
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	usage, ok := m.regions[from]
+	switch {
+	case !ok:
+		m.regions[from] = &regionUsage{windowStart: windowStart, count: count}
+	case windowStart.Equal(usage.windowStart):
+		usage.count = max(usage.count, count)
+	case windowStart.After(usage.windowStart):
+		usage.previous = 0
+		if windowStart.Equal(usage.windowStart.Add(m.global.Per)) {
+			usage.previous = usage.count
+		}
+		usage.windowStart = windowStart
+		usage.count = count
+	}
+	m.rebalance()
+}
+
+// ExportUsage returns the usage of this region in the current window, to send to the other regions.
+func (m *MergedLimiter) ExportUsage() UsageSummary {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	m.rebalance()
+	return UsageSummary{
+		Region:      m.self,
+		WindowStart: m.windowStart,
+		Count:       m.Limiter.Stats().AllowedRequests - m.allowedAtStart,
+	}
+}
+
+// rebalance moves to the current window and sets the rolling window to the allowance left by the other regions.
+func (m *MergedLimiter) rebalance() {
+	// This must be called with the mutex already locked
+	if start := m.clock.Now().Truncate(m.global.Per); start.After(m.windowStart) {
+		m.windowStart = start
+		m.allowedAtStart = m.Limiter.Stats().AllowedRequests
+	}
+	m.window.setRate(Rate{Count: m.allowance(), Per: m.global.Per})
+}
+
+// allowance returns the requests this region may allow in a period: the global count minus the margin and the usage
+// of the other regions over the last period. The usage of the previous window is weighted by how much of it the last
+// period still covers, so the reports of a region that stopped reporting decay to nothing within two windows.
+func (m *MergedLimiter) allowance() int {
+	// This must be called with the mutex already locked
+	elapsed := m.clock.Now().Sub(m.windowStart)
+	previousWeight := 1 - float64(elapsed)/float64(m.global.Per)
+
+	var others float64
+	for _, usage := range m.regions {
+		switch {
+		case !usage.windowStart.Before(m.windowStart):
+			// A region whose clock is ahead may already report the next window
+			others += float64(usage.count) + float64(usage.previous)*previousWeight
+		case usage.windowStart.Equal(m.windowStart.Add(-m.global.Per)):
+			others += float64(usage.count) * previousWeight
+		}
+	}
+
+	allowance := float64(m.global.Count)*(1-m.margin) - others
+	return max(int(math.Floor(allowance)), 0)
+}
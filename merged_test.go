@@ -0,0 +1,120 @@
+package limit_test
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limittest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergedLimiter(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	eu := limit.NewMergedLimiter(limit.Rate{Count: 100, Per: 1 * time.Second}, "eu", 0.1, limit.WithClock(clock))
+	rate := func() int {
+		return eu.Limiter.(limit.Configurer).Limit().Count
+	}
+
+	// Until the other regions report, the region allows the global rate minus the margin
+	assert.Equal(t, 90, rate())
+	for i := 0; i < 5; i++ {
+		assert.True(t, eu.Allowed())
+	}
+	assert.Equal(t, limit.UsageSummary{Region: "eu", WindowStart: time.Unix(0, 0), Count: 5}, eu.ExportUsage())
+
+	// Counts only grow, so stale and repeated reports don't lower them, and the region's own reports are ignored
+	eu.MergeUsage("us", time.Unix(0, 0), 30)
+	eu.MergeUsage("us", time.Unix(0, 0), 20)
+	eu.MergeUsage("ap", time.Unix(0, 0), 10)
+	eu.MergeUsage("eu", time.Unix(0, 0), 50)
+	assert.Equal(t, 50, rate())
+
+	// Exhausting the allowance left by the other regions
+	for eu.Allowed() {
+	}
+	assert.Equal(t, 50, eu.Stats().AllowedRequests)
+
+	// In the next window the reports of the last one decay as it leaves the period
+	clock.Advance(1250 * time.Millisecond)
+	summary := eu.ExportUsage()
+	assert.Equal(t, limit.UsageSummary{Region: "eu", WindowStart: time.Unix(1, 0), Count: 0}, summary)
+	assert.Equal(t, 60, rate())
+
+	// A report of an older window than the last one is ignored
+	eu.MergeUsage("us", time.Unix(1, 0), 5)
+	eu.MergeUsage("us", time.Unix(0, 0), 80)
+	assert.Equal(t, 55, rate())
+
+	// Regions that stop reporting are forgotten within two windows
+	clock.Advance(2 * time.Second)
+	eu.ExportUsage()
+	assert.Equal(t, 90, rate())
+}
+
+// TestMergedLimiter_Simulation runs three regions offered 500 requests per second in total against a global rate of
+// 300 per second, exchanging usage every 100ms. Between exchanges the other regions allow up to about 33 requests a
+// region doesn't know of, 11% of the global rate, which is the worst-case overshoot without a margin. A margin at
+// least that large absorbs it.
+func TestMergedLimiter_Simulation(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		margin       float64
+		maxOvershoot float64
+	}{
+		{margin: 0, maxOvershoot: 0.12},
+		{margin: 0.1, maxOvershoot: 0.03},
+		{margin: 0.2, maxOvershoot: 0},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.margin), func(t *testing.T) {
+			t.Parallel()
+
+			clock := limittest.NewFakeClock(time.Unix(0, 0))
+			global := limit.Rate{Count: 300, Per: 1 * time.Second}
+			regions := []*limit.MergedLimiter{
+				limit.NewMergedLimiter(global, "eu", tt.margin, limit.WithClock(clock)),
+				limit.NewMergedLimiter(global, "us", tt.margin, limit.WithClock(clock)),
+				limit.NewMergedLimiter(global, "ap", tt.margin, limit.WithClock(clock)),
+			}
+
+			var admitted []time.Time
+			for ms := 0; ms < 10000; ms++ {
+				// Each region is offered a request every 6ms
+				for _, r := range regions {
+					if ms%6 == 0 && r.Allowed() {
+						admitted = append(admitted, clock.Now())
+					}
+				}
+
+				if ms%100 == 99 {
+					var summaries []limit.UsageSummary
+					for _, r := range regions {
+						summaries = append(summaries, r.ExportUsage())
+					}
+					for _, r := range regions {
+						for _, s := range summaries {
+							r.MergeUsage(s.Region, s.WindowStart, s.Count)
+						}
+					}
+				}
+				clock.Advance(1 * time.Millisecond)
+			}
+
+			// The most requests allowed in any period
+			worst := 0
+			for i, at := range admitted {
+				first, _ := slices.BinarySearchFunc(admitted, at.Add(-global.Per+1), time.Time.Compare)
+				worst = max(worst, i-first+1)
+			}
+			overshoot := float64(worst-global.Count) / float64(global.Count)
+			t.Logf("margin %.2f: at most %d requests per period, %.1f%% overshoot", tt.margin, worst, 100*overshoot)
+			assert.LessOrEqual(t, overshoot, tt.maxOvershoot)
+		})
+	}
+}
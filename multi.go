@@ -0,0 +1,404 @@
+package limit
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrCombinedDetach is returned when detaching a reservation holding reservations of several limiters, the ones of
+// NewMultiLimiter and of the children of a HierarchicalLimiter.
+var ErrCombinedDetach = errors.New("reservations of several limiters can't be detached")
+
+var _ Limiter = (*multiLimiter)(nil)
+
+// multiLimiter admits requests only if all of its limiters admit them.
+type multiLimiter struct {
+	// Mutex
+	mux sync.Mutex
+
+	// Config
+	limiters []Limiter
+
+	// State
+	allowed int
+	denied  int
+}
+
+// NewMultiLimiter returns a limiter admitting a request only if every one of limiters admits it, e.g. 10/s and 100/min
+// and 1000/h. Allowed takes a reservation of each limiter and consumes them only if it got all of them, otherwise it
+// gives them back, so a limiter that allowed the request doesn't spend anything when another one denies it. Only the
+// limiter that turned the request down counts it as denied. WaitContext holds the reservations already taken while it
+// waits for the next limiter, so the request is admitted by all of them at once.
+//
+// A leaky bucket's reservations only hold room in its queue, so Allowed instead holds an event it let leak right away,
+// which it gives back if another limiter turns the request down, unless another event leaked since. Waits and
+// reservations hold room in their queue and consuming waits for the event to leak, like their own ReserveContext.
+// Allowed reserves limiters from other packages with TryReserve, a unit at a time.
+//
+// Stats counts the requests made through the multi limiter, with the NextAllowedTime of the most constrained
+// limiter. Info, Available and EstimatedWait are the most constrained ones too, and Config, Labels and the reservation
+// ages are the first limiter's. Clear, Close and CancelWaiters apply to every limiter.
+func NewMultiLimiter(limiters ...Limiter) Limiter {
+	return &multiLimiter{limiters: slices.Clone(limiters)}
+}
+
+func (m *multiLimiter) Wait() {
+	_ = m.WaitContext(context.Background())
+}
+
+func (m *multiLimiter) WaitTimeout(timeout time.Duration) error {
+	return m.WaitNTimeout(timeout, 1)
+}
+
+func (m *multiLimiter) WaitContext(ctx context.Context) error {
+	return m.WaitNContext(ctx, 1)
+}
+
+func (m *multiLimiter) WaitN(n int) error {
+	return m.WaitNContext(context.Background(), n)
+}
+
+func (m *multiLimiter) WaitNTimeout(timeout time.Duration, n int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return m.WaitNContext(ctx, n)
+}
+
+func (m *multiLimiter) WaitNContext(ctx context.Context, n int) error {
+	if n <= 0 {
+		return nil
+	}
+
+	err := waitAll(ctx, m.limiters, n)
+	m.record(err == nil)
+	return err
+}
+
+func (m *multiLimiter) Allowed() bool {
+	return m.AllowN(1)
+}
+
+func (m *multiLimiter) AllowN(n int) bool {
+	if n <= 0 {
+		return true
+	}
+
+	ok := allowAll(m.limiters, n)
+	m.record(ok)
+	return ok
+}
+
+func (m *multiLimiter) record(allowed bool) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if allowed {
+		m.allowed++
+	} else {
+		m.denied++
+	}
+}
+
+func (m *multiLimiter) Clear() {
+	for _, l := range m.limiters {
+		l.Clear()
+	}
+}
+
+func (m *multiLimiter) Close() error {
+	var errs []error
+	for _, l := range m.limiters {
+		errs = append(errs, l.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func (m *multiLimiter) CancelWaiters(err error) int {
+	canceled := 0
+	for _, l := range m.limiters {
+		canceled += l.CancelWaiters(err)
+	}
+	return canceled
+}
+
+// Stats counts the requests made through the multi limiter. NextAllowedTime is the latest of the limiters, the zero
+// time if any of them can't tell, and Remaining the smallest.
+func (m *multiLimiter) Stats() Stats {
+	m.mux.Lock()
+	stats := Stats{AllowedRequests: m.allowed, DeniedRequests: m.denied}
+	m.mux.Unlock()
+
+	for i, l := range m.limiters {
+		other := l.Stats()
+		switch {
+		case i == 0:
+			stats.NextAllowedTime = other.NextAllowedTime
+			stats.Remaining = other.Remaining
+		case stats.NextAllowedTime.IsZero() || other.NextAllowedTime.IsZero():
+			stats.NextAllowedTime = time.Time{}
+		default:
+			stats.NextAllowedTime = latest(stats.NextAllowedTime, other.NextAllowedTime)
+		}
+		stats.Remaining = min(stats.Remaining, other.Remaining)
+		stats.Paused = stats.Paused || other.Paused
+		stats.PendingReservations += other.PendingReservations
+		stats.QueueDepth += other.QueueDepth
+	}
+	return stats
+}
+
+// Info is the one of the limiter with the fewest requests remaining.
+func (m *multiLimiter) Info() LimitInfo {
+	var info LimitInfo
+	for i, l := range m.limiters {
+		if other := l.Info(); i == 0 || other.Remaining < info.Remaining {
+			info = other
+		}
+	}
+	return info
+}
+
+func (m *multiLimiter) Available() int {
+	return m.Info().Remaining
+}
+
+func (m *multiLimiter) EstimatedWait() time.Duration {
+	var wait time.Duration
+	for _, l := range m.limiters {
+		wait = max(wait, l.EstimatedWait())
+	}
+	return wait
+}
+
+func (m *multiLimiter) Config() Config {
+	if len(m.limiters) == 0 {
+		return Config{}
+	}
+	return m.limiters[0].Config()
+}
+
+func (m *multiLimiter) Labels() map[string]string {
+	if len(m.limiters) == 0 {
+		return nil
+	}
+	return m.limiters[0].Labels()
+}
+
+func (m *multiLimiter) PendingReservationAges(n int) []time.Duration {
+	if len(m.limiters) == 0 {
+		return nil
+	}
+	return m.limiters[0].PendingReservationAges(n)
+}
+
+// Waiters returns the callers blocked in each limiter, one after the other.
+func (m *multiLimiter) Waiters() []WaiterInfo {
+	var waiters []WaiterInfo
+	for _, l := range m.limiters {
+		waiters = append(waiters, l.Waiters()...)
+	}
+	return waiters
+}
+
+func (m *multiLimiter) TryReserve(reservationTTL *time.Duration) (Reservation, bool) {
+	return tryReserveAll(m.limiters, reservationTTL)
+}
+
+func (m *multiLimiter) Reserve(reservationTTL *time.Duration) Reservation {
+	reservation, err := m.ReserveContext(context.Background(), reservationTTL)
+	if err != nil {
+		return failedReservation{err: err}
+	}
+	return reservation
+}
+
+func (m *multiLimiter) ReserveTimeout(timeout time.Duration, reservationTTL *time.Duration) (Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return m.ReserveContext(ctx, reservationTTL)
+}
+
+func (m *multiLimiter) ReserveContext(ctx context.Context, reservationTTL *time.Duration) (Reservation, error) {
+	return m.ReserveN(ctx, 1, reservationTTL)
+}
+
+func (m *multiLimiter) ReserveN(ctx context.Context, n int, reservationTTL *time.Duration) (Reservation, error) {
+	if err := reserveCostErr(n); err != nil {
+		return nil, err
+	}
+	return reserveAll(ctx, m.limiters, n, reservationTTL)
+}
+
+func (m *multiLimiter) Permits(ctx context.Context) <-chan struct{} {
+	return deliverPermits(ctx, func(ctx context.Context) (permit, error) {
+		return reservePermit(ctx, m)
+	})
+}
+
+// nowReserver is implemented by the limiters that can reserve n units consuming takes right away, without waiting,
+// for allowAll to ask several limiters all or nothing.
+type nowReserver interface {
+	// reserveNow reserves n units if AllowN would allow them, otherwise it counts the denial like AllowN and returns
+	// false.
+	reserveNow(n int) (Reservation, bool)
+}
+
+// allowAll reports whether every one of limiters allows n units right now, consuming them only if all of them do.
+// The limiters are reserved in order, the ones that can't reserve n units without waiting, from other packages, with
+// TryReserve a unit at a time.
+func allowAll(limiters []Limiter, n int) bool {
+	var reservations []Reservation
+	for _, l := range limiters {
+		if r, ok := nowReserverOf(l); ok {
+			reservation, ok := r.reserveNow(n)
+			if !ok {
+				cancelAll(reservations)
+				return false
+			}
+			reservations = append(reservations, reservation)
+			continue
+		}
+
+		for range n {
+			reservation, ok := l.TryReserve(nil)
+			if !ok {
+				cancelAll(reservations)
+				return false
+			}
+			reservations = append(reservations, reservation)
+		}
+	}
+
+	_, err := consumeAll(reservations)
+	return err == nil
+}
+
+// nowReserverOf returns l as a nowReserver, looking through the jitter limiters, which only stretch waits and allow
+// what their limiter allows.
+func nowReserverOf(l Limiter) (nowReserver, bool) {
+	for {
+		j, ok := l.(*jitterLimiter)
+		if !ok {
+			break
+		}
+		l = j.Limiter
+	}
+	r, ok := l.(nowReserver)
+	return r, ok
+}
+
+// waitAll blocks until every one of limiters allows n units or ctx is done, holding the reservations already taken
+// while it waits for the next limiter and giving them back if one fails.
+func waitAll(ctx context.Context, limiters []Limiter, n int) error {
+	reservation, err := reserveAll(ctx, limiters, n, nil)
+	if err != nil {
+		return err
+	}
+	return reservation.ConsumeContext(ctx)
+}
+
+// tryReserveAll reserves a unit of every one of limiters right now, or none of them.
+func tryReserveAll(limiters []Limiter, reservationTTL *time.Duration) (Reservation, bool) {
+	reservations := make([]Reservation, 0, len(limiters))
+	for _, l := range limiters {
+		reservation, ok := l.TryReserve(reservationTTL)
+		if !ok {
+			cancelAll(reservations)
+			return nil, false
+		}
+		reservations = append(reservations, reservation)
+	}
+	return &combinedReservation{reservations: reservations}, true
+}
+
+// reserveAll reserves n units of every one of limiters in order, holding the reservations already taken while it
+// waits for the next limiter and giving them back if one fails.
+func reserveAll(ctx context.Context, limiters []Limiter, n int, reservationTTL *time.Duration) (Reservation, error) {
+	reservations := make([]Reservation, 0, len(limiters))
+	for _, l := range limiters {
+		reservation, err := l.ReserveN(ctx, n, reservationTTL)
+		if err != nil {
+			cancelAll(reservations)
+			return nil, err
+		}
+		reservations = append(reservations, reservation)
+	}
+	return &combinedReservation{reservations: reservations}, nil
+}
+
+// combinedReservation holds a reservation of each of several limiters.
+type combinedReservation struct {
+	reservations []Reservation
+}
+
+// Consume consumes the reservations in order, or none of them if one expired or was canceled. If one fails while
+// they're consumed, e.g. because Clear canceled it meanwhile, the ones after it are canceled but the ones before it
+// stay consumed.
+func (r *combinedReservation) Consume() error {
+	_, err := consumeAll(r.reservations)
+	return err
+}
+
+// ConsumeContext is Consume, waiting for each reservation as its ConsumeContext does.
+func (r *combinedReservation) ConsumeContext(ctx context.Context) error {
+	if _, err := pendingAll(r.reservations); err != nil {
+		return err
+	}
+	for i, reservation := range r.reservations {
+		if err := reservation.ConsumeContext(ctx); err != nil {
+			cancelAll(r.reservations[i+1:])
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *combinedReservation) Cancel() {
+	cancelAll(r.reservations)
+}
+
+func (r *combinedReservation) Detach() (ReservationHandle, error) {
+	return ReservationHandle{}, ErrCombinedDetach
+}
+
+// ReadyAt is the latest of the reservations.
+func (r *combinedReservation) ReadyAt() time.Time {
+	var readyAt time.Time
+	for _, reservation := range r.reservations {
+		readyAt = latest(readyAt, reservation.ReadyAt())
+	}
+	return readyAt
+}
+
+func (r *combinedReservation) Delay() time.Duration {
+	var delay time.Duration
+	for _, reservation := range r.reservations {
+		delay = max(delay, reservation.Delay())
+	}
+	return delay
+}
+
+// ExpiresAt is the earliest expiry of the reservations.
+func (r *combinedReservation) ExpiresAt() (time.Time, bool) {
+	var expiresAt time.Time
+	expires := false
+	for _, reservation := range r.reservations {
+		if at, ok := reservation.ExpiresAt(); ok && (!expires || at.Before(expiresAt)) {
+			expiresAt, expires = at, true
+		}
+	}
+	return expiresAt, expires
+}
+
+// State is the first one of the reservations that isn't pending, pending if they all are.
+func (r *combinedReservation) State() ReservationState {
+	for _, reservation := range r.reservations {
+		if state := reservation.State(); state != ReservationPending {
+			return state
+		}
+	}
+	return ReservationPending
+}
@@ -0,0 +1,185 @@
+package limit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limittest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultiLimiter_Allowed(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	perSecond := limit.NewTokenBucket(2, 1*time.Second, limit.WithClock(clock))
+	perMinute := limit.NewRollingWindow(1, 1*time.Minute, limit.WithClock(clock))
+	limiter := limit.NewMultiLimiter(perSecond, perMinute)
+
+	assert.True(t, limiter.Allowed())
+
+	// The second limiter turns the request down, the first doesn't spend its token
+	assert.False(t, limiter.Allowed())
+	assert.Equal(t, 1, perSecond.Available())
+	assert.Equal(t, 0, perSecond.Stats().DeniedRequests)
+	assert.Equal(t, 1, perMinute.Stats().DeniedRequests)
+
+	stats := limiter.Stats()
+	assert.Equal(t, 1, stats.AllowedRequests)
+	assert.Equal(t, 1, stats.DeniedRequests)
+	assert.Equal(t, 0, stats.Remaining)
+	// The most constrained limiter sets the next allowed time
+	assert.Equal(t, perMinute.Stats().NextAllowedTime, stats.NextAllowedTime)
+	assert.Equal(t, perMinute.EstimatedWait(), limiter.EstimatedWait())
+}
+
+func TestMultiLimiter_Wait(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	perSecond := limit.NewTokenBucket(1, 1*time.Second, limit.WithClock(clock))
+	perWindow := limit.NewRollingWindow(2, 3*time.Second, limit.WithClock(clock))
+	limiter := limit.NewMultiLimiter(perSecond, perWindow)
+	assert.True(t, limiter.Allowed())
+	clock.Advance(1 * time.Second)
+	assert.True(t, limiter.Allowed())
+
+	waited := make(chan error)
+	go func() {
+		waited <- limiter.WaitContext(context.Background())
+	}()
+
+	// The token of the first limiter is held while waiting for the second
+	clock.BlockUntil(1)
+	clock.Advance(1 * time.Second)
+	clock.BlockUntil(1)
+	assert.False(t, perSecond.Allowed())
+
+	// Both admit the request at once when the window has room
+	clock.Advance(1*time.Second + time.Millisecond)
+	assert.NoError(t, <-waited)
+	assert.Equal(t, 3, perSecond.Stats().AllowedRequests)
+	assert.Equal(t, 3, perWindow.Stats().AllowedRequests)
+}
+
+func TestMultiLimiter_LeakyBucketLast(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	leaky := limit.NewLeakyBucket(1, 1*time.Second, 5, limit.WithClock(clock))
+	bucket := limit.NewTokenBucket(1, 1*time.Minute, limit.WithClock(clock))
+	assert.True(t, bucket.Allowed())
+	limiter := limit.NewMultiLimiter(leaky, bucket)
+
+	// The leaky bucket gives back the event it let leak when the token bucket turns the request down
+	assert.False(t, limiter.Allowed())
+	assert.Equal(t, 0, leaky.Stats().QueueDepth)
+	assert.Equal(t, 0, leaky.Stats().AllowedRequests)
+	assert.Equal(t, 1, bucket.Stats().DeniedRequests)
+
+	// Reservations hold room in both
+	reservation, ok := limit.NewMultiLimiter(leaky, limit.NewTokenBucket(1, 1*time.Minute, limit.WithClock(clock))).TryReserve(nil)
+	assert.True(t, ok)
+	assert.Equal(t, limit.ReservationPending, reservation.State())
+	reservation.Cancel()
+	assert.Equal(t, limit.ReservationCanceled, reservation.State())
+	_, err := reservation.Detach()
+	assert.ErrorIs(t, err, limit.ErrCombinedDetach)
+}
+
+func TestMultiLimiter_AllowN(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	bucket := limit.NewTokenBucket(3, 1*time.Second, limit.WithClock(clock))
+	window := limit.NewRollingWindow(2, 1*time.Second, limit.WithClock(clock))
+	limiter := limit.NewMultiLimiter(bucket, window)
+
+	// The request is a single reservation of 3 units, turned down once by the window
+	assert.False(t, limiter.AllowN(3))
+	assert.Equal(t, 3, bucket.Available())
+	assert.Equal(t, 1, window.Stats().DeniedRequests)
+
+	assert.True(t, limiter.AllowN(2))
+	assert.Equal(t, 1, bucket.Available())
+	assert.Equal(t, 1, bucket.Stats().AllowedRequests)
+	assert.Equal(t, 1, window.Stats().AllowedRequests)
+}
+
+func TestMultiLimiter_LeakyBuckets(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	first := limit.NewLeakyBucket(1, 1*time.Second, 5, limit.WithClock(clock))
+	second := limit.NewLeakyBucket(1, 1*time.Second, 5, limit.WithClock(clock))
+	assert.True(t, second.Allowed())
+
+	// A wrapped leaky bucket is only allowed if it can leak right away, without holding room in its queue
+	limiter := limit.NewMultiLimiter(first, limit.NewJitterLimiter(second, 0.5, limit.WithClock(clock)))
+	assert.False(t, limiter.Allowed())
+	assert.Equal(t, 0, second.Stats().QueueDepth)
+	assert.Equal(t, 1, second.Stats().DeniedRequests)
+
+	// The first bucket got its leak back when the second turned the request down
+	assert.Equal(t, time.Duration(0), first.EstimatedWait())
+	assert.Equal(t, 0, first.Stats().AllowedRequests)
+
+	clock.Advance(1 * time.Second)
+	assert.True(t, limiter.Allowed())
+	assert.Equal(t, 1, first.Stats().AllowedRequests)
+	assert.False(t, first.Allowed())
+}
+
+func TestMultiLimiter_ConsumeCanceled(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	bucket := limit.NewTokenBucket(1, 1*time.Second, limit.WithClock(clock))
+	window := limit.NewRollingWindow(1, 1*time.Second, limit.WithClock(clock))
+	reservation, ok := limit.NewMultiLimiter(bucket, window).TryReserve(nil)
+	assert.True(t, ok)
+
+	// None of the reservations is consumed once one of them was canceled
+	window.Clear()
+	assert.ErrorIs(t, reservation.Consume(), limit.ErrReservationCanceled)
+	assert.Equal(t, 0, bucket.Stats().AllowedRequests)
+	assert.Equal(t, 1, bucket.Available())
+}
+
+// foreignLimiter hides the limiter it wraps from the package, as a limiter from another package would.
+type foreignLimiter struct {
+	limit.Limiter
+}
+
+func TestMultiLimiter_OtherPackages(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	foreign := limit.NewTokenBucket(3, 1*time.Minute, limit.WithClock(clock))
+	bucket := limit.NewTokenBucket(2, 1*time.Minute, limit.WithClock(clock))
+	limiter := limit.NewMultiLimiter(foreignLimiter{foreign}, foreignLimiter{bucket})
+
+	// A limiter from another package gets its units back when a later one turns the request down
+	assert.False(t, limiter.AllowN(3))
+	assert.Equal(t, 3, foreign.Available())
+	assert.Equal(t, 0, foreign.Stats().AllowedRequests)
+	assert.Equal(t, 2, bucket.Available())
+
+	assert.True(t, limiter.AllowN(2))
+	assert.Equal(t, 1, foreign.Available())
+	assert.Equal(t, 0, bucket.Available())
+}
+
+func TestMultiLimiter_ReserveN_NotPositive(t *testing.T) {
+	t.Parallel()
+
+	limiter := limit.NewMultiLimiter(limit.NewTokenBucket(1, 1*time.Minute))
+	for _, n := range []int{0, -1} {
+		reservation, err := limiter.ReserveN(context.Background(), n, nil)
+		assert.Error(t, err)
+		assert.Nil(t, reservation)
+	}
+	assert.Equal(t, 0, limiter.Stats().DeniedRequests)
+}
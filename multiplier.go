@@ -0,0 +1,155 @@
+package limit
+
+import (
+	"math"
+	"time"
+)
+
+// rateSetter is implemented by the limiters in this package whose rate can change after they were created, the token
+// bucket and the rolling window.
+type rateSetter interface {
+	// setRate changes the rate the limiter enforces, waking its waiters to check it again.
+	setRate(rate Rate)
+}
+
+// MultiplierStats reports the multiplier a KeyedLimiter applies to the rate of a key, see WithMultiplier.
+type MultiplierStats struct {
+	// The multiplier last applied to the key.
+	Multiplier float64 `json:"multiplier"`
+	// The rate the limiter of the key was created with.
+	Base Rate `json:"base"`
+	// The rate the limiter of the key enforces, the base rate scaled by the multiplier.
+	Effective Rate `json:"effective"`
+	// When the multiplier was last asked for, zero if it hasn't been yet.
+	RefreshedAt time.Time `json:"refreshed_at"`
+}
+
+// multiplier holds the config set with WithMultiplier.
+type multiplier struct {
+	fn      func(key string) float64
+	refresh time.Duration
+	epsilon float64
+}
+
+// keyMultiplier is the multiplier state of a key in use.
+type keyMultiplier struct {
+	limiter     Limiter
+	base        Rate
+	value       float64
+	refreshedAt time.Time
+	refreshing  bool
+	// Refreshes are numbered as they start, so one that returns late doesn't undo a later one
+	started int
+	applied int
+}
+
+// changed reports whether m is far enough from the applied multiplier to change the rate, always if one of them is 0.
+func (km *keyMultiplier) changed(m, epsilon float64) bool {
+	if m == 0 || km.value == 0 {
+		return m != km.value
+	}
+	return math.Abs(m-km.value) > epsilon
+}
+
+// stats returns the multiplier stats of the key.
+func (km *keyMultiplier) stats() *MultiplierStats {
+	return &MultiplierStats{
+		Multiplier:  km.value,
+		Base:        km.base,
+		Effective:   scaleRate(km.base, km.value),
+		RefreshedAt: km.refreshedAt,
+	}
+}
+
+// scaleRate multiplies the count of rate by m, rounded. If that rounds down to nothing but m isn't 0, it lengthens the
+// period of a single event instead, so 1/s scaled by 0.5 is 1/2s.
+func scaleRate(rate Rate, m float64) Rate {
+	if m <= 0 {
+		return Rate{Count: 0, Per: rate.Per}
+	}
+
+	scaled := float64(rate.Count) * m
+	if count := math.Round(scaled); count >= 1 {
+		return Rate{Count: int(count), Per: rate.Per}
+	}
+	return Rate{Count: 1, Per: time.Duration(float64(rate.Per) / scaled)}
+}
+
+// newKeyMultiplier returns the multiplier of a key created with limiter, at 1 until it's first refreshed.
+func newKeyMultiplier(limiter Limiter) *keyMultiplier {
+	km := &keyMultiplier{limiter: limiter, value: 1}
+	if c, ok := limiter.(Configurer); ok {
+		km.base = c.Limit()
+	}
+	return km
+}
+
+// refreshDue reports whether the multiplier of key is due to be asked for again, marking it as being refreshed if so.
+func (k *KeyedLimiter) refreshDue(key string) bool {
+	// This must be called with both mutexes already locked
+	km, ok := k.multiplied[key]
+	if !ok || km.refreshing {
+		return false
+	}
+	if !km.refreshedAt.IsZero() && k.clock.Now().Sub(km.refreshedAt) < k.multiplier.refresh {
+		return false
+	}
+	km.refreshing = true
+	return true
+}
+
+// RefreshKey asks for the multiplier of key right away instead of waiting for its refresh, e.g. when the score of a
+// client changed, and changes the rate of its limiter if the multiplier moved by more than the epsilon. It does
+// nothing if the keyed limiter wasn't created WithMultiplier or key isn't in use.
+func (k *KeyedLimiter) RefreshKey(key string) {
+	if k.multiplier == nil {
+		return
+	}
+
+	k.mux.Lock()
+	k.keysMux.Lock()
+	km, ok := k.multiplied[key]
+	k.keysMux.Unlock()
+	if !ok {
+		k.mux.Unlock()
+		return
+	}
+	km.started++
+	refresh := km.started
+	k.mux.Unlock()
+
+	// Called without holding the lock, it may be slow
+	m := max(k.multiplier.fn(key), 0)
+
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	if refresh < km.applied {
+		return
+	}
+	km.applied = refresh
+	km.refreshing = false
+	km.refreshedAt = k.clock.Now()
+	if !km.changed(m, k.multiplier.epsilon) {
+		return
+	}
+
+	km.value = m
+	if s, ok := km.limiter.(rateSetter); ok {
+		s.setRate(scaleRate(km.base, m))
+	}
+}
+
+// KeyStats returns the stats of the limiter of key, creating it if it's the first time key is used, with the
+// multiplier applied to it if the keyed limiter was created WithMultiplier.
+func (k *KeyedLimiter) KeyStats(key string) Stats {
+	stats := k.Get(key).Stats()
+
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	k.keysMux.Lock()
+	defer k.keysMux.Unlock()
+	if km, ok := k.multiplied[key]; ok {
+		stats.Multiplier = km.stats()
+	}
+	return stats
+}
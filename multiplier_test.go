@@ -0,0 +1,129 @@
+package limit_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limittest"
+	"github.com/stretchr/testify/assert"
+)
+
+// scores is a reputation system scoring keys, 1 unless set otherwise.
+type scores struct {
+	mux    sync.Mutex
+	scores map[string]float64
+	calls  atomic.Int64
+}
+
+func (s *scores) set(key string, score float64) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.scores == nil {
+		s.scores = make(map[string]float64)
+	}
+	s.scores[key] = score
+}
+
+func (s *scores) score(key string) float64 {
+	s.calls.Add(1)
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if score, ok := s.scores[key]; ok {
+		return score
+	}
+	return 1
+}
+
+func TestKeyedLimiter_Multiplier(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Now())
+	var s scores
+	keyed := limit.NewKeyedLimiter(func(string) limit.Limiter {
+		return limit.NewTokenBucket(10, 1*time.Second, limit.WithClock(clock))
+	}, limit.WithClock(clock), limit.WithMultiplier(s.score, 1*time.Minute))
+
+	allowed := func() int {
+		n := 0
+		for keyed.Get("user").Allowed() {
+			n++
+		}
+		return n
+	}
+	assert.Equal(t, 10, allowed())
+
+	// Halving the score mid-traffic halves the rate
+	s.set("user", 0.5)
+	keyed.RefreshKey("user")
+	clock.Advance(1 * time.Second)
+	assert.Equal(t, 5, allowed())
+
+	stats := keyed.KeyStats("user")
+	if assert.NotNil(t, stats.Multiplier) {
+		assert.Equal(t, 0.5, stats.Multiplier.Multiplier)
+		assert.Equal(t, limit.Rate{Count: 10, Per: 1 * time.Second}, stats.Multiplier.Base)
+		assert.Equal(t, limit.Rate{Count: 5, Per: 1 * time.Second}, stats.Multiplier.Effective)
+		assert.Equal(t, clock.Now().Add(-1*time.Second), stats.Multiplier.RefreshedAt)
+	}
+	assert.Equal(t, 15, stats.AllowedRequests)
+
+	// A score of 0 denies everything, however long the key waits
+	s.set("user", 0)
+	keyed.RefreshKey("user")
+	clock.Advance(1 * time.Hour)
+	assert.Equal(t, 0, allowed())
+	assert.Error(t, keyed.Get("user").WaitContext(context.Background()))
+
+	// Raising it again refills from empty at the new rate
+	s.set("user", 2)
+	keyed.RefreshKey("user")
+	assert.Equal(t, 0, allowed())
+	clock.Advance(1 * time.Second)
+	assert.Equal(t, 20, allowed())
+
+	// Other keys keep their rate
+	assert.Nil(t, limit.NewKeyedLimiter(userAndOrg).KeyStats("user").Multiplier)
+	assert.Equal(t, 1.0, keyed.KeyStats("other").Multiplier.Multiplier)
+}
+
+func TestKeyedLimiter_Multiplier_Refresh(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Now())
+	var s scores
+	keyed := limit.NewKeyedLimiter(func(string) limit.Limiter {
+		return limit.NewRollingWindow(10, 1*time.Second, limit.WithClock(clock))
+	}, limit.WithClock(clock), limit.WithMultiplier(s.score, 1*time.Minute), limit.WithMultiplierEpsilon(0.1))
+	rate := func() limit.Rate {
+		return keyed.Get("user").(limit.Configurer).Limit()
+	}
+
+	// The first use asks for the score in the background, and not again until the refresh is due
+	keyed.Get("user")
+	assert.Eventually(t, func() bool { return s.calls.Load() == 1 }, 1*time.Second, 1*time.Millisecond)
+	keyed.Get("user")
+	assert.Equal(t, int64(1), s.calls.Load())
+
+	// Changes within the epsilon keep the rate
+	s.set("user", 1.05)
+	clock.Advance(1 * time.Minute)
+	keyed.Get("user")
+	assert.Eventually(t, func() bool { return s.calls.Load() == 2 }, 1*time.Second, 1*time.Millisecond)
+	assert.Equal(t, limit.Rate{Count: 10, Per: 1 * time.Second}, rate())
+
+	s.set("user", 1.5)
+	clock.Advance(1 * time.Minute)
+	keyed.Get("user")
+	assert.Eventually(t, func() bool {
+		return rate() == limit.Rate{Count: 15, Per: 1 * time.Second}
+	}, 1*time.Second, 1*time.Millisecond)
+
+	// Multipliers too small for a single event per window lengthen it instead
+	s.set("user", 0.03125)
+	keyed.RefreshKey("user")
+	assert.Equal(t, limit.Rate{Count: 1, Per: 3200 * time.Millisecond}, rate())
+}
@@ -0,0 +1,705 @@
+package limit
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"time"
+)
+
+// Option configures a limiter on construction. Options that only apply to some limiters say so, and NewTokenBucket,
+// NewRollingWindow and NewLeakyBucket reject the ones that don't apply to them.
+type Option func(*options)
+
+// limiterKind is a set of the limiters an option applies to.
+type limiterKind int
+
+const (
+	kindTokenBucket limiterKind = 1 << iota
+	kindRollingWindow
+	kindLeakyBucket
+	// The other limiters and helpers, which don't check their options
+	kindOther
+
+	kindAll = kindTokenBucket | kindRollingWindow | kindLeakyBucket | kindOther
+)
+
+func (k limiterKind) String() string {
+	switch k {
+	case kindTokenBucket:
+		return "token bucket"
+	case kindRollingWindow:
+		return "rolling window"
+	case kindLeakyBucket:
+		return "leaky bucket"
+	default:
+		return fmt.Sprintf("limiterKind(%d)", int(k))
+	}
+}
+
+// restrictedOption is an option that only applies to some kinds of limiters.
+type restrictedOption struct {
+	name  string
+	kinds limiterKind
+}
+
+type options struct {
+	name              string
+	clock             Clock
+	reservationMode   ReservationMode
+	ttlFromContext    bool
+	budgetAhead       int
+	leadingEdge       bool
+	discardOnClose    bool
+	overflowPolicy    OverflowPolicy
+	blackouts         blackouts
+	labels            map[string]string
+	smoothing         time.Duration
+	startAt           time.Time
+	maxWait           time.Duration
+	linkReservations  bool
+	planTTL           time.Duration
+	planErrorTTL      time.Duration
+	planFallback      PlanFallback
+	evictionHook      EvictionHook
+	keyCache          KeyCache
+	idleTimeout       time.Duration
+	maxKeys           int
+	admissionHook     AdmissionHook
+	multiplier        func(key string) float64
+	multiplierRefresh time.Duration
+	multiplierEpsilon float64
+	abandonedAfter    time.Duration
+	onAbandoned       func(info ReservationInfo)
+	reservationStacks bool
+	partitionInterval time.Duration
+	clockTolerance    time.Duration
+	onClockAnomaly    func(anomaly ClockAnomaly)
+	trickleRate       Rate
+	borrowBackoff     time.Duration
+	configPoll        time.Duration
+	configDecoder     func(data []byte, v any) error
+	onConfigError     func(err error)
+	retireRemoved     bool
+	retireDrain       time.Duration
+	exportBuffer      int
+	exportAttempts    int
+	exportBackoff     time.Duration
+	oversubscription  float64
+	requireLimiter    bool
+	limiterInContext  bool
+	adaptiveQueue     *adaptiveQueue
+	statsHeartbeat    time.Duration
+	slack             int
+	pausePolicy       PausePolicy
+	burst             int
+	initialTokens     int
+	maxWaiters        int
+	defaultTTL        *time.Duration
+	increaseStep      int
+	increaseAfter     int
+	decreaseFactor    float64
+	decreaseAfter     int
+	decreaseCooldown  time.Duration
+	maxStarvation     time.Duration
+	fallbackCooldown  time.Duration
+	jitter            float64
+	jitterSource      rand.Source
+	restricted        []restrictedOption
+	accepted          limiterKind // Set by acceptOptions
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		clock:             realClock{},
+		budgetAhead:       1,
+		planTTL:           1 * time.Minute,
+		planErrorTTL:      10 * time.Second,
+		partitionInterval: 10 * time.Second,
+		multiplierEpsilon: 0.01,
+		trickleRate:       Rate{Count: 1, Per: 1 * time.Second},
+		borrowBackoff:     1 * time.Second,
+		configPoll:        5 * time.Second,
+		configDecoder:     json.Unmarshal,
+		exportAttempts:    3,
+		exportBackoff:     1 * time.Second,
+		statsHeartbeat:    5 * time.Second,
+		slack:             10,
+		initialTokens:     -1,
+		increaseStep:      1,
+		increaseAfter:     10,
+		decreaseFactor:    0.5,
+		decreaseAfter:     3,
+		decreaseCooldown:  -1,
+		maxStarvation:     30 * time.Second,
+		fallbackCooldown:  30 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// only records that the option called name only applies to kinds.
+func (o *options) only(name string, kinds limiterKind) {
+	o.restricted = append(o.restricted, restrictedOption{name: name, kinds: kinds})
+}
+
+// check returns an error naming the first option that doesn't apply to kind, nil if they all do.
+func (o options) check(kind limiterKind) error {
+	for _, r := range o.restricted {
+		if r.kinds&(kind|o.accepted) == 0 {
+			return fmt.Errorf("option %s doesn't apply to a %s", r.name, kind)
+		}
+	}
+	return nil
+}
+
+// acceptOptions makes the limiters created with the options accept the ones of kinds too, for the constructors that
+// pass their options on to the limiters they create after applying their own.
+func acceptOptions(opts []Option, kinds limiterKind) []Option {
+	return append(slices.Clip(opts), func(o *options) {
+		o.accepted |= kinds
+	})
+}
+
+// WithName names the limiter. The name is included in the errors it returns to tell it apart from other limiters.
+func WithName(name string) Option {
+	return func(o *options) {
+		o.name = name
+	}
+}
+
+// WithLabels attaches static labels to the limiter, like the team owning it or the dependency it protects, for
+// integrations to add to their metrics, logs and spans.
+func WithLabels(labels map[string]string) Option {
+	labels = maps.Clone(labels)
+	return func(o *options) {
+		o.labels = labels
+	}
+}
+
+// WithClock makes the limiter tell the time with the given clock instead of the time package.
+func WithClock(clock Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+// WithReservationTTLFromContext caps the expiry of reservations by the deadline of the context they were requested
+// with, if it has one, in addition to their TTL.
+func WithReservationTTLFromContext() Option {
+	return func(o *options) {
+		o.ttlFromContext = true
+	}
+}
+
+// WithDefaultReservationTTL makes reservations requested with a nil TTL expire after d, instead of never.
+func WithDefaultReservationTTL(d time.Duration) Option {
+	return func(o *options) {
+		o.defaultTTL = &d
+	}
+}
+
+// ReservationMode chooses when a rolling window reservation is recorded as an event in the window.
+type ReservationMode int
+
+const (
+	// ReservationCountsAtConsume records the event when the reservation is consumed. The reservation holds a slot
+	// while pending, and the event then holds it for a full window, so a reservation held for a while uses its slot
+	// for the hold time plus the window. This is the default.
+	ReservationCountsAtConsume ReservationMode = iota
+	// ReservationCountsAtReserve records the event when the reservation is taken and Consume only acknowledges it.
+	// The slot is held for exactly one window from the reservation, so a reservation consumed after its window has
+	// passed doesn't count against the current one, and consumption can exceed the limit within a window as measured
+	// at consume time.
+	ReservationCountsAtReserve
+)
+
+// WithReservationMode sets when reservations are recorded in the window. It only applies to the rolling window.
+func WithReservationMode(mode ReservationMode) Option {
+	return func(o *options) {
+		o.only("WithReservationMode", kindRollingWindow)
+		o.reservationMode = mode
+	}
+}
+
+// WithSmoothing spreads the rolling window admissions over the window by also capping the events of any sub-interval
+// at its share of the limit, rounded up. A limit of 600 per minute smoothed over 1s admits at most 10 events in any
+// second. It only applies to the rolling window.
+func WithSmoothing(subInterval time.Duration) Option {
+	return func(o *options) {
+		o.only("WithSmoothing", kindRollingWindow)
+		o.smoothing = subInterval
+	}
+}
+
+// WithBudgetAhead sets how many requests a budget limiter may get ahead of its schedule, 1 by default. It only applies
+// to the budget limiter.
+func WithBudgetAhead(n int) Option {
+	return func(o *options) {
+		o.only("WithBudgetAhead", kindOther)
+		o.budgetAhead = n
+	}
+}
+
+// WithLeadingEdge makes a debounced function run on the first call of a burst instead of after the burst. It only
+// applies to DebounceFunc.
+func WithLeadingEdge() Option {
+	return func(o *options) {
+		o.only("WithLeadingEdge", kindOther)
+		o.leadingEdge = true
+	}
+}
+
+// WithDiscardOnClose makes Close drop the queued items instead of handling them first. It only applies to the leaky
+// worker.
+func WithDiscardOnClose() Option {
+	return func(o *options) {
+		o.only("WithDiscardOnClose", kindOther)
+		o.discardOnClose = true
+	}
+}
+
+// OverflowPolicy chooses what happens to a request arriving at a full queue.
+type OverflowPolicy int
+
+const (
+	// RejectNew fails the new request with a queue full error. This is the default.
+	RejectNew OverflowPolicy = iota
+	// DropOldest evicts the request that has been queued the longest, which fails with ErrEvicted, to queue the new one.
+	DropOldest
+	// DropNewest fails the new request with ErrDropped, counting it like a request Allowed turned down rather than as
+	// a queue full error.
+	DropNewest
+)
+
+// WithOverflowPolicy sets what happens to requests arriving at a full queue. It applies to the leaky bucket and the
+// leaky worker.
+func WithOverflowPolicy(p OverflowPolicy) Option {
+	return func(o *options) {
+		o.only("WithOverflowPolicy", kindLeakyBucket|kindOther)
+		o.overflowPolicy = p
+	}
+}
+
+// PausePolicy chooses what happens to a reservation requested while the limiter is paused, see Pauser.
+type PausePolicy int
+
+const (
+	// BlockWhilePaused makes the reservation wait for the limiter to resume, like a wait. This is the default.
+	BlockWhilePaused PausePolicy = iota
+	// FailWhilePaused fails the reservation with ErrPaused right away.
+	FailWhilePaused
+)
+
+// WithPausePolicy sets what happens to reservations requested while the limiter is paused.
+func WithPausePolicy(p PausePolicy) Option {
+	return func(o *options) {
+		o.pausePolicy = p
+	}
+}
+
+// WithAdaptiveQueue makes a leaky bucket size its queue so a full queue leaks in about targetMaxWait at the leak rate,
+// clamped to [minQueue, maxQueue], instead of using the queue size it was created with. The size is checked again
+// every targetMaxWait and whenever the leak rate changes. It grows at once but shrinks gradually, half the way at a
+// time, without ejecting queued callers. It only applies to the leaky bucket.
+func WithAdaptiveQueue(targetMaxWait time.Duration, minQueue, maxQueue int) Option {
+	return func(o *options) {
+		o.only("WithAdaptiveQueue", kindLeakyBucket)
+		o.adaptiveQueue = &adaptiveQueue{targetMaxWait: targetMaxWait, minQueue: minQueue, maxQueue: maxQueue}
+	}
+}
+
+// WithAdditiveIncrease makes an adaptive limiter raise its count by step after every successes requests reported as
+// successful, 1 after 10 by default. It only applies to NewAdaptiveLimiter.
+func WithAdditiveIncrease(step, successes int) Option {
+	return func(o *options) {
+		o.only("WithAdditiveIncrease", kindOther)
+		o.increaseStep = step
+		o.increaseAfter = successes
+	}
+}
+
+// WithMultiplicativeDecrease makes an adaptive limiter multiply its count by factor once failures requests were reported
+// as failed since its last adjustment, and not again until cooldown went by, so the failures of requests sent at the
+// old rate don't cut it twice. It's 0.5 after 3 failures with a cooldown of the limiter's period by default. It only
+// applies to NewAdaptiveLimiter.
+func WithMultiplicativeDecrease(factor float64, failures int, cooldown time.Duration) Option {
+	return func(o *options) {
+		o.only("WithMultiplicativeDecrease", kindOther)
+		o.decreaseFactor = factor
+		o.decreaseAfter = failures
+		o.decreaseCooldown = cooldown
+	}
+}
+
+// WithMaxStarvation sets how long a caller of a PriorityLimiter waits before it's promoted ahead of every priority, 30s
+// by default. Zero never promotes callers, so a steady stream of higher priorities can starve the lower ones. It only
+// applies to NewPriorityLimiter.
+func WithMaxStarvation(d time.Duration) Option {
+	return func(o *options) {
+		o.only("WithMaxStarvation", kindOther)
+		o.maxStarvation = d
+	}
+}
+
+// WithFallbackCooldown sets how long a fallback limiter serves the calls with its secondary limiter after the primary
+// failed, before probing the primary again, 30s by default. It only applies to NewFallbackLimiter.
+func WithFallbackCooldown(d time.Duration) Option {
+	return func(o *options) {
+		o.only("WithFallbackCooldown", kindOther)
+		o.fallbackCooldown = d
+	}
+}
+
+// WithSlack sets how many requests a paced limiter allows at once to catch up after a stall, 10 by default. It only
+// applies to NewPaced.
+func WithSlack(n int) Option {
+	return func(o *options) {
+		o.only("WithSlack", kindOther)
+		o.slack = n
+	}
+}
+
+// WithoutSlack makes a paced limiter restart its schedule after a stall instead of catching up, so requests are never
+// closer than the pacing interval. It only applies to NewPaced.
+func WithoutSlack() Option {
+	return WithSlack(0)
+}
+
+// WithBurst sets the capacity of a token bucket to n instead of its count, so it can allow n requests at once while
+// refilling at its rate. The capacity scales with the rate when leases or a new rate change it. It only applies to
+// NewTokenBucket.
+func WithBurst(n int) Option {
+	return func(o *options) {
+		o.only("WithBurst", kindTokenBucket)
+		o.burst = n
+	}
+}
+
+// WithInitialTokens makes a token bucket start with n tokens, capped by its capacity, instead of full. It only applies
+// to NewTokenBucket.
+func WithInitialTokens(n int) Option {
+	return func(o *options) {
+		o.only("WithInitialTokens", kindTokenBucket)
+		o.initialTokens = n
+	}
+}
+
+// WithBlackouts makes the limiter deny every request while the time of day in loc, UTC if nil, falls in one of
+// windows. Waiting callers sleep until the window ends.
+func WithBlackouts(windows []ClockRange, loc *time.Location) Option {
+	if loc == nil {
+		loc = time.UTC
+	}
+	windows = slices.Clone(windows)
+	return withBlackouts(blackouts{ranges: windows, loc: loc})
+}
+
+// WithStartTime keeps the limiter closed until t, denying every request before it while waiting callers sleep until
+// then. The limiter starts at t with the allowance it would have had if it had been created then.
+func WithStartTime(t time.Time) Option {
+	return func(o *options) {
+		o.startAt = t
+	}
+}
+
+// WithMaxWait makes the limiter turn away callers expected to wait longer than d in total with a WaitTooLongError,
+// instead of queuing them. The expected wait is checked when the caller arrives and every time it tries again, so a
+// caller admitted to wait may still be turned away if capacity frees up later than expected. Wait and Reserve can't
+// return the error, so they return without being admitted or with an unusable reservation.
+func WithMaxWait(d time.Duration) Option {
+	return func(o *options) {
+		o.maxWait = d
+	}
+}
+
+// WithJitter stretches every sleep of a caller waiting for the limiter by a random share of up to fraction of it, so
+// clients of limiters with the same parameters don't all wake at the same instants and stampede the downstream. It
+// only ever lengthens the sleeps, a caller is never admitted earlier than without jitter. See NewJitterLimiter for
+// limiters that don't take it.
+func WithJitter(fraction float64) Option {
+	return func(o *options) {
+		o.jitter = fraction
+	}
+}
+
+// WithJitterSource makes the jitter set with WithJitter draw from src instead of the global source, e.g. a seeded one
+// in tests.
+func WithJitterSource(src rand.Source) Option {
+	return func(o *options) {
+		o.jitterSource = src
+	}
+}
+
+// WithMaxWaiters caps how many callers can wait for the limiter at once. Callers arriving once n are waiting are turned
+// away with ErrQueueFull, counted as ReasonQueueFull, instead of queuing.
+func WithMaxWaiters(n int) Option {
+	return func(o *options) {
+		o.maxWaiters = n
+	}
+}
+
+// WithLinkedReservations makes the reservations taken with ReserveContext cancel themselves once the context they were
+// requested with is done, if they weren't consumed by then, so a caller that goes away doesn't hold capacity until the
+// TTL. Consuming a reservation after it canceled itself fails with ErrReservationCanceled.
+func WithLinkedReservations() Option {
+	return func(o *options) {
+		o.linkReservations = true
+	}
+}
+
+// WithAbandonedReservationDetector calls fn for every reservation still pending, neither consumed, canceled nor
+// expired, after it was taken, which usually means an error path forgot to cancel it. The reports are counted in
+// Stats. It's a debugging aid: each reservation keeps a goroutine until after passes.
+func WithAbandonedReservationDetector(after time.Duration, fn func(info ReservationInfo)) Option {
+	return func(o *options) {
+		o.abandonedAfter = after
+		o.onAbandoned = fn
+	}
+}
+
+// WithReservationOversubscription lets reservations be taken for up to factor times the free capacity, e.g. 1.3 for
+// 130%, for workloads canceling many of their reservations. Consume still enforces the limit: a reservation consumed
+// while there is no capacity for it fails with ErrOversubscribed, counted in Stats().Oversubscribed. It applies to the
+// token bucket and to the rolling window with ReservationCountsAtConsume.
+func WithReservationOversubscription(factor float64) Option {
+	return func(o *options) {
+		o.oversubscription = factor
+	}
+}
+
+// WithReservationStacks records the stack taking each reservation for the abandoned reservation detector to report.
+// Capturing stacks is slow, so it's off by default.
+func WithReservationStacks() Option {
+	return func(o *options) {
+		o.reservationStacks = true
+	}
+}
+
+// WithClockAnomalies sets how far ahead of the clock a timestamp of the limiter may be before it's counted in
+// Stats.ClockAnomalies, and calls fn, if not nil, on its own goroutine for each one. Timestamps ahead of the clock are
+// pulled back to it either way, the tolerance only keeps small steps out of the reports.
+func WithClockAnomalies(tolerance time.Duration, fn func(anomaly ClockAnomaly)) Option {
+	return func(o *options) {
+		o.clockTolerance = tolerance
+		o.onClockAnomaly = fn
+	}
+}
+
+// WithPartitionInterval sets how often a limiter created with NewPartitioned counts the instances again, 10 seconds by
+// default. It only applies to the partitioned limiter.
+func WithPartitionInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.only("WithPartitionInterval", kindOther)
+		o.partitionInterval = d
+	}
+}
+
+// WithPlanTTL sets how long a PlanLimiter caches the plan of a key, 1 minute by default. A change of plan is picked up
+// once the cached one expires. It only applies to the plan limiter.
+func WithPlanTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.only("WithPlanTTL", kindOther)
+		o.planTTL = ttl
+	}
+}
+
+// WithPlanErrorTTL sets how long a PlanLimiter caches the outcome of a failed resolution, 10 seconds by default, so a
+// resolver that is down isn't asked again on every request. It only applies to the plan limiter.
+func WithPlanErrorTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.only("WithPlanErrorTTL", kindOther)
+		o.planErrorTTL = ttl
+	}
+}
+
+// WithPlanFallback sets what a PlanLimiter does when its resolver fails, which by default is limiting the key to one
+// request per second, see FallbackTo. It only applies to the plan limiter.
+func WithPlanFallback(fallback PlanFallback) Option {
+	return func(o *options) {
+		o.only("WithPlanFallback", kindOther)
+		o.planFallback = fallback
+	}
+}
+
+// WithTrickleRate sets the rate a borrowing limiter allows while its quota source fails and it has no tokens left,
+// one request per second by default. It only applies to the borrowing limiter.
+func WithTrickleRate(rate Rate) Option {
+	return func(o *options) {
+		o.only("WithTrickleRate", kindOther)
+		o.trickleRate = rate
+	}
+}
+
+// WithBorrowBackoff sets how long a borrowing limiter waits before borrowing again after its quota source failed or
+// granted less than a chunk, 1 second by default. It only applies to the borrowing limiter.
+func WithBorrowBackoff(d time.Duration) Option {
+	return func(o *options) {
+		o.only("WithBorrowBackoff", kindOther)
+		o.borrowBackoff = d
+	}
+}
+
+// WithEvictionHook makes a KeyedLimiter call hook with the final stats of every key it drops, e.g. to flush them to
+// metrics. It only applies to the keyed limiter.
+func WithEvictionHook(hook EvictionHook) Option {
+	return func(o *options) {
+		o.only("WithEvictionHook", kindOther)
+		o.evictionHook = hook
+	}
+}
+
+// WithIdleTimeout makes a KeyedLimiter drop the limiter of a key once it went unused for d, unless it has waiters or
+// pending reservations. It only applies to the keyed limiter.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.only("WithIdleTimeout", kindOther)
+		o.idleTimeout = d
+	}
+}
+
+// WithMaxKeys makes a KeyedLimiter hold at most n keys in a cache created with NewLRUCache, evicting the least recently
+// used one when a new key would exceed n. If all n keys are busy, the cache refuses the new key, which keeps its limiter
+// outside the cache until a later use finds room, so the keyed limiter only holds more than n keys for the ones that
+// came while all n were busy. WithKeyCache takes precedence over it. It only applies to the keyed limiter.
+func WithMaxKeys(n int) Option {
+	return func(o *options) {
+		o.only("WithMaxKeys", kindOther)
+		o.maxKeys = n
+	}
+}
+
+// WithKeyCache makes a KeyedLimiter store its limiters in cache instead of a map, e.g. to bound their memory. Keys
+// the cache evicts are reported to the eviction hook, and limiters with waiters or pending reservations are never
+// evicted. It only applies to the keyed limiter.
+func WithKeyCache(cache KeyCache) Option {
+	return func(o *options) {
+		o.only("WithKeyCache", kindOther)
+		o.keyCache = cache
+	}
+}
+
+// WithMultiplier makes a KeyedLimiter scale the rate of each key by fn(key), e.g. a reputation score, without
+// rebuilding its limiter: 2 doubles the rate and 0 denies every request of the key until the multiplier changes. fn
+// is called in the background when a key is used at least refresh after it was last called, or right away with
+// RefreshKey. A new key starts at 1 until then. Only the token bucket and the rolling window change their rate, other
+// limiters keep theirs. It only applies to the keyed limiter.
+func WithMultiplier(fn func(key string) float64, refresh time.Duration) Option {
+	return func(o *options) {
+		o.only("WithMultiplier", kindOther)
+		o.multiplier = fn
+		o.multiplierRefresh = refresh
+	}
+}
+
+// WithMultiplierEpsilon sets how much the multiplier of a key must change for its rate to change, 0.01 by default, so
+// a score that jitters doesn't keep changing the rate. A change from or to 0 always applies. It only applies to the
+// keyed limiter with WithMultiplier.
+func WithMultiplierEpsilon(epsilon float64) Option {
+	return func(o *options) {
+		o.only("WithMultiplierEpsilon", kindOther)
+		o.multiplierEpsilon = epsilon
+	}
+}
+
+// WithAdmissionHook makes the HTTP middleware call hook after deciding on each request it limits, e.g. to annotate the
+// request's span. It only applies to Middleware.
+func WithAdmissionHook(hook AdmissionHook) Option {
+	return func(o *options) {
+		o.only("WithAdmissionHook", kindOther)
+		o.admissionHook = hook
+	}
+}
+
+// withBlackouts carries a limiter's blackouts over to the limiters it creates, like the ones backing its leases.
+func withBlackouts(bs blackouts) Option {
+	return func(o *options) {
+		o.blackouts = bs
+	}
+}
+
+// WithConfigPoll sets how often WatchConfig reads the configuration file, 5s by default.
+func WithConfigPoll(interval time.Duration) Option {
+	return func(o *options) {
+		o.only("WithConfigPoll", kindOther)
+		o.configPoll = interval
+	}
+}
+
+// WithConfigDecoder makes WatchConfig decode the configuration file with decode, e.g. yaml.Unmarshal, instead of
+// json.Unmarshal.
+func WithConfigDecoder(decode func(data []byte, v any) error) Option {
+	return func(o *options) {
+		o.only("WithConfigDecoder", kindOther)
+		o.configDecoder = decode
+	}
+}
+
+// WithConfigErrors makes WatchConfig call fn with the error of each configuration file it rejected after the first
+// load. The limiters are left as they were.
+func WithConfigErrors(fn func(err error)) Option {
+	return func(o *options) {
+		o.only("WithConfigErrors", kindOther)
+		o.onConfigError = fn
+	}
+}
+
+// WithRetireRemoved makes a Registry drop the limiters whose config was removed once they have no waiters or pending
+// reservations, or drain after the removal at the latest. Without it they stay in the registry. WatchConfig checks
+// them on every poll.
+func WithRetireRemoved(drain time.Duration) Option {
+	return func(o *options) {
+		o.only("WithRetireRemoved", kindOther)
+		o.retireRemoved = true
+		o.retireDrain = drain
+	}
+}
+
+// WithExportBuffer sets how many events an Exporter buffers before dropping new ones, 10 batches by default.
+func WithExportBuffer(n int) Option {
+	return func(o *options) {
+		o.only("WithExportBuffer", kindOther)
+		o.exportBuffer = n
+	}
+}
+
+// WithExportRetries sets how many times an Exporter tries to deliver a batch before dropping it, 3 by default, waiting
+// backoff after the first failure and twice as long after each of the next, 1s by default.
+func WithExportRetries(attempts int, backoff time.Duration) Option {
+	return func(o *options) {
+		o.only("WithExportRetries", kindOther)
+		o.exportAttempts = attempts
+		o.exportBackoff = backoff
+	}
+}
+
+// WithRequiredLimiter makes WaitFromContext fail with ErrNoLimiter when the context carries no limiter, instead of
+// returning right away.
+func WithRequiredLimiter() Option {
+	return func(o *options) {
+		o.only("WithRequiredLimiter", kindOther)
+		o.requireLimiter = true
+	}
+}
+
+// WithLimiterInContext makes the HTTP middleware set the limiter of the route a request matched on its context, see
+// FromContext, and the route name as its key, see KeyFromContext, so the handlers can take more permits of the same
+// limiter. It only applies to Middleware.
+func WithLimiterInContext() Option {
+	return func(o *options) {
+		o.only("WithLimiterInContext", kindOther)
+		o.limiterInContext = true
+	}
+}
+
+// WithStatsHeartbeat sets how often EventsHandler sends the stats of its limiters, 5s by default.
+func WithStatsHeartbeat(interval time.Duration) Option {
+	return func(o *options) {
+		o.only("WithStatsHeartbeat", kindOther)
+		o.statsHeartbeat = interval
+	}
+}
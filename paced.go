@@ -0,0 +1,482 @@
+package limit
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// PacedLimiter is a Limiter spacing requests evenly, see NewPaced.
+type PacedLimiter interface {
+	Limiter
+	// Take blocks until the next request is scheduled and returns the time it was scheduled for, or the zero time once
+	// the limiter is closed.
+	Take() time.Time
+}
+
+type paced struct {
+	base
+
+	// Config
+	perSecond  int
+	perRequest time.Duration
+	maxSlack   time.Duration // How far behind schedule the pacing catches up
+
+	// State
+	last time.Time // When the last request was scheduled, zero before the first one
+
+	// Reservations tracking
+	pendingReservations map[*pacedReservation]struct{}
+}
+
+// NewPaced creates a limiter spacing requests evenly at perSecond, like go.uber.org/ratelimit. The first request is
+// allowed right away and each one after it is scheduled a 1/perSecond interval after the previous one. After a stall,
+// the limiter catches up by allowing up to 10 requests at once, or as set with WithSlack, before spacing them again.
+// WithoutSlack turns catching up off, so the schedule restarts from the next request.
+//
+// Reservations take their requests' places in the schedule. Canceling one gives them back only if no request was
+// scheduled after it.
+func NewPaced(perSecond int, opts ...Option) PacedLimiter {
+	o := newOptions(opts)
+	perRequest := time.Second / time.Duration(perSecond)
+	p := &paced{
+		perSecond:           perSecond,
+		perRequest:          perRequest,
+		maxSlack:            time.Duration(o.slack) * perRequest,
+		pendingReservations: make(map[*pacedReservation]struct{}),
+	}
+	p.init(o)
+	p.remaining = func() int { return p.issuableLocked(p.clock.Now()) }
+	p.nextAllowed = func() time.Time {
+		now := p.clock.Now()
+		return latest(p.nextLocked(now), now)
+	}
+	return p
+}
+
+// nextLocked returns when the next request is scheduled if it arrives at now.
+func (p *paced) nextLocked(now time.Time) time.Time {
+	// This must be called with the mutex already locked
+	if now.Before(p.last) {
+		// The wall clock stepped backwards, schedule from now instead of waiting for it to catch up
+		p.clockStepped(p.last.Sub(now))
+		p.last = now.Add(-p.perRequest)
+	}
+
+	sinceLast := now.Sub(p.last)
+	switch {
+	case p.last.IsZero(), p.maxSlack == 0 && sinceLast > p.perRequest:
+		return now
+	case p.maxSlack > 0 && sinceLast > p.maxSlack+p.perRequest:
+		// Catch up with at most the slack
+		return now.Add(-p.maxSlack)
+	default:
+		return p.last.Add(p.perRequest)
+	}
+}
+
+// tryScheduleLocked schedules the next n requests if their time came, returning when the first one is scheduled,
+// otherwise it returns how long until it does.
+func (p *paced) tryScheduleLocked(n int) (time.Time, bool, time.Duration) {
+	// This must be called with the mutex already locked
+	now := p.clock.Now()
+	last := p.last
+	var first time.Time
+	for i := range n {
+		next := p.nextLocked(now)
+		if next.After(now) || !p.closedUntil().IsZero() {
+			p.last = last
+			return time.Time{}, false, p.retryIn(next.Add(time.Duration(n-1-i)*p.perRequest), p.perRequest)
+		}
+		if i == 0 {
+			first = next
+		}
+		p.last = next
+	}
+	return first, true, 0
+}
+
+// issuableLocked returns how many requests could be scheduled at now, at most the slack plus one.
+func (p *paced) issuableLocked(now time.Time) int {
+	// This must be called with the mutex already locked
+	last := p.last
+	defer func() { p.last = last }()
+
+	n := 0
+	for ; n <= int(p.maxSlack/p.perRequest); n++ {
+		next := p.nextLocked(now)
+		if next.After(now) {
+			break
+		}
+		p.last = next
+	}
+	return n
+}
+
+// Take blocks until the next request is scheduled and returns the time it was scheduled for, which is in the past
+// while the limiter catches up after a stall.
+func (p *paced) Take() time.Time {
+	var at time.Time
+	_ = p.await(context.Background(), func() (bool, time.Duration, error) {
+		var ok bool
+		var retryIn time.Duration
+		at, ok, retryIn = p.tryScheduleLocked(1)
+		if ok {
+			p.countAllowed()
+		}
+		return ok, retryIn, nil
+	}, nil)
+	return at
+}
+
+func (p *paced) WaitContext(ctx context.Context) error {
+	return p.WaitNContext(ctx, 1)
+}
+
+func (p *paced) WaitN(n int) error {
+	return p.WaitNContext(context.Background(), n)
+}
+
+func (p *paced) WaitNTimeout(timeout time.Duration, n int) error {
+	ctx, cancel := withTimeout(p.clock, timeout)
+	defer cancel()
+	return p.WaitNContext(ctx, n)
+}
+
+// WaitNContext waits until n requests can all be scheduled by now, which takes the slack after a stall, failing right
+// away if n is more than the burst.
+func (p *paced) WaitNContext(ctx context.Context, n int) error {
+	if n <= 0 {
+		return nil
+	}
+
+	return p.await(ctx, func() (bool, time.Duration, error) {
+		if err := p.costErrLocked(n); err != nil {
+			return false, 0, err
+		}
+
+		_, ok, retryIn := p.tryScheduleLocked(n)
+		if ok {
+			p.countAllowed()
+		}
+		return ok, retryIn, nil
+	}, nil)
+}
+
+func (p *paced) Wait() {
+	_ = p.WaitContext(context.Background())
+}
+
+func (p *paced) WaitTimeout(timeout time.Duration) error {
+	ctx, cancel := withTimeout(p.clock, timeout)
+	defer cancel()
+	return p.WaitContext(ctx)
+}
+
+// costErrLocked denies n requests and returns an error if they exceed the burst, nil otherwise.
+func (p *paced) costErrLocked(n int) error {
+	// This must be called with the mutex already locked
+	if n > p.Burst() {
+		p.deny(ReasonLimited)
+		return fmt.Errorf("cost %d exceeds the burst of %d", n, p.Burst())
+	}
+	return nil
+}
+
+func (p *paced) Allowed() bool {
+	return p.AllowN(1)
+}
+
+// AllowN allows n requests if they can all be scheduled by now, which takes the slack after a stall.
+func (p *paced) AllowN(n int) bool {
+	if n <= 0 {
+		return true
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return p.allowLocked(n)
+}
+
+func (p *paced) AllowedReport() (AdmitReport, bool) {
+	return p.allowReport(func() bool { return p.allowLocked(1) })
+}
+
+func (p *paced) WaitContextReport(ctx context.Context) (AdmitReport, error) {
+	return waitReport(ctx, p.WaitContext)
+}
+
+func (p *paced) allowLocked(n int) bool {
+	// This must be called with the mutex already locked
+	if p.denyHalted() {
+		return false
+	}
+	if _, ok, _ := p.tryScheduleLocked(n); ok {
+		p.countAllowed()
+		return true
+	}
+
+	p.deny(p.limitedReason())
+	return false
+}
+
+// Clear restarts the schedule, so the next request is allowed right away, and cancels the pending reservations.
+func (p *paced) Clear() {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	p.cancelReservationsLocked()
+	p.last = time.Time{}
+	p.waiters.notify()
+}
+
+func (p *paced) Close() error {
+	return p.close(p.cancelReservationsLocked)
+}
+
+// cancelReservationsLocked cancels the pending reservations.
+func (p *paced) cancelReservationsLocked() {
+	// This must be called with the mutex already locked
+	for res := range p.pendingReservations {
+		res.canceled = true
+	}
+	p.pendingReservations = make(map[*pacedReservation]struct{})
+}
+
+func (p *paced) Stats() Stats {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.cleanupExpiredReservations()
+
+	stats := p.stats()
+	stats.PendingReservations = len(p.pendingReservations)
+	return stats
+}
+
+// Info reports the rate as the limit and the requests that could be allowed at once as remaining, with Reset being
+// when the next request is scheduled.
+func (p *paced) Info() LimitInfo {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.cleanupExpiredReservations()
+
+	now := p.clock.Now()
+	return p.info(p.perSecond, p.issuableLocked(now), latest(p.nextLocked(now), now), time.Second)
+}
+
+// Available returns how many requests could be allowed at once right now.
+func (p *paced) Available() int {
+	return p.Info().Remaining
+}
+
+func (p *paced) EstimatedWait() time.Duration {
+	return p.waitUntil(p.Stats().NextAllowedTime)
+}
+
+func (p *paced) Config() Config {
+	return Config{Algorithm: AlgorithmPaced, Count: p.perSecond, Per: time.Second}
+}
+
+// Limit returns the rate requests are spaced at.
+func (p *paced) Limit() Rate {
+	return Rate{Count: p.perSecond, Per: time.Second}
+}
+
+// Burst returns the most requests allowed at once, when catching up with the whole slack.
+func (p *paced) Burst() int {
+	return int(p.maxSlack/p.perRequest) + 1
+}
+
+func (p *paced) PendingReservationAges(n int) []time.Duration {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.cleanupExpiredReservations()
+
+	reservedAt := make([]time.Time, 0, len(p.pendingReservations))
+	for res := range p.pendingReservations {
+		reservedAt = append(reservedAt, res.reservedAt)
+	}
+	return p.reservationAges(reservedAt, n)
+}
+
+func (p *paced) cleanupExpiredReservations() {
+	// This must be called with the mutex already locked
+	now := p.clock.Now()
+	for res := range p.pendingReservations {
+		if res.expiresAt != nil && now.After(*res.expiresAt) {
+			delete(p.pendingReservations, res)
+		}
+	}
+}
+
+// tryReserveLocked takes the next n places in the schedule for a reservation if their time came, otherwise it returns
+// how long until it does.
+func (p *paced) tryReserveLocked(ctx context.Context, n int, reservationTTL *time.Duration) (*pacedReservation, time.Duration) {
+	// This must be called with the mutex already locked
+	previous := p.last
+	if _, ok, retryIn := p.tryScheduleLocked(n); !ok {
+		return nil, retryIn
+	}
+
+	reservation := &pacedReservation{
+		limiter:    p,
+		reservedAt: p.clock.Now(),
+		expiresAt:  p.expiryFor(ctx, reservationTTL),
+		at:         p.last,
+		previous:   previous,
+	}
+	p.pendingReservations[reservation] = struct{}{}
+	p.watchAbandoned(reservation.reservedAt, func() bool {
+		return pendingAt(p.clock.Now(), reservation.consumed, reservation.canceled, reservation.expiresAt)
+	})
+	return reservation, 0
+}
+
+func (p *paced) TryReserve(reservationTTL *time.Duration) (Reservation, bool) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if reservation, _ := p.tryReserveLocked(context.Background(), 1, reservationTTL); reservation != nil {
+		return reservation, true
+	}
+
+	p.deny(p.limitedReason())
+	return nil, false
+}
+
+func (p *paced) Reserve(reservationTTL *time.Duration) Reservation {
+	reservation, err := p.ReserveContext(context.Background(), reservationTTL)
+	if err != nil {
+		return failedReservation{err: err}
+	}
+	return reservation
+}
+
+func (p *paced) ReserveTimeout(timeout time.Duration, reservationTTL *time.Duration) (Reservation, error) {
+	ctx, cancel := withTimeout(p.clock, timeout)
+	defer cancel()
+	return p.ReserveContext(ctx, reservationTTL)
+}
+
+func (p *paced) ReserveContext(ctx context.Context, reservationTTL *time.Duration) (Reservation, error) {
+	return p.ReserveN(ctx, 1, reservationTTL)
+}
+
+func (p *paced) ReserveN(ctx context.Context, n int, reservationTTL *time.Duration) (Reservation, error) {
+	if err := reserveCostErr(n); err != nil {
+		return nil, err
+	}
+
+	var reservation *pacedReservation
+	err := p.awaitReservation(ctx, func() (bool, time.Duration, error) {
+		if err := p.costErrLocked(n); err != nil {
+			return false, 0, err
+		}
+
+		var retryIn time.Duration
+		reservation, retryIn = p.tryReserveLocked(ctx, n, reservationTTL)
+		return reservation != nil, retryIn, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	p.link(ctx, reservation)
+	return reservation, nil
+}
+
+func (p *paced) Permits(ctx context.Context) <-chan struct{} {
+	return deliverPermits(ctx, func(ctx context.Context) (permit, error) {
+		return reservePermit(ctx, p)
+	})
+}
+
+// latest returns the later of a and b.
+func latest(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// pacedReservation implements the Reservation interface. It holds its place in the schedule from when it was taken.
+type pacedReservation struct {
+	limiter    *paced
+	reservedAt time.Time
+	expiresAt  *time.Time
+	at         time.Time // The last place in the schedule it holds
+	previous   time.Time // The place before its first one, to give them back on Cancel
+	consumed   bool
+	canceled   bool
+}
+
+func (r *pacedReservation) Consume() error {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	if err := reservationErr(r.limiter.clock.Now(), r.consumed, r.canceled, r.expiresAt); err != nil {
+		delete(r.limiter.pendingReservations, r)
+		return err
+	}
+
+	r.consumed = true
+	delete(r.limiter.pendingReservations, r)
+	r.limiter.countAllowed()
+	return nil
+}
+
+// ConsumeContext is Consume, which never blocks.
+func (r *pacedReservation) ConsumeContext(context.Context) error {
+	return r.Consume()
+}
+
+func (r *pacedReservation) Cancel() {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	if r.consumed || r.canceled {
+		return
+	}
+	r.canceled = true
+	delete(r.limiter.pendingReservations, r)
+	if r.limiter.last.Equal(r.at) {
+		// Nothing was scheduled after it, its places are free again
+		r.limiter.last = r.previous
+		r.limiter.waiters.notify()
+	}
+}
+
+func (r *pacedReservation) Detach() (ReservationHandle, error) {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	state := func() error {
+		return reservationErr(r.limiter.clock.Now(), r.consumed, r.canceled, r.expiresAt)
+	}
+	if err := state(); err != nil {
+		return ReservationHandle{}, err
+	}
+	return r.limiter.detach(r, r.expiresAt, state), nil
+}
+
+// ReadyAt is now, Consume never blocks.
+func (r *pacedReservation) ReadyAt() time.Time {
+	return r.limiter.clock.Now()
+}
+
+func (r *pacedReservation) Delay() time.Duration {
+	return 0
+}
+
+func (r *pacedReservation) ExpiresAt() (time.Time, bool) {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	return expiry(r.expiresAt)
+}
+
+func (r *pacedReservation) State() ReservationState {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	return reservationState(r.limiter.clock.Now(), r.consumed, r.canceled, r.expiresAt)
+}
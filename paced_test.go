@@ -0,0 +1,96 @@
+package limit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limittest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPaced_Take(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	limiter := limit.NewPaced(10, limit.WithClock(clock))
+
+	// The first request is scheduled right away
+	assert.True(t, limiter.Take().Equal(time.Unix(0, 0)))
+
+	taken := make(chan time.Time)
+	go func() {
+		taken <- limiter.Take()
+		taken <- limiter.Take()
+	}()
+
+	// The next ones are spaced 100ms apart
+	clock.BlockUntil(1)
+	clock.Advance(100 * time.Millisecond)
+	assert.True(t, (<-taken).Equal(time.Unix(0, 0).Add(100*time.Millisecond)))
+	clock.BlockUntil(1)
+	clock.Advance(100 * time.Millisecond)
+	assert.True(t, (<-taken).Equal(time.Unix(0, 0).Add(200*time.Millisecond)))
+	assert.Equal(t, 3, limiter.Stats().AllowedRequests)
+}
+
+func TestPaced_CatchesUpWithSlack(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	limiter := limit.NewPaced(10, limit.WithClock(clock), limit.WithSlack(3))
+	assert.Equal(t, 4, limiter.(limit.Configurer).Burst())
+	assert.Equal(t, limit.Config{Algorithm: limit.AlgorithmPaced, Count: 10, Per: 1 * time.Second}, limiter.Config())
+	assert.True(t, limiter.Allowed())
+	assert.False(t, limiter.Allowed())
+
+	// After a stall, the 3 missed requests of slack are allowed at once along with the current one
+	clock.Advance(2 * time.Second)
+	assert.Equal(t, 4, limiter.Info().Remaining)
+	for i := 0; i < 4; i++ {
+		assert.True(t, limiter.Allowed())
+	}
+	assert.False(t, limiter.Allowed())
+	assert.Equal(t, 0, limiter.Info().Remaining)
+
+	// And then they are spaced again
+	clock.Advance(100 * time.Millisecond)
+	assert.True(t, limiter.Allowed())
+	assert.False(t, limiter.Allowed())
+	assert.Equal(t, clock.Now().Add(100*time.Millisecond), limiter.Stats().NextAllowedTime)
+}
+
+func TestPaced_WithoutSlack(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	limiter := limit.NewPaced(10, limit.WithClock(clock), limit.WithoutSlack())
+	assert.True(t, limiter.Allowed())
+
+	// The schedule restarts after a stall
+	clock.Advance(2 * time.Second)
+	assert.True(t, limiter.Allowed())
+	assert.False(t, limiter.Allowed())
+	assert.Equal(t, 1, limiter.(limit.Configurer).Burst())
+}
+
+func TestPaced_CancelGivesBackItsPlace(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	limiter := limit.NewPaced(10, limit.WithClock(clock))
+
+	reservation := limiter.Reserve(nil)
+	assert.False(t, limiter.Allowed())
+	reservation.Cancel()
+	assert.True(t, limiter.Allowed())
+
+	// A place is only given back if nothing was scheduled after it
+	clock.Advance(100 * time.Millisecond)
+	reservation = limiter.Reserve(nil)
+	clock.Advance(100 * time.Millisecond)
+	assert.True(t, limiter.Allowed())
+	reservation.Cancel()
+	assert.False(t, limiter.Allowed())
+	assert.Equal(t, 2, limiter.Stats().AllowedRequests)
+}
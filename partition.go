@@ -0,0 +1,88 @@
+package limit
+
+import "time"
+
+// InstanceCounter returns how many instances of the service share a global rate, e.g. the ready replicas of a
+// deployment. It's called with the limiter's lock held, so it must be cheap and must not use the limiter.
+type InstanceCounter func() int
+
+// StaticInstances returns an InstanceCounter always counting n instances.
+func StaticInstances(n int) InstanceCounter {
+	return func() int { return n }
+}
+
+// EndpointsCounter returns an InstanceCounter counting the addresses endpoints returns, e.g. the ready addresses of a
+// Kubernetes Endpoints object kept up to date by an informer.
+func EndpointsCounter(endpoints func() []string) InstanceCounter {
+	return func() int { return len(endpoints()) }
+}
+
+// PartitionStats describe the share of a global rate a partitioned limiter enforces.
+type PartitionStats struct {
+	// The instances the global rate was last split between
+	Instances int `json:"instances"`
+	// The share of the global rate this instance allows
+	Share Rate `json:"share"`
+}
+
+// partition splits a global rate between the instances counted by counter, checking the count every interval.
+type partition struct {
+	global    Rate
+	counter   InstanceCounter
+	interval  time.Duration
+	instances int
+	checkedAt time.Time
+}
+
+// share returns the capacity and window of a bucket refilling at the share of the global rate of one of n instances.
+// The capacity is the share of the global count, and the window is stretched so the refill rate is exact even when
+// the count doesn't split evenly.
+func (p *partition) share(n int) (int, time.Duration) {
+	count := max(p.global.Count/n, 1)
+	return count, p.global.Per * time.Duration(count*n) / time.Duration(p.global.Count)
+}
+
+// NewPartitioned creates a token bucket enforcing this instance's share of a global rate, the global rate divided by
+// the instances counter returns, as a cheap alternative to sharing state between instances. The count is checked
+// again every 10 seconds, or the interval set with WithPartitionInterval. A change of count adjusts the refill rate and
+// capacity without handing out the tokens of the new capacity at once, so scaling doesn't cause a burst.
+func NewPartitioned(global Rate, counter InstanceCounter, opts ...Option) Limiter {
+	o := newOptions(opts)
+	p := &partition{global: global, counter: counter, interval: o.partitionInterval}
+	p.instances = max(counter(), 1)
+	p.checkedAt = o.clock.Now()
+
+	count, duration := p.share(p.instances)
+	t := NewTokenBucket(count, duration, acceptOptions(opts, kindOther)...).(*tokenBucket)
+	t.partition = p
+	return t
+}
+
+// rebalance counts the instances again if the interval passed, and adjusts the bucket to the new share if the count
+// changed.
+func (t *tokenBucket) rebalance() {
+	// This must be called with the mutex already locked
+	p := t.partition
+	if p == nil || t.clock.Now().Sub(p.checkedAt) < p.interval {
+		return
+	}
+
+	p.checkedAt = t.clock.Now()
+	instances := max(p.counter(), 1)
+	if instances == p.instances {
+		return
+	}
+
+	p.instances = instances
+	t.setRateLocked(p.share(instances))
+}
+
+// partitionStats returns the share the bucket enforces, nil if it isn't partitioned.
+func (t *tokenBucket) partitionStats() *PartitionStats {
+	// This must be called with the mutex already locked
+	if t.partition == nil {
+		return nil
+	}
+	count, duration := t.partition.share(t.partition.instances)
+	return &PartitionStats{Instances: t.partition.instances, Share: Rate{Count: count, Per: duration}}
+}
@@ -0,0 +1,87 @@
+package limit_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limittest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartitioned_Stats(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Now())
+	var instances atomic.Int64
+	instances.Store(4)
+	limiter := limit.NewPartitioned(limit.Rate{Count: 100, Per: 1 * time.Second}, func() int { return int(instances.Load()) },
+		limit.WithClock(clock), limit.WithPartitionInterval(1*time.Second))
+	assert.Equal(t, &limit.PartitionStats{Instances: 4, Share: limit.Rate{Count: 25, Per: 1 * time.Second}}, limiter.Stats().Partition)
+
+	// The count is only checked again once the interval passed
+	instances.Store(3)
+	assert.Equal(t, 4, limiter.Stats().Partition.Instances)
+	clock.Advance(1 * time.Second)
+	assert.Equal(t, &limit.PartitionStats{Instances: 3, Share: limit.Rate{Count: 33, Per: 990 * time.Millisecond}}, limiter.Stats().Partition)
+
+	assert.Nil(t, limit.NewTokenBucket(1, 1*time.Second).Stats().Partition)
+}
+
+func TestPartitioned_ScaleUpDoesNotBurst(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Now())
+	var instances atomic.Int64
+	instances.Store(10)
+	limiter := limit.NewPartitioned(limit.Rate{Count: 100, Per: 1 * time.Second}, func() int { return int(instances.Load()) },
+		limit.WithClock(clock), limit.WithPartitionInterval(1*time.Second))
+	for limiter.Allowed() {
+	}
+
+	// Going down to a single instance raises the capacity to 100, but the bucket fills up at the new rate
+	instances.Store(1)
+	clock.Advance(1 * time.Second)
+	allowed := 0
+	for limiter.Allowed() {
+		allowed++
+	}
+	assert.Equal(t, 10, allowed)
+	assert.Equal(t, 100, limiter.(limit.Configurer).Burst())
+}
+
+func TestPartitioned_AggregateFollowsGlobalRate(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Now())
+	var instances atomic.Int64
+	instances.Store(3)
+	counter := func() int { return int(instances.Load()) }
+
+	global := limit.Rate{Count: 100, Per: 1 * time.Second}
+	limiters := make([]limit.Limiter, 5)
+	for i := range limiters {
+		limiters[i] = limit.NewPartitioned(global, counter, limit.WithClock(clock), limit.WithPartitionInterval(1*time.Second))
+	}
+
+	// The instances running scale from 3 to 5 and then down to 2, each taking all it's allowed every 10ms
+	allowed := 0
+	for step := 0; step < 6000; step++ {
+		switch step {
+		case 2000:
+			instances.Store(5)
+		case 4000:
+			instances.Store(2)
+		}
+
+		for _, limiter := range limiters[:instances.Load()] {
+			for limiter.Allowed() {
+				allowed++
+			}
+		}
+		clock.Advance(10 * time.Millisecond)
+	}
+
+	assert.InDelta(t, 6000, allowed, 300)
+}
@@ -0,0 +1,60 @@
+package limit
+
+import "time"
+
+// pausedRetry is how often a caller blocked while the limiter is paused checks again. Resume wakes it right away, the
+// timer is only a backstop.
+const pausedRetry = time.Minute
+
+// Pauser is implemented by the limiters in this package, which can stop admitting requests for a while, e.g. for a
+// maintenance window, without losing their state.
+type Pauser interface {
+	// Pause stops admitting requests until Resume: Allowed returns false, and waits block until the limiter resumes or
+	// their context is done. Reservations block too, or fail with FailWhilePaused. Pausing it again does nothing.
+	Pause()
+	// Resume admits requests again and wakes the blocked callers. Resuming a limiter that isn't paused does nothing.
+	Resume()
+}
+
+func (b *base) Pause() {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.pauseLocked()
+}
+
+func (b *base) pauseLocked() {
+	// This must be called with the mutex already locked
+	if b.paused {
+		return
+	}
+	b.paused = true
+	b.pausedAt = b.clock.Now()
+}
+
+func (b *base) Resume() {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.resumeLocked()
+}
+
+// resumeLocked resumes the limiter and returns how long it was paused, zero if it wasn't.
+func (b *base) resumeLocked() time.Duration {
+	// This must be called with the mutex already locked
+	if !b.paused {
+		return 0
+	}
+	b.paused = false
+	b.waiters.notify()
+	return max(b.clock.Now().Sub(b.pausedAt), 0)
+}
+
+// pausedReservationErr denies a reservation and returns ErrPaused if the limiter is paused and its policy is
+// FailWhilePaused, nil otherwise.
+func (b *base) pausedReservationErr() error {
+	// This must be called with the mutex already locked
+	if !b.paused || b.pausePolicy != FailWhilePaused {
+		return nil
+	}
+	b.deny(ReasonPaused)
+	return ErrPaused
+}
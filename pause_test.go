@@ -0,0 +1,107 @@
+package limit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limittest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPause_DeniesUntilResume(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	limiter := limit.NewRollingWindow(10, 1*time.Second, limit.WithClock(clock))
+	limiter.(limit.Pauser).Pause()
+
+	assert.False(t, limiter.Allowed())
+	assert.Equal(t, 0, limiter.Available())
+	stats := limiter.Stats()
+	assert.True(t, stats.Paused)
+	assert.True(t, stats.NextAllowedTime.IsZero())
+	assert.Equal(t, 1, stats.DeniedByReason[limit.ReasonPaused])
+
+	limiter.(limit.Pauser).Resume()
+	assert.True(t, limiter.Allowed())
+	assert.False(t, limiter.Stats().Paused)
+}
+
+func TestPause_WaitersBlockUntilResume(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	limiter := limit.NewTokenBucket(10, 1*time.Second, limit.WithClock(clock))
+	limiter.(limit.Pauser).Pause()
+
+	waited := make(chan error)
+	go func() {
+		waited <- limiter.WaitContext(context.Background())
+	}()
+	assert.Eventually(t, func() bool { return len(limiter.Waiters()) == 1 }, time.Second, time.Millisecond)
+
+	limiter.(limit.Pauser).Resume()
+	assert.NoError(t, <-waited)
+	assert.Equal(t, 1, limiter.Stats().AllowedRequests)
+	assert.Equal(t, 0, limiter.Stats().DeniedRequests)
+}
+
+func TestPause_TokenBucketDoesNotRefill(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	limiter := limit.NewTokenBucket(10, 1*time.Second, limit.WithClock(clock))
+	assert.True(t, limiter.AllowN(10))
+	clock.Advance(50 * time.Millisecond)
+
+	limiter.(limit.Pauser).Pause()
+	clock.Advance(1 * time.Hour)
+	limiter.(limit.Pauser).Resume()
+	assert.Equal(t, 0, limiter.Available())
+
+	// Refilling picks up where it left off
+	clock.Advance(50 * time.Millisecond)
+	assert.Equal(t, 1, limiter.Available())
+}
+
+func TestPause_RollingWindowEventsAgeOut(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	limiter := limit.NewRollingWindow(10, 1*time.Second, limit.WithClock(clock))
+	assert.True(t, limiter.AllowN(10))
+
+	limiter.(limit.Pauser).Pause()
+	clock.Advance(2 * time.Second)
+	limiter.(limit.Pauser).Resume()
+	assert.Equal(t, 10, limiter.Available())
+}
+
+func TestPause_ReservationPolicy(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	limiter := limit.NewTokenBucket(10, 1*time.Second, limit.WithClock(clock), limit.WithPausePolicy(limit.FailWhilePaused))
+	limiter.(limit.Pauser).Pause()
+	_, err := limiter.ReserveContext(context.Background(), nil)
+	assert.ErrorIs(t, err, limit.ErrPaused)
+
+	// By default reservations wait for the limiter to resume
+	limiter = limit.NewTokenBucket(10, 1*time.Second, limit.WithClock(clock))
+	limiter.(limit.Pauser).Pause()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = limiter.ReserveContext(ctx, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	reserved := make(chan error)
+	go func() {
+		_, err := limiter.ReserveContext(context.Background(), nil)
+		reserved <- err
+	}()
+	assert.Eventually(t, func() bool { return len(limiter.Waiters()) == 1 }, time.Second, time.Millisecond)
+	limiter.(limit.Pauser).Resume()
+	assert.NoError(t, <-reserved)
+}
@@ -0,0 +1,46 @@
+package limit
+
+import "context"
+
+// permit is taken from a limiter before it's delivered to a Permits receiver.
+type permit struct {
+	// use is called once the permit was delivered
+	use func()
+	// giveBack is called if the receiver went away before taking it
+	giveBack func()
+}
+
+// deliverPermits sends a permit each time take returns one, until ctx is done or take fails.
+func deliverPermits(ctx context.Context, take func(ctx context.Context) (permit, error)) <-chan struct{} {
+	permits := make(chan struct{})
+	go func() {
+		defer close(permits)
+		for {
+			p, err := take(ctx)
+			if err != nil {
+				return
+			}
+
+			select {
+			case permits <- struct{}{}:
+				p.use()
+			case <-ctx.Done():
+				p.giveBack()
+				return
+			}
+		}
+	}()
+	return permits
+}
+
+// reservePermit takes a permit as a reservation, so an undelivered permit holds its capacity until it's given back.
+func reservePermit(ctx context.Context, l Limiter) (permit, error) {
+	reservation, err := l.ReserveContext(ctx, nil)
+	if err != nil {
+		return permit{}, err
+	}
+	return permit{
+		use:      func() { _ = reservation.Consume() },
+		giveBack: reservation.Cancel,
+	}, nil
+}
@@ -0,0 +1,130 @@
+package limit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter_Permits_Pacing(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range limiterConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			limiter := newLimiter(5, 100*time.Millisecond)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			start := time.Now()
+			permits := limiter.Permits(ctx)
+			for i := 0; i < 10; i++ {
+				<-permits
+			}
+
+			// Ten permits at five per 100ms can't all arrive in the first window
+			assert.GreaterOrEqual(t, time.Since(start), 80*time.Millisecond)
+			assert.Equal(t, 10, limiter.Stats().AllowedRequests)
+		})
+	}
+}
+
+func TestLimiter_Permits_DoNotAccumulate(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range limiterConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			limiter := newLimiter(5, 100*time.Millisecond)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			permits := limiter.Permits(ctx)
+
+			// Three windows go by without receiving
+			time.Sleep(300 * time.Millisecond)
+
+			received := 0
+			deadline := time.After(10 * time.Millisecond)
+		drain:
+			for {
+				select {
+				case <-permits:
+					received++
+				case <-deadline:
+					break drain
+				}
+			}
+			// At most the burst, plus one more if the drain straddles a refill
+			assert.GreaterOrEqual(t, received, 1)
+			assert.LessOrEqual(t, received, 6)
+		})
+	}
+}
+
+func TestLimiter_Permits_ClosedOnCancel(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range limiterConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			limiter := newLimiter(1, 1*time.Hour)
+			ctx, cancel := context.WithCancel(context.Background())
+
+			permits := limiter.Permits(ctx)
+			<-permits
+			cancel()
+
+			for range permits {
+				t.Fatal("no permit should be delivered after the first one")
+			}
+		})
+	}
+}
+
+func TestLimiter_Permits_UndeliveredPermitIsGivenBack(t *testing.T) {
+	t.Parallel()
+
+	for name, newLimiter := range leaserConstructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			limiter := newLimiter(2, 1*time.Hour)
+			ctx, cancel := context.WithCancel(context.Background())
+
+			permits := limiter.Permits(ctx)
+			<-permits
+
+			// The second permit is held for a receiver that never comes
+			assert.Eventually(t, func() bool { return !limiter.Stats().NextAllowedTime.Before(time.Now()) }, 1*time.Second, 1*time.Millisecond)
+			cancel()
+			for range permits {
+			}
+
+			assert.True(t, limiter.Allowed())
+			assert.False(t, limiter.Allowed())
+		})
+	}
+}
+
+func TestLease_Permits_StopWhenLeaseEnds(t *testing.T) {
+	t.Parallel()
+
+	parent := limit.NewTokenBucket(10, 1*time.Second)
+	lease, err := parent.(limit.Leaser).AcquireLease(limit.Rate{Count: 100, Per: 1 * time.Minute}, 1*time.Minute)
+	assert.NoError(t, err)
+
+	permits := lease.Permits(context.Background())
+	<-permits
+	lease.Release()
+
+	// The permit already taken may still be delivered, then the channel closes
+	for range permits {
+	}
+}
@@ -0,0 +1,138 @@
+package limit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// PlanResolver tells the rate each API key is entitled to, e.g. by looking up the plan of its account.
+type PlanResolver interface {
+	// Plan returns the rate of the plan apiKey is on and the burst it may spend at once.
+	Plan(ctx context.Context, apiKey string) (rate Rate, burst int, err error)
+}
+
+// PlanFallback decides the plan of an API key whose resolution failed with err. Returning an error turns the key's
+// requests down with it instead.
+type PlanFallback func(apiKey string, err error) (rate Rate, burst int, fallbackErr error)
+
+// FallbackTo returns a PlanFallback putting keys whose resolution failed on the given rate and burst.
+func FallbackTo(rate Rate, burst int) PlanFallback {
+	return func(string, error) (Rate, int, error) {
+		return rate, burst, nil
+	}
+}
+
+// plan is what a key is entitled to.
+type plan struct {
+	rate  Rate
+	burst int
+}
+
+// planEntry is the cached plan of a key and the limiter built for it.
+type planEntry struct {
+	plan      plan
+	limiter   Limiter
+	err       error
+	expiresAt time.Time
+}
+
+// PlanLimiter holds a limiter per API key built for the plan the key is on, resolving plans through a PlanResolver
+// and caching them. A limiter is rebuilt, starting afresh, when its key's plan changes.
+type PlanLimiter struct {
+	// Mutex
+	mux sync.Mutex
+
+	// Config
+	resolver   PlanResolver
+	newLimiter func(rate Rate, burst int) Limiter
+	clock      Clock
+	ttl        time.Duration
+	errorTTL   time.Duration
+	fallback   PlanFallback
+
+	// State
+	entries map[string]*planEntry
+}
+
+// NewPlanLimiter returns a PlanLimiter building the limiter of each key with newLimiter at the rate and burst of its
+// plan, e.g. a token bucket of burst tokens refilled at rate. It accepts WithClock, WithPlanTTL, WithPlanErrorTTL and
+// WithPlanFallback.
+func NewPlanLimiter(resolver PlanResolver, newLimiter func(rate Rate, burst int) Limiter, opts ...Option) *PlanLimiter {
+	o := newOptions(opts)
+	fallback := o.planFallback
+	if fallback == nil {
+		fallback = FallbackTo(Rate{Count: 1, Per: 1 * time.Second}, 1)
+	}
+	return &PlanLimiter{
+		resolver:   resolver,
+		newLimiter: newLimiter,
+		clock:      o.clock,
+		ttl:        o.planTTL,
+		errorTTL:   o.planErrorTTL,
+		fallback:   fallback,
+		entries:    make(map[string]*planEntry),
+	}
+}
+
+// Get returns the limiter of apiKey, resolving its plan if it isn't cached. The error is the one the fallback
+// returned, if the resolution failed and the fallback turned the key down.
+func (p *PlanLimiter) Get(ctx context.Context, apiKey string) (Limiter, error) {
+	p.mux.Lock()
+	if entry, ok := p.entries[apiKey]; ok && p.clock.Now().Before(entry.expiresAt) {
+		defer p.mux.Unlock()
+		return entry.limiter, entry.err
+	}
+	p.mux.Unlock()
+
+	// Resolving may take a round trip, don't hold up the other keys meanwhile
+	resolved, ttl, err := p.resolve(ctx, apiKey)
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	entry, ok := p.entries[apiKey]
+	if !ok {
+		entry = &planEntry{}
+		p.entries[apiKey] = entry
+	}
+	entry.expiresAt = p.clock.Now().Add(ttl)
+	entry.err = err
+	if err != nil {
+		entry.limiter = nil
+		return nil, err
+	}
+
+	if entry.limiter == nil || entry.plan != resolved {
+		entry.plan = resolved
+		entry.limiter = p.newLimiter(resolved.rate, resolved.burst)
+	}
+	return entry.limiter, nil
+}
+
+// Allowed reports whether the limiter of apiKey allows the request right now. It's false if the key was turned down.
+func (p *PlanLimiter) Allowed(ctx context.Context, apiKey string) bool {
+	l, err := p.Get(ctx, apiKey)
+	return err == nil && l.Allowed()
+}
+
+// WaitContext blocks until the limiter of apiKey allows the request or the context is done.
+func (p *PlanLimiter) WaitContext(ctx context.Context, apiKey string) error {
+	l, err := p.Get(ctx, apiKey)
+	if err != nil {
+		return err
+	}
+	return l.WaitContext(ctx)
+}
+
+// resolve asks the resolver for the plan of apiKey, applying the fallback if it fails, and returns how long to cache
+// the outcome.
+func (p *PlanLimiter) resolve(ctx context.Context, apiKey string) (plan, time.Duration, error) {
+	rate, burst, err := p.resolver.Plan(ctx, apiKey)
+	if err == nil {
+		return plan{rate: rate, burst: burst}, p.ttl, nil
+	}
+
+	rate, burst, err = p.fallback(apiKey, err)
+	return plan{rate: rate, burst: burst}, p.errorTTL, err
+}
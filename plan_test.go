@@ -0,0 +1,134 @@
+package limit_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limittest"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	freeTier = limit.Rate{Count: 1, Per: 1 * time.Hour}
+	proTier  = limit.Rate{Count: 3, Per: 1 * time.Hour}
+)
+
+// fakeResolver puts keys on the tiers it's told, counting the lookups.
+type fakeResolver struct {
+	mux     sync.Mutex
+	tiers   map[string]limit.Rate
+	err     error
+	lookups int
+}
+
+func (r *fakeResolver) Plan(_ context.Context, apiKey string) (limit.Rate, int, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.lookups++
+	if r.err != nil {
+		return limit.Rate{}, 0, r.err
+	}
+	rate := r.tiers[apiKey]
+	return rate, rate.Count, nil
+}
+
+func (r *fakeResolver) set(apiKey string, rate limit.Rate, err error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.tiers[apiKey] = rate
+	r.err = err
+}
+
+func planBucket(rate limit.Rate, burst int) limit.Limiter {
+	return limit.NewTokenBucket(burst, time.Duration(burst)*rate.Per/time.Duration(rate.Count))
+}
+
+func TestPlanLimiter_FlipsBetweenTiers(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Now())
+	resolver := &fakeResolver{tiers: map[string]limit.Rate{"key": freeTier}}
+	plans := limit.NewPlanLimiter(resolver, planBucket, limit.WithClock(clock), limit.WithPlanTTL(1*time.Minute))
+	ctx := context.Background()
+
+	assert.True(t, plans.Allowed(ctx, "key"))
+	assert.False(t, plans.Allowed(ctx, "key"))
+
+	// The upgrade is picked up once the cached plan expires
+	resolver.set("key", proTier, nil)
+	assert.False(t, plans.Allowed(ctx, "key"))
+	clock.Advance(1 * time.Minute)
+	for i := 0; i < 3; i++ {
+		assert.True(t, plans.Allowed(ctx, "key"))
+	}
+	assert.False(t, plans.Allowed(ctx, "key"))
+
+	// And so is the downgrade
+	resolver.set("key", freeTier, nil)
+	clock.Advance(1 * time.Minute)
+	assert.True(t, plans.Allowed(ctx, "key"))
+	assert.False(t, plans.Allowed(ctx, "key"))
+	assert.Equal(t, 3, resolver.lookups)
+}
+
+func TestPlanLimiter_KeepsLimiterWhilePlanIsUnchanged(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Now())
+	resolver := &fakeResolver{tiers: map[string]limit.Rate{"key": freeTier}}
+	plans := limit.NewPlanLimiter(resolver, planBucket, limit.WithClock(clock))
+	ctx := context.Background()
+
+	first, err := plans.Get(ctx, "key")
+	assert.NoError(t, err)
+	clock.Advance(2 * time.Minute)
+	second, err := plans.Get(ctx, "key")
+	assert.NoError(t, err)
+	assert.Same(t, first, second)
+	assert.Equal(t, 2, resolver.lookups)
+}
+
+func TestPlanLimiter_ResolverFails(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Now())
+	resolver := &fakeResolver{tiers: map[string]limit.Rate{"key": proTier}}
+	plans := limit.NewPlanLimiter(resolver, planBucket, limit.WithClock(clock),
+		limit.WithPlanFallback(limit.FallbackTo(freeTier, 1)), limit.WithPlanErrorTTL(10*time.Second))
+	ctx := context.Background()
+
+	// The key falls back to the free tier, and the failure is cached
+	resolver.set("key", proTier, errors.New("billing is down"))
+	assert.True(t, plans.Allowed(ctx, "key"))
+	assert.False(t, plans.Allowed(ctx, "key"))
+	assert.Equal(t, 1, resolver.lookups)
+
+	resolver.set("key", proTier, nil)
+	clock.Advance(10 * time.Second)
+	for i := 0; i < 3; i++ {
+		assert.True(t, plans.Allowed(ctx, "key"))
+	}
+	assert.Equal(t, 2, resolver.lookups)
+}
+
+func TestPlanLimiter_FallbackTurnsKeyDown(t *testing.T) {
+	t.Parallel()
+
+	resolverErr := errors.New("unknown key")
+	resolver := &fakeResolver{tiers: map[string]limit.Rate{}, err: resolverErr}
+	plans := limit.NewPlanLimiter(resolver, planBucket, limit.WithPlanFallback(func(_ string, err error) (limit.Rate, int, error) {
+		return limit.Rate{}, 0, err
+	}))
+	ctx := context.Background()
+
+	assert.False(t, plans.Allowed(ctx, "key"))
+	assert.ErrorIs(t, plans.WaitContext(ctx, "key"), resolverErr)
+	_, err := plans.Get(ctx, "key")
+	assert.ErrorIs(t, err, resolverErr)
+	assert.Equal(t, 1, resolver.lookups)
+}
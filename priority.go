@@ -0,0 +1,208 @@
+package limit
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// PriorityStats are the counters PriorityLimiter keeps for each priority.
+type PriorityStats struct {
+	AllowedRequests int `json:"allowed_requests"`
+	DeniedRequests  int `json:"denied_requests"`
+	// The allowed requests promoted after waiting longer than the max starvation time
+	Promoted int `json:"promoted"`
+}
+
+// priorityWaiter is a caller blocked in a PriorityLimiter.
+type priorityWaiter struct {
+	priority int
+	since    time.Time
+	// Signaled when the waiter is first in line
+	turn chan struct{}
+	// Stops the waiter's wait on the limiter when a waiter with a higher priority arrives
+	preempt context.CancelFunc
+}
+
+// PriorityLimiter shares a limiter between callers of different priorities, e.g. latency-sensitive traffic and
+// background work: when the limiter frees up, the waiting caller with the highest priority gets it first.
+type PriorityLimiter struct {
+	// Mutex
+	mux sync.Mutex
+
+	// Config
+	limiter       Limiter
+	clock         Clock
+	maxStarvation time.Duration
+
+	// State
+	// The callers in line, the first one is the one waiting on the limiter
+	queue []*priorityWaiter
+	stats map[int]PriorityStats
+}
+
+// NewPriorityLimiter returns a PriorityLimiter ordering the callers waiting for l by priority. Only the caller first in
+// line waits on l, and a caller with a higher priority arriving meanwhile takes its place. A caller waiting longer than
+// the time set with WithMaxStarvation, 30s by default, is promoted ahead of every priority so it can't starve.
+// It accepts WithClock and WithMaxStarvation.
+//
+// A caller losing its place stops waiting on l, which counts it as denied with ReasonContext. Callers using l directly
+// aren't ordered.
+func NewPriorityLimiter(l Limiter, opts ...Option) *PriorityLimiter {
+	o := newOptions(opts)
+	return &PriorityLimiter{
+		limiter:       l,
+		clock:         o.clock,
+		maxStarvation: o.maxStarvation,
+		stats:         make(map[int]PriorityStats),
+	}
+}
+
+// WaitPriority blocks until the limiter allows a request of priority, after the callers with a higher priority and
+// the ones with the same priority that arrived first, or until the context is done.
+func (p *PriorityLimiter) WaitPriority(ctx context.Context, priority int) error {
+	p.mux.Lock()
+	w := &priorityWaiter{priority: priority, since: p.clock.Now(), turn: make(chan struct{}, 1)}
+	p.enqueueLocked(w)
+	p.mux.Unlock()
+
+	for {
+		select {
+		case <-w.turn:
+		case <-ctx.Done():
+			p.mux.Lock()
+			p.leaveLocked(w, false)
+			p.mux.Unlock()
+			return ctx.Err()
+		}
+
+		p.mux.Lock()
+		if p.queue[0] != w {
+			// Preempted before it got to wait on the limiter
+			p.mux.Unlock()
+			continue
+		}
+		waitCtx, preempt := context.WithCancel(ctx)
+		w.preempt = preempt
+		p.mux.Unlock()
+
+		err := p.limiter.WaitContext(waitCtx)
+		preempt()
+
+		p.mux.Lock()
+		if err != nil && ctx.Err() == nil && waitCtx.Err() != nil {
+			// Preempted by a caller with a higher priority, wait for the turn again
+			w.preempt = nil
+			p.mux.Unlock()
+			continue
+		}
+		p.leaveLocked(w, err == nil)
+		p.mux.Unlock()
+		return err
+	}
+}
+
+// AllowedPriority reports whether the limiter allows a request of priority right now, taking it if so. It doesn't
+// jump the callers waiting with the same or a higher priority.
+func (p *PriorityLimiter) AllowedPriority(priority int) bool {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	now := p.clock.Now()
+	allowed := !slices.ContainsFunc(p.queue, func(w *priorityWaiter) bool {
+		return w.priority >= priority || p.starved(w, now)
+	}) && p.limiter.Allowed()
+	p.recordLocked(priority, allowed, false)
+	return allowed
+}
+
+// Waiting returns how many callers are in line.
+func (p *PriorityLimiter) Waiting() int {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return len(p.queue)
+}
+
+// Stats returns the counters of every priority used so far.
+func (p *PriorityLimiter) Stats() map[int]PriorityStats {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return maps.Clone(p.stats)
+}
+
+// enqueueLocked puts w in line, preempting the caller first in line if w goes ahead of it.
+func (p *PriorityLimiter) enqueueLocked(w *priorityWaiter) {
+	// This must be called with the mutex already locked
+	p.queue = append(p.queue, w)
+	p.reorderLocked()
+}
+
+// leaveLocked takes w out of line, counting whether it was allowed, and hands the turn to the next caller.
+func (p *PriorityLimiter) leaveLocked(w *priorityWaiter, allowed bool) {
+	// This must be called with the mutex already locked
+	p.queue = slices.DeleteFunc(p.queue, func(other *priorityWaiter) bool { return other == w })
+	p.recordLocked(w.priority, allowed, allowed && p.starved(w, p.clock.Now()))
+	p.reorderLocked()
+}
+
+// reorderLocked sorts the line, promoting the starved callers, and gives the turn to the first caller. If it isn't the
+// one already waiting on the limiter, that one is preempted.
+func (p *PriorityLimiter) reorderLocked() {
+	// This must be called with the mutex already locked
+	if len(p.queue) == 0 {
+		return
+	}
+
+	now := p.clock.Now()
+	slices.SortStableFunc(p.queue, func(a, b *priorityWaiter) int {
+		switch {
+		case p.ahead(a, b, now):
+			return -1
+		case p.ahead(b, a, now):
+			return 1
+		}
+		return 0
+	})
+	for _, w := range p.queue[1:] {
+		if w.preempt != nil {
+			w.preempt()
+		}
+	}
+	select {
+	case p.queue[0].turn <- struct{}{}:
+	default:
+	}
+}
+
+// ahead reports whether a goes before b: starved callers first in arrival order, then by priority and arrival.
+func (p *PriorityLimiter) ahead(a, b *priorityWaiter, now time.Time) bool {
+	aStarved, bStarved := p.starved(a, now), p.starved(b, now)
+	switch {
+	case aStarved != bStarved:
+		return aStarved
+	case !aStarved && a.priority != b.priority:
+		return a.priority > b.priority
+	}
+	return a.since.Before(b.since)
+}
+
+// starved reports whether w waited longer than the max starvation time.
+func (p *PriorityLimiter) starved(w *priorityWaiter, now time.Time) bool {
+	return p.maxStarvation > 0 && now.Sub(w.since) >= p.maxStarvation
+}
+
+func (p *PriorityLimiter) recordLocked(priority int, allowed, promoted bool) {
+	// This must be called with the mutex already locked
+	stats := p.stats[priority]
+	if allowed {
+		stats.AllowedRequests++
+	} else {
+		stats.DeniedRequests++
+	}
+	if promoted {
+		stats.Promoted++
+	}
+	p.stats[priority] = stats
+}
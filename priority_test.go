@@ -0,0 +1,104 @@
+package limit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limittest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPriorityLimiter_HigherPriorityFirst(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	bucket := limit.NewTokenBucket(1, 1*time.Second, limit.WithClock(clock))
+	assert.True(t, bucket.Allowed())
+	limiter := limit.NewPriorityLimiter(bucket, limit.WithClock(clock))
+
+	order := make(chan int)
+	wait := func(priority int) {
+		assert.NoError(t, limiter.WaitPriority(context.Background(), priority))
+		order <- priority
+	}
+	go wait(0)
+	clock.BlockUntil(1)
+
+	// The high priority caller takes the place of the low priority one waiting on the bucket
+	go wait(10)
+	assert.Eventually(t, func() bool {
+		return limiter.Waiting() == 2 && bucket.Stats().DeniedByReason[limit.ReasonContext] == 1
+	}, time.Second, time.Millisecond)
+	clock.BlockUntil(1)
+	clock.Advance(1 * time.Second)
+	assert.Equal(t, 10, <-order)
+
+	clock.BlockUntil(1)
+	clock.Advance(1 * time.Second)
+	assert.Equal(t, 0, <-order)
+
+	assert.Equal(t, map[int]limit.PriorityStats{0: {AllowedRequests: 1}, 10: {AllowedRequests: 1}}, limiter.Stats())
+}
+
+func TestPriorityLimiter_Starvation(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	bucket := limit.NewTokenBucket(1, 1*time.Second, limit.WithClock(clock))
+	assert.True(t, bucket.Allowed())
+	limiter := limit.NewPriorityLimiter(bucket, limit.WithClock(clock), limit.WithMaxStarvation(2500*time.Millisecond))
+
+	order := make(chan int)
+	for i, priority := range []int{0, 10, 10, 10} {
+		go func() {
+			assert.NoError(t, limiter.WaitPriority(context.Background(), priority))
+			order <- priority
+		}()
+		assert.Eventually(t, func() bool { return limiter.Waiting() == i+1 }, time.Second, time.Millisecond)
+		if i == 0 {
+			// The high priority callers arrive later
+			clock.BlockUntil(1)
+			clock.Advance(500 * time.Millisecond)
+		}
+	}
+	assert.Eventually(t, func() bool {
+		return bucket.Stats().DeniedByReason[limit.ReasonContext] == 1
+	}, time.Second, time.Millisecond)
+
+	// Once it waited for the max starvation time, the low priority caller goes ahead of the last high priority one
+	var got []int
+	for range 4 {
+		clock.BlockUntil(1)
+		clock.Advance(1 * time.Second)
+		got = append(got, <-order)
+	}
+	assert.Equal(t, []int{10, 10, 0, 10}, got)
+	assert.Equal(t, 1, limiter.Stats()[0].Promoted)
+}
+
+func TestPriorityLimiter_AllowedPriority(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	bucket := limit.NewTokenBucket(2, 1*time.Second, limit.WithClock(clock))
+	limiter := limit.NewPriorityLimiter(bucket, limit.WithClock(clock))
+	assert.True(t, limiter.AllowedPriority(0))
+	assert.True(t, limiter.AllowedPriority(0))
+
+	// A caller waiting with a higher priority isn't jumped
+	ctx, cancel := context.WithCancel(context.Background())
+	waited := make(chan error)
+	go func() {
+		waited <- limiter.WaitPriority(ctx, 5)
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(500 * time.Millisecond)
+	assert.False(t, limiter.AllowedPriority(1))
+
+	// Giving up counts as denied
+	cancel()
+	assert.ErrorIs(t, <-waited, context.Canceled)
+	assert.Equal(t, map[int]limit.PriorityStats{0: {AllowedRequests: 2}, 1: {DeniedRequests: 1}, 5: {DeniedRequests: 1}}, limiter.Stats())
+}
@@ -0,0 +1,58 @@
+package limit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rate is a number of events allowed per duration.
+type Rate struct {
+	Count int           `json:"count"`
+	Per   time.Duration `json:"per"`
+}
+
+// in returns the number of events the rate allows in d.
+func (r Rate) in(d time.Duration) float64 {
+	return float64(r.Count) * float64(d) / float64(r.Per)
+}
+
+// validateRate returns why count per duration isn't a rate a limiter can enforce, nil if it is. With spaced, the
+// interval between two events, duration divided by count, mustn't truncate to zero either.
+func validateRate(count int, duration time.Duration, spaced bool) error {
+	switch {
+	case count <= 0:
+		return fmt.Errorf("count %d is not positive", count)
+	case duration <= 0:
+		return fmt.Errorf("duration %s is not positive", duration)
+	case spaced && duration/time.Duration(count) == 0:
+		return fmt.Errorf("rate of %d/%s is more than one per nanosecond", count, duration)
+	}
+	return nil
+}
+
+// ParseRate parses a rate like "100/s", "5000/m" or "10/500ms": a count, a slash and a duration in the format of
+// time.ParseDuration, whose leading 1 can be left out. Spaces around the parts are ignored.
+func ParseRate(s string) (count int, per time.Duration, err error) {
+	countPart, perPart, ok := strings.Cut(s, "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("rate %q: want count/duration, e.g. 100/s", s)
+	}
+
+	countPart, perPart = strings.TrimSpace(countPart), strings.TrimSpace(perPart)
+	count, err = strconv.Atoi(countPart)
+	if err != nil || count <= 0 {
+		return 0, 0, fmt.Errorf("rate %q: count %q is not a positive integer", s, countPart)
+	}
+
+	unit := perPart
+	if unit != "" && (unit[0] < '0' || unit[0] > '9') && unit[0] != '.' {
+		unit = "1" + unit
+	}
+	per, err = time.ParseDuration(unit)
+	if err != nil || per <= 0 {
+		return 0, 0, fmt.Errorf("rate %q: duration %q is not a positive duration", s, perPart)
+	}
+	return count, per, nil
+}
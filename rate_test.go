@@ -0,0 +1,70 @@
+package limit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRate(t *testing.T) {
+	t.Parallel()
+
+	for s, want := range map[string]limit.Rate{
+		"100/s":       {Count: 100, Per: 1 * time.Second},
+		"5000/m":      {Count: 5000, Per: 1 * time.Minute},
+		"2/h":         {Count: 2, Per: 1 * time.Hour},
+		"10/500ms":    {Count: 10, Per: 500 * time.Millisecond},
+		"3/1m30s":     {Count: 3, Per: 90 * time.Second},
+		" 100 / 1s\n": {Count: 100, Per: 1 * time.Second},
+	} {
+		count, per, err := limit.ParseRate(s)
+		assert.NoError(t, err, s)
+		assert.Equal(t, want, limit.Rate{Count: count, Per: per}, s)
+	}
+
+	for s, message := range map[string]string{
+		"100":      "want count/duration",
+		"0/s":      `count "0" is not a positive integer`,
+		"-5/s":     `count "-5" is not a positive integer`,
+		"x/s":      `count "x" is not a positive integer`,
+		"10/":      `duration "" is not a positive duration`,
+		"10/-1s":   `duration "-1s" is not a positive duration`,
+		"10/0s":    `duration "0s" is not a positive duration`,
+		"10/weeks": `duration "weeks" is not a positive duration`,
+	} {
+		_, _, err := limit.ParseRate(s)
+		assert.ErrorContains(t, err, message, s)
+	}
+}
+
+func TestNewFromString(t *testing.T) {
+	t.Parallel()
+
+	limiter, err := limit.NewTokenBucketFromString("10/s")
+	assert.NoError(t, err)
+	assert.Equal(t, limit.Rate{Count: 10, Per: 1 * time.Second}, limiter.(limit.Configurer).Limit())
+
+	limiter, err = limit.NewRollingWindowFromString("5/m")
+	assert.NoError(t, err)
+	assert.Equal(t, limit.Rate{Count: 5, Per: 1 * time.Minute}, limiter.(limit.Configurer).Limit())
+
+	limiter, err = limit.NewLeakyBucketFromString("2/s", 8)
+	assert.NoError(t, err)
+	assert.Equal(t, limit.Rate{Count: 2, Per: 1 * time.Second}, limiter.(limit.Configurer).Limit())
+	assert.Equal(t, 8, limiter.(limit.QueueConfigurer).MaxQueue())
+
+	_, err = limit.NewTokenBucketFromString("10")
+	assert.Error(t, err)
+	_, err = limit.NewRollingWindowFromString("0/s")
+	assert.Error(t, err)
+	_, err = limit.NewLeakyBucketFromString("1/-1s", 8)
+	assert.Error(t, err)
+
+	// Valid rates the limiters can't enforce fail instead of panicking
+	_, err = limit.NewTokenBucketFromString("2000/us")
+	assert.Error(t, err)
+	_, err = limit.NewLeakyBucketFromString("2/s", -1)
+	assert.Error(t, err)
+}
@@ -0,0 +1,229 @@
+package limit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The algorithms a Config can name. Configs read from files, see Validate, only support the first three, the others
+// are reported by the Config of limiters created with their own constructors.
+const (
+	AlgorithmTokenBucket          = "token_bucket"
+	AlgorithmRollingWindow        = "rolling_window"
+	AlgorithmLeakyBucket          = "leaky_bucket"
+	AlgorithmBudget               = "budget"
+	AlgorithmPaced                = "paced"
+	AlgorithmFileTokenBucket      = "file_token_bucket"
+	AlgorithmBorrowing            = "borrowing"
+	AlgorithmSlidingWindowCounter = "sliding_window_counter"
+	AlgorithmConcurrency          = "concurrency"
+	AlgorithmUnlimited            = "unlimited"
+)
+
+// Config describes a limiter, e.g. one entry of the configuration file read by WatchConfig.
+//
+// Per is a duration string like "1m" in JSON, or a number of nanoseconds. Decoders with their own duration support,
+// like yaml.v3, decode it as they do any time.Duration.
+type Config struct {
+	// Algorithm is one of AlgorithmTokenBucket, AlgorithmRollingWindow and AlgorithmLeakyBucket, or for the Config of
+	// other limiters the Algorithm constant naming them.
+	Algorithm string `json:"algorithm" yaml:"algorithm"`
+	// Count is the number of requests allowed per Per, zero if the limiter has no rate of its own.
+	Count int           `json:"count" yaml:"count"`
+	Per   time.Duration `json:"per" yaml:"per"`
+	// MaxQueue is the queue size of a leaky bucket, zero for the other algorithms.
+	MaxQueue int `json:"max_queue" yaml:"max_queue"`
+}
+
+func (c *Config) UnmarshalJSON(data []byte) error {
+	type plain Config
+	var raw struct {
+		plain
+		Per json.RawMessage `json:"per"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*c = Config(raw.plain)
+	if len(raw.Per) == 0 {
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(raw.Per, &s); err != nil {
+		return json.Unmarshal(raw.Per, &c.Per)
+	}
+	per, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("per: %w", err)
+	}
+	c.Per = per
+	return nil
+}
+
+// Validate reports what's wrong with the config, if anything.
+func (c Config) Validate() error {
+	switch c.Algorithm {
+	case AlgorithmTokenBucket, AlgorithmRollingWindow:
+		if c.MaxQueue != 0 {
+			return fmt.Errorf("max_queue only applies to the %s", AlgorithmLeakyBucket)
+		}
+	case AlgorithmLeakyBucket:
+		if c.MaxQueue <= 0 {
+			return fmt.Errorf("invalid max_queue %d", c.MaxQueue)
+		}
+	default:
+		return fmt.Errorf("unknown algorithm %q", c.Algorithm)
+	}
+	if err := validateRate(c.Count, c.Per, c.Algorithm != AlgorithmRollingWindow); err != nil {
+		return fmt.Errorf("invalid rate: %w", err)
+	}
+	return nil
+}
+
+// NewLimiter creates the limiter the config describes. The config must be valid. Options that don't apply to its
+// algorithm are ignored, so the same options can serve configs of every algorithm.
+func (c Config) NewLimiter(opts ...Option) Limiter {
+	opts = acceptOptions(opts, kindAll)
+	switch c.Algorithm {
+	case AlgorithmRollingWindow:
+		return NewRollingWindow(c.Count, c.Per, opts...)
+	case AlgorithmLeakyBucket:
+		return NewLeakyBucket(c.Count, c.Per, c.MaxQueue, opts...)
+	default:
+		return NewTokenBucket(c.Count, c.Per, opts...)
+	}
+}
+
+// Registry holds named limiters created from configs, which WatchConfig keeps in line with a configuration file.
+// Callers should look their limiter up on every use, as a config change may replace it.
+type Registry struct {
+	// Mutex
+	mux sync.Mutex
+
+	// State
+	entries map[string]*registryEntry
+}
+
+// registryEntry is a limiter of a Registry with the config it currently enforces.
+type registryEntry struct {
+	config  Config
+	limiter Limiter
+	// When the config of the limiter was removed, zero while it's configured
+	removedAt time.Time
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{entries: make(map[string]*registryEntry)}
+}
+
+// Get returns the limiter named name.
+func (r *Registry) Get(name string) (Limiter, bool) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	e, ok := r.entries[name]
+	if !ok {
+		return nil, false
+	}
+	return e.limiter, true
+}
+
+// Names returns the names of the limiters in the registry, sorted.
+func (r *Registry) Names() []string {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	names := make([]string, 0, len(r.entries))
+	for name := range r.entries {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Apply brings the registry in line with configs, or returns an error without changing anything if one of them is
+// invalid. Limiters whose algorithm and queue size are unchanged keep their state, getting the new rate if it changed.
+// Changing the algorithm or queue size, or the rate of a leaky bucket, replaces the limiter. New limiters are created
+// with opts and named after their entry.
+//
+// Limiters whose config was removed are kept unless WithRetireRemoved is given, then they're retired as described
+// there.
+func (r *Registry) Apply(configs map[string]Config, opts ...Option) error {
+	var errs []error
+	for name, c := range configs {
+		if err := c.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("limiter %q: %w", name, err))
+		}
+	}
+	if len(errs) > 0 {
+		slices.SortFunc(errs, func(a, b error) int {
+			return strings.Compare(a.Error(), b.Error())
+		})
+		return errors.Join(errs...)
+	}
+
+	o := newOptions(opts)
+	named := func(name string) []Option {
+		return append(slices.Clip(opts), WithName(name))
+	}
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	now := o.clock.Now()
+	for name, c := range configs {
+		e, ok := r.entries[name]
+		switch {
+		case !ok:
+			r.entries[name] = &registryEntry{config: c, limiter: c.NewLimiter(named(name)...)}
+			continue
+		case e.config == c:
+		case e.config.Algorithm == c.Algorithm && e.config.MaxQueue == c.MaxQueue && canSetRate(e.limiter):
+			e.limiter.(rateSetter).setRate(Rate{Count: c.Count, Per: c.Per})
+			e.config = c
+		default:
+			e.limiter = c.NewLimiter(named(name)...)
+			e.config = c
+		}
+		e.removedAt = time.Time{}
+	}
+
+	for name, e := range r.entries {
+		if _, ok := configs[name]; !ok && e.removedAt.IsZero() {
+			e.removedAt = now
+		}
+	}
+	if o.retireRemoved {
+		r.retireLocked(now, o.retireDrain)
+	}
+	return nil
+}
+
+// retire drops the removed limiters that are idle or were removed at least drain ago.
+func (r *Registry) retire(now time.Time, drain time.Duration) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.retireLocked(now, drain)
+}
+
+// This must be called with the mutex already locked
+func (r *Registry) retireLocked(now time.Time, drain time.Duration) {
+	for name, e := range r.entries {
+		if e.removedAt.IsZero() {
+			continue
+		}
+		if !busy(e.limiter) || now.Sub(e.removedAt) >= drain {
+			delete(r.entries, name)
+		}
+	}
+}
+
+// canSetRate reports whether the rate of l can change in place.
+func canSetRate(l Limiter) bool {
+	_, ok := l.(rateSetter)
+	return ok
+}
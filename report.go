@@ -0,0 +1,72 @@
+package limit
+
+import (
+	"context"
+	"time"
+)
+
+// AdmitReport describes what an admitted request went through. It's taken in the same critical section that admitted
+// the request, so its numbers agree with each other.
+type AdmitReport struct {
+	// How long the request waited to be admitted
+	Waited time.Duration
+	// The callers already waiting when the request arrived
+	QueueDepth int
+	// The requests the limiter could still allow right after admitting this one, net of pending reservations
+	Remaining int
+}
+
+// Reporter is implemented by the limiters in this package, which can report what the requests they admit went through.
+type Reporter interface {
+	// WaitContextReport is WaitContext, also returning the report of the request once it's admitted.
+	WaitContextReport(ctx context.Context) (AdmitReport, error)
+	// AllowedReport is Allowed, also returning the report of the request if it's allowed.
+	AllowedReport() (AdmitReport, bool)
+}
+
+// reportKey is the context key of the report set with ContextWithReport.
+type reportKey struct{}
+
+// ContextWithReport returns a context carrying report, for the handlers of an admitted request to read with
+// ReportFromContext.
+func ContextWithReport(ctx context.Context, report AdmitReport) context.Context {
+	return context.WithValue(ctx, reportKey{}, report)
+}
+
+// ReportFromContext returns the report of the request ctx belongs to, set by Middleware for the limiters implementing
+// Reporter.
+func ReportFromContext(ctx context.Context) (AdmitReport, bool) {
+	report, ok := ctx.Value(reportKey{}).(AdmitReport)
+	return report, ok
+}
+
+// reportSinkKey is the context key of the report WaitContextReport has the waiter fill in.
+type reportSinkKey struct{}
+
+// waitReport calls wait with a context asking the limiter to fill in the report of the request when it's admitted.
+func waitReport(ctx context.Context, wait func(ctx context.Context) error) (AdmitReport, error) {
+	report := new(AdmitReport)
+	if err := wait(context.WithValue(ctx, reportSinkKey{}, report)); err != nil {
+		return AdmitReport{}, err
+	}
+	return *report, nil
+}
+
+// admitReport returns the report of a request admitted now after waiting behind depth callers.
+func (b *base) admitReport(waited time.Duration, depth int) AdmitReport {
+	// This must be called with the mutex already locked
+	return AdmitReport{Waited: waited, QueueDepth: depth, Remaining: b.remainingNow()}
+}
+
+// allowReport runs allow, which admits or denies a request without waiting, with the mutex locked, reporting the
+// request if it's admitted.
+func (b *base) allowReport(allow func() bool) (AdmitReport, bool) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	depth := len(b.waiters.waiters)
+	if !allow() {
+		return AdmitReport{}, false
+	}
+	return b.admitReport(0, depth), true
+}
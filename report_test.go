@@ -0,0 +1,102 @@
+package limit_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limittest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWaitContextReport(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	bucket := limit.NewTokenBucket(2, 2*time.Second, limit.WithClock(clock)).(limit.Reporter)
+
+	report, err := bucket.WaitContextReport(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, limit.AdmitReport{Remaining: 1}, report)
+	report, ok := bucket.AllowedReport()
+	assert.True(t, ok)
+	assert.Equal(t, limit.AdmitReport{Remaining: 0}, report)
+	_, ok = bucket.AllowedReport()
+	assert.False(t, ok)
+
+	// The second caller arrives behind the first
+	type result struct {
+		report limit.AdmitReport
+		err    error
+	}
+	first, second := make(chan result), make(chan result)
+	wait := func(results chan<- result) {
+		report, err := bucket.WaitContextReport(context.Background())
+		results <- result{report, err}
+	}
+	go wait(first)
+	clock.BlockUntil(1)
+	go wait(second)
+	clock.BlockUntil(2)
+
+	// Whichever gets the next token, the other waits for the one after
+	clock.Advance(1 * time.Second)
+	var got []result
+	select {
+	case r := <-first:
+		got = append(got, r)
+		clock.BlockUntil(1)
+		clock.Advance(1 * time.Second)
+		got = append(got, <-second)
+	case r := <-second:
+		got = append(got, r)
+		clock.BlockUntil(1)
+		clock.Advance(1 * time.Second)
+		got = append(got, <-first)
+	}
+	for _, r := range got {
+		assert.NoError(t, r.err)
+		assert.Equal(t, 0, r.report.Remaining)
+	}
+	assert.ElementsMatch(t, []time.Duration{1 * time.Second, 2 * time.Second}, []time.Duration{got[0].report.Waited, got[1].report.Waited})
+	assert.ElementsMatch(t, []int{0, 1}, []int{got[0].report.QueueDepth, got[1].report.QueueDepth})
+}
+
+func TestAllowedReport(t *testing.T) {
+	t.Parallel()
+
+	for name, limiter := range map[string]limit.Limiter{
+		"token bucket":   limit.NewTokenBucket(3, 1*time.Hour),
+		"rolling window": limit.NewRollingWindow(3, 1*time.Hour),
+		// The room left in the queue
+		"leaky bucket": limit.NewLeakyBucket(1, 1*time.Hour, 2),
+	} {
+		report, ok := limiter.(limit.Reporter).AllowedReport()
+		assert.True(t, ok, name)
+		assert.Equal(t, 2, report.Remaining, name)
+	}
+}
+
+func TestMiddleware_Report(t *testing.T) {
+	t.Parallel()
+
+	var reports []limit.AdmitReport
+	handler := limit.Middleware(limit.Routes{
+		{Pattern: "/search", Limiter: limit.NewTokenBucket(2, 1*time.Hour)},
+		// Limiters not implementing Reporter don't set a report
+		{Pattern: "/other", Limiter: limit.Experiment(limit.NewTokenBucket(2, 1*time.Hour), nil, 0)},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report, ok := limit.ReportFromContext(r.Context())
+		if ok {
+			reports = append(reports, report)
+		}
+	}))
+
+	for _, path := range []string{"/search", "/search", "/search", "/other"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	assert.Equal(t, []limit.AdmitReport{{Remaining: 1}, {Remaining: 0}}, reports)
+}
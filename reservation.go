@@ -0,0 +1,301 @@
+package limit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"runtime/debug"
+	"time"
+)
+
+// reservationExpiry returns when a reservation taken now expires, nil if it never does.
+// The TTL is authoritative. When capByContext is set the context deadline, if any, caps it too.
+func reservationExpiry(ctx context.Context, now time.Time, reservationTTL *time.Duration, capByContext bool) *time.Time {
+	var expiresAt *time.Time
+	if reservationTTL != nil {
+		expiresAt = new(time.Time)
+		*expiresAt = now.Add(*reservationTTL)
+	}
+
+	if deadline, ok := ctx.Deadline(); capByContext && ok && (expiresAt == nil || deadline.Before(*expiresAt)) {
+		expiresAt = &deadline
+	}
+	return expiresAt
+}
+
+// expiryFor returns when a reservation requested now with reservationTTL expires, the default TTL applying if it's nil.
+func (b *base) expiryFor(ctx context.Context, reservationTTL *time.Duration) *time.Time {
+	// This must be called with the mutex already locked
+	if reservationTTL == nil {
+		reservationTTL = b.defaultTTL
+	}
+	return reservationExpiry(ctx, b.clock.Now(), reservationTTL, b.ttlFromContext)
+}
+
+// link cancels reservation once ctx is done, if the limiter was created with WithLinkedReservations. Cancel does
+// nothing to a consumed reservation, and both run under the limiter's lock, so whichever comes first wins.
+func (b *base) link(ctx context.Context, reservation Reservation) {
+	if b.linkReservations {
+		context.AfterFunc(ctx, reservation.Cancel)
+	}
+}
+
+// weighted is a pending reservation holding units of a limiter's capacity, one unless taken with ReserveN.
+type weighted interface {
+	comparable
+	units() int
+}
+
+// reservedUnits returns the units held by the pending reservations.
+func reservedUnits[R weighted](pending map[R]struct{}) int {
+	total := 0
+	for res := range pending {
+		total += res.units()
+	}
+	return total
+}
+
+// reserveCostErr returns why n units can't be reserved regardless of the limiter's state, nil if they can be.
+func reserveCostErr(n int) error {
+	if n < 1 {
+		return fmt.Errorf("cost %d is not positive", n)
+	}
+	return nil
+}
+
+// failedReservation is what Reserve returns when it couldn't reserve, e.g. because the caller was turned away or the
+// lease ended. Consuming it fails with the reason.
+type failedReservation struct {
+	err error
+}
+
+func (r failedReservation) Consume() error {
+	return r.err
+}
+
+func (r failedReservation) ConsumeContext(context.Context) error {
+	return r.err
+}
+
+func (failedReservation) Cancel() {}
+
+func (r failedReservation) Detach() (ReservationHandle, error) {
+	return ReservationHandle{}, r.err
+}
+
+// ReadyAt is the zero time, Consume fails right away.
+func (failedReservation) ReadyAt() time.Time {
+	return time.Time{}
+}
+
+func (failedReservation) Delay() time.Duration {
+	return 0
+}
+
+func (failedReservation) ExpiresAt() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+// State is canceled, the reservation was never granted.
+func (failedReservation) State() ReservationState {
+	return ReservationCanceled
+}
+
+// ReservationInfo describes a reservation reported by the abandoned reservation detector.
+type ReservationInfo struct {
+	// Limiter is the name given to the limiter with WithName, empty if it wasn't named.
+	Limiter string
+	// ReservedAt is when the reservation was taken.
+	ReservedAt time.Time
+	// Stack is the stack that took the reservation, nil unless the limiter was created with WithReservationStacks.
+	Stack []byte
+}
+
+// watchAbandoned reports a reservation taken at reservedAt to the abandoned reservation detector, if there is one,
+// should it still be pending once the detector's delay passed. pending is called with the mutex locked.
+func (b *base) watchAbandoned(reservedAt time.Time, pending func() bool) {
+	// This must be called with the mutex already locked
+	if b.onAbandoned == nil {
+		return
+	}
+
+	info := ReservationInfo{Limiter: b.name, ReservedAt: reservedAt}
+	if b.stacks {
+		info.Stack = debug.Stack()
+	}
+	timer := b.clock.NewTimer(b.abandonedAfter)
+	go func() {
+		<-timer.C()
+
+		b.mux.Lock()
+		abandoned := pending()
+		if abandoned {
+			b.abandoned++
+		}
+		b.mux.Unlock()
+
+		if abandoned {
+			b.onAbandoned(info)
+		}
+	}()
+}
+
+// ReservationState is where a reservation is in its lifecycle.
+type ReservationState int
+
+const (
+	// ReservationPending is a reservation still holding capacity, neither consumed, canceled nor expired.
+	ReservationPending ReservationState = iota
+	// ReservationConsumed is a reservation consumed.
+	ReservationConsumed
+	// ReservationCanceled is a reservation canceled, by the caller, Clear, Close or its linked context.
+	ReservationCanceled
+	// ReservationExpired is a reservation whose TTL passed before it was consumed or canceled.
+	ReservationExpired
+)
+
+func (s ReservationState) String() string {
+	switch s {
+	case ReservationPending:
+		return "pending"
+	case ReservationConsumed:
+		return "consumed"
+	case ReservationCanceled:
+		return "canceled"
+	case ReservationExpired:
+		return "expired"
+	default:
+		return fmt.Sprintf("ReservationState(%d)", int(s))
+	}
+}
+
+// reservationState returns the state of a reservation at now. An expired reservation is reported as such even if the
+// limiter didn't clean it up yet.
+func reservationState(now time.Time, consumed, canceled bool, expiresAt *time.Time) ReservationState {
+	switch {
+	case consumed:
+		return ReservationConsumed
+	case canceled:
+		return ReservationCanceled
+	case expiresAt != nil && now.After(*expiresAt):
+		return ReservationExpired
+	default:
+		return ReservationPending
+	}
+}
+
+// expiry returns when a reservation expires, and false if it never does.
+func expiry(expiresAt *time.Time) (time.Time, bool) {
+	if expiresAt == nil {
+		return time.Time{}, false
+	}
+	return *expiresAt, true
+}
+
+// pendingAt reports whether a reservation neither consumed nor canceled is still pending at now.
+func pendingAt(now time.Time, consumed, canceled bool, expiresAt *time.Time) bool {
+	return !consumed && !canceled && (expiresAt == nil || !now.After(*expiresAt))
+}
+
+// reservationErr returns why a reservation can't be used at now, nil if it's still pending.
+func reservationErr(now time.Time, consumed, canceled bool, expiresAt *time.Time) error {
+	switch {
+	case consumed:
+		return ErrReservationConsumed
+	case canceled:
+		return ErrReservationCanceled
+	case expiresAt != nil && now.After(*expiresAt):
+		return ErrReservationExpired
+	default:
+		return nil
+	}
+}
+
+// stateErr returns the error consuming a reservation in state fails with, nil if it's pending.
+func stateErr(state ReservationState) error {
+	switch state {
+	case ReservationConsumed:
+		return ErrReservationConsumed
+	case ReservationCanceled:
+		return ErrReservationCanceled
+	case ReservationExpired:
+		return ErrReservationExpired
+	default:
+		return nil
+	}
+}
+
+// ReservationHandle identifies a detached reservation, to attach it again with Attacher.Attach, e.g. in the worker that
+// handles a job queued by the request handler that reserved. It's comparable and can be serialized to JSON.
+type ReservationHandle struct {
+	ID string `json:"id"`
+	// When the reservation expires, zero if it never does
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Attacher is implemented by the limiters in this package, which can revive the reservations detached from them.
+type Attacher interface {
+	// Attach returns the reservation detached as handle. A handle can be attached once, after that or once the
+	// reservation is no longer pending Attach fails. The reservation keeps its expiry.
+	Attach(handle ReservationHandle) (Reservation, error)
+}
+
+// detachedReservation is a reservation detached from a limiter and not attached yet.
+type detachedReservation struct {
+	reservation Reservation
+	// Returns why the reservation can't be used anymore, called with the mutex locked
+	err func() error
+}
+
+// detach registers reservation, still pending, to be attached with the returned handle. Detaching it again returns the
+// same handle.
+func (b *base) detach(reservation Reservation, expiresAt *time.Time, err func() error) ReservationHandle {
+	// This must be called with the mutex already locked
+	if handle, ok := b.handles[reservation]; ok {
+		return handle
+	}
+	if b.detached == nil {
+		b.detached = make(map[string]detachedReservation)
+		b.handles = make(map[Reservation]ReservationHandle)
+	}
+
+	// Drop the reservations that can no longer be attached
+	for id, d := range b.detached {
+		if d.err() != nil {
+			delete(b.detached, id)
+			delete(b.handles, d.reservation)
+		}
+	}
+
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	handle := ReservationHandle{ID: hex.EncodeToString(id)}
+	if expiresAt != nil {
+		handle.ExpiresAt = *expiresAt
+	}
+	b.detached[handle.ID] = detachedReservation{reservation: reservation, err: err}
+	b.handles[reservation] = handle
+	return handle
+}
+
+// Attach returns the reservation detached as handle. It fails with ErrUnknownReservation for handles the limiter didn't
+// detach, that were already attached, or that were dropped once their reservation was no longer pending.
+func (b *base) Attach(handle ReservationHandle) (Reservation, error) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	d, ok := b.detached[handle.ID]
+	if !ok {
+		if !handle.ExpiresAt.IsZero() && b.clock.Now().After(handle.ExpiresAt) {
+			return nil, ErrReservationExpired
+		}
+		return nil, ErrUnknownReservation
+	}
+	delete(b.detached, handle.ID)
+	delete(b.handles, d.reservation)
+	if err := d.err(); err != nil {
+		return nil, err
+	}
+	return d.reservation, nil
+}
@@ -0,0 +1,125 @@
+package limit
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrRetryBudgetExhausted wraps the error of a call CallWithRetries didn't retry because the retry budget ran out.
+var ErrRetryBudgetExhausted = errors.New("retry budget exhausted")
+
+// retryBudgetSlots is the number of slots a retry budget splits its window into.
+const retryBudgetSlots = 10
+
+// retrySlot counts the requests and retries of one slot of a retry budget's window.
+type retrySlot struct {
+	epoch    int64 // The slot's start, in slot widths since the Unix epoch
+	requests int
+	retries  int
+}
+
+// RetryBudgetStats describes the recent traffic of a retry budget.
+type RetryBudgetStats struct {
+	// The primary requests recorded in the window
+	Requests int
+	// The retries allowed in the window
+	Retries int
+	// Retries over requests in the window, 0 without requests
+	Ratio float64
+	// The total number of retries turned down since the budget was created
+	Rejected int
+}
+
+// RetryBudget caps retries to a fraction of the primary requests of a rolling window, so a failing downstream isn't
+// hit by a retry storm on top of the regular traffic.
+type RetryBudget struct {
+	// Mutex
+	mux sync.Mutex
+
+	// Config
+	fraction   float64
+	minRetries int
+	width      time.Duration
+	clock      Clock
+
+	// State
+	slots    [retryBudgetSlots]retrySlot
+	rejected int
+}
+
+// NewRetryBudget creates a retry budget allowing retries up to fraction of the primary requests recorded in the last
+// window, and at least minRetries per window so low traffic can still retry. The window rolls in tenths of window.
+// It accepts WithClock.
+func NewRetryBudget(fraction float64, window time.Duration, minRetries int, opts ...Option) *RetryBudget {
+	o := newOptions(opts)
+	return &RetryBudget{
+		fraction:   fraction,
+		minRetries: minRetries,
+		width:      max(window/retryBudgetSlots, 1),
+		clock:      o.clock,
+	}
+}
+
+// RecordRequest records a primary request, adding to the retries the budget allows. Call it for every first attempt.
+func (b *RetryBudget) RecordRequest() {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.slot().requests++
+}
+
+// AllowRetry reports whether a retry fits in the budget, recording it if it does.
+func (b *RetryBudget) AllowRetry() bool {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	requests, retries := b.totals()
+	if retries >= max(int(float64(requests)*b.fraction), b.minRetries) {
+		b.rejected++
+		return false
+	}
+	b.slot().retries++
+	return true
+}
+
+// Stats returns the requests and retries of the current window and the retries rejected so far.
+func (b *RetryBudget) Stats() RetryBudgetStats {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	requests, retries := b.totals()
+	stats := RetryBudgetStats{Requests: requests, Retries: retries, Rejected: b.rejected}
+	if requests > 0 {
+		stats.Ratio = float64(retries) / float64(requests)
+	}
+	return stats
+}
+
+// slot returns the slot of the current time, emptying it if it last held an older one.
+func (b *RetryBudget) slot() *retrySlot {
+	// This must be called with the mutex already locked
+	epoch := b.epoch()
+	s := &b.slots[(epoch%retryBudgetSlots+retryBudgetSlots)%retryBudgetSlots]
+	if s.epoch != epoch {
+		*s = retrySlot{epoch: epoch}
+	}
+	return s
+}
+
+// totals sums the requests and retries of the slots within the window.
+func (b *RetryBudget) totals() (requests, retries int) {
+	// This must be called with the mutex already locked
+	epoch := b.epoch()
+	for _, s := range b.slots {
+		if age := epoch - s.epoch; age >= 0 && age < retryBudgetSlots {
+			requests += s.requests
+			retries += s.retries
+		}
+	}
+	return requests, retries
+}
+
+func (b *RetryBudget) epoch() int64 {
+	// This must be called with the mutex already locked
+	return b.clock.Now().UnixNano() / int64(b.width)
+}
@@ -0,0 +1,103 @@
+package limit_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limittest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryBudget_Outage(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	budget := limit.NewRetryBudget(0.1, 10*time.Second, 5, limit.WithClock(clock))
+	limiter := limit.NewTokenBucket(1_000_000, 1*time.Second, limit.WithClock(clock))
+
+	// Every call fails, each one would retry twice without a budget
+	outage := errors.New("unavailable")
+	calls := 0
+	exhausted := 0
+	for i := 0; i < 1000; i++ {
+		_, err := limit.CallWithRetries(context.Background(), limiter, budget, 3, func(context.Context) (int, error) {
+			calls++
+			return 0, outage
+		})
+		assert.ErrorIs(t, err, outage)
+		if errors.Is(err, limit.ErrRetryBudgetExhausted) {
+			exhausted++
+		}
+	}
+
+	// Retries are capped at a tenth of the requests
+	stats := budget.Stats()
+	assert.Equal(t, 1000, stats.Requests)
+	assert.Equal(t, 100, stats.Retries)
+	assert.Equal(t, 0.1, stats.Ratio)
+	assert.Equal(t, 1100, calls)
+	// Every call turned down gave up on its first rejected retry
+	assert.Equal(t, exhausted, stats.Rejected)
+	assert.Greater(t, exhausted, 850)
+}
+
+func TestRetryBudget_Window(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	budget := limit.NewRetryBudget(0.5, 10*time.Second, 1, limit.WithClock(clock))
+
+	// Without traffic the minimum is allowed
+	assert.True(t, budget.AllowRetry())
+	assert.False(t, budget.AllowRetry())
+
+	for i := 0; i < 4; i++ {
+		budget.RecordRequest()
+	}
+	assert.True(t, budget.AllowRetry())
+	assert.False(t, budget.AllowRetry())
+
+	// The requests roll out of the window a slot at a time
+	clock.Advance(5 * time.Second)
+	budget.RecordRequest()
+	budget.RecordRequest()
+	clock.Advance(5 * time.Second)
+	assert.Equal(t, limit.RetryBudgetStats{Requests: 2, Retries: 0, Ratio: 0, Rejected: 2}, budget.Stats())
+	assert.True(t, budget.AllowRetry())
+	assert.False(t, budget.AllowRetry())
+
+	clock.Advance(5 * time.Second)
+	assert.Equal(t, limit.RetryBudgetStats{Requests: 0, Retries: 1, Ratio: 0, Rejected: 3}, budget.Stats())
+}
+
+func TestCallWithRetries(t *testing.T) {
+	t.Parallel()
+
+	budget := limit.NewRetryBudget(0.1, 10*time.Second, 10)
+	calls := 0
+	got, err := limit.CallWithRetries(context.Background(), limit.NewTokenBucket(10, 1*time.Hour), budget, 3, func(context.Context) (string, error) {
+		calls++
+		if calls < 2 {
+			return "", errors.New("flaky")
+		}
+		return "ok", nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", got)
+	assert.Equal(t, 2, calls)
+
+	// A call the limiter doesn't admit isn't retried
+	limiter := limit.NewTokenBucket(1, 1*time.Hour)
+	assert.True(t, limiter.Allowed())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = limit.CallWithRetries(ctx, limiter, budget, 3, func(context.Context) (string, error) {
+		t.Fatal("fn shouldn't be called")
+		return "", nil
+	})
+	assert.ErrorIs(t, err, limit.ErrNotAdmitted)
+	assert.Equal(t, 1, budget.Stats().Retries)
+}
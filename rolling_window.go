@@ -3,25 +3,30 @@ package limit
 import (
 	"context"
 	"fmt"
-	"sync"
+	"math"
+	"slices"
 	"time"
 )
 
 type eventLog struct {
 	timestamp time.Time
+	// The reservation that recorded this event, when reservations count at reserve time
+	reservation *rollingWindowReservation
 }
 
+var _ Limiter = (*rollingWindow)(nil)
+
 type rollingWindow struct {
-	// Mutex
-	mux sync.Mutex
+	base
 
 	// Config
-	maxEventCount int
-	rateDuration  time.Duration
+	count           int
+	maxEventCount   int // Reduced by active leases
+	rateDuration    time.Duration
+	reservationMode ReservationMode
+	smoothing       time.Duration
 
 	// State
-	allowedEvents       int
-	deniedEvents        int
 	rollingWindow       []eventLog
 	pendingReservations map[*rollingWindowReservation]struct{} // Track actual reservation objects
 }
@@ -29,45 +34,95 @@ type rollingWindow struct {
 // NewRollingWindow creates a new rolling window rate limiter.
 // The count parameter is the number of events allowed in the duration.
 // The duration parameter is the time window in which the events are allowed.
-func NewRollingWindow(count int, duration time.Duration) Limiter {
-	return &rollingWindow{
-		mux:                 sync.Mutex{},
+// It panics if count or duration isn't positive or if an option doesn't apply to a rolling window, see
+// NewRollingWindowE.
+func NewRollingWindow(count int, duration time.Duration, opts ...Option) Limiter {
+	o := newOptions(opts)
+	err := validateRate(count, duration, false)
+	if err == nil {
+		err = o.check(kindRollingWindow)
+	}
+	if err != nil {
+		panic(fmt.Sprintf("limit: NewRollingWindow: %v", err))
+	}
+
+	r := &rollingWindow{
+		reservationMode:     o.reservationMode,
+		smoothing:           o.smoothing,
+		count:               count,
 		maxEventCount:       count,
 		rateDuration:        duration,
 		rollingWindow:       make([]eventLog, 0),
 		pendingReservations: make(map[*rollingWindowReservation]struct{}),
 	}
+	r.init(o)
+	r.remaining = func() int { return r.maxEventCount - len(r.rollingWindow) - reservedUnits(r.pendingReservations) }
+	r.nextAllowed = func() time.Time { return r.nextAllowedTime(1) }
+	return r
+}
+
+// NewRollingWindowE is NewRollingWindow returning an error instead of panicking on an invalid rate or option.
+func NewRollingWindowE(count int, duration time.Duration, opts ...Option) (Limiter, error) {
+	if err := validateRate(count, duration, false); err != nil {
+		return nil, err
+	}
+	if err := newOptions(opts).check(kindRollingWindow); err != nil {
+		return nil, err
+	}
+	return NewRollingWindow(count, duration, opts...), nil
+}
+
+// NewRollingWindowFromString creates a rolling window allowing rate, a string like "100/s" as ParseRate parses it.
+func NewRollingWindowFromString(rate string, opts ...Option) (Limiter, error) {
+	count, per, err := ParseRate(rate)
+	if err != nil {
+		return nil, err
+	}
+	return NewRollingWindowE(count, per, opts...)
 }
 
 func (r *rollingWindow) WaitContext(ctx context.Context) error {
-	for {
-		r.mux.Lock()
-		r.removeExpiredEvents()
-		r.cleanupExpiredReservations() // Clean up expired reservations
-
-		if len(r.rollingWindow)+len(r.pendingReservations) < r.maxEventCount {
-			r.rollingWindow = append(r.rollingWindow, eventLog{timestamp: time.Now()})
-			r.allowedEvents++
-			r.mux.Unlock()
-			return nil
-		}
+	return r.WaitNContext(ctx, 1)
+}
 
-		r.mux.Unlock()
+func (r *rollingWindow) WaitN(n int) error {
+	return r.WaitNContext(context.Background(), n)
+}
 
-		waitDuration := r.rateDuration
-		if len(r.rollingWindow) > 0 {
-			waitDuration = r.rollingWindow[0].timestamp.Add(r.rateDuration).Sub(time.Now())
-		}
-		select {
-		case <-ctx.Done():
-			r.mux.Lock()
-			r.deniedEvents++
-			r.mux.Unlock()
-			return ctx.Err()
-		case <-time.After(waitDuration):
-			// Wait until the next event is allowed
+func (r *rollingWindow) WaitNTimeout(timeout time.Duration, n int) error {
+	ctx, cancel := withTimeout(r.clock, timeout)
+	defer cancel()
+	return r.WaitNContext(ctx, n)
+}
+
+func (r *rollingWindow) WaitNContext(ctx context.Context, n int) error {
+	if n <= 0 {
+		return nil
+	}
+
+	return r.await(ctx, func() (bool, time.Duration, error) {
+		if err := r.costErrLocked(n); err != nil {
+			return false, 0, err
 		}
+
+		ok, retryIn := r.tryRecordLocked(n)
+		return ok, retryIn, nil
+	}, nil)
+}
+
+// costErrLocked denies n events and returns an error if they exceed the window limit or the smoothing cap, nil
+// otherwise.
+func (r *rollingWindow) costErrLocked(n int) error {
+	// This must be called with the mutex already locked
+	if n > r.maxEventCount {
+		r.deny(ReasonLimited)
+		return fmt.Errorf("cost %d exceeds the window limit of %d", n, r.maxEventCount)
 	}
+	if r.smoothing > 0 && n > r.smoothingCap() {
+		r.deny(ReasonSmoothing)
+		return fmt.Errorf("cost %d exceeds the smoothing limit of %d", n, r.smoothingCap())
+	}
+	return nil
 }
 
 func (r *rollingWindow) Wait() {
@@ -75,38 +130,209 @@ func (r *rollingWindow) Wait() {
 }
 
 func (r *rollingWindow) WaitTimeout(timeout time.Duration) error {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	ctx, cancel := withTimeout(r.clock, timeout)
 	defer cancel()
 	return r.WaitContext(ctx)
 }
 
 func (r *rollingWindow) Allowed() bool {
+	return r.AllowN(1)
+}
+
+func (r *rollingWindow) AllowedReport() (AdmitReport, bool) {
+	return r.allowReport(func() bool { return r.allowLocked(1) })
+}
+
+func (r *rollingWindow) WaitContextReport(ctx context.Context) (AdmitReport, error) {
+	return waitReport(ctx, r.WaitContext)
+}
+
+func (r *rollingWindow) AllowN(n int) bool {
+	if n <= 0 {
+		return true
+	}
+
 	r.mux.Lock()
 	defer r.mux.Unlock()
-	r.removeExpiredEvents()
-	r.cleanupExpiredReservations() // Clean up expired reservations
+	return r.allowLocked(n)
+}
 
-	// Check considering both active events and pending reservations
-	if len(r.rollingWindow)+len(r.pendingReservations) < r.maxEventCount {
-		r.rollingWindow = append(r.rollingWindow, eventLog{timestamp: time.Now()})
-		r.allowedEvents++
+func (r *rollingWindow) allowLocked(n int) bool {
+	// This must be called with the mutex already locked
+	if r.denyHalted() {
+		return false
+	}
+	if ok, _ := r.tryRecordLocked(n); ok {
 		return true
 	}
 
-	r.deniedEvents++
+	r.deny(r.deniedReason(n))
 	return false
 }
 
+// deniedReason returns why n events were turned down, telling the smoothing cap apart from the window limit.
+func (r *rollingWindow) deniedReason(n int) Reason {
+	// This must be called with the mutex already locked
+	if reason := r.limitedReason(); reason != ReasonLimited {
+		return reason
+	}
+	if r.availableLocked(n) {
+		// The window had room, so the smoothing cap turned them down
+		return ReasonSmoothing
+	}
+	return ReasonLimited
+}
+
+// tryRecordLocked records n events if there are enough free slots in the window, otherwise it returns how long until
+// it's worth trying again.
+func (r *rollingWindow) tryRecordLocked(n int) (bool, time.Duration) {
+	// This must be called with the mutex already locked
+	if !r.availableLocked(n) || r.smoothedLocked(n) || !r.closedUntil().IsZero() {
+		return false, r.retryIn(r.nextAllowedTime(n), r.rateDuration)
+	}
+
+	now := r.clock.Now()
+	for range n {
+		r.rollingWindow = append(r.rollingWindow, eventLog{timestamp: now})
+	}
+	r.countAllowed()
+	return true, 0
+}
+
+// tryReserveLocked reserves n slots if they are free in the window, otherwise it returns how long until it's worth
+// trying again.
+func (r *rollingWindow) tryReserveLocked(ctx context.Context, n int, reservationTTL *time.Duration) (*rollingWindowReservation, time.Duration) {
+	// This must be called with the mutex already locked
+	if !r.reservableLocked(n) || r.smoothedLocked(n) || !r.closedUntil().IsZero() {
+		return nil, r.retryIn(r.nextAllowedTime(n), r.rateDuration)
+	}
+
+	reservation := &rollingWindowReservation{
+		limiter:    r,
+		n:          n,
+		reservedAt: r.clock.Now(),
+		expiresAt:  r.expiryFor(ctx, reservationTTL),
+	}
+	if r.reservationMode == ReservationCountsAtReserve {
+		// The events hold the slots, so the reservation isn't pending
+		reservation.stamped = true
+		for range n {
+			r.rollingWindow = append(r.rollingWindow, eventLog{timestamp: r.clock.Now(), reservation: reservation})
+		}
+	} else {
+		r.pendingReservations[reservation] = struct{}{} // Track this reservation
+	}
+	r.watchAbandoned(reservation.reservedAt, func() bool {
+		return pendingAt(r.clock.Now(), reservation.consumed, reservation.canceled, reservation.expiresAt)
+	})
+	return reservation, 0
+}
+
+// reservableLocked drops expired events and reservations and reports whether n slots can be reserved, oversubscribing
+// the free slots with WithReservationOversubscription unless reservations are recorded in the window.
+func (r *rollingWindow) reservableLocked(n int) bool {
+	// This must be called with the mutex already locked
+	if r.availableLocked(n) {
+		return true
+	}
+	return r.reservationMode == ReservationCountsAtConsume &&
+		reservedUnits(r.pendingReservations)+n <= r.reservationRoom(r.maxEventCount-len(r.rollingWindow))
+}
+
+// availableLocked drops expired events and reservations and reports whether there are n free slots in the window,
+// considering both active events and pending reservations.
+func (r *rollingWindow) availableLocked(n int) bool {
+	// This must be called with the mutex already locked
+	r.expireLeases(r.applyLeases)
+	r.removeExpiredEvents()
+	r.cleanupExpiredReservations()
+	return len(r.rollingWindow)+reservedUnits(r.pendingReservations)+n <= r.maxEventCount
+}
+
+// smoothingCap returns how many events the smoothing allows per sub-interval, its share of the window limit rounded up.
+func (r *rollingWindow) smoothingCap() int {
+	// This must be called with the mutex already locked
+	return int(math.Ceil(float64(r.maxEventCount) * float64(r.smoothing) / float64(r.rateDuration)))
+}
+
+// smoothedLocked reports whether the smoothing cap turns away n more events, counting the events of the last
+// sub-interval.
+func (r *rollingWindow) smoothedLocked(n int) bool {
+	// This must be called with the mutex already locked
+	if r.smoothing <= 0 {
+		return false
+	}
+	return len(r.smoothingEvents())+n > r.smoothingCap()
+}
+
+// smoothingEvents returns the events of the last sub-interval, oldest first.
+func (r *rollingWindow) smoothingEvents() []eventLog {
+	// This must be called with the mutex already locked
+	now := r.clock.Now()
+	i := len(r.rollingWindow)
+	for i > 0 && now.Sub(r.rollingWindow[i-1].timestamp) < r.smoothing {
+		i--
+	}
+	return r.rollingWindow[i:]
+}
+
+// AcquireLease carves rate out of the window for ttl, lowering the events allowed in the window by the leased share
+// of the rate, rounded up.
+func (r *rollingWindow) AcquireLease(rate Rate, ttl time.Duration) (Lease, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	return r.acquireLease(rate, ttl, r.count, r.rateDuration, func() Limiter {
+		return NewRollingWindow(rate.Count, rate.Per, WithName(r.name), WithLabels(r.labels), WithClock(r.clock), withBlackouts(r.blackouts))
+	}, r.applyLeases)
+}
+
+// setRate changes the events allowed in the window and its length. Events already recorded are kept, so lowering the
+// rate denies requests until enough of them leave the shorter window.
+func (r *rollingWindow) setRate(rate Rate) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.count, r.rateDuration = rate.Count, rate.Per
+	r.applyLeases()
+	r.waiters.notify()
+}
+
+// SetLimit keeps the recorded events: a lower count admits nothing until enough of them age out, and a shorter
+// duration ages out the events older than it right away.
+func (r *rollingWindow) SetLimit(count int, duration time.Duration) {
+	if duration <= 0 || count < 0 {
+		panic(fmt.Sprintf("limit: SetLimit with an invalid limit of %d/%s", count, duration))
+	}
+	r.setRate(Rate{Count: count, Per: duration})
+}
+
+// applyLeases recomputes the events allowed in the window after the active leases.
+func (r *rollingWindow) applyLeases() {
+	// This must be called with the mutex already locked
+	r.maxEventCount = r.count - int(math.Ceil(r.leases.leasedIn(r.rateDuration)))
+}
+
 func (r *rollingWindow) removeExpiredEvents() {
 	// This must be called with the mutex already locked
-	for len(r.rollingWindow) > 0 && time.Since(r.rollingWindow[0].timestamp) > r.rateDuration {
+	now := r.clock.Now()
+	for len(r.rollingWindow) > 0 && now.Sub(r.rollingWindow[0].timestamp) > r.rateDuration {
 		r.rollingWindow = r.rollingWindow[1:]
 	}
+
+	// Events from the future were recorded before the wall clock stepped backwards, count them from now instead of
+	// holding their slots until the clock catches up
+	if last := len(r.rollingWindow) - 1; last >= 0 && r.rollingWindow[last].timestamp.After(now) {
+		r.clockStepped(r.rollingWindow[last].timestamp.Sub(now))
+	}
+	for i := len(r.rollingWindow) - 1; i >= 0 && r.rollingWindow[i].timestamp.After(now); i-- {
+		r.rollingWindow[i].timestamp = now
+	}
 }
 
 func (r *rollingWindow) cleanupExpiredReservations() {
 	// This must be called with the mutex already locked
-	now := time.Now()
+	now := r.clock.Now()
 	for res := range r.pendingReservations {
 		if res.expiresAt != nil && now.After(*res.expiresAt) {
 			delete(r.pendingReservations, res)
@@ -118,124 +344,377 @@ func (r *rollingWindow) Clear() {
 	r.mux.Lock()
 	defer r.mux.Unlock()
 
-	// Mark all reservations as canceled
+	r.cancelReservationsLocked()
+
+	// Clear the rolling window
+	r.rollingWindow = make([]eventLog, 0)
+	r.waiters.notify()
+}
+
+func (r *rollingWindow) Close() error {
+	return r.close(r.cancelReservationsLocked)
+}
+
+// cancelReservationsLocked cancels the pending reservations.
+func (r *rollingWindow) cancelReservationsLocked() {
+	// This must be called with the mutex already locked
 	for res := range r.pendingReservations {
 		res.canceled = true
 	}
-
-	// Clear the pending reservations map
+	for _, event := range r.rollingWindow {
+		if event.reservation != nil && !event.reservation.consumed {
+			event.reservation.canceled = true
+		}
+	}
 	r.pendingReservations = make(map[*rollingWindowReservation]struct{})
-
-	// Clear the rolling window
-	r.rollingWindow = make([]eventLog, 0)
 }
 
 func (r *rollingWindow) Stats() Stats {
 	r.mux.Lock()
 	defer r.mux.Unlock()
+	r.expireLeases(r.applyLeases)
+	r.removeExpiredEvents()
+	r.cleanupExpiredReservations()
+
+	stats := r.stats()
+	stats.PendingReservations = len(r.pendingReservations)
+	return stats
+}
+
+// Info reports the events allowed per window as the limit, with Reset being when the oldest event in the window
+// expires.
+func (r *rollingWindow) Info() LimitInfo {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.expireLeases(r.applyLeases)
+	r.removeExpiredEvents()
+	r.cleanupExpiredReservations()
 
-	nextAllowedTime := time.Now()
+	reset := r.clock.Now()
 	if len(r.rollingWindow) > 0 {
-		nextAllowedTime = r.rollingWindow[0].timestamp.Add(r.rateDuration)
+		reset = r.rollingWindow[0].timestamp.Add(r.rateDuration)
+	}
+	return r.info(r.maxEventCount, r.maxEventCount-len(r.rollingWindow)-reservedUnits(r.pendingReservations), reset, r.rateDuration)
+}
+
+// Available returns the free slots in the window net of pending reservations, without taking any.
+func (r *rollingWindow) Available() int {
+	return r.Info().Remaining
+}
+
+// EstimatedWait returns how long until enough events expire from the window to make room past pending reservations.
+func (r *rollingWindow) EstimatedWait() time.Duration {
+	return r.waitUntil(r.Stats().NextAllowedTime)
+}
+
+func (r *rollingWindow) Config() Config {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return Config{Algorithm: AlgorithmRollingWindow, Count: r.count, Per: r.rateDuration}
+}
+
+// Limit returns the events allowed per window, net of active leases.
+func (r *rollingWindow) Limit() Rate {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.expireLeases(r.applyLeases)
+	return Rate{Count: r.maxEventCount, Per: r.rateDuration}
+}
+
+// Burst returns the events allowed at once, the whole window unless the smoothing cap is lower.
+func (r *rollingWindow) Burst() int {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.expireLeases(r.applyLeases)
+	if r.smoothing > 0 {
+		return min(r.smoothingCap(), r.maxEventCount)
+	}
+	return r.maxEventCount
+}
+
+// EventLister is implemented by the limiters that keep a log of the events they allowed, the rolling window.
+type EventLister interface {
+	// Events returns when the events still counted against the limit happened, oldest first and at most n of them.
+	// It's a snapshot meant for debugging, events may expire or be recorded right after.
+	Events(n int) []time.Time
+}
+
+func (r *rollingWindow) Events(n int) []time.Time {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.removeExpiredEvents()
+
+	logged := r.rollingWindow[:min(len(r.rollingWindow), max(n, 0))]
+	events := make([]time.Time, 0, len(logged))
+	for _, event := range logged {
+		events = append(events, event.timestamp)
+	}
+	return events
+}
+
+func (r *rollingWindow) PendingReservationAges(n int) []time.Duration {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.cleanupExpiredReservations()
+
+	reservedAt := make([]time.Time, 0, len(r.pendingReservations))
+	for res := range r.pendingReservations {
+		reservedAt = append(reservedAt, res.reservedAt)
+	}
+	stamped := make(map[*rollingWindowReservation]bool)
+	for _, event := range r.rollingWindow {
+		// Reservations recorded when reserving are pending until consumed, once for all their events
+		if res := event.reservation; res != nil && !res.consumed && !stamped[res] {
+			stamped[res] = true
+			reservedAt = append(reservedAt, res.reservedAt)
+		}
+	}
+	return r.reservationAges(reservedAt, n)
+}
+
+// nextAllowedTime returns when n slots in the window will be free net of pending reservations, once enough events or
+// reservations expire. It returns the zero time if only consuming or canceling reservations can free them.
+func (r *rollingWindow) nextAllowedTime(n int) time.Time {
+	// This must be called with the mutex already locked
+	excess := len(r.rollingWindow) + reservedUnits(r.pendingReservations) + n - 1 - r.maxEventCount
+	if excess < 0 {
+		return r.afterSmoothing(r.clock.Now(), n)
+	}
+
+	frees := make([]time.Time, 0, len(r.rollingWindow))
+	for _, event := range r.rollingWindow {
+		frees = append(frees, event.timestamp.Add(r.rateDuration))
+	}
+	for res := range r.pendingReservations {
+		for range res.n {
+			if res.expiresAt != nil {
+				frees = append(frees, *res.expiresAt)
+			}
+		}
+	}
+	if len(frees) <= excess {
+		return time.Time{}
+	}
+
+	slices.SortFunc(frees, time.Time.Compare)
+	return r.afterSmoothing(frees[excess], n)
+}
+
+// afterSmoothing returns when the smoothing cap allows n more events, if that's later than next.
+func (r *rollingWindow) afterSmoothing(next time.Time, n int) time.Time {
+	// This must be called with the mutex already locked
+	if r.smoothing <= 0 {
+		return next
+	}
+
+	events := r.smoothingEvents()
+	excess := len(events) + n - 1 - r.smoothingCap()
+	if excess < 0 || excess >= len(events) {
+		return next
+	}
+	if free := events[excess].timestamp.Add(r.smoothing); free.After(next) {
+		return free
+	}
+	return next
+}
+
+func (r *rollingWindow) TryReserve(reservationTTL *time.Duration) (Reservation, bool) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if reservation, _ := r.tryReserveLocked(context.Background(), 1, reservationTTL); reservation != nil {
+		return reservation, true
 	}
 
-	return Stats{
-		AllowedRequests: r.allowedEvents,
-		DeniedRequests:  r.deniedEvents,
-		NextAllowedTime: nextAllowedTime,
+	r.deny(r.deniedReason(1))
+	return nil, false
+}
+
+// reserveNow reserves n slots if AllowN would record them, without oversubscribing them.
+func (r *rollingWindow) reserveNow(n int) (Reservation, bool) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if r.denyHalted() {
+		return nil, false
+	}
+	if r.availableLocked(n) {
+		if reservation, _ := r.tryReserveLocked(context.Background(), n, nil); reservation != nil {
+			return reservation, true
+		}
 	}
+
+	r.deny(r.deniedReason(n))
+	return nil, false
 }
 
 func (r *rollingWindow) Reserve(reservationTTL *time.Duration) Reservation {
-	reservation, _ := r.ReserveContext(context.Background(), reservationTTL)
+	reservation, err := r.ReserveContext(context.Background(), reservationTTL)
+	if err != nil {
+		return failedReservation{err: err}
+	}
 	return reservation
 }
 
 func (r *rollingWindow) ReserveTimeout(timeout time.Duration, reservationTTL *time.Duration) (Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	ctx, cancel := withTimeout(r.clock, timeout)
 	defer cancel()
 	return r.ReserveContext(ctx, reservationTTL)
 }
 
+func (r *rollingWindow) Permits(ctx context.Context) <-chan struct{} {
+	return deliverPermits(ctx, func(ctx context.Context) (permit, error) {
+		return reservePermit(ctx, r)
+	})
+}
+
 func (r *rollingWindow) ReserveContext(ctx context.Context, reservationTTL *time.Duration) (Reservation, error) {
-	for {
-		r.mux.Lock()
-		r.removeExpiredEvents()
-		r.cleanupExpiredReservations() // Clean up expired reservations
-
-		// Consider both actual events and pending reservations
-		if len(r.rollingWindow)+len(r.pendingReservations) < r.maxEventCount {
-			var expiresAt *time.Time
-			if reservationTTL != nil {
-				expiresAt = new(time.Time)
-				*expiresAt = time.Now().Add(*reservationTTL)
-			}
-			reservation := &rollingWindowReservation{
-				limiter:   r,
-				expiresAt: expiresAt, // Expires after same time as wait time
-			}
-			r.pendingReservations[reservation] = struct{}{} // Track this reservation
-			r.mux.Unlock()
-			return reservation, nil
-		}
+	return r.ReserveN(ctx, 1, reservationTTL)
+}
 
-		waitDuration := r.rateDuration
-		if len(r.rollingWindow) > 0 {
-			waitDuration = r.rollingWindow[0].timestamp.Add(r.rateDuration).Sub(time.Now())
-		}
-		r.mux.Unlock()
-
-		select {
-		case <-ctx.Done():
-			r.mux.Lock()
-			r.deniedEvents++
-			r.mux.Unlock()
-			return nil, ctx.Err()
-		case <-time.After(waitDuration):
-			// Continue waiting
+func (r *rollingWindow) ReserveN(ctx context.Context, n int, reservationTTL *time.Duration) (Reservation, error) {
+	if err := reserveCostErr(n); err != nil {
+		return nil, err
+	}
+
+	var reservation *rollingWindowReservation
+	err := r.awaitReservation(ctx, func() (bool, time.Duration, error) {
+		if err := r.costErrLocked(n); err != nil {
+			return false, 0, err
 		}
+
+		var retryIn time.Duration
+		reservation, retryIn = r.tryReserveLocked(ctx, n, reservationTTL)
+		return reservation != nil, retryIn, nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	r.link(ctx, reservation)
+	return reservation, nil
 }
 
 // rollingWindowReservation implements the Reservation interface
 type rollingWindowReservation struct {
-	limiter   *rollingWindow
-	expiresAt *time.Time
-	consumed  bool
-	canceled  bool
+	limiter    *rollingWindow
+	n          int // Slots held
+	reservedAt time.Time
+	expiresAt  *time.Time
+	consumed   bool
+	canceled   bool
+	// When a reservation taken with ReserveDelayed is ready, zero for the others
+	readyAt time.Time
+	// Whether the window event was recorded when reserving
+	stamped bool
 }
 
 func (r *rollingWindowReservation) Consume() error {
+	return r.ConsumeContext(context.Background())
+}
+
+// ConsumeContext waits out the delay of a reservation taken with ReserveDelayed, canceling it if ctx is done first. The
+// others never block.
+func (r *rollingWindowReservation) ConsumeContext(ctx context.Context) error {
+	if err := r.limiter.sleepUntil(ctx, r.readyAt, r.Cancel); err != nil {
+		return err
+	}
+
 	r.limiter.mux.Lock()
 	defer r.limiter.mux.Unlock()
 
 	if r.consumed {
-		return fmt.Errorf("reservation already consumed")
+		return ErrReservationConsumed
 	}
 
 	if r.canceled {
-		return fmt.Errorf("reservation was canceled")
+		return ErrReservationCanceled
 	}
 
-	if r.expiresAt != nil && time.Now().After(*r.expiresAt) {
+	if r.expiresAt != nil && r.limiter.clock.Now().After(*r.expiresAt) {
 		delete(r.limiter.pendingReservations, r) // Remove expired reservation
-		return fmt.Errorf("reservation expired")
+		return ErrReservationExpired
+	}
+
+	if !r.stamped && r.limiter.oversubscription > 1 {
+		// More slots may have been reserved than the window has free
+		r.limiter.removeExpiredEvents()
+		if len(r.limiter.rollingWindow)+r.n > r.limiter.maxEventCount {
+			r.limiter.oversubscribed++
+			return ErrOversubscribed
+		}
 	}
 
 	r.consumed = true
+	r.limiter.countAllowed()
+	if r.stamped {
+		// The event was already recorded when reserving
+		return nil
+	}
+
 	delete(r.limiter.pendingReservations, r) // Remove from pending
-	r.limiter.rollingWindow = append(r.limiter.rollingWindow, eventLog{timestamp: time.Now()})
-	r.limiter.allowedEvents++
+	for range r.n {
+		r.limiter.rollingWindow = append(r.limiter.rollingWindow, eventLog{timestamp: r.limiter.clock.Now()})
+	}
 
 	return nil
 }
 
+func (r *rollingWindowReservation) units() int {
+	return r.n
+}
+
 func (r *rollingWindowReservation) Cancel() {
 	r.limiter.mux.Lock()
 	defer r.limiter.mux.Unlock()
 
-	if !r.consumed {
-		r.canceled = true
+	if r.consumed || r.canceled {
+		return
+	}
+
+	r.canceled = true
+	if r.stamped {
+		// Free the slot recorded when reserving, unless it already left the window
+		r.limiter.rollingWindow = slices.DeleteFunc(r.limiter.rollingWindow, func(event eventLog) bool {
+			return event.reservation == r
+		})
+	} else {
 		delete(r.limiter.pendingReservations, r) // Remove from pending
 	}
+	r.limiter.waiters.notify()
+}
+
+func (r *rollingWindowReservation) Detach() (ReservationHandle, error) {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	state := func() error {
+		return reservationErr(r.limiter.clock.Now(), r.consumed, r.canceled, r.expiresAt)
+	}
+	if err := state(); err != nil {
+		return ReservationHandle{}, err
+	}
+	return r.limiter.detach(r, r.expiresAt, state), nil
+}
+
+// ReadyAt is now, Consume only blocks for the delay of a reservation taken with ReserveDelayed.
+func (r *rollingWindowReservation) ReadyAt() time.Time {
+	return latest(r.readyAt, r.limiter.clock.Now())
+}
+
+func (r *rollingWindowReservation) Delay() time.Duration {
+	return max(r.readyAt.Sub(r.limiter.clock.Now()), 0)
+}
+
+func (r *rollingWindowReservation) ExpiresAt() (time.Time, bool) {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	return expiry(r.expiresAt)
+}
+
+func (r *rollingWindowReservation) State() ReservationState {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	return reservationState(r.limiter.clock.Now(), r.consumed, r.canceled, r.expiresAt)
 }
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limittest"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -13,19 +14,27 @@ func TestRollingWindow_Wait(t *testing.T) {
 	t.Parallel()
 
 	// 5 requests per second
-	limiter := limit.NewRollingWindow(5, 1*time.Second)
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	limiter := limit.NewRollingWindow(5, 1*time.Second, limit.WithClock(clock))
 
-	start := time.Now()
+	start := clock.Now()
 	for i := 0; i < 5; i++ {
 		limiter.Wait()
 	}
 
-	// 5 requests should have been pseudo instant
-	assert.True(t, time.Since(start) < time.Millisecond)
+	// 5 requests should have been instant
+	assert.Equal(t, start, clock.Now())
 
-	// Extra request should wait for the next 1 second
-	limiter.Wait()
-	assert.True(t, time.Since(start) >= time.Second)
+	// Extra request should wait for the first events to leave the window, just after 1 second
+	waited := make(chan struct{})
+	go func() {
+		limiter.Wait()
+		close(waited)
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(1*time.Second + time.Nanosecond)
+	<-waited
+	assert.Equal(t, 6, limiter.Stats().AllowedRequests)
 }
 
 func TestRollingWindow_Allowed(t *testing.T) {
@@ -65,8 +74,7 @@ func TestRollingWindow_Reserve_BlocksAfterCapacityReached(t *testing.T) {
 	defer cancel()
 
 	_, err := limiter.ReserveContext(ctx, nil)
-	assert.Error(t, err)
-	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
 
 	// Verify reservations can be consumed
 	for _, res := range reservations {
@@ -99,3 +107,235 @@ func TestRollingWindow_Reserve_CancelFreesCapacity(t *testing.T) {
 	// After consuming, we should be at capacity again
 	assert.False(t, limiter.Allowed())
 }
+
+func TestRollingWindow_Stats_NextAllowedTimeAccountsForReservations(t *testing.T) {
+	t.Parallel()
+
+	// 2 requests per second
+	limiter := limit.NewRollingWindow(2, 1*time.Second)
+
+	// A window event is still allowing more requests now
+	assert.True(t, limiter.Allowed())
+	assert.WithinDuration(t, time.Now(), limiter.Stats().NextAllowedTime, 10*time.Millisecond)
+
+	// The remaining slot is held by a reservation expiring before the event
+	ttl := 100 * time.Millisecond
+	limiter.Reserve(&ttl)
+
+	next := limiter.Stats().NextAllowedTime
+	assert.False(t, limiter.Allowed())
+	assert.WithinDuration(t, time.Now().Add(ttl), next, 20*time.Millisecond)
+
+	time.Sleep(time.Until(next) + 10*time.Millisecond)
+	assert.True(t, limiter.Allowed())
+}
+
+func TestRollingWindow_ReservationMode_LongHeldReservation(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name         string
+		mode         limit.ReservationMode
+		allowedAfter int
+	}{
+		// The consumed event is recorded now, so it takes one of the two slots of the current window
+		{name: "CountsAtConsume", mode: limit.ReservationCountsAtConsume, allowedAfter: 1},
+		// The event was recorded a window ago and already expired, so both slots are free
+		{name: "CountsAtReserve", mode: limit.ReservationCountsAtReserve, allowedAfter: 2},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// 2 requests per 300ms
+			limiter := limit.NewRollingWindow(2, 300*time.Millisecond, limit.WithReservationMode(tt.mode))
+
+			// The reservation holds a slot while pending in both modes
+			res := limiter.Reserve(nil)
+			assert.True(t, limiter.Allowed())
+			assert.False(t, limiter.Allowed())
+
+			// Hold the reservation past the window boundary
+			time.Sleep(350 * time.Millisecond)
+			assert.NoError(t, res.Consume())
+
+			allowed := 0
+			for limiter.Allowed() {
+				allowed++
+			}
+			assert.Equal(t, tt.allowedAfter, allowed)
+		})
+	}
+}
+
+func TestRollingWindow_ReservationCountsAtReserve_CancelFreesSlot(t *testing.T) {
+	t.Parallel()
+
+	// 1 request per second
+	limiter := limit.NewRollingWindow(1, 1*time.Second, limit.WithReservationMode(limit.ReservationCountsAtReserve))
+
+	res := limiter.Reserve(nil)
+	assert.False(t, limiter.Allowed())
+
+	res.Cancel()
+	assert.Error(t, res.Consume())
+	assert.True(t, limiter.Allowed())
+}
+
+func TestRollingWindow_ClockSteps(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Now())
+
+	// 2 requests per second
+	limiter := limit.NewRollingWindow(2, 1*time.Second, limit.WithClock(clock))
+	assert.True(t, limiter.Allowed())
+	assert.True(t, limiter.Allowed())
+	assert.False(t, limiter.Allowed())
+
+	// Stepping the clock backwards doesn't hold the events until the clock catches up
+	clock.Set(clock.Now().Add(-5 * time.Second))
+	assert.False(t, limiter.Allowed())
+	clock.Advance(1*time.Second + time.Nanosecond)
+	assert.True(t, limiter.Allowed())
+	assert.True(t, limiter.Allowed())
+	assert.False(t, limiter.Allowed())
+
+	// Stepping it forwards expires every event
+	clock.Set(clock.Now().Add(1 * time.Hour))
+	assert.True(t, limiter.Allowed())
+	assert.True(t, limiter.Allowed())
+	assert.False(t, limiter.Allowed())
+}
+
+func TestRollingWindow_Smoothing_ShapesBurst(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Now())
+	limiter := limit.NewRollingWindow(600, 1*time.Minute, limit.WithClock(clock), limit.WithSmoothing(1*time.Second))
+
+	// A burst of 20 every second for half a minute only gets 10 through each second
+	for second := 0; second < 30; second++ {
+		admitted := 0
+		for i := 0; i < 20; i++ {
+			if limiter.Allowed() {
+				admitted++
+			}
+		}
+		assert.Equal(t, 10, admitted, "second %d", second)
+		clock.Advance(1 * time.Second)
+	}
+
+	stats := limiter.Stats()
+	assert.Equal(t, 300, stats.AllowedRequests)
+	assert.Equal(t, map[limit.Reason]int{limit.ReasonSmoothing: 300}, stats.DeniedByReason)
+}
+
+func TestRollingWindow_Smoothing_WindowStillBinds(t *testing.T) {
+	t.Parallel()
+
+	// One event per second, rounded up from a tenth, and 10 per minute overall
+	clock := limittest.NewFakeClock(time.Now())
+	limiter := limit.NewRollingWindow(10, 1*time.Minute, limit.WithClock(clock), limit.WithSmoothing(1*time.Second))
+
+	for i := 0; i < 9; i++ {
+		assert.True(t, limiter.Allowed())
+		assert.False(t, limiter.Allowed())
+		clock.Advance(1 * time.Second)
+	}
+	assert.Equal(t, map[limit.Reason]int{limit.ReasonSmoothing: 9}, limiter.Stats().DeniedByReason)
+
+	// The tenth event fills the window, so the next second has smoothing room but the window turns it down
+	assert.True(t, limiter.Allowed())
+	clock.Advance(1 * time.Second)
+	assert.False(t, limiter.Allowed())
+	assert.Equal(t, map[limit.Reason]int{limit.ReasonSmoothing: 9, limit.ReasonLimited: 1}, limiter.Stats().DeniedByReason)
+}
+
+func TestRollingWindow_Smoothing_WaitsForSubInterval(t *testing.T) {
+	t.Parallel()
+
+	start := time.Now()
+	clock := limittest.NewFakeClock(start)
+	limiter := limit.NewRollingWindow(600, 1*time.Minute, limit.WithClock(clock), limit.WithSmoothing(1*time.Second))
+	for i := 0; i < 10; i++ {
+		assert.True(t, limiter.Allowed())
+	}
+	assert.Equal(t, start.Add(1*time.Second), limiter.Stats().NextAllowedTime)
+
+	done := make(chan error, 1)
+	go func() { done <- limiter.WaitContext(context.Background()) }()
+
+	clock.BlockUntil(1)
+	clock.Advance(1 * time.Second)
+	assert.NoError(t, <-done)
+	assert.Equal(t, 11, limiter.Stats().AllowedRequests)
+}
+
+func TestRollingWindow_Events(t *testing.T) {
+	t.Parallel()
+
+	start := time.Now()
+	clock := limittest.NewFakeClock(start)
+	limiter := limit.NewRollingWindow(10, 30*time.Second, limit.WithClock(clock))
+	for i := 0; i < 3; i++ {
+		assert.True(t, limiter.Allowed())
+		clock.Advance(10 * time.Second)
+	}
+
+	events := limiter.(limit.EventLister)
+	assert.Equal(t, []time.Time{start, start.Add(10 * time.Second), start.Add(20 * time.Second)}, events.Events(10))
+	assert.Equal(t, []time.Time{start, start.Add(10 * time.Second)}, events.Events(2))
+	assert.Empty(t, events.Events(0))
+
+	// Expired events are no longer counted against the limit
+	clock.Advance(1 * time.Second)
+	assert.Equal(t, []time.Time{start.Add(10 * time.Second), start.Add(20 * time.Second)}, events.Events(10))
+}
+
+func TestRollingWindow_PendingReservationAges_CountsAtReserve(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Now())
+	limiter := limit.NewRollingWindow(10, 1*time.Minute, limit.WithClock(clock), limit.WithReservationMode(limit.ReservationCountsAtReserve))
+
+	first := limiter.Reserve(nil)
+	clock.Advance(5 * time.Second)
+	_ = limiter.Reserve(nil)
+	assert.Equal(t, []time.Duration{5 * time.Second, 0}, limiter.PendingReservationAges(10))
+
+	// A consumed reservation stays in the window but is no longer pending
+	assert.NoError(t, first.Consume())
+	assert.Equal(t, []time.Duration{0}, limiter.PendingReservationAges(10))
+}
+
+func TestRollingWindow_SetLimit(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	limiter := limit.NewRollingWindow(10, 1*time.Minute, limit.WithClock(clock))
+	assert.True(t, limiter.AllowN(4))
+
+	// A lower count keeps the recorded events, so nothing is admitted until they age out
+	limiter.(limit.LimitSetter).SetLimit(3, 1*time.Minute)
+	assert.False(t, limiter.Allowed())
+	assert.Equal(t, limit.Config{Algorithm: limit.AlgorithmRollingWindow, Count: 3, Per: 1 * time.Minute}, limiter.Config())
+
+	// Waiters sleeping until the events age out of the minute are woken up by a shorter window
+	clock.Advance(2 * time.Second)
+	waited := make(chan error)
+	for range 3 {
+		go func() {
+			waited <- limiter.WaitContext(context.Background())
+		}()
+	}
+	clock.BlockUntil(3)
+	start := clock.Now()
+	limiter.(limit.LimitSetter).SetLimit(3, 1*time.Second)
+	for range 3 {
+		assert.NoError(t, <-waited)
+	}
+	assert.Equal(t, start, clock.Now())
+	assert.Equal(t, 4, limiter.Stats().AllowedRequests)
+
+	assert.Panics(t, func() { limiter.(limit.LimitSetter).SetLimit(3, 0) })
+}
@@ -0,0 +1,100 @@
+package limit
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Route limits the requests matching Pattern with Limiter. Patterns follow net/http's ServeMux, e.g. "POST /search",
+// "/admin/" or "/items/{id}", and the most specific one wins, so "/" makes a default route.
+type Route struct {
+	// Name identifies the route to the handlers, see RouteName. Pattern if empty.
+	Name    string
+	Pattern string
+	Limiter Limiter
+	// Bypass lets the matching requests through without limiting them, e.g. for health checks.
+	Bypass bool
+}
+
+// Routes configures the limiter of each route of an HTTP server, see Middleware.
+type Routes []Route
+
+// routeKey is the context key of the route a request matched.
+type routeKey struct{}
+
+// RouteName returns the name of the route the request with ctx matched, empty if it matched none.
+func RouteName(ctx context.Context) string {
+	name, _ := ctx.Value(routeKey{}).(string)
+	return name
+}
+
+// Middleware returns HTTP middleware limiting each request with the limiter of the route it matches. Requests the
+// limiter doesn't allow get 429 Too Many Requests with a Retry-After header. Requests matching no route, a Bypass one
+// or one without a Limiter aren't limited. The name of the matched route is available to the next handler through RouteName,
+// and the AdmitReport of the request through ReportFromContext if the limiter implements Reporter.
+// It panics if the patterns are invalid or conflict, like ServeMux.Handle. It accepts WithAdmissionHook and
+// WithLimiterInContext.
+func Middleware(routes Routes, opts ...Option) func(http.Handler) http.Handler {
+	o := newOptions(opts)
+
+	// A ServeMux does the matching so patterns behave exactly as in net/http, its handlers are never called
+	mux := http.NewServeMux()
+	byPattern := make(map[string]Route, len(routes))
+	for _, route := range routes {
+		if route.Name == "" {
+			route.Name = route.Pattern
+		}
+		mux.Handle(route.Pattern, http.NotFoundHandler())
+		byPattern[route.Pattern] = route
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, pattern := mux.Handler(r)
+			route, ok := byPattern[pattern]
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			r = r.WithContext(context.WithValue(r.Context(), routeKey{}, route.Name))
+			if route.Bypass || route.Limiter == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if o.limiterInContext {
+				r = r.WithContext(NewKeyContext(NewContext(r.Context(), route.Limiter), route.Name))
+			}
+
+			var allowed bool
+			if reporter, ok := route.Limiter.(Reporter); ok {
+				var report AdmitReport
+				report, allowed = reporter.AllowedReport()
+				if allowed {
+					r = r.WithContext(ContextWithReport(r.Context(), report))
+				}
+			} else {
+				allowed = route.Limiter.Allowed()
+			}
+			if o.admissionHook != nil {
+				o.admissionHook(r.Context(), Admission{Key: route.Name, Limiter: route.Limiter, Limited: !allowed})
+			}
+			if allowed {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("Retry-After", retryAfterSeconds(route.Limiter.Stats().NextAllowedTime))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		})
+	}
+}
+
+// retryAfterSeconds formats the whole seconds until next, rounded up and never less than one.
+func retryAfterSeconds(next time.Time) string {
+	seconds := int(math.Ceil(time.Until(next).Seconds()))
+	return strconv.Itoa(max(seconds, 1))
+}
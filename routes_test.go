@@ -0,0 +1,109 @@
+package limit_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware_Routes(t *testing.T) {
+	t.Parallel()
+
+	search := limit.NewTokenBucket(1, 1*time.Hour)
+	admin := limit.NewTokenBucket(1, 1*time.Hour)
+	adminUsers := limit.NewTokenBucket(1, 1*time.Hour)
+	fallback := limit.NewTokenBucket(1, 1*time.Hour)
+	handler := limit.Middleware(limit.Routes{
+		{Name: "search", Pattern: "POST /search", Limiter: search},
+		{Pattern: "/admin/", Limiter: admin},
+		{Pattern: "/admin/users/", Limiter: adminUsers},
+		{Pattern: "/healthz", Bypass: true},
+		{Name: "default", Pattern: "/", Limiter: fallback},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(limit.RouteName(r.Context())))
+	}))
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+
+	for _, tc := range []struct {
+		method, path string
+		route        string
+	}{
+		{"POST", "/search", "search"},
+		// The method-specific route doesn't apply to GET, the default does
+		{"GET", "/search", "default"},
+		// The longest prefix wins
+		{"GET", "/admin/users/42", "/admin/users/"},
+		{"GET", "/admin/settings", "/admin/"},
+		{"GET", "/healthz", "/healthz"},
+	} {
+		rec := serve(tc.method, tc.path)
+		assert.Equal(t, http.StatusOK, rec.Code, "%s %s", tc.method, tc.path)
+		assert.Equal(t, tc.route, rec.Body.String(), "%s %s", tc.method, tc.path)
+	}
+
+	// Each route used up its own limiter
+	for _, path := range []string{"/search", "/admin/users/1", "/admin/x", "/other"} {
+		method := "GET"
+		if path == "/search" {
+			method = "POST"
+		}
+		rec := serve(method, path)
+		assert.Equal(t, http.StatusTooManyRequests, rec.Code, path)
+		assert.NotEmpty(t, rec.Header().Get("Retry-After"), path)
+	}
+
+	// The bypass route is never limited
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, serve("GET", "/healthz").Code)
+	}
+}
+
+func TestMiddleware_NoMatchIsUnlimited(t *testing.T) {
+	t.Parallel()
+
+	limiter := limit.NewTokenBucket(1, 1*time.Hour)
+	handler := limit.Middleware(limit.Routes{{Pattern: "GET /api/", Limiter: limiter}})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, limit.RouteName(r.Context()))
+	}))
+
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/static/app.js", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+	assert.Equal(t, 0, limiter.Stats().AllowedRequests)
+}
+
+func TestMiddleware_AdmissionHook(t *testing.T) {
+	t.Parallel()
+
+	limiter := limit.NewTokenBucket(1, 1*time.Hour)
+	var admissions []limit.Admission
+	handler := limit.Middleware(limit.Routes{
+		{Name: "search", Pattern: "/search", Limiter: limiter},
+		{Pattern: "/healthz", Bypass: true},
+	}, limit.WithAdmissionHook(func(ctx context.Context, admission limit.Admission) {
+		assert.Equal(t, "search", limit.RouteName(ctx))
+		admissions = append(admissions, admission)
+	}))(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	for _, path := range []string{"/search", "/search", "/healthz"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	// Bypassed requests aren't reported
+	assert.Equal(t, []limit.Admission{
+		{Key: "search", Limiter: limiter},
+		{Key: "search", Limiter: limiter, Limited: true},
+	}, admissions)
+}
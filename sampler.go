@@ -0,0 +1,145 @@
+package limit
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+// sampledKey is the state a DenialSampler keeps for one key.
+type sampledKey struct {
+	// Admits the denials logged in full
+	logged Limiter
+	// The denials not logged since the interval started, by reason
+	suppressed map[Reason]int
+	since      time.Time
+	lastDenial time.Time
+}
+
+// DenialSampler logs denials without flooding the logs when a client goes haywire: it logs the first denials of each
+// key per interval in full, and then a summary line per interval with the denials it suppressed.
+type DenialSampler struct {
+	// Mutex
+	mux sync.Mutex
+
+	// Config
+	logger   *slog.Logger
+	clock    Clock
+	first    int
+	interval time.Duration
+
+	// State
+	keys map[string]*sampledKey
+}
+
+// NewDenialSampler returns a DenialSampler logging to logger the first denials of each key in any interval in full.
+// It accepts WithClock, and panics if first or interval isn't positive.
+func NewDenialSampler(logger *slog.Logger, first int, interval time.Duration, opts ...Option) *DenialSampler {
+	if err := validateRate(first, interval, false); err != nil {
+		panic(fmt.Sprintf("limit: NewDenialSampler: %v", err))
+	}
+
+	o := newOptions(opts)
+	return &DenialSampler{
+		logger:   logger,
+		clock:    o.clock,
+		first:    first,
+		interval: interval,
+		keys:     make(map[string]*sampledKey),
+	}
+}
+
+// Denied records a denial of key, logging it in full unless the key already had its share of full logs in the last
+// interval. It also logs the summaries of the intervals that ended, see Flush.
+func (s *DenialSampler) Denied(ctx context.Context, key string, reason Reason) {
+	s.mux.Lock()
+	now := s.clock.Now()
+	summaries := s.summarizeLocked(now)
+
+	k, ok := s.keys[key]
+	if !ok {
+		// A rolling window so that no interval, wherever it starts, has more than first full logs
+		k = &sampledKey{
+			logged:     NewRollingWindow(s.first, s.interval, WithClock(s.clock)),
+			suppressed: make(map[Reason]int),
+			since:      now,
+		}
+		s.keys[key] = k
+	}
+	k.lastDenial = now
+	full := k.logged.Allowed()
+	if !full {
+		k.suppressed[reason]++
+	}
+	s.mux.Unlock()
+
+	// Log without holding the lock, handlers may be slow
+	s.log(ctx, summaries)
+	if full {
+		s.logger.LogAttrs(ctx, slog.LevelWarn, "request denied by limiter", slog.String("key", key), slog.String("reason", string(reason)))
+	}
+}
+
+// Flush logs the summaries of the intervals that ended. Denied does it as well, so a periodic Flush is only needed for
+// the summaries of keys that stopped being denied to be logged on time.
+func (s *DenialSampler) Flush(ctx context.Context) {
+	s.mux.Lock()
+	summaries := s.summarizeLocked(s.clock.Now())
+	s.mux.Unlock()
+	s.log(ctx, summaries)
+}
+
+// summary is the line logged for the denials of a key suppressed in an interval.
+type summary struct {
+	key        string
+	suppressed map[Reason]int
+}
+
+// summarizeLocked collects the summaries of the keys whose interval ended and starts their next interval. Keys with
+// nothing to summarize and no denial in the last interval are forgotten, their full logs already left the window.
+func (s *DenialSampler) summarizeLocked(now time.Time) []summary {
+	// This must be called with the mutex already locked
+	var summaries []summary
+	for key, k := range s.keys {
+		if now.Sub(k.since) < s.interval {
+			continue
+		}
+
+		if len(k.suppressed) > 0 {
+			summaries = append(summaries, summary{key: key, suppressed: k.suppressed})
+			k.suppressed = make(map[Reason]int)
+		} else if now.Sub(k.lastDenial) >= s.interval {
+			delete(s.keys, key)
+			continue
+		}
+		k.since = now
+	}
+	slices.SortFunc(summaries, func(a, b summary) int {
+		return cmp.Compare(a.key, b.key)
+	})
+	return summaries
+}
+
+func (s *DenialSampler) log(ctx context.Context, summaries []summary) {
+	for _, sum := range summaries {
+		total := 0
+		reasons := make([]slog.Attr, 0, len(sum.suppressed))
+		for reason, n := range sum.suppressed {
+			total += n
+			reasons = append(reasons, slog.Int(string(reason), n))
+		}
+		slices.SortFunc(reasons, func(a, b slog.Attr) int {
+			return cmp.Compare(a.Key, b.Key)
+		})
+
+		s.logger.LogAttrs(ctx, slog.LevelWarn, "denials suppressed",
+			slog.String("key", sum.key),
+			slog.Int("suppressed", total),
+			slog.Attr{Key: "reasons", Value: slog.GroupValue(reasons...)},
+		)
+	}
+}
@@ -0,0 +1,130 @@
+package limit_test
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limittest"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingHandler keeps the records logged through it.
+type recordingHandler struct {
+	mux     sync.Mutex
+	records []slog.Record
+}
+
+func (h *recordingHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *recordingHandler) Handle(_ context.Context, r slog.Record) error {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.records = append(h.records, r.Clone())
+	return nil
+}
+
+func (h *recordingHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+
+func (h *recordingHandler) WithGroup(string) slog.Handler { return h }
+
+// take returns the records logged since the last call.
+func (h *recordingHandler) take() []slog.Record {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	records := h.records
+	h.records = nil
+	return records
+}
+
+func attrs(r slog.Record) map[string]any {
+	attrs := make(map[string]any)
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Value.Kind() == slog.KindGroup {
+			group := make(map[string]any)
+			for _, g := range a.Value.Group() {
+				group[g.Key] = g.Value.Any()
+			}
+			attrs[a.Key] = group
+		} else {
+			attrs[a.Key] = a.Value.Any()
+		}
+		return true
+	})
+	return attrs
+}
+
+func TestDenialSampler_Storm(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Now())
+	handler := &recordingHandler{}
+	sampler := limit.NewDenialSampler(slog.New(handler), 5, 1*time.Minute, limit.WithClock(clock))
+	ctx := context.Background()
+
+	// A storm of 100 denials of one key and a few of another over 30 seconds
+	for i := 0; i < 100; i++ {
+		reason := limit.ReasonLimited
+		if i%5 >= 3 {
+			reason = limit.ReasonContext
+		}
+		sampler.Denied(ctx, "haywire", reason)
+		if i < 10 {
+			sampler.Denied(ctx, "other", limit.ReasonLimited)
+		}
+		clock.Advance(300 * time.Millisecond)
+	}
+
+	full := handler.take()
+	assert.Len(t, full, 10)
+	assert.Equal(t, map[string]any{"key": "haywire", "reason": "limited"}, attrs(full[0]))
+
+	clock.Advance(30 * time.Second)
+	sampler.Flush(ctx)
+	summaries := handler.take()
+	if assert.Len(t, summaries, 2) {
+		assert.Equal(t, "denials suppressed", summaries[0].Message)
+		assert.Equal(t, map[string]any{
+			"key":        "haywire",
+			"suppressed": int64(95),
+			"reasons":    map[string]any{"context": int64(38), "limited": int64(57)},
+		}, attrs(summaries[0]))
+		assert.Equal(t, map[string]any{
+			"key":        "other",
+			"suppressed": int64(5),
+			"reasons":    map[string]any{"limited": int64(5)},
+		}, attrs(summaries[1]))
+	}
+
+	// A quiet interval has nothing to summarize, and then the key starts over
+	clock.Advance(1 * time.Minute)
+	sampler.Flush(ctx)
+	assert.Empty(t, handler.take())
+	sampler.Denied(ctx, "haywire", limit.ReasonLimited)
+	assert.Len(t, handler.take(), 1)
+}
+
+func TestDenialSampler_SummaryOnNextDenial(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Now())
+	handler := &recordingHandler{}
+	sampler := limit.NewDenialSampler(slog.New(handler), 1, 1*time.Second, limit.WithClock(clock))
+	ctx := context.Background()
+
+	sampler.Denied(ctx, "key", limit.ReasonLimited)
+	sampler.Denied(ctx, "key", limit.ReasonLimited)
+	assert.Len(t, handler.take(), 1)
+
+	// The summary of the previous interval comes before the full log of the new one
+	clock.Advance(1*time.Second + 1*time.Millisecond)
+	sampler.Denied(ctx, "key", limit.ReasonQueueFull)
+	records := handler.take()
+	if assert.Len(t, records, 2) {
+		assert.Equal(t, "denials suppressed", records[0].Message)
+		assert.Equal(t, map[string]any{"key": "key", "reason": "queue_full"}, attrs(records[1]))
+	}
+}
@@ -0,0 +1,493 @@
+package limit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+var _ Limiter = (*slidingWindowCounter)(nil)
+
+// slidingWindowCounter approximates a rolling window with the counts of two fixed windows, the current one and the
+// previous one, weighting the previous count by how much of it the rolling window still overlaps.
+type slidingWindowCounter struct {
+	base
+
+	// Config
+	count    int
+	duration time.Duration
+
+	// State
+	windowStart time.Time // When the current fixed window started
+	current     int
+	previous    int
+
+	// Reservations tracking
+	pendingReservations map[*slidingWindowCounterReservation]struct{}
+}
+
+// NewSlidingWindowCounter creates a limiter allowing count events in any window of duration, approximately: it only
+// keeps the counts of the current and previous fixed windows, so its memory doesn't grow with the rate like the
+// rolling window's. The previous count is weighted by how much of it the rolling window overlaps, assuming its events
+// were spread evenly, so a burst at the end of a window can let up to twice the count through in the worst case.
+// It panics if count or duration isn't positive.
+func NewSlidingWindowCounter(count int, duration time.Duration, opts ...Option) Limiter {
+	if err := validateRate(count, duration, false); err != nil {
+		panic(fmt.Sprintf("limit: NewSlidingWindowCounter: %v", err))
+	}
+
+	o := newOptions(opts)
+	s := &slidingWindowCounter{
+		count:               count,
+		duration:            duration,
+		windowStart:         o.clock.Now(),
+		pendingReservations: make(map[*slidingWindowCounterReservation]struct{}),
+	}
+	s.init(o)
+	s.remaining = func() int { return s.roomLocked() }
+	s.nextAllowed = func() time.Time { return s.nextAllowedTime(1) }
+	return s
+}
+
+func (s *slidingWindowCounter) WaitContext(ctx context.Context) error {
+	return s.WaitNContext(ctx, 1)
+}
+
+func (s *slidingWindowCounter) WaitN(n int) error {
+	return s.WaitNContext(context.Background(), n)
+}
+
+func (s *slidingWindowCounter) WaitNTimeout(timeout time.Duration, n int) error {
+	ctx, cancel := withTimeout(s.clock, timeout)
+	defer cancel()
+	return s.WaitNContext(ctx, n)
+}
+
+func (s *slidingWindowCounter) WaitNContext(ctx context.Context, n int) error {
+	if n <= 0 {
+		return nil
+	}
+
+	return s.await(ctx, func() (bool, time.Duration, error) {
+		if err := s.costErrLocked(n); err != nil {
+			return false, 0, err
+		}
+
+		ok, retryIn := s.tryRecordLocked(n)
+		return ok, retryIn, nil
+	}, nil)
+}
+
+// costErrLocked denies n events and returns an error if they exceed the window limit, nil otherwise.
+func (s *slidingWindowCounter) costErrLocked(n int) error {
+	// This must be called with the mutex already locked
+	if n > s.count {
+		s.deny(ReasonLimited)
+		return fmt.Errorf("cost %d exceeds the window limit of %d", n, s.count)
+	}
+	return nil
+}
+
+func (s *slidingWindowCounter) Wait() {
+	_ = s.WaitContext(context.Background())
+}
+
+func (s *slidingWindowCounter) WaitTimeout(timeout time.Duration) error {
+	ctx, cancel := withTimeout(s.clock, timeout)
+	defer cancel()
+	return s.WaitContext(ctx)
+}
+
+func (s *slidingWindowCounter) AllowedReport() (AdmitReport, bool) {
+	return s.allowReport(func() bool { return s.allowLocked(1) })
+}
+
+func (s *slidingWindowCounter) WaitContextReport(ctx context.Context) (AdmitReport, error) {
+	return waitReport(ctx, s.WaitContext)
+}
+
+func (s *slidingWindowCounter) Allowed() bool {
+	return s.AllowN(1)
+}
+
+func (s *slidingWindowCounter) AllowN(n int) bool {
+	if n <= 0 {
+		return true
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.allowLocked(n)
+}
+
+func (s *slidingWindowCounter) allowLocked(n int) bool {
+	// This must be called with the mutex already locked
+	if s.denyHalted() {
+		return false
+	}
+	if ok, _ := s.tryRecordLocked(n); ok {
+		return true
+	}
+
+	s.deny(s.limitedReason())
+	return false
+}
+
+// tryRecordLocked counts n events in the current window if the estimate has room for them, otherwise it returns how
+// long until it's worth trying again.
+func (s *slidingWindowCounter) tryRecordLocked(n int) (bool, time.Duration) {
+	// This must be called with the mutex already locked
+	if !s.availableLocked(n) || !s.closedUntil().IsZero() {
+		return false, s.retryIn(s.nextAllowedTime(n), s.duration)
+	}
+
+	s.current += n
+	s.countAllowed()
+	return true, 0
+}
+
+// tryReserveLocked reserves n events if the estimate has room for them, otherwise it returns how long until it's worth
+// trying again.
+func (s *slidingWindowCounter) tryReserveLocked(ctx context.Context, n int, reservationTTL *time.Duration) (*slidingWindowCounterReservation, time.Duration) {
+	// This must be called with the mutex already locked
+	if !s.availableLocked(n) || !s.closedUntil().IsZero() {
+		return nil, s.retryIn(s.nextAllowedTime(n), s.duration)
+	}
+
+	reservation := &slidingWindowCounterReservation{
+		limiter:    s,
+		n:          n,
+		reservedAt: s.clock.Now(),
+		expiresAt:  s.expiryFor(ctx, reservationTTL),
+	}
+	s.pendingReservations[reservation] = struct{}{}
+	s.watchAbandoned(reservation.reservedAt, func() bool {
+		return pendingAt(s.clock.Now(), reservation.consumed, reservation.canceled, reservation.expiresAt)
+	})
+	return reservation, 0
+}
+
+// availableLocked rolls the windows over and drops expired reservations, and reports whether the estimate has room
+// for n more events net of pending reservations.
+func (s *slidingWindowCounter) availableLocked(n int) bool {
+	// This must be called with the mutex already locked
+	s.roll()
+	s.cleanupExpiredReservations()
+	return s.estimate(s.clock.Now())+float64(reservedUnits(s.pendingReservations)+n) <= float64(s.count)
+}
+
+// roomLocked returns how many more events the estimate has room for net of pending reservations.
+func (s *slidingWindowCounter) roomLocked() int {
+	// This must be called with the mutex already locked
+	return int(math.Floor(float64(s.count) - s.estimate(s.clock.Now()) - float64(reservedUnits(s.pendingReservations))))
+}
+
+// estimate returns the events the rolling window ending at now would hold: those of the current fixed window, and the
+// previous window's weighted by the part of it the rolling window overlaps.
+func (s *slidingWindowCounter) estimate(now time.Time) float64 {
+	// This must be called with the mutex already locked
+	overlap := 1 - float64(now.Sub(s.windowStart))/float64(s.duration)
+	return float64(s.previous)*max(overlap, 0) + float64(s.current)
+}
+
+// roll moves on to the fixed window now falls in, the current count becoming the previous one if it's the next window.
+func (s *slidingWindowCounter) roll() {
+	// This must be called with the mutex already locked
+	now := s.clock.Now()
+	elapsed := now.Sub(s.windowStart)
+	if elapsed < 0 {
+		// The wall clock stepped backwards, start the current window from now instead of waiting for it to catch up
+		s.clockStepped(-elapsed)
+		s.windowStart = now
+		return
+	}
+
+	windows := elapsed / s.duration
+	switch windows {
+	case 0:
+		return
+	case 1:
+		s.previous = s.current
+	default:
+		s.previous = 0
+	}
+	s.current = 0
+	s.windowStart = s.windowStart.Add(windows * s.duration)
+}
+
+func (s *slidingWindowCounter) cleanupExpiredReservations() {
+	// This must be called with the mutex already locked
+	now := s.clock.Now()
+	for res := range s.pendingReservations {
+		if res.expiresAt != nil && now.After(*res.expiresAt) {
+			delete(s.pendingReservations, res)
+		}
+	}
+}
+
+// nextAllowedTime returns when the estimate has room for n more events net of pending reservations, as the weight of
+// the previous window goes down. It returns the zero time if only consuming or canceling reservations can make room.
+func (s *slidingWindowCounter) nextAllowedTime(n int) time.Time {
+	// This must be called with the mutex already locked
+	now := s.clock.Now()
+	needed := reservedUnits(s.pendingReservations) + n
+	if s.estimate(now)+float64(needed) <= float64(s.count) {
+		return now
+	}
+	if needed > s.count {
+		return time.Time{}
+	}
+
+	start, previous, room := s.windowStart, s.previous, s.count-s.current-needed
+	if room < 0 {
+		// The current window alone is too full, wait until it's the previous one
+		start, previous, room = start.Add(s.duration), s.current, s.count-needed
+	}
+	overlap := float64(room) / float64(previous)
+	return start.Add(time.Duration(math.Ceil((1 - overlap) * float64(s.duration))))
+}
+
+func (s *slidingWindowCounter) Clear() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.cancelReservationsLocked()
+	s.current, s.previous = 0, 0
+	s.waiters.notify()
+}
+
+func (s *slidingWindowCounter) Close() error {
+	return s.close(s.cancelReservationsLocked)
+}
+
+// cancelReservationsLocked cancels the pending reservations.
+func (s *slidingWindowCounter) cancelReservationsLocked() {
+	// This must be called with the mutex already locked
+	for res := range s.pendingReservations {
+		res.canceled = true
+	}
+
+	s.pendingReservations = make(map[*slidingWindowCounterReservation]struct{})
+}
+
+func (s *slidingWindowCounter) Stats() Stats {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.roll()
+	s.cleanupExpiredReservations()
+
+	stats := s.stats()
+	stats.PendingReservations = len(s.pendingReservations)
+	return stats
+}
+
+// Info reports the events allowed per window as the limit, with Reset being when both fixed windows counted in the
+// estimate are over.
+func (s *slidingWindowCounter) Info() LimitInfo {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.roll()
+	s.cleanupExpiredReservations()
+
+	reset := s.clock.Now()
+	switch {
+	case s.current > 0:
+		reset = s.windowStart.Add(2 * s.duration)
+	case s.previous > 0:
+		reset = s.windowStart.Add(s.duration)
+	}
+	return s.info(s.count, s.roomLocked(), reset, s.duration)
+}
+
+// Available returns how many more events the estimate has room for net of pending reservations, without taking any.
+func (s *slidingWindowCounter) Available() int {
+	return s.Info().Remaining
+}
+
+func (s *slidingWindowCounter) EstimatedWait() time.Duration {
+	return s.waitUntil(s.Stats().NextAllowedTime)
+}
+
+func (s *slidingWindowCounter) Config() Config {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return Config{Algorithm: AlgorithmSlidingWindowCounter, Count: s.count, Per: s.duration}
+}
+
+// Limit returns the events allowed per window.
+func (s *slidingWindowCounter) Limit() Rate {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return Rate{Count: s.count, Per: s.duration}
+}
+
+// Burst returns the events allowed at once, the whole window.
+func (s *slidingWindowCounter) Burst() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.count
+}
+
+func (s *slidingWindowCounter) PendingReservationAges(n int) []time.Duration {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.cleanupExpiredReservations()
+
+	reservedAt := make([]time.Time, 0, len(s.pendingReservations))
+	for res := range s.pendingReservations {
+		reservedAt = append(reservedAt, res.reservedAt)
+	}
+	return s.reservationAges(reservedAt, n)
+}
+
+func (s *slidingWindowCounter) TryReserve(reservationTTL *time.Duration) (Reservation, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if reservation, _ := s.tryReserveLocked(context.Background(), 1, reservationTTL); reservation != nil {
+		return reservation, true
+	}
+
+	s.deny(s.limitedReason())
+	return nil, false
+}
+
+func (s *slidingWindowCounter) Reserve(reservationTTL *time.Duration) Reservation {
+	reservation, err := s.ReserveContext(context.Background(), reservationTTL)
+	if err != nil {
+		return failedReservation{err: err}
+	}
+	return reservation
+}
+
+func (s *slidingWindowCounter) ReserveTimeout(timeout time.Duration, reservationTTL *time.Duration) (Reservation, error) {
+	ctx, cancel := withTimeout(s.clock, timeout)
+	defer cancel()
+	return s.ReserveContext(ctx, reservationTTL)
+}
+
+func (s *slidingWindowCounter) Permits(ctx context.Context) <-chan struct{} {
+	return deliverPermits(ctx, func(ctx context.Context) (permit, error) {
+		return reservePermit(ctx, s)
+	})
+}
+
+func (s *slidingWindowCounter) ReserveContext(ctx context.Context, reservationTTL *time.Duration) (Reservation, error) {
+	return s.ReserveN(ctx, 1, reservationTTL)
+}
+
+func (s *slidingWindowCounter) ReserveN(ctx context.Context, n int, reservationTTL *time.Duration) (Reservation, error) {
+	if err := reserveCostErr(n); err != nil {
+		return nil, err
+	}
+
+	var reservation *slidingWindowCounterReservation
+	err := s.awaitReservation(ctx, func() (bool, time.Duration, error) {
+		if err := s.costErrLocked(n); err != nil {
+			return false, 0, err
+		}
+
+		var retryIn time.Duration
+		reservation, retryIn = s.tryReserveLocked(ctx, n, reservationTTL)
+		return reservation != nil, retryIn, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.link(ctx, reservation)
+	return reservation, nil
+}
+
+// slidingWindowCounterReservation implements the Reservation interface
+type slidingWindowCounterReservation struct {
+	limiter    *slidingWindowCounter
+	n          int // Events held
+	reservedAt time.Time
+	expiresAt  *time.Time
+	consumed   bool
+	canceled   bool
+}
+
+// Consume counts the events in the fixed window it's consumed in.
+func (r *slidingWindowCounterReservation) Consume() error {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	if r.consumed {
+		return ErrReservationConsumed
+	}
+
+	if r.canceled {
+		return ErrReservationCanceled
+	}
+
+	if r.expiresAt != nil && r.limiter.clock.Now().After(*r.expiresAt) {
+		delete(r.limiter.pendingReservations, r)
+		return ErrReservationExpired
+	}
+
+	r.consumed = true
+	delete(r.limiter.pendingReservations, r)
+	r.limiter.roll()
+	r.limiter.current += r.n
+	r.limiter.countAllowed()
+
+	return nil
+}
+
+// ConsumeContext is Consume, which never blocks.
+func (r *slidingWindowCounterReservation) ConsumeContext(context.Context) error {
+	return r.Consume()
+}
+
+func (r *slidingWindowCounterReservation) units() int {
+	return r.n
+}
+
+func (r *slidingWindowCounterReservation) Cancel() {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	if !r.consumed {
+		r.canceled = true
+		delete(r.limiter.pendingReservations, r)
+		r.limiter.waiters.notify()
+	}
+}
+
+func (r *slidingWindowCounterReservation) Detach() (ReservationHandle, error) {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	state := func() error {
+		return reservationErr(r.limiter.clock.Now(), r.consumed, r.canceled, r.expiresAt)
+	}
+	if err := state(); err != nil {
+		return ReservationHandle{}, err
+	}
+	return r.limiter.detach(r, r.expiresAt, state), nil
+}
+
+// ReadyAt is now, Consume never blocks.
+func (r *slidingWindowCounterReservation) ReadyAt() time.Time {
+	return r.limiter.clock.Now()
+}
+
+func (r *slidingWindowCounterReservation) Delay() time.Duration {
+	return 0
+}
+
+func (r *slidingWindowCounterReservation) ExpiresAt() (time.Time, bool) {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	return expiry(r.expiresAt)
+}
+
+func (r *slidingWindowCounterReservation) State() ReservationState {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	return reservationState(r.limiter.clock.Now(), r.consumed, r.canceled, r.expiresAt)
+}
@@ -0,0 +1,119 @@
+package limit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limittest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlidingWindowCounter_Allow(t *testing.T) {
+	t.Parallel()
+
+	// 5 requests per second
+	limiter := limit.NewSlidingWindowCounter(5, 1*time.Second)
+
+	// 5 requests should be allowed
+	for i := 0; i < 5; i++ {
+		assert.True(t, limiter.Allowed())
+	}
+
+	// Extra request should be denied
+	assert.False(t, limiter.Allowed())
+	assert.Equal(t, limit.Config{Algorithm: limit.AlgorithmSlidingWindowCounter, Count: 5, Per: 1 * time.Second}, limiter.Config())
+}
+
+func TestSlidingWindowCounter_WeightsPreviousWindow(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	limiter := limit.NewSlidingWindowCounter(10, 1*time.Second, limit.WithClock(clock))
+	assert.True(t, limiter.AllowN(10))
+
+	// The previous window counts in full right after it ended
+	clock.Advance(1 * time.Second)
+	assert.False(t, limiter.Allowed())
+
+	// Halfway through the window, it counts for half
+	clock.Advance(500 * time.Millisecond)
+	assert.Equal(t, 5, limiter.Available())
+	assert.True(t, limiter.AllowN(5))
+	assert.False(t, limiter.Allowed())
+
+	// Once it's two windows behind, it doesn't count anymore
+	clock.Advance(1500 * time.Millisecond)
+	assert.Equal(t, 10, limiter.Available())
+}
+
+func TestSlidingWindowCounter_BurstStraddlingBoundary(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	limiter := limit.NewSlidingWindowCounter(100, 1*time.Minute, limit.WithClock(clock))
+
+	// A burst from 1s before the end of the first window to 1s after it
+	clock.Advance(59 * time.Second)
+	allowed := 0
+	for range 200 {
+		for limiter.Allowed() {
+			allowed++
+		}
+		clock.Advance(10 * time.Millisecond)
+	}
+
+	// A fixed window would have allowed 200, the estimate only lets through what the 1s past the boundary allows
+	epsilon := float64(1*time.Second) / float64(1*time.Minute)
+	assert.LessOrEqual(t, float64(allowed), 100*(1+epsilon))
+	assert.GreaterOrEqual(t, allowed, 100)
+}
+
+func TestSlidingWindowCounter_Wait(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	limiter := limit.NewSlidingWindowCounter(2, 1*time.Second, limit.WithClock(clock))
+	limiter.Wait()
+	limiter.Wait()
+
+	// The next request fits once half of the next window went by and the previous one counts for 1
+	assert.Equal(t, 1500*time.Millisecond, limiter.EstimatedWait())
+	waited := make(chan error)
+	go func() {
+		waited <- limiter.WaitContext(context.Background())
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(1500 * time.Millisecond)
+	assert.NoError(t, <-waited)
+	assert.Equal(t, 3, limiter.Stats().AllowedRequests)
+}
+
+func TestSlidingWindowCounter_Reservations(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	limiter := limit.NewSlidingWindowCounter(2, 1*time.Second, limit.WithClock(clock))
+
+	ttl := 100 * time.Millisecond
+	expiring := limiter.Reserve(&ttl)
+	held := limiter.Reserve(nil)
+	assert.False(t, limiter.Allowed())
+	assert.Equal(t, 2, limiter.Stats().PendingReservations)
+
+	// An expired reservation frees its room
+	clock.Advance(ttl + time.Millisecond)
+	assert.ErrorIs(t, expiring.Consume(), limit.ErrReservationExpired)
+	assert.True(t, limiter.Allowed())
+
+	// A canceled one too
+	held.Cancel()
+	reservation, ok := limiter.TryReserve(nil)
+	assert.True(t, ok)
+
+	// A consumed one counts in the window it was consumed in
+	assert.NoError(t, reservation.Consume())
+	assert.Equal(t, 0, limiter.Available())
+	assert.Equal(t, 0, limiter.Stats().PendingReservations)
+}
@@ -0,0 +1,89 @@
+package limit
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// statsTimeFormat is RFC 3339 with millisecond precision, how Stats encodes NextAllowedTime.
+const statsTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// jsonStats is Stats without its methods, to encode and decode the fields other than NextAllowedTime as usual.
+type jsonStats Stats
+
+// MarshalJSON encodes NextAllowedTime in UTC as RFC 3339 with millisecond precision, leaving it out if it's zero.
+func (s Stats) MarshalJSON() ([]byte, error) {
+	var next string
+	if !s.NextAllowedTime.IsZero() {
+		next = s.NextAllowedTime.UTC().Format(statsTimeFormat)
+	}
+	return json.Marshal(struct {
+		jsonStats
+		NextAllowedTime string `json:"next_allowed_time,omitempty"`
+	}{jsonStats(s), next})
+}
+
+// UnmarshalJSON decodes stats encoded by MarshalJSON, with NextAllowedTime in UTC to the millisecond.
+func (s *Stats) UnmarshalJSON(data []byte) error {
+	decoded := struct {
+		*jsonStats
+		NextAllowedTime string `json:"next_allowed_time,omitempty"`
+	}{jsonStats: (*jsonStats)(s)}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	s.NextAllowedTime = time.Time{}
+	if decoded.NextAllowedTime != "" {
+		next, err := time.Parse(time.RFC3339Nano, decoded.NextAllowedTime)
+		if err != nil {
+			return fmt.Errorf("invalid next_allowed_time: %w", err)
+		}
+		s.NextAllowedTime = next
+	}
+	return nil
+}
+
+// String returns the stats on a line for logs, e.g. "allowed=120 denied=4 remaining=6 pending=0 queue=0
+// next=2024-05-01T10:00:00.250Z", with "paused" at the end while the limiter is paused.
+func (s Stats) String() string {
+	next := "none"
+	if !s.NextAllowedTime.IsZero() {
+		next = s.NextAllowedTime.UTC().Format(statsTimeFormat)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "allowed=%d denied=%d remaining=%d pending=%d queue=%d next=%s",
+		s.AllowedRequests, s.DeniedRequests, s.Remaining, s.PendingReservations, s.QueueDepth, next)
+	if s.Paused {
+		b.WriteString(" paused")
+	}
+	return b.String()
+}
+
+// addStats adds the counters of other to stats, for limiters combining the stats of several. The fields describing the
+// state of a single limiter, like NextAllowedTime and Remaining, are left as they are.
+func addStats(stats *Stats, other Stats) {
+	stats.AllowedRequests += other.AllowedRequests
+	stats.DeniedRequests += other.DeniedRequests
+	stats.DeniedByReason = maps.Clone(stats.DeniedByReason)
+	for reason, n := range other.DeniedByReason {
+		if stats.DeniedByReason == nil {
+			stats.DeniedByReason = make(map[Reason]int)
+		}
+		stats.DeniedByReason[reason] += n
+	}
+	stats.Leases = append(stats.Leases, other.Leases...)
+	slices.SortStableFunc(stats.Leases, func(a, b LeaseStats) int {
+		return a.ExpiresAt.Compare(b.ExpiresAt)
+	})
+	stats.AbandonedReservations += other.AbandonedReservations
+	stats.ClockAnomalies += other.ClockAnomalies
+	stats.Oversubscribed += other.Oversubscribed
+	stats.PendingReservations += other.PendingReservations
+	stats.QueueDepth += other.QueueDepth
+}
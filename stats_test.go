@@ -0,0 +1,56 @@
+package limit_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limittest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats_JSON(t *testing.T) {
+	t.Parallel()
+
+	stats := limit.Stats{
+		AllowedRequests: 120,
+		DeniedRequests:  4,
+		DeniedByReason:  map[limit.Reason]int{limit.ReasonLimited: 4},
+		NextAllowedTime: time.Date(2024, 5, 1, 10, 0, 0, 250_123_456, time.UTC),
+		Remaining:       6,
+		Leases:          []limit.LeaseStats{{Rate: limit.Rate{Count: 1, Per: time.Second}, ExpiresAt: time.Unix(60, 0).UTC()}},
+	}
+	data, err := json.Marshal(stats)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"allowed_requests":120`)
+	assert.Contains(t, string(data), `"denied_by_reason":{"limited":4}`)
+	assert.Contains(t, string(data), `"next_allowed_time":"2024-05-01T10:00:00.250Z"`)
+	assert.NotContains(t, string(data), `"partition"`)
+
+	// The round trip keeps NextAllowedTime to the millisecond
+	var decoded limit.Stats
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	stats.NextAllowedTime = stats.NextAllowedTime.Truncate(time.Millisecond)
+	assert.Equal(t, stats, decoded)
+
+	// A zero NextAllowedTime is left out
+	data, err = json.Marshal(limit.Stats{})
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "next_allowed_time")
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.True(t, decoded.NextAllowedTime.IsZero())
+}
+
+func TestStats_String(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC))
+	limiter := limit.NewTokenBucket(4, 1*time.Second, limit.WithClock(clock))
+	assert.True(t, limiter.AllowN(4))
+	assert.False(t, limiter.Allowed())
+	assert.Equal(t, "allowed=1 denied=1 remaining=0 pending=0 queue=0 next=2024-05-01T10:00:00.250Z", limiter.Stats().String())
+
+	limiter.(limit.Pauser).Pause()
+	assert.Equal(t, "allowed=1 denied=1 remaining=0 pending=0 queue=0 next=none paused", limiter.Stats().String())
+}
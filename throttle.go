@@ -0,0 +1,154 @@
+package limit
+
+import (
+	"sync"
+	"time"
+)
+
+// Throttle runs a function only when its limiter admits it, dropping the calls it doesn't.
+type Throttle struct {
+	// Mutex
+	mux sync.Mutex
+
+	// Config
+	limiter Limiter
+	fn      func()
+
+	// State
+	dropped int
+}
+
+// ThrottleFunc returns a Throttle calling fn whenever l allows it.
+func ThrottleFunc(l Limiter, fn func()) *Throttle {
+	return &Throttle{limiter: l, fn: fn}
+}
+
+// Call runs the function if the limiter allows it right now and reports whether it did.
+func (t *Throttle) Call() bool {
+	if !t.limiter.Allowed() {
+		t.mux.Lock()
+		defer t.mux.Unlock()
+		t.dropped++
+		return false
+	}
+
+	t.fn()
+	return true
+}
+
+// Dropped returns the number of calls that didn't run the function.
+func (t *Throttle) Dropped() int {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	return t.dropped
+}
+
+// Debounce runs a function once calls to it stop for a quiet period, or on the first call of a burst with
+// WithLeadingEdge.
+type Debounce struct {
+	// Mutex
+	mux sync.Mutex
+
+	// Config
+	quiet   time.Duration
+	fn      func()
+	clock   Clock
+	leading bool
+
+	// State
+	pending  bool
+	deadline time.Time // End of the quiet period of the current burst
+	stop     chan struct{}
+	stopped  bool
+}
+
+// DebounceFunc returns a Debounce calling fn once calls stop for the quiet period. It accepts WithClock and
+// WithLeadingEdge.
+func DebounceFunc(quiet time.Duration, fn func(), opts ...Option) *Debounce {
+	o := newOptions(opts)
+	return &Debounce{
+		quiet:   quiet,
+		fn:      fn,
+		clock:   o.clock,
+		leading: o.leadingEdge,
+		stop:    make(chan struct{}),
+	}
+}
+
+// Call starts or extends the current burst.
+func (d *Debounce) Call() {
+	if d.extend() && d.leading {
+		d.fn()
+	}
+}
+
+// extend extends the current burst, starting one if there is none, and reports whether it started one.
+func (d *Debounce) extend() bool {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	if d.stopped {
+		return false
+	}
+
+	d.deadline = d.clock.Now().Add(d.quiet)
+	if d.pending {
+		return false
+	}
+
+	d.pending = true
+	go d.wait()
+	return true
+}
+
+// wait waits for the end of the current burst, running the function at the end unless on the leading edge.
+func (d *Debounce) wait() {
+	for {
+		remaining, ended := d.remaining()
+		if ended {
+			if !d.leading && !d.isStopped() {
+				d.fn()
+			}
+			return
+		}
+
+		timer := d.clock.NewTimer(remaining)
+		select {
+		case <-d.stop:
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+	}
+}
+
+// remaining returns how long until the current burst ends, ending it if it did or the Debounce was stopped.
+func (d *Debounce) remaining() (time.Duration, bool) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	remaining := d.deadline.Sub(d.clock.Now())
+	if remaining > 0 && !d.stopped {
+		return remaining, false
+	}
+
+	d.pending = false
+	return 0, true
+}
+
+func (d *Debounce) isStopped() bool {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	return d.stopped
+}
+
+// Stop drops the pending call, if any, and ignores every call after it. It's safe to call more than once.
+func (d *Debounce) Stop() {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	if !d.stopped {
+		d.stopped = true
+		close(d.stop)
+	}
+}
@@ -0,0 +1,116 @@
+package limit_test
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limittest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThrottleFunc(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int64
+	throttle := limit.ThrottleFunc(limit.NewRollingWindow(5, 1*time.Second), func() {
+		calls.Add(1)
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			throttle.Call()
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(5), calls.Load())
+	assert.Equal(t, 45, throttle.Dropped())
+}
+
+func TestDebounceFunc_TrailingEdge(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Now())
+	calls := make(chan struct{}, 10)
+	debounce := limit.DebounceFunc(100*time.Millisecond, func() {
+		calls <- struct{}{}
+	}, limit.WithClock(clock))
+
+	debounce.Call()
+	clock.BlockUntil(1)
+	clock.Advance(50 * time.Millisecond)
+	debounce.Call()
+
+	// The first timer fires at 100ms but the burst was extended to 150ms
+	clock.Advance(50 * time.Millisecond)
+	clock.BlockUntil(1)
+	assert.Empty(t, calls)
+
+	clock.Advance(50 * time.Millisecond)
+	<-calls
+	assert.Eventually(t, func() bool { return !limit.DebouncePending(debounce) }, 1*time.Second, 1*time.Millisecond)
+	assert.Empty(t, calls)
+
+	// A new burst runs it again
+	debounce.Call()
+	clock.BlockUntil(1)
+	clock.Advance(100 * time.Millisecond)
+	<-calls
+}
+
+func TestDebounceFunc_LeadingEdge(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Now())
+	var calls atomic.Int64
+	debounce := limit.DebounceFunc(100*time.Millisecond, func() {
+		calls.Add(1)
+	}, limit.WithClock(clock), limit.WithLeadingEdge())
+
+	debounce.Call()
+	assert.Equal(t, int64(1), calls.Load())
+
+	clock.BlockUntil(1)
+	clock.Advance(50 * time.Millisecond)
+	debounce.Call()
+	clock.Advance(99 * time.Millisecond)
+	debounce.Call()
+	assert.Equal(t, int64(1), calls.Load())
+
+	// Once the calls stop for the quiet period the next one runs right away
+	clock.BlockUntil(1)
+	clock.Advance(100 * time.Millisecond)
+	assert.Eventually(t, func() bool { return !limit.DebouncePending(debounce) }, 1*time.Second, 1*time.Millisecond)
+	assert.Equal(t, int64(1), calls.Load())
+
+	debounce.Call()
+	assert.Equal(t, int64(2), calls.Load())
+}
+
+func TestDebounceFunc_Stop(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Now())
+	var calls atomic.Int64
+	debounce := limit.DebounceFunc(100*time.Millisecond, func() {
+		calls.Add(1)
+	}, limit.WithClock(clock))
+
+	debounce.Call()
+	clock.BlockUntil(1)
+	debounce.Stop()
+	debounce.Stop()
+
+	// The pending goroutine exits without running the function
+	assert.Eventually(t, func() bool { return clock.Timers() == 0 }, 1*time.Second, 1*time.Millisecond)
+	clock.Advance(1 * time.Second)
+	debounce.Call()
+	clock.Advance(1 * time.Second)
+	assert.Equal(t, int64(0), calls.Load())
+}
@@ -3,64 +3,127 @@ package limit
 import (
 	"context"
 	"fmt"
-	"sync"
+	"math"
+	"slices"
 	"time"
 )
 
+var _ Limiter = (*tokenBucket)(nil)
+
 type tokenBucket struct {
-	// Mutex
-	mux sync.Mutex
+	base
 
 	// Config
-	maxCapacity     int
+	count           int
+	duration        time.Duration
+	maxCapacity     int // Reduced by active leases
 	currentCapacity int
-	refillRate      time.Duration
+	refillRate      time.Duration // Reduced by active leases
+	burst           int           // Capacity at initialCount, set by WithBurst
+	initialCount    int           // The count the bucket was created with
+	partition       *partition    // Set by NewPartitioned
 
 	// State
-	allowedEvents int
-	deniedEvents  int
-	lastRefill    time.Time
+	lastRefill time.Time
 
 	// Reservations tracking
 	pendingReservations map[*tokenBucketReservation]struct{}
 }
 
-func NewTokenBucket(count int, duration time.Duration) Limiter {
-	return &tokenBucket{
-		mux:                 sync.Mutex{},
-		maxCapacity:         count,
-		currentCapacity:     count,
+// NewTokenBucket creates a token bucket holding count tokens, which refill at count per duration. It panics if count or
+// duration isn't positive, if the refill interval, duration divided by count, truncates to zero or if an option
+// doesn't apply to a token bucket, see NewTokenBucketE.
+func NewTokenBucket(count int, duration time.Duration, opts ...Option) Limiter {
+	o := newOptions(opts)
+	err := validateRate(count, duration, true)
+	if err == nil {
+		err = o.check(kindTokenBucket)
+	}
+	if err != nil {
+		panic(fmt.Sprintf("limit: NewTokenBucket: %v", err))
+	}
+
+	capacity := count
+	if o.burst > 0 {
+		capacity = o.burst
+	}
+	t := &tokenBucket{
+		count:               count,
+		duration:            duration,
+		maxCapacity:         capacity,
+		currentCapacity:     capacity,
 		refillRate:          duration / time.Duration(count),
-		lastRefill:          time.Now(),
+		burst:               capacity,
+		initialCount:        count,
+		lastRefill:          o.clock.Now(),
 		pendingReservations: make(map[*tokenBucketReservation]struct{}),
 	}
+	if o.initialTokens >= 0 {
+		t.currentCapacity = min(o.initialTokens, capacity)
+	}
+	t.init(o)
+	t.remaining = func() int { return t.currentCapacity - reservedUnits(t.pendingReservations) }
+	t.nextAllowed = func() time.Time { return t.nextAllowedTime(1) }
+	return t
+}
+
+// NewTokenBucketE is NewTokenBucket returning an error instead of panicking on an invalid rate or option.
+func NewTokenBucketE(count int, duration time.Duration, opts ...Option) (Limiter, error) {
+	if err := validateRate(count, duration, true); err != nil {
+		return nil, err
+	}
+	if err := newOptions(opts).check(kindTokenBucket); err != nil {
+		return nil, err
+	}
+	return NewTokenBucket(count, duration, opts...), nil
+}
+
+// NewTokenBucketFromString creates a token bucket allowing rate, a string like "100/s" as ParseRate parses it.
+func NewTokenBucketFromString(rate string, opts ...Option) (Limiter, error) {
+	count, per, err := ParseRate(rate)
+	if err != nil {
+		return nil, err
+	}
+	return NewTokenBucketE(count, per, opts...)
 }
 
 func (t *tokenBucket) WaitContext(ctx context.Context) error {
-	for {
-		t.mux.Lock()
-		t.refill()
-		t.cleanupExpiredReservations()
-
-		if t.currentCapacity-len(t.pendingReservations) > 0 {
-			t.currentCapacity--
-			t.allowedEvents++
-			t.mux.Unlock()
-			return nil
-		}
+	return t.WaitNContext(ctx, 1)
+}
 
-		t.mux.Unlock()
+func (t *tokenBucket) WaitN(n int) error {
+	return t.WaitNContext(context.Background(), n)
+}
+
+func (t *tokenBucket) WaitNTimeout(timeout time.Duration, n int) error {
+	ctx, cancel := withTimeout(t.clock, timeout)
+	defer cancel()
+	return t.WaitNContext(ctx, n)
+}
 
-		select {
-		case <-ctx.Done():
-			t.mux.Lock()
-			t.deniedEvents++
-			t.mux.Unlock()
-			return ctx.Err()
-		case <-time.After(t.lastRefill.Add(t.refillRate).Sub(time.Now())):
-			// Wait until the next event is allowed
+func (t *tokenBucket) WaitNContext(ctx context.Context, n int) error {
+	if n <= 0 {
+		return nil
+	}
+
+	return t.await(ctx, func() (bool, time.Duration, error) {
+		if err := t.costErrLocked(n); err != nil {
+			return false, 0, err
 		}
+
+		ok, retryIn := t.tryTakeLocked(n)
+		return ok, retryIn, nil
+	}, nil)
+}
+
+// costErrLocked denies n units and returns an error if they exceed the bucket capacity, nil otherwise.
+func (t *tokenBucket) costErrLocked(n int) error {
+	// This must be called with the mutex already locked
+	if n > t.maxCapacity {
+		t.deny(ReasonLimited)
+		return fmt.Errorf("cost %d exceeds the bucket capacity of %d", n, t.maxCapacity)
 	}
+	return nil
 }
 
 func (t *tokenBucket) Wait() {
@@ -68,61 +131,329 @@ func (t *tokenBucket) Wait() {
 }
 
 func (t *tokenBucket) WaitTimeout(timeout time.Duration) error {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	ctx, cancel := withTimeout(t.clock, timeout)
 	defer cancel()
 	return t.WaitContext(ctx)
 }
 
 func (t *tokenBucket) Allowed() bool {
+	return t.AllowN(1)
+}
+
+func (t *tokenBucket) AllowedReport() (AdmitReport, bool) {
+	return t.allowReport(func() bool { return t.allowLocked(1) })
+}
+
+func (t *tokenBucket) WaitContextReport(ctx context.Context) (AdmitReport, error) {
+	return waitReport(ctx, t.WaitContext)
+}
+
+func (t *tokenBucket) AllowN(n int) bool {
+	if n <= 0 {
+		return true
+	}
+
 	t.mux.Lock()
 	defer t.mux.Unlock()
-	t.refill()
-	t.cleanupExpiredReservations()
+	return t.allowLocked(n)
+}
 
-	if t.currentCapacity-len(t.pendingReservations) > 0 {
-		t.currentCapacity--
-		t.allowedEvents++
+func (t *tokenBucket) allowLocked(n int) bool {
+	// This must be called with the mutex already locked
+	if t.denyHalted() {
+		return false
+	}
+	if ok, _ := t.tryTakeLocked(n); ok {
 		return true
 	}
 
-	t.deniedEvents++
+	t.deny(t.limitedReason())
 	return false
 }
 
+// tryTakeLocked takes n tokens if they are available net of pending reservations, otherwise it returns how long until
+// it's worth trying again.
+func (t *tokenBucket) tryTakeLocked(n int) (bool, time.Duration) {
+	// This must be called with the mutex already locked
+	if !t.availableLocked(n) || !t.closedUntil().IsZero() {
+		return false, t.retryIn(t.nextAllowedTime(n), t.refillRate)
+	}
+
+	t.currentCapacity -= n
+	t.countAllowed()
+	return true, 0
+}
+
+// tryReserveLocked reserves n tokens if they are available net of pending reservations, otherwise it returns how long
+// until it's worth trying again.
+func (t *tokenBucket) tryReserveLocked(ctx context.Context, n int, reservationTTL *time.Duration) (*tokenBucketReservation, time.Duration) {
+	// This must be called with the mutex already locked
+	if !t.reservableLocked(n) || !t.closedUntil().IsZero() {
+		return nil, t.retryIn(t.nextAllowedTime(n), t.refillRate)
+	}
+
+	reservation := &tokenBucketReservation{
+		limiter:    t,
+		n:          n,
+		reservedAt: t.clock.Now(),
+		expiresAt:  t.expiryFor(ctx, reservationTTL),
+	}
+	t.pendingReservations[reservation] = struct{}{}
+	t.watchAbandoned(reservation.reservedAt, func() bool {
+		return pendingAt(t.clock.Now(), reservation.consumed, reservation.canceled, reservation.expiresAt)
+	})
+	return reservation, 0
+}
+
+// reservableLocked refills the bucket and reports whether n tokens can be reserved, oversubscribing the tokens with
+// WithReservationOversubscription.
+func (t *tokenBucket) reservableLocked(n int) bool {
+	// This must be called with the mutex already locked
+	return t.availableLocked(n) || reservedUnits(t.pendingReservations)+n <= t.reservationRoom(t.currentCapacity)
+}
+
+// availableLocked refills the bucket and reports whether n tokens are available net of pending reservations.
+func (t *tokenBucket) availableLocked(n int) bool {
+	// This must be called with the mutex already locked
+	t.expireLeases(t.applyLeases)
+	t.rebalance()
+	t.refill()
+	t.cleanupExpiredReservations()
+	return t.currentCapacity-reservedUnits(t.pendingReservations) >= n
+}
+
 func (t *tokenBucket) Clear() {
 	t.mux.Lock()
 	defer t.mux.Unlock()
 
-	// Mark all reservations as canceled
+	t.cancelReservationsLocked()
+	t.currentCapacity = t.maxCapacity
+	t.lastRefill = t.clock.Now()
+	t.waiters.notify()
+}
+
+func (t *tokenBucket) Close() error {
+	return t.close(t.cancelReservationsLocked)
+}
+
+// cancelReservationsLocked cancels the pending reservations.
+func (t *tokenBucket) cancelReservationsLocked() {
+	// This must be called with the mutex already locked
 	for res := range t.pendingReservations {
 		res.canceled = true
 	}
 
-	// Clear the pending reservations map
 	t.pendingReservations = make(map[*tokenBucketReservation]struct{})
-	t.currentCapacity = t.maxCapacity
-	t.lastRefill = time.Now()
+}
+
+// Resume picks up refilling where Pause left off, so the paused time doesn't refill the bucket.
+func (t *tokenBucket) Resume() {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.lastRefill = t.lastRefill.Add(t.resumeLocked())
 }
 
 func (t *tokenBucket) Stats() Stats {
 	t.mux.Lock()
 	defer t.mux.Unlock()
+	t.expireLeases(t.applyLeases)
+	t.rebalance()
+	t.refill()
+	t.cleanupExpiredReservations()
+
+	stats := t.stats()
+	stats.PendingReservations = len(t.pendingReservations)
+	stats.Partition = t.partitionStats()
+	return stats
+}
+
+// Info reports the bucket capacity as the limit, with Reset being when the bucket would be full again.
+func (t *tokenBucket) Info() LimitInfo {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.expireLeases(t.applyLeases)
+	t.rebalance()
+	t.refill()
+	t.cleanupExpiredReservations()
+
+	reset := t.clock.Now()
+	if missing := t.maxCapacity - t.currentCapacity; missing > 0 {
+		reset = t.lastRefill.Add(time.Duration(missing) * t.refillRate)
+	}
+	return t.info(t.maxCapacity, t.currentCapacity-reservedUnits(t.pendingReservations), reset, t.duration)
+}
+
+// Available returns the tokens in the bucket net of pending reservations, without taking any.
+func (t *tokenBucket) Available() int {
+	return t.Info().Remaining
+}
+
+// EstimatedWait returns how long until a token refills that no pending reservation holds.
+func (t *tokenBucket) EstimatedWait() time.Duration {
+	return t.waitUntil(t.Stats().NextAllowedTime)
+}
+
+func (t *tokenBucket) Config() Config {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.rebalance()
+	return Config{Algorithm: AlgorithmTokenBucket, Count: t.count, Per: t.duration}
+}
+
+// Limit returns the rate the bucket refills at, net of active leases.
+func (t *tokenBucket) Limit() Rate {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.expireLeases(t.applyLeases)
+	t.rebalance()
+	if t.count <= 0 {
+		return Rate{Count: 0, Per: t.duration}
+	}
+	return Rate{Count: max(int(t.remainingRate()), 1), Per: t.duration}
+}
+
+// Burst returns the bucket capacity.
+func (t *tokenBucket) Burst() int {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.expireLeases(t.applyLeases)
+	t.rebalance()
+	return t.maxCapacity
+}
+
+func (t *tokenBucket) PendingReservationAges(n int) []time.Duration {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.cleanupExpiredReservations()
+
+	reservedAt := make([]time.Time, 0, len(t.pendingReservations))
+	for res := range t.pendingReservations {
+		reservedAt = append(reservedAt, res.reservedAt)
+	}
+	return t.reservationAges(reservedAt, n)
+}
+
+// nextAllowedTime returns when n tokens will be available net of pending reservations, walking the upcoming refills
+// and reservation expiries in order. It returns the zero time if only consuming or canceling reservations can free them.
+func (t *tokenBucket) nextAllowedTime(n int) time.Time {
+	// This must be called with the mutex already locked
+	now := t.clock.Now()
+	available := t.currentCapacity - reservedUnits(t.pendingReservations)
+	if available >= n {
+		return now
+	}
+
+	var expiries []time.Time
+	for res := range t.pendingReservations {
+		for range res.n {
+			if res.expiresAt != nil {
+				expiries = append(expiries, *res.expiresAt)
+			}
+		}
+	}
+	slices.SortFunc(expiries, time.Time.Compare)
+
+	tokens := t.currentCapacity
+	nextRefill := t.lastRefill.Add(t.refillRate)
+	for {
+		var at time.Time
+		canRefill := tokens < t.maxCapacity
+		switch {
+		case len(expiries) > 0 && (!canRefill || expiries[0].Before(nextRefill)):
+			at = expiries[0]
+			expiries = expiries[1:]
+		case canRefill:
+			at = nextRefill
+			nextRefill = nextRefill.Add(t.refillRate)
+			tokens++
+		default:
+			return time.Time{}
+		}
+
+		available++
+		if available >= n {
+			return at
+		}
+	}
+}
+
+// AcquireLease carves rate out of the bucket for ttl, lowering both its refill rate and its capacity by the leased
+// share of the rate.
+func (t *tokenBucket) AcquireLease(rate Rate, ttl time.Duration) (Lease, error) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	return t.acquireLease(rate, ttl, t.count, t.duration, func() Limiter {
+		return NewTokenBucket(rate.Count, rate.Per, WithName(t.name), WithLabels(t.labels), WithClock(t.clock), withBlackouts(t.blackouts))
+	}, t.applyLeases)
+}
+
+// applyLeases recomputes the refill rate and capacity left after the active leases.
+func (t *tokenBucket) applyLeases() {
+	// This must be called with the mutex already locked
 	t.refill()
-	nextAllowedTime := time.Now()
-	if t.currentCapacity == 0 {
-		nextAllowedTime = t.lastRefill.Add(t.refillRate)
+	if t.count <= 0 {
+		// A rate of zero, set by a multiplier of 0, never refills
+		t.refillRate = math.MaxInt64
+		t.maxCapacity = 0
+		t.currentCapacity = 0
+		return
 	}
+	remaining := t.remainingRate()
+	t.refillRate = time.Duration(float64(t.duration) / remaining)
+	t.maxCapacity = max(int(remaining*float64(t.burst)/float64(t.initialCount)), 1)
+	t.currentCapacity = min(t.currentCapacity, t.maxCapacity)
+}
+
+// remainingRate returns the count per duration left after the active leases.
+func (t *tokenBucket) remainingRate() float64 {
+	// This must be called with the mutex already locked
+	return float64(t.count) - t.leases.leasedIn(t.duration)
+}
+
+// setRate changes the rate the bucket refills at, keeping its tokens up to the new capacity.
+func (t *tokenBucket) setRate(rate Rate) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.setRateLocked(rate.Count, rate.Per)
+}
+
+// SetRate keeps the tokens in the bucket up to the new capacity, and tokens refill at the new rate from the last
+// refill. A partitioned bucket goes back to its share of the global rate when the instance count changes.
+func (t *tokenBucket) SetRate(count int, per time.Duration) {
+	if per <= 0 || count < 0 {
+		panic(fmt.Sprintf("limit: SetRate with an invalid rate of %d/%s", count, per))
+	}
+	t.setRate(Rate{Count: count, Per: per})
+}
 
-	return Stats{
-		AllowedRequests: t.allowedEvents,
-		DeniedRequests:  t.deniedEvents,
-		NextAllowedTime: nextAllowedTime,
+func (t *tokenBucket) setRateLocked(count int, duration time.Duration) {
+	// This must be called with the mutex already locked
+	t.refill()
+	if t.count <= 0 {
+		// The bucket was stopped, it refills from empty starting now
+		t.lastRefill = t.clock.Now()
 	}
+	t.count, t.duration = count, duration
+	t.applyLeases()
+	t.waiters.notify()
 }
 
 func (t *tokenBucket) refill() {
-	now := time.Now()
+	// This must be called with the mutex already locked
+	now := t.clock.Now()
+	if t.paused {
+		// The refill clock stands still while paused
+		now = t.pausedAt
+	}
 	elapsed := now.Sub(t.lastRefill)
+	if elapsed < 0 {
+		// The wall clock stepped backwards, refill from now instead of waiting for it to catch up
+		t.clockStepped(-elapsed)
+		t.lastRefill = now
+		return
+	}
+
 	newTokens := int(elapsed / t.refillRate)
 
 	if newTokens == 0 {
@@ -139,7 +470,7 @@ func (t *tokenBucket) refill() {
 
 func (t *tokenBucket) cleanupExpiredReservations() {
 	// This must be called with the mutex already locked
-	now := time.Now()
+	now := t.clock.Now()
 	for res := range t.pendingReservations {
 		if res.expiresAt != nil && now.After(*res.expiresAt) {
 			delete(t.pendingReservations, res)
@@ -147,87 +478,143 @@ func (t *tokenBucket) cleanupExpiredReservations() {
 	}
 }
 
+func (t *tokenBucket) TryReserve(reservationTTL *time.Duration) (Reservation, bool) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	if reservation, _ := t.tryReserveLocked(context.Background(), 1, reservationTTL); reservation != nil {
+		return reservation, true
+	}
+
+	t.deny(t.limitedReason())
+	return nil, false
+}
+
+// reserveNow reserves n tokens if AllowN would take them, without oversubscribing them.
+func (t *tokenBucket) reserveNow(n int) (Reservation, bool) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	if t.denyHalted() {
+		return nil, false
+	}
+	if t.availableLocked(n) {
+		if reservation, _ := t.tryReserveLocked(context.Background(), n, nil); reservation != nil {
+			return reservation, true
+		}
+	}
+
+	t.deny(t.limitedReason())
+	return nil, false
+}
+
 func (t *tokenBucket) Reserve(reservationTTL *time.Duration) Reservation {
-	reservation, _ := t.ReserveContext(context.Background(), reservationTTL)
+	reservation, err := t.ReserveContext(context.Background(), reservationTTL)
+	if err != nil {
+		return failedReservation{err: err}
+	}
 	return reservation
 }
 
 func (t *tokenBucket) ReserveTimeout(timeout time.Duration, reservationTTL *time.Duration) (Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	ctx, cancel := withTimeout(t.clock, timeout)
 	defer cancel()
 	return t.ReserveContext(ctx, reservationTTL)
 }
 
+func (t *tokenBucket) Permits(ctx context.Context) <-chan struct{} {
+	return deliverPermits(ctx, func(ctx context.Context) (permit, error) {
+		return reservePermit(ctx, t)
+	})
+}
+
 func (t *tokenBucket) ReserveContext(ctx context.Context, reservationTTL *time.Duration) (Reservation, error) {
-	for {
-		t.mux.Lock()
-		t.refill()
-		t.cleanupExpiredReservations()
-
-		if t.currentCapacity-len(t.pendingReservations) > 0 {
-			var expiresAt *time.Time
-			if reservationTTL != nil {
-				expiresAt = new(time.Time)
-				*expiresAt = time.Now().Add(*reservationTTL)
-			}
-			reservation := &tokenBucketReservation{
-				limiter:   t,
-				expiresAt: expiresAt,
-			}
-			t.pendingReservations[reservation] = struct{}{}
-			t.mux.Unlock()
-			return reservation, nil
-		}
+	return t.ReserveN(ctx, 1, reservationTTL)
+}
+
+func (t *tokenBucket) ReserveN(ctx context.Context, n int, reservationTTL *time.Duration) (Reservation, error) {
+	if err := reserveCostErr(n); err != nil {
+		return nil, err
+	}
 
-		nextRefillTime := t.lastRefill.Add(t.refillRate)
-		t.mux.Unlock()
-
-		select {
-		case <-ctx.Done():
-			t.mux.Lock()
-			t.deniedEvents++
-			t.mux.Unlock()
-			return nil, ctx.Err()
-		case <-time.After(nextRefillTime.Sub(time.Now())):
-			// Continue waiting for a token
+	var reservation *tokenBucketReservation
+	err := t.awaitReservation(ctx, func() (bool, time.Duration, error) {
+		if err := t.costErrLocked(n); err != nil {
+			return false, 0, err
 		}
+
+		var retryIn time.Duration
+		reservation, retryIn = t.tryReserveLocked(ctx, n, reservationTTL)
+		return reservation != nil, retryIn, nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	t.link(ctx, reservation)
+	return reservation, nil
 }
 
 // tokenBucketReservation implements the Reservation interface
 type tokenBucketReservation struct {
-	limiter   *tokenBucket
-	expiresAt *time.Time
-	consumed  bool
-	canceled  bool
+	limiter    *tokenBucket
+	n          int // Tokens held
+	reservedAt time.Time
+	expiresAt  *time.Time
+	consumed   bool
+	canceled   bool
+	// When a reservation taken with ReserveDelayed is ready, zero for the others
+	readyAt time.Time
 }
 
 func (r *tokenBucketReservation) Consume() error {
+	return r.ConsumeContext(context.Background())
+}
+
+// ConsumeContext waits out the delay of a reservation taken with ReserveDelayed, canceling it if ctx is done first. The
+// others never block.
+func (r *tokenBucketReservation) ConsumeContext(ctx context.Context) error {
+	if err := r.limiter.sleepUntil(ctx, r.readyAt, r.Cancel); err != nil {
+		return err
+	}
+
 	r.limiter.mux.Lock()
 	defer r.limiter.mux.Unlock()
 
 	if r.consumed {
-		return fmt.Errorf("reservation already consumed")
+		return ErrReservationConsumed
 	}
 
 	if r.canceled {
-		return fmt.Errorf("reservation was canceled")
+		return ErrReservationCanceled
 	}
 
-	if r.expiresAt != nil && time.Now().After(*r.expiresAt) {
+	if r.expiresAt != nil && r.limiter.clock.Now().After(*r.expiresAt) {
 		delete(r.limiter.pendingReservations, r)
-		return fmt.Errorf("reservation expired")
+		return ErrReservationExpired
+	}
+
+	if r.limiter.oversubscription > 1 {
+		// More tokens may have been reserved than the bucket holds
+		r.limiter.refill()
+		if r.limiter.currentCapacity < r.n {
+			r.limiter.oversubscribed++
+			return ErrOversubscribed
+		}
 	}
 
 	r.consumed = true
 	delete(r.limiter.pendingReservations, r)
 	// Only decrease capacity when actually consumed
-	r.limiter.currentCapacity--
-	r.limiter.allowedEvents++
+	r.limiter.currentCapacity -= r.n
+	r.limiter.countAllowed()
 
 	return nil
 }
 
+func (r *tokenBucketReservation) units() int {
+	return r.n
+}
+
 func (r *tokenBucketReservation) Cancel() {
 	r.limiter.mux.Lock()
 	defer r.limiter.mux.Unlock()
@@ -235,5 +622,43 @@ func (r *tokenBucketReservation) Cancel() {
 	if !r.consumed {
 		r.canceled = true
 		delete(r.limiter.pendingReservations, r)
+		// The reserved token is free again
+		r.limiter.waiters.notify()
 	}
 }
+
+func (r *tokenBucketReservation) Detach() (ReservationHandle, error) {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	state := func() error {
+		return reservationErr(r.limiter.clock.Now(), r.consumed, r.canceled, r.expiresAt)
+	}
+	if err := state(); err != nil {
+		return ReservationHandle{}, err
+	}
+	return r.limiter.detach(r, r.expiresAt, state), nil
+}
+
+// ReadyAt is now, Consume only blocks for the delay of a reservation taken with ReserveDelayed.
+func (r *tokenBucketReservation) ReadyAt() time.Time {
+	return latest(r.readyAt, r.limiter.clock.Now())
+}
+
+func (r *tokenBucketReservation) Delay() time.Duration {
+	return max(r.readyAt.Sub(r.limiter.clock.Now()), 0)
+}
+
+func (r *tokenBucketReservation) ExpiresAt() (time.Time, bool) {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	return expiry(r.expiresAt)
+}
+
+func (r *tokenBucketReservation) State() ReservationState {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	return reservationState(r.limiter.clock.Now(), r.consumed, r.canceled, r.expiresAt)
+}
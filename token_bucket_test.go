@@ -6,26 +6,35 @@ import (
 	"time"
 
 	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limittest"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestTokenBucket_Wait(t *testing.T) {
 	t.Parallel()
 
-	start := time.Now()
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	start := clock.Now()
 
 	// 5 requests per second
-	limiter := limit.NewTokenBucket(5, 1*time.Second)
+	limiter := limit.NewTokenBucket(5, 1*time.Second, limit.WithClock(clock))
 	for i := 0; i < 5; i++ {
 		limiter.Wait()
 	}
 
-	// 5 requests should have been pseudo instant
-	assert.True(t, time.Since(start) < time.Millisecond)
+	// 5 requests should have been instant
+	assert.Equal(t, start, clock.Now())
 
-	// Extra request should wait for the refill rate (approx 200ms
-	limiter.Wait()
-	assert.True(t, time.Since(start) >= 200*time.Millisecond)
+	// Extra request should wait for the refill rate of 200ms
+	waited := make(chan struct{})
+	go func() {
+		limiter.Wait()
+		close(waited)
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(200 * time.Millisecond)
+	<-waited
+	assert.Equal(t, 6, limiter.Stats().AllowedRequests)
 }
 
 func TestTokenBucket_Allow(t *testing.T) {
@@ -65,8 +74,7 @@ func TestTokenBucket_Reserve_BlocksAfterCapacityReached(t *testing.T) {
 	defer cancel()
 
 	_, err := limiter.ReserveContext(ctx, nil)
-	assert.Error(t, err)
-	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
 
 	// Verify reservations can be consumed
 	for _, res := range reservations {
@@ -99,3 +107,150 @@ func TestTokenBucket_Reserve_CancelFreesCapacity(t *testing.T) {
 	// After consuming, we should be at capacity again
 	assert.False(t, limiter.Allowed())
 }
+
+func TestTokenBucket_Stats_NextAllowedTimeAccountsForReservations(t *testing.T) {
+	t.Parallel()
+
+	// 2 requests per second, a token every 500ms
+	limiter := limit.NewTokenBucket(2, 1*time.Second)
+
+	// Both tokens are held by reservations expiring well before the next refill
+	ttl := 100 * time.Millisecond
+	limiter.Reserve(&ttl)
+	limiter.Reserve(&ttl)
+
+	next := limiter.Stats().NextAllowedTime
+	assert.False(t, limiter.Allowed())
+	assert.WithinDuration(t, time.Now().Add(ttl), next, 20*time.Millisecond)
+
+	time.Sleep(time.Until(next) + 10*time.Millisecond)
+	assert.True(t, limiter.Allowed())
+}
+
+func TestTokenBucket_Stats_NextAllowedTimeZeroWhenOnlyReservationsCanFree(t *testing.T) {
+	t.Parallel()
+
+	// 2 requests per second
+	limiter := limit.NewTokenBucket(2, 1*time.Second)
+
+	// The bucket is full, but every token is held by a reservation that never expires
+	limiter.Reserve(nil)
+	res := limiter.Reserve(nil)
+	assert.True(t, limiter.Stats().NextAllowedTime.IsZero())
+
+	res.Cancel()
+	assert.WithinDuration(t, time.Now(), limiter.Stats().NextAllowedTime, 10*time.Millisecond)
+	assert.True(t, limiter.Allowed())
+}
+
+func TestTokenBucket_ClockSteps(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Now())
+
+	// 2 requests per second, a token every 500ms
+	limiter := limit.NewTokenBucket(2, 1*time.Second, limit.WithClock(clock))
+	assert.True(t, limiter.Allowed())
+	assert.True(t, limiter.Allowed())
+	assert.False(t, limiter.Allowed())
+
+	// Stepping the clock backwards doesn't lock the bucket out until the clock catches up
+	clock.Set(clock.Now().Add(-5 * time.Second))
+	assert.False(t, limiter.Allowed())
+	clock.Advance(500 * time.Millisecond)
+	assert.True(t, limiter.Allowed())
+
+	// Stepping it forwards refills the bucket, but never past its capacity
+	clock.Set(clock.Now().Add(1 * time.Hour))
+	assert.True(t, limiter.Allowed())
+	assert.True(t, limiter.Allowed())
+	assert.False(t, limiter.Allowed())
+}
+
+func TestTokenBucket_Wait_FakeClock(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Now())
+
+	// 2 requests per second, a token every 500ms
+	limiter := limit.NewTokenBucket(2, 1*time.Second, limit.WithClock(clock))
+	limiter.Wait()
+	limiter.Wait()
+
+	done := make(chan struct{})
+	go func() {
+		limiter.Wait()
+		close(done)
+	}()
+
+	clock.BlockUntil(1)
+	clock.Advance(500 * time.Millisecond)
+	<-done
+}
+
+func TestTokenBucket_Burst(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	limiter := limit.NewTokenBucket(10, 1*time.Second, limit.WithClock(clock), limit.WithBurst(20))
+	assert.True(t, limiter.AllowN(20))
+	assert.False(t, limiter.Allowed())
+	configurer := limiter.(limit.Configurer)
+	assert.Equal(t, limit.Rate{Count: 10, Per: 1 * time.Second}, configurer.Limit())
+	assert.Equal(t, 20, configurer.Burst())
+
+	// It refills at the rate, up to the burst
+	clock.Advance(1 * time.Second)
+	assert.Equal(t, 10, limiter.Available())
+	clock.Advance(5 * time.Second)
+	assert.Equal(t, 20, limiter.Available())
+}
+
+func TestTokenBucket_InitialTokens(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	limiter := limit.NewTokenBucket(10, 1*time.Second, limit.WithClock(clock), limit.WithInitialTokens(0))
+	assert.False(t, limiter.Allowed())
+	clock.Advance(100 * time.Millisecond)
+	assert.True(t, limiter.Allowed())
+
+	// More than the capacity fills the bucket
+	limiter = limit.NewTokenBucket(10, 1*time.Second, limit.WithClock(clock), limit.WithInitialTokens(50))
+	assert.Equal(t, 10, limiter.Available())
+}
+
+func TestTokenBucket_SetRate(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	limiter := limit.NewTokenBucket(10, 1*time.Second, limit.WithClock(clock))
+	assert.True(t, limiter.AllowN(4))
+
+	// Lowering the rate keeps the tokens up to the new capacity
+	limiter.(limit.RateSetter).SetRate(2, 1*time.Minute)
+	assert.Equal(t, 2, limiter.Available())
+	assert.Equal(t, limit.Config{Algorithm: limit.AlgorithmTokenBucket, Count: 2, Per: 1 * time.Minute}, limiter.Config())
+	assert.True(t, limiter.AllowN(2))
+
+	// A waiter sleeping on the slow refill is woken up by a faster rate
+	waited := make(chan error)
+	go func() {
+		waited <- limiter.WaitContext(context.Background())
+	}()
+	clock.BlockUntil(1)
+	start := clock.Now()
+	limiter.(limit.RateSetter).SetRate(10, 1*time.Second)
+	for done := false; !done; {
+		clock.Advance(100 * time.Millisecond)
+		select {
+		case err := <-waited:
+			assert.NoError(t, err)
+			done = true
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	assert.Less(t, clock.Now().Sub(start), 1*time.Second)
+
+	assert.Panics(t, func() { limiter.(limit.RateSetter).SetRate(10, 0) })
+}
@@ -0,0 +1,299 @@
+package limit
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrUnlimitedDetach is returned when detaching a reservation of a limiter created with Unlimited, which has nothing
+// to attach it back to.
+var ErrUnlimitedDetach = errors.New("reservations of an unlimited limiter can't be detached")
+
+var _ Limiter = (*unlimitedLimiter)(nil)
+
+// unlimitedLimiter allows every request, counting them.
+type unlimitedLimiter struct {
+	// Mutex
+	mux sync.Mutex
+
+	// Config
+	labels map[string]string
+	clock  Clock
+
+	// State
+	allowed int
+	denied  int
+	reasons map[Reason]int
+	pending int
+	// Bumped by Clear, the reservations taken before don't count as pending anymore
+	generation int
+	stopped    bool
+}
+
+// Unlimited returns a limiter allowing every request right away, e.g. where limiting is disabled by a flag or in
+// tests, so callers don't need to guard against a nil limiter. Its Stats still count the requests allowed, with a
+// NextAllowedTime of now, so dashboards keep showing the traffic. Its reservations never expire and consuming them
+// always succeeds. Like any limiter it can be closed, after which it denies every request with ErrLimiterClosed.
+// It accepts WithClock and WithLabels.
+func Unlimited(opts ...Option) Limiter {
+	o := newOptions(opts)
+	return &unlimitedLimiter{labels: o.labels, clock: o.clock, reasons: make(map[Reason]int)}
+}
+
+func (u *unlimitedLimiter) Wait() {
+	_ = u.WaitContext(context.Background())
+}
+
+func (u *unlimitedLimiter) WaitTimeout(time.Duration) error {
+	return u.WaitN(1)
+}
+
+func (u *unlimitedLimiter) WaitContext(ctx context.Context) error {
+	return u.WaitNContext(ctx, 1)
+}
+
+func (u *unlimitedLimiter) WaitN(n int) error {
+	return u.WaitNContext(context.Background(), n)
+}
+
+func (u *unlimitedLimiter) WaitNTimeout(_ time.Duration, n int) error {
+	return u.WaitN(n)
+}
+
+// WaitNContext returns right away, it only fails once the limiter is closed.
+func (u *unlimitedLimiter) WaitNContext(_ context.Context, n int) error {
+	if n <= 0 {
+		return nil
+	}
+	if !u.AllowN(n) {
+		return ErrLimiterClosed
+	}
+	return nil
+}
+
+func (u *unlimitedLimiter) Allowed() bool {
+	return u.AllowN(1)
+}
+
+func (u *unlimitedLimiter) AllowN(n int) bool {
+	if n <= 0 {
+		return true
+	}
+
+	u.mux.Lock()
+	defer u.mux.Unlock()
+
+	if u.stopped {
+		u.denyLocked(ReasonClosed)
+		return false
+	}
+	u.allowed++
+	return true
+}
+
+func (u *unlimitedLimiter) denyLocked(reason Reason) {
+	// This must be called with the mutex already locked
+	u.denied++
+	u.reasons[reason]++
+}
+
+// Clear drops the pending reservations, consuming them still succeeds. The counters are kept like the other limiters
+// do.
+func (u *unlimitedLimiter) Clear() {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+
+	u.pending = 0
+	u.generation++
+}
+
+func (u *unlimitedLimiter) Close() error {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+
+	u.stopped = true
+	return nil
+}
+
+// CancelWaiters returns 0, callers never block.
+func (u *unlimitedLimiter) CancelWaiters(error) int {
+	return 0
+}
+
+func (u *unlimitedLimiter) Stats() Stats {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+
+	stats := Stats{
+		AllowedRequests:     u.allowed,
+		DeniedRequests:      u.denied,
+		DeniedByReason:      maps.Clone(u.reasons),
+		PendingReservations: u.pending,
+	}
+	if !u.stopped {
+		stats.NextAllowedTime = u.clock.Now()
+		stats.Remaining = math.MaxInt
+	}
+	return stats
+}
+
+// Info reports no limit: as many requests remaining as an int holds, with the reset time now.
+func (u *unlimitedLimiter) Info() LimitInfo {
+	return LimitInfo{Limit: math.MaxInt, Remaining: u.Available(), Reset: u.clock.Now()}
+}
+
+func (u *unlimitedLimiter) Available() int {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+
+	if u.stopped {
+		return 0
+	}
+	return math.MaxInt
+}
+
+func (u *unlimitedLimiter) EstimatedWait() time.Duration {
+	return 0
+}
+
+func (u *unlimitedLimiter) Config() Config {
+	return Config{Algorithm: AlgorithmUnlimited}
+}
+
+func (u *unlimitedLimiter) Labels() map[string]string {
+	return maps.Clone(u.labels)
+}
+
+// PendingReservationAges returns nil, the reservations aren't tracked.
+func (u *unlimitedLimiter) PendingReservationAges(int) []time.Duration {
+	return nil
+}
+
+// Waiters returns nil, callers never block.
+func (u *unlimitedLimiter) Waiters() []WaiterInfo {
+	return nil
+}
+
+func (u *unlimitedLimiter) TryReserve(reservationTTL *time.Duration) (Reservation, bool) {
+	reservation, err := u.ReserveN(context.Background(), 1, reservationTTL)
+	return reservation, err == nil
+}
+
+func (u *unlimitedLimiter) Reserve(reservationTTL *time.Duration) Reservation {
+	reservation, err := u.ReserveContext(context.Background(), reservationTTL)
+	if err != nil {
+		return failedReservation{err: err}
+	}
+	return reservation
+}
+
+func (u *unlimitedLimiter) ReserveTimeout(_ time.Duration, reservationTTL *time.Duration) (Reservation, error) {
+	return u.ReserveContext(context.Background(), reservationTTL)
+}
+
+func (u *unlimitedLimiter) ReserveContext(ctx context.Context, reservationTTL *time.Duration) (Reservation, error) {
+	return u.ReserveN(ctx, 1, reservationTTL)
+}
+
+// ReserveN returns a reservation right away, which never expires whatever reservationTTL is.
+func (u *unlimitedLimiter) ReserveN(_ context.Context, n int, _ *time.Duration) (Reservation, error) {
+	if err := reserveCostErr(n); err != nil {
+		return nil, err
+	}
+
+	u.mux.Lock()
+	defer u.mux.Unlock()
+
+	if u.stopped {
+		u.denyLocked(ReasonClosed)
+		return nil, ErrLimiterClosed
+	}
+	u.pending++
+	return &unlimitedReservation{limiter: u, generation: u.generation}, nil
+}
+
+func (u *unlimitedLimiter) Permits(ctx context.Context) <-chan struct{} {
+	return deliverPermits(ctx, func(ctx context.Context) (permit, error) {
+		return reservePermit(ctx, u)
+	})
+}
+
+// unlimitedReservation implements the Reservation interface
+type unlimitedReservation struct {
+	limiter    *unlimitedLimiter
+	generation int // The limiter's generation when it was taken
+	consumed   bool
+	canceled   bool
+}
+
+// Consume always succeeds, counting the request as allowed the first time.
+func (r *unlimitedReservation) Consume() error {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	if r.consumed || r.canceled {
+		return nil
+	}
+	r.consumed = true
+	r.releaseLocked()
+	r.limiter.allowed++
+	return nil
+}
+
+// ConsumeContext is Consume, which never blocks.
+func (r *unlimitedReservation) ConsumeContext(context.Context) error {
+	return r.Consume()
+}
+
+func (r *unlimitedReservation) Cancel() {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	if !r.consumed && !r.canceled {
+		r.canceled = true
+		r.releaseLocked()
+	}
+}
+
+// releaseLocked stops counting the reservation as pending, unless Clear already did.
+func (r *unlimitedReservation) releaseLocked() {
+	// This must be called with the mutex already locked
+	if r.generation == r.limiter.generation {
+		r.limiter.pending--
+	}
+}
+
+func (r *unlimitedReservation) Detach() (ReservationHandle, error) {
+	return ReservationHandle{}, ErrUnlimitedDetach
+}
+
+// ReadyAt is now, Consume never blocks.
+func (r *unlimitedReservation) ReadyAt() time.Time {
+	return r.limiter.clock.Now()
+}
+
+func (r *unlimitedReservation) Delay() time.Duration {
+	return 0
+}
+
+// ExpiresAt returns false, the reservation never expires.
+func (r *unlimitedReservation) ExpiresAt() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (r *unlimitedReservation) State() ReservationState {
+	r.limiter.mux.Lock()
+	defer r.limiter.mux.Unlock()
+
+	switch {
+	case r.consumed:
+		return ReservationConsumed
+	case r.canceled:
+		return ReservationCanceled
+	}
+	return ReservationPending
+}
@@ -0,0 +1,66 @@
+package limit_test
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limittest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnlimited(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	limiter := limit.Unlimited(limit.WithClock(clock))
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				assert.True(t, limiter.Allowed())
+				assert.NoError(t, limiter.WaitN(5))
+			}
+		}()
+	}
+	wg.Wait()
+
+	reservation := limiter.Reserve(nil)
+	assert.Equal(t, 1, limiter.Stats().PendingReservations)
+	assert.NoError(t, reservation.Consume())
+	assert.NoError(t, reservation.Consume())
+	assert.Equal(t, limit.ReservationConsumed, reservation.State())
+
+	stats := limiter.Stats()
+	assert.Equal(t, 2001, stats.AllowedRequests)
+	assert.Equal(t, 0, stats.DeniedRequests)
+	assert.Equal(t, 0, stats.PendingReservations)
+	assert.Equal(t, time.Unix(0, 0), stats.NextAllowedTime)
+	assert.Equal(t, math.MaxInt, limiter.Available())
+	assert.Zero(t, limiter.EstimatedWait())
+}
+
+func TestUnlimited_Close(t *testing.T) {
+	t.Parallel()
+
+	limiter := limit.Unlimited()
+	reservation, ok := limiter.TryReserve(nil)
+	assert.True(t, ok)
+
+	assert.NoError(t, limiter.Close())
+	assert.False(t, limiter.Allowed())
+	assert.ErrorIs(t, limiter.WaitN(1), limit.ErrLimiterClosed)
+
+	// Reservations taken before still succeed
+	assert.NoError(t, reservation.Consume())
+
+	stats := limiter.Stats()
+	assert.Equal(t, 1, stats.AllowedRequests)
+	assert.Equal(t, map[limit.Reason]int{limit.ReasonClosed: 2}, stats.DeniedByReason)
+	assert.Equal(t, 0, stats.Remaining)
+}
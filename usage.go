@@ -0,0 +1,165 @@
+package limit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// usageRecord is the busy time of a released acquisition, counted from when it was released.
+type usageRecord struct {
+	at   time.Time
+	used time.Duration
+}
+
+// UsageStats describes the busy time of a UsageLimiter's window.
+type UsageStats struct {
+	// The busy time released in the window
+	Used time.Duration
+	// The estimates of the acquisitions not released yet
+	InFlight time.Duration
+	// The budget left for new acquisitions, net of the busy time used and in flight
+	Remaining time.Duration
+	// The total number of acquisitions admitted since the limiter was created
+	Acquired int
+	// The total number of acquisitions that gave up waiting since the limiter was created
+	Denied int
+}
+
+// UsageLimiter caps the busy time spent per rolling window rather than the number of calls, e.g. 30 seconds of
+// downstream processing per minute. Callers acquire an estimate of the time they'll take and release the time they
+// actually took.
+type UsageLimiter struct {
+	// Mutex
+	mux sync.Mutex
+
+	// Config
+	budget time.Duration
+	window time.Duration
+	clock  Clock
+	jitter *jitter
+
+	// State
+	records  []usageRecord // Oldest first
+	inFlight time.Duration
+	acquired int
+	denied   int
+	waiters  waitQueue
+}
+
+// NewUsageLimiter creates a limiter allowing budget of busy time per rolling window. It accepts WithClock and
+// WithJitter.
+func NewUsageLimiter(budget time.Duration, window time.Duration, opts ...Option) *UsageLimiter {
+	o := newOptions(opts)
+	return &UsageLimiter{budget: budget, window: window, clock: o.clock, jitter: newJitter(o)}
+}
+
+// Acquire blocks until the busy time of the window plus the estimates in flight leave room for estimated, or until
+// ctx is done. Once admitted, the caller must call release with the time it actually took, which replaces the estimate
+// in the window. Underestimates can push the window over the budget, by at most the difference, and the next callers
+// wait for it to age out. Calling release more than once does nothing.
+func (u *UsageLimiter) Acquire(ctx context.Context, estimated time.Duration) (release func(actual time.Duration), err error) {
+	if estimated > u.budget {
+		return nil, fmt.Errorf("estimate %s exceeds the budget of %s", estimated, u.budget)
+	}
+
+	w := &waiter{since: u.clock.Now(), wake: make(chan struct{}, 1)}
+	for {
+		retryIn, ok := u.tryAcquire(w, estimated)
+		if ok {
+			var once sync.Once
+			return func(actual time.Duration) {
+				once.Do(func() { u.release(estimated, actual) })
+			}, nil
+		}
+
+		timer := u.clock.NewTimer(u.jitter.stretch(retryIn))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			u.mux.Lock()
+			u.waiters.remove(w)
+			u.denied++
+			u.mux.Unlock()
+			return nil, contextError(ctx, "", u.clock.Now().Sub(w.since), retryIn)
+		case <-w.wake:
+		case <-timer.C():
+		}
+		timer.Stop()
+	}
+}
+
+// tryAcquire admits an acquisition of estimated if it fits in the budget, otherwise it returns how long until it's
+// worth trying again.
+func (u *UsageLimiter) tryAcquire(w *waiter, estimated time.Duration) (time.Duration, bool) {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+
+	u.expire()
+	if u.usedLocked()+u.inFlight+estimated <= u.budget {
+		u.waiters.remove(w)
+		u.inFlight += estimated
+		u.acquired++
+		return 0, true
+	}
+
+	u.waiters.add(w)
+	// Wait for enough busy time to age out, or for a release to wake the waiter
+	excess := u.usedLocked() + u.inFlight + estimated - u.budget
+	for _, r := range u.records {
+		excess -= r.used
+		if excess <= 0 {
+			return max(r.at.Add(u.window).Sub(u.clock.Now()), time.Nanosecond), false
+		}
+	}
+	return u.window, false
+}
+
+// release replaces an estimate in flight with the time actually used.
+func (u *UsageLimiter) release(estimated, actual time.Duration) {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+
+	u.inFlight -= estimated
+	if actual > 0 {
+		u.records = append(u.records, usageRecord{at: u.clock.Now(), used: actual})
+	}
+	u.waiters.notify()
+}
+
+// Stats returns the busy time of the window and the counters of the limiter.
+func (u *UsageLimiter) Stats() UsageStats {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	u.expire()
+
+	used := u.usedLocked()
+	return UsageStats{
+		Used:      used,
+		InFlight:  u.inFlight,
+		Remaining: max(u.budget-used-u.inFlight, 0),
+		Acquired:  u.acquired,
+		Denied:    u.denied,
+	}
+}
+
+// expire drops the busy time released before the window.
+func (u *UsageLimiter) expire() {
+	// This must be called with the mutex already locked
+	cutoff := u.clock.Now().Add(-u.window)
+	i := 0
+	for i < len(u.records) && !u.records[i].at.After(cutoff) {
+		i++
+	}
+	u.records = u.records[i:]
+}
+
+func (u *UsageLimiter) usedLocked() time.Duration {
+	// This must be called with the mutex already locked
+	var used time.Duration
+	for _, r := range u.records {
+		used += r.used
+	}
+	return used
+}
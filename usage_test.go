@@ -0,0 +1,75 @@
+package limit_test
+
+import (
+	"context"
+	"math/rand/v2"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limittest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsageLimiter_Budget(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	limiter := limit.NewUsageLimiter(30*time.Second, 1*time.Minute, limit.WithClock(clock))
+	// A done context makes Acquire try once
+	done, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	rng := rand.New(rand.NewPCG(1, 2))
+	admitted := 0
+	for i := 0; i < 500; i++ {
+		estimated := time.Duration(1+rng.IntN(5)) * time.Second
+		// Over- and underestimates by up to half
+		actual := time.Duration(float64(estimated) * (0.5 + rng.Float64()))
+
+		release, err := limiter.Acquire(done, estimated)
+		if err != nil {
+			clock.Advance(1 * time.Second)
+			continue
+		}
+		admitted++
+		release(actual)
+		release(actual) // Released once
+
+		// An underestimate overruns the budget by less than its estimate
+		stats := limiter.Stats()
+		assert.LessOrEqual(t, stats.Used, 30*time.Second+estimated)
+		assert.Zero(t, stats.InFlight)
+		clock.Advance(500 * time.Millisecond)
+	}
+	assert.Equal(t, admitted, limiter.Stats().Acquired)
+	assert.Equal(t, 500-admitted, limiter.Stats().Denied)
+	assert.Greater(t, admitted, 50)
+}
+
+func TestUsageLimiter_Waits(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	limiter := limit.NewUsageLimiter(10*time.Second, 1*time.Minute, limit.WithClock(clock))
+
+	first, err := limiter.Acquire(context.Background(), 6*time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, limit.UsageStats{InFlight: 6 * time.Second, Remaining: 4 * time.Second, Acquired: 1}, limiter.Stats())
+
+	_, err = limiter.Acquire(context.Background(), 11*time.Second)
+	assert.Error(t, err)
+
+	// The estimate doesn't fit until the time the first acquisition used ages out of the window
+	first(8 * time.Second)
+	assert.Equal(t, limit.UsageStats{Used: 8 * time.Second, Remaining: 2 * time.Second, Acquired: 1}, limiter.Stats())
+	admitted := make(chan error)
+	go func() {
+		_, err := limiter.Acquire(context.Background(), 5*time.Second)
+		admitted <- err
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(1 * time.Minute)
+	assert.NoError(t, <-admitted)
+	assert.Equal(t, limit.UsageStats{InFlight: 5 * time.Second, Remaining: 5 * time.Second, Acquired: 2}, limiter.Stats())
+}
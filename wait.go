@@ -0,0 +1,281 @@
+package limit
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+// attemptFunc tries to admit a waiting caller, it's called with the mutex locked. It returns whether the caller was
+// admitted and, if not, how long until it's worth trying again, or an error to stop waiting with.
+type attemptFunc func() (admitted bool, retryIn time.Duration, err error)
+
+// waiter is a caller blocked in a limiter.
+type waiter struct {
+	since time.Time
+	// From the caller's context, for Waiters
+	deadline time.Time
+	tag      string
+	// Signaled to make the waiter try again before its timer fires
+	wake chan struct{}
+	// Set when the waiter is ejected from the queue, it stops waiting with it
+	err error
+	// Slots the waiter holds in the limiter's queue, if it has one
+	queued int
+	// Filled in when the waiter is admitted, for WaitContextReport
+	report *AdmitReport
+	// The callers already waiting when the waiter first tried, -1 until then
+	depth int
+	// Set for reservations, which follow the pause policy
+	reserving bool
+}
+
+// waitQueue holds the callers blocked in a limiter in arrival order. It's guarded by the limiter's mutex.
+type waitQueue struct {
+	waiters []*waiter
+}
+
+func (q *waitQueue) add(w *waiter) {
+	if !slices.Contains(q.waiters, w) {
+		q.waiters = append(q.waiters, w)
+	}
+}
+
+func (q *waitQueue) remove(w *waiter) {
+	q.waiters = slices.DeleteFunc(q.waiters, func(other *waiter) bool { return other == w })
+}
+
+// notify wakes every waiter to try again, used whenever capacity frees up earlier than they expected.
+func (q *waitQueue) notify() {
+	for _, w := range q.waiters {
+		select {
+		case w.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// tagKey is the context key of the tag set with WithTag.
+type tagKey struct{}
+
+// WithTag returns a context tagging the calls waiting on a limiter with it, to tell them apart in Waiters.
+func WithTag(ctx context.Context, tag string) context.Context {
+	return context.WithValue(ctx, tagKey{}, tag)
+}
+
+// WaiterInfo describes a caller blocked in a limiter.
+type WaiterInfo struct {
+	// How long the caller has been waiting
+	Waiting time.Duration
+	// The deadline of the caller's context, zero if it has none
+	Deadline time.Time
+	// The tag set on the caller's context with WithTag, empty if none
+	Tag string
+}
+
+// Waiters returns the callers blocked in the limiter, in the order they started waiting.
+func (b *base) Waiters() []WaiterInfo {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	now := b.clock.Now()
+	infos := make([]WaiterInfo, 0, len(b.waiters.waiters))
+	for _, w := range b.waiters.waiters {
+		infos = append(infos, WaiterInfo{Waiting: now.Sub(w.since), Deadline: w.deadline, Tag: w.tag})
+	}
+	return infos
+}
+
+// CancelWaiters stops every blocked caller with err, ErrWaitCanceled if nil, and returns how many there were. Tokens,
+// window events and pending reservations are left as they are, only the slots the callers held in a queue are freed.
+func (b *base) CancelWaiters(err error) int {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if err == nil {
+		err = ErrWaitCanceled
+	}
+	waiters := slices.Clone(b.waiters.waiters)
+	for _, w := range waiters {
+		b.deny(ReasonCanceled)
+		b.eject(w, err)
+	}
+	return len(waiters)
+}
+
+// close stops the limiter for good, running cancelReservations with the mutex locked and ejecting the blocked callers
+// with ErrLimiterClosed. It does nothing if the limiter was already stopped.
+func (b *base) close(cancelReservations func()) error {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if b.stopped {
+		return nil
+	}
+	b.stopped = true
+	cancelReservations()
+	for _, w := range slices.Clone(b.waiters.waiters) {
+		b.deny(ReasonClosed)
+		b.eject(w, ErrLimiterClosed)
+	}
+	return nil
+}
+
+// denyHalted denies a request if the limiter was closed or is paused, and reports whether it did.
+func (b *base) denyHalted() bool {
+	// This must be called with the mutex already locked
+	switch {
+	case b.stopped:
+		b.deny(ReasonClosed)
+	case b.paused:
+		b.deny(ReasonPaused)
+	default:
+		return false
+	}
+	return true
+}
+
+// eject stops w from waiting with err.
+func (b *base) eject(w *waiter, err error) {
+	// This must be called with the mutex already locked
+	w.err = err
+	b.waiters.remove(w)
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (b *base) newWaiter(ctx context.Context) *waiter {
+	deadline, _ := ctx.Deadline()
+	tag, _ := ctx.Value(tagKey{}).(string)
+	report, _ := ctx.Value(reportSinkKey{}).(*AdmitReport)
+	return &waiter{since: b.clock.Now(), deadline: deadline, tag: tag, wake: make(chan struct{}, 1), report: report, depth: -1}
+}
+
+// await blocks until attempt admits the caller or stops it with an error, or until ctx is done.
+// giveUp, if set, is called with the mutex locked when the caller stops waiting because ctx is done or it was ejected.
+func (b *base) await(ctx context.Context, attempt attemptFunc, giveUp func()) error {
+	return b.awaitAs(ctx, b.newWaiter(ctx), attempt, giveUp)
+}
+
+// awaitReservation is await for a reservation, which fails instead of blocking while the limiter is paused with
+// FailWhilePaused.
+func (b *base) awaitReservation(ctx context.Context, attempt attemptFunc) error {
+	w := b.newWaiter(ctx)
+	w.reserving = true
+	return b.awaitAs(ctx, w, attempt, nil)
+}
+
+// awaitAs is await for a waiter created beforehand, for callers that need to refer to it.
+func (b *base) awaitAs(ctx context.Context, w *waiter, attempt attemptFunc, giveUp func()) error {
+	for {
+		retryIn, done, err := b.tryAwait(ctx, w, attempt, giveUp)
+		if done {
+			return err
+		}
+
+		timer := b.clock.NewTimer(b.jitter.stretch(retryIn))
+		select {
+		case <-ctx.Done():
+		case <-w.wake:
+		case <-timer.C():
+		}
+		timer.Stop()
+	}
+}
+
+// queueFullErr returns the error of a request turned away because the queue is full.
+func (b *base) queueFullErr() error {
+	// This must be called with the mutex already locked
+	return &LimitError{Limiter: b.name, Reason: ReasonQueueFull, RetryAfter: b.retryAfter(), Err: ErrQueueFull}
+}
+
+// addWaiter queues w to be woken up when capacity frees up, unless WithMaxWaiters caps the waiters and there's no room
+// for it, in which case w is turned away.
+func (b *base) addWaiter(w *waiter, giveUp func()) error {
+	// This must be called with the mutex already locked
+	if b.maxWaiters > 0 && len(b.waiters.waiters) >= b.maxWaiters && !slices.Contains(b.waiters.waiters, w) {
+		b.deny(ReasonQueueFull)
+		if giveUp != nil {
+			giveUp()
+		}
+		return b.queueFullErr()
+	}
+	b.waiters.add(w)
+	return nil
+}
+
+func (b *base) tryAwait(ctx context.Context, w *waiter, attempt attemptFunc, giveUp func()) (time.Duration, bool, error) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if w.err != nil {
+		if giveUp != nil {
+			giveUp()
+		}
+		return 0, true, w.err
+	}
+
+	if b.stopped {
+		b.waiters.remove(w)
+		b.deny(ReasonClosed)
+		if giveUp != nil {
+			giveUp()
+		}
+		return 0, true, ErrLimiterClosed
+	}
+
+	if ctx.Err() != nil {
+		b.waiters.remove(w)
+		b.deny(ReasonContext)
+		if giveUp != nil {
+			giveUp()
+		}
+		return 0, true, contextError(ctx, b.name, b.clock.Now().Sub(w.since), b.retryAfter())
+	}
+
+	if b.paused {
+		if w.reserving {
+			if err := b.pausedReservationErr(); err != nil {
+				b.waiters.remove(w)
+				if giveUp != nil {
+					giveUp()
+				}
+				return 0, true, err
+			}
+		}
+		// Resume wakes the waiter, MaxWait doesn't apply since there's no telling when
+		if err := b.addWaiter(w, giveUp); err != nil {
+			return 0, true, err
+		}
+		return pausedRetry, false, nil
+	}
+
+	if w.depth < 0 {
+		w.depth = len(b.waiters.waiters)
+	}
+	admitted, retryIn, err := attempt()
+	if admitted || err != nil {
+		b.waiters.remove(w)
+		if admitted && w.report != nil {
+			*w.report = b.admitReport(b.clock.Now().Sub(w.since), w.depth)
+		}
+		return 0, true, err
+	}
+
+	if estimate := b.clock.Now().Sub(w.since) + retryIn; b.maxWait > 0 && estimate > b.maxWait {
+		b.waiters.remove(w)
+		b.deny(ReasonWaitTooLong)
+		if giveUp != nil {
+			giveUp()
+		}
+		return 0, true, &WaitTooLongError{Limiter: b.name, Estimate: estimate, MaxWait: b.maxWait}
+	}
+
+	if err := b.addWaiter(w, giveUp); err != nil {
+		return 0, true, err
+	}
+	// Trying again right away gives the same answer, wait at least until the clock moves on
+	return max(retryIn, time.Nanosecond), false, nil
+}
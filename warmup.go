@@ -0,0 +1,240 @@
+package limit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// warmupStart is the share of the target rate a warm-up token bucket starts at.
+const warmupStart = 0.1
+
+// WarmupStats reports the ramp of a limiter created with NewWarmupTokenBucket.
+type WarmupStats struct {
+	// The rate the limiter enforces now
+	Rate Rate `json:"rate"`
+	// The rate it ramps up to
+	Target Rate `json:"target"`
+	// When it reaches the target rate
+	WarmAt time.Time `json:"warm_at"`
+}
+
+var _ Limiter = (*warmupLimiter)(nil)
+
+type warmupLimiter struct {
+	*tokenBucket
+
+	// Mutex, taken before the bucket's
+	mux sync.Mutex
+
+	// Config
+	target int
+	per    time.Duration
+	period time.Duration
+
+	// State
+	startedAt time.Time
+	count     int
+}
+
+// NewWarmupTokenBucket creates a token bucket that starts at 10% of count per duration and ramps up linearly to count
+// over warmupPeriod, like Guava's warm-up RateLimiter, e.g. for a service restarting with a cold cache. The ramp starts
+// over with Clear. The bucket's rate follows the ramp on every call, waking the blocked callers, and its Config, Limit
+// and Burst report the rate it enforces now, as does Stats in Warmup. SetRate changes the target rate. The options
+// are the token bucket's.
+// It panics if count, duration or warmupPeriod isn't positive, or if the refill interval truncates to zero like
+// NewTokenBucket.
+func NewWarmupTokenBucket(count int, duration, warmupPeriod time.Duration, opts ...Option) Limiter {
+	err := validateRate(count, duration, true)
+	if err == nil && warmupPeriod <= 0 {
+		err = fmt.Errorf("warm-up period %s is not positive", warmupPeriod)
+	}
+	if err != nil {
+		panic(fmt.Sprintf("limit: NewWarmupTokenBucket: %v", err))
+	}
+
+	w := &warmupLimiter{target: count, per: duration, period: warmupPeriod}
+	w.count = w.countAt(0)
+	w.tokenBucket = NewTokenBucket(w.count, duration, opts...).(*tokenBucket)
+	w.startedAt = w.clock.Now()
+	return w
+}
+
+// countAt returns the count of the ramp elapsed into the warm-up period, at least 1.
+func (w *warmupLimiter) countAt(elapsed time.Duration) int {
+	if elapsed >= w.period {
+		return w.target
+	}
+	progress := float64(max(elapsed, 0)) / float64(w.period)
+	return max(int(float64(w.target)*(warmupStart+(1-warmupStart)*progress)), 1)
+}
+
+// ramp applies the count of the ramp to the bucket if it changed.
+func (w *warmupLimiter) ramp() {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	w.rampLocked()
+}
+
+func (w *warmupLimiter) rampLocked() {
+	// This must be called with the mutex already locked
+	if count := w.countAt(w.clock.Now().Sub(w.startedAt)); count != w.count {
+		w.count = count
+		w.tokenBucket.SetRate(count, w.per)
+	}
+}
+
+func (w *warmupLimiter) Wait() {
+	_ = w.WaitContext(context.Background())
+}
+
+func (w *warmupLimiter) WaitTimeout(timeout time.Duration) error {
+	return w.WaitNTimeout(timeout, 1)
+}
+
+func (w *warmupLimiter) WaitContext(ctx context.Context) error {
+	return w.WaitNContext(ctx, 1)
+}
+
+func (w *warmupLimiter) WaitN(n int) error {
+	return w.WaitNContext(context.Background(), n)
+}
+
+func (w *warmupLimiter) WaitNTimeout(timeout time.Duration, n int) error {
+	ctx, cancel := withTimeout(w.clock, timeout)
+	defer cancel()
+	return w.WaitNContext(ctx, n)
+}
+
+func (w *warmupLimiter) WaitNContext(ctx context.Context, n int) error {
+	w.ramp()
+	return w.tokenBucket.WaitNContext(ctx, n)
+}
+
+func (w *warmupLimiter) WaitContextReport(ctx context.Context) (AdmitReport, error) {
+	return waitReport(ctx, w.WaitContext)
+}
+
+func (w *warmupLimiter) Allowed() bool {
+	return w.AllowN(1)
+}
+
+func (w *warmupLimiter) AllowN(n int) bool {
+	w.ramp()
+	return w.tokenBucket.AllowN(n)
+}
+
+func (w *warmupLimiter) AllowedReport() (AdmitReport, bool) {
+	w.ramp()
+	return w.tokenBucket.AllowedReport()
+}
+
+// Clear empties the bucket down to the start of the ramp, which starts over.
+func (w *warmupLimiter) Clear() {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	w.startedAt = w.clock.Now()
+	w.count = w.countAt(0)
+	w.tokenBucket.SetRate(w.count, w.per)
+	w.tokenBucket.Clear()
+}
+
+// SetRate changes the rate the bucket ramps up to, keeping the progress of the ramp.
+func (w *warmupLimiter) SetRate(count int, per time.Duration) {
+	if per <= 0 || count < 0 {
+		panic(fmt.Sprintf("limit: SetRate with an invalid rate of %d/%s", count, per))
+	}
+
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	w.target, w.per = count, per
+	w.count = w.countAt(w.clock.Now().Sub(w.startedAt))
+	w.tokenBucket.SetRate(w.count, w.per)
+}
+
+// Stats reports the ramp in Warmup.
+func (w *warmupLimiter) Stats() Stats {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	w.rampLocked()
+
+	stats := w.tokenBucket.Stats()
+	stats.Warmup = &WarmupStats{
+		Rate:   Rate{Count: w.count, Per: w.per},
+		Target: Rate{Count: w.target, Per: w.per},
+		WarmAt: w.startedAt.Add(w.period),
+	}
+	return stats
+}
+
+func (w *warmupLimiter) Info() LimitInfo {
+	w.ramp()
+	return w.tokenBucket.Info()
+}
+
+func (w *warmupLimiter) Available() int {
+	w.ramp()
+	return w.tokenBucket.Available()
+}
+
+func (w *warmupLimiter) EstimatedWait() time.Duration {
+	w.ramp()
+	return w.tokenBucket.EstimatedWait()
+}
+
+func (w *warmupLimiter) Config() Config {
+	w.ramp()
+	return w.tokenBucket.Config()
+}
+
+func (w *warmupLimiter) Limit() Rate {
+	w.ramp()
+	return w.tokenBucket.Limit()
+}
+
+func (w *warmupLimiter) Burst() int {
+	w.ramp()
+	return w.tokenBucket.Burst()
+}
+
+func (w *warmupLimiter) TryReserve(reservationTTL *time.Duration) (Reservation, bool) {
+	w.ramp()
+	return w.tokenBucket.TryReserve(reservationTTL)
+}
+
+func (w *warmupLimiter) reserveNow(n int) (Reservation, bool) {
+	w.ramp()
+	return w.tokenBucket.reserveNow(n)
+}
+
+func (w *warmupLimiter) Reserve(reservationTTL *time.Duration) Reservation {
+	reservation, err := w.ReserveContext(context.Background(), reservationTTL)
+	if err != nil {
+		return failedReservation{err: err}
+	}
+	return reservation
+}
+
+func (w *warmupLimiter) ReserveTimeout(timeout time.Duration, reservationTTL *time.Duration) (Reservation, error) {
+	ctx, cancel := withTimeout(w.clock, timeout)
+	defer cancel()
+	return w.ReserveContext(ctx, reservationTTL)
+}
+
+func (w *warmupLimiter) ReserveContext(ctx context.Context, reservationTTL *time.Duration) (Reservation, error) {
+	return w.ReserveN(ctx, 1, reservationTTL)
+}
+
+func (w *warmupLimiter) ReserveN(ctx context.Context, n int, reservationTTL *time.Duration) (Reservation, error) {
+	w.ramp()
+	return w.tokenBucket.ReserveN(ctx, n, reservationTTL)
+}
+
+func (w *warmupLimiter) Permits(ctx context.Context) <-chan struct{} {
+	return deliverPermits(ctx, func(ctx context.Context) (permit, error) {
+		return reservePermit(ctx, w)
+	})
+}
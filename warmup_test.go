@@ -0,0 +1,63 @@
+package limit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/agustinbanchio/go-limit/limittest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWarmupTokenBucket(t *testing.T) {
+	t.Parallel()
+
+	clock := limittest.NewFakeClock(time.Unix(0, 0))
+	limiter := limit.NewWarmupTokenBucket(100, 1*time.Second, 100*time.Second, limit.WithClock(clock))
+	drain := func() int {
+		allowed := 0
+		for limiter.Allowed() {
+			allowed++
+		}
+		return allowed
+	}
+
+	// It starts at 10% of the rate
+	assert.Equal(t, 10, drain())
+	assert.Equal(t, limit.Config{Algorithm: limit.AlgorithmTokenBucket, Count: 10, Per: 1 * time.Second}, limiter.Config())
+
+	// The admissions of a second follow the ramp
+	for _, at := range []struct {
+		elapsed  time.Duration
+		admitted int
+	}{
+		{25 * time.Second, 32},
+		{50 * time.Second, 55},
+		{100 * time.Second, 100},
+	} {
+		clock.Advance(at.elapsed - clock.Now().Sub(time.Unix(0, 0)))
+		drain()
+		clock.Advance(1 * time.Second)
+		assert.InDelta(t, at.admitted, drain(), 1, "after %s", at.elapsed)
+	}
+
+	stats := limiter.Stats()
+	assert.Equal(t, &limit.WarmupStats{
+		Rate:   limit.Rate{Count: 100, Per: 1 * time.Second},
+		Target: limit.Rate{Count: 100, Per: 1 * time.Second},
+		WarmAt: time.Unix(100, 0),
+	}, stats.Warmup)
+
+	// Clear starts the ramp over
+	limiter.Clear()
+	assert.Equal(t, 10, drain())
+	assert.Equal(t, time.Unix(201, 0), limiter.Stats().Warmup.WarmAt)
+}
+
+func TestWarmupTokenBucket_InvalidPeriod(t *testing.T) {
+	t.Parallel()
+
+	assert.PanicsWithValue(t, "limit: NewWarmupTokenBucket: warm-up period 0s is not positive", func() {
+		limit.NewWarmupTokenBucket(100, 1*time.Second, 0)
+	})
+}
@@ -0,0 +1,79 @@
+package limit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+)
+
+// WatchConfig applies the limiter configs in the file at path to registry, then keeps applying them as the file
+// changes until ctx is done. The file maps limiter names to configs, in JSON unless WithConfigDecoder is given.
+//
+// It returns the error of the first load, leaving the registry untouched. Later the file is read every poll interval,
+// see WithConfigPoll, and applied as with Registry.Apply when its contents change. A file that can't be read, decoded
+// or validated is rejected as a whole, keeping the limiters as they were, and reported to the function set with
+// WithConfigErrors.
+//
+// The options are passed to the limiters the registry creates, so it also accepts their options, like WithClock.
+func WatchConfig(ctx context.Context, path string, registry *Registry, opts ...Option) error {
+	o := newOptions(opts)
+	seen, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := applyConfig(path, seen, registry, o.configDecoder, opts); err != nil {
+		return err
+	}
+
+	report := func(err error) {
+		if o.onConfigError != nil {
+			o.onConfigError(err)
+		}
+	}
+	go func() {
+		// Errors are reported once, not on every poll until the file changes
+		readFailed := false
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-o.clock.After(o.configPoll):
+			}
+
+			if o.retireRemoved {
+				registry.retire(o.clock.Now(), o.retireDrain)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				if !readFailed {
+					report(err)
+				}
+				readFailed = true
+				continue
+			}
+			readFailed = false
+			if bytes.Equal(data, seen) {
+				continue
+			}
+			seen = data
+			if err := applyConfig(path, data, registry, o.configDecoder, opts); err != nil {
+				report(err)
+			}
+		}
+	}()
+	return nil
+}
+
+// applyConfig decodes the contents of the file at path and applies them to registry.
+func applyConfig(path string, data []byte, registry *Registry, decode func(data []byte, v any) error, opts []Option) error {
+	var configs map[string]Config
+	if err := decode(data, &configs); err != nil {
+		return fmt.Errorf("decoding %s: %w", path, err)
+	}
+	if err := registry.Apply(configs, opts...); err != nil {
+		return fmt.Errorf("applying %s: %w", path, err)
+	}
+	return nil
+}
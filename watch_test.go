@@ -0,0 +1,123 @@
+package limit_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/agustinbanchio/go-limit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchConfig(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "limits.json")
+	write := func(contents string) {
+		// Renamed into place, so the watcher never reads a partial file
+		tmp := path + ".tmp"
+		assert.NoError(t, os.WriteFile(tmp, []byte(contents), 0o600))
+		assert.NoError(t, os.Rename(tmp, path))
+	}
+	write(`{"api": {"algorithm": "rolling_window", "count": 2, "per": "1h"}}`)
+
+	var mux sync.Mutex
+	var errs []error
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	registry := limit.NewRegistry()
+	err := limit.WatchConfig(ctx, path, registry,
+		limit.WithConfigPoll(5*time.Millisecond),
+		limit.WithRetireRemoved(0),
+		limit.WithConfigErrors(func(err error) {
+			mux.Lock()
+			defer mux.Unlock()
+			errs = append(errs, err)
+		}))
+	assert.NoError(t, err)
+
+	api, ok := registry.Get("api")
+	assert.True(t, ok)
+	assert.True(t, api.Allowed())
+	assert.True(t, api.Allowed())
+	assert.False(t, api.Allowed())
+
+	// Raising the rate applies to the live limiter, which keeps the requests it already allowed
+	write(`{"api": {"algorithm": "rolling_window", "count": 4, "per": "1h"}}`)
+	assert.Eventually(t, func() bool {
+		return api.(limit.Configurer).Limit().Count == 4
+	}, 1*time.Second, 5*time.Millisecond)
+	live, _ := registry.Get("api")
+	assert.Same(t, api, live)
+	assert.True(t, api.Allowed())
+	assert.True(t, api.Allowed())
+	assert.False(t, api.Allowed())
+
+	// An invalid file is rejected as a whole
+	write(`{"api": {"algorithm": "rolling_window", "count": 8, "per": "1h"}, "search": {"algorithm": "token_bucket", "count": 0, "per": "1s"}}`)
+	assert.Eventually(t, func() bool {
+		mux.Lock()
+		defer mux.Unlock()
+		return len(errs) == 1
+	}, 1*time.Second, 5*time.Millisecond)
+	assert.ErrorContains(t, errs[0], `limiter "search": invalid rate: count 0 is not positive`)
+	assert.Equal(t, 4, api.(limit.Configurer).Limit().Count)
+	assert.Equal(t, []string{"api"}, registry.Names())
+
+	// New limiters are created and removed ones retired once idle
+	write(`{"search": {"algorithm": "leaky_bucket", "count": 10, "per": "1s", "max_queue": 5}}`)
+	assert.Eventually(t, func() bool {
+		_, ok := registry.Get("api")
+		return !ok
+	}, 1*time.Second, 5*time.Millisecond)
+	search, ok := registry.Get("search")
+	assert.True(t, ok)
+	assert.Equal(t, limit.Rate{Count: 10, Per: 1 * time.Second}, search.(limit.Configurer).Limit())
+	assert.Equal(t, []string{"search"}, registry.Names())
+}
+
+func TestWatchConfig_FirstLoadFails(t *testing.T) {
+	t.Parallel()
+
+	registry := limit.NewRegistry()
+	err := limit.WatchConfig(context.Background(), filepath.Join(t.TempDir(), "missing.json"), registry)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	path := filepath.Join(t.TempDir(), "limits.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"api": {"algorithm": "fixed_window", "count": 1, "per": "1s"}}`), 0o600))
+	err = limit.WatchConfig(context.Background(), path, registry)
+	assert.ErrorContains(t, err, `limiter "api": unknown algorithm "fixed_window"`)
+	assert.Empty(t, registry.Names())
+}
+
+func TestRegistry_Apply(t *testing.T) {
+	t.Parallel()
+
+	registry := limit.NewRegistry()
+	assert.NoError(t, registry.Apply(map[string]limit.Config{
+		"api":    {Algorithm: limit.AlgorithmTokenBucket, Count: 1, Per: 1 * time.Hour},
+		"events": {Algorithm: limit.AlgorithmLeakyBucket, Count: 1, Per: 1 * time.Second, MaxQueue: 2},
+	}))
+	api, _ := registry.Get("api")
+	events, _ := registry.Get("events")
+	assert.True(t, api.Allowed())
+
+	// Changing the algorithm replaces the limiter, as does changing the rate of a leaky bucket
+	assert.NoError(t, registry.Apply(map[string]limit.Config{
+		"api":    {Algorithm: limit.AlgorithmRollingWindow, Count: 1, Per: 1 * time.Hour},
+		"events": {Algorithm: limit.AlgorithmLeakyBucket, Count: 2, Per: 1 * time.Second, MaxQueue: 2},
+	}))
+	replaced, _ := registry.Get("api")
+	assert.NotSame(t, api, replaced)
+	assert.True(t, replaced.Allowed())
+	replaced, _ = registry.Get("events")
+	assert.NotSame(t, events, replaced)
+	assert.Equal(t, limit.Rate{Count: 2, Per: 1 * time.Second}, replaced.(limit.Configurer).Limit())
+
+	// Without WithRetireRemoved removed limiters stay
+	assert.NoError(t, registry.Apply(map[string]limit.Config{}))
+	assert.Equal(t, []string{"api", "events"}, registry.Names())
+}